@@ -0,0 +1,123 @@
+package ipget
+
+import (
+	"context"
+	"fmt"
+
+	cid "github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	unixfs "github.com/ipfs/go-unixfs"
+	unixfspb "github.com/ipfs/go-unixfs/pb"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget/internal/dagutil"
+)
+
+// accurateSizeMaxNodes is how many nodes working out the size of a file
+// fetches at most before giving up on it, so that it stays cheaper than
+// the fetch it is for.
+const accurateSizeMaxNodes = 1024
+
+// WithAccurateSize makes Get work out the size of a file whose root doesn't
+// record it, from the rest of its DAG, so that its progress has a total.
+// The nodes it fetches for that are fetched before Get returns. Leaves are
+// only fetched when no parent records their size, and the file is left
+// with no size if it would take more than accurateSizeMaxNodes nodes.
+func WithAccurateSize() GetOption {
+	return func(s *getSettings) {
+		s.accurateSize = true
+	}
+}
+
+// sizedFile is a file whose size was worked out from its DAG.
+type sizedFile struct {
+	files.File
+	size int64
+}
+
+func (f *sizedFile) Size() (int64, error) {
+	return f.size, nil
+}
+
+// accurateSize returns nd, the content of p, with its size worked out if it
+// is a file whose root doesn't record one. It is returned as it was if the
+// size can't be worked out; the file can still be read.
+func (s *Session) accurateSize(ctx context.Context, p ipath.Path, nd files.Node) files.Node {
+	f, ok := nd.(files.File)
+	if !ok {
+		return nd
+	}
+	if size, err := f.Size(); err == nil && size > 0 {
+		return nd
+	}
+	rp, err := s.api.ResolvePath(ctx, p)
+	if err != nil {
+		return nd
+	}
+	budget := accurateSizeMaxNodes
+	size, err := dagFileSize(ctx, s.api.Dag(), rp.Cid(), nil, &budget)
+	if err != nil {
+		logger(ctx, s.log).Printf("the size of %s is unknown: %s", p, err)
+		return nd
+	}
+	return &sizedFile{File: f, size: size}
+}
+
+// dagFileSize returns the size of the data of the unixfs file at c, as the
+// sizes its nodes record for their children tell where they do, and from
+// the children themselves where they don't. Raw leaves are never fetched,
+// their links give their size. budget is how many more nodes may be
+// fetched, up is the path from the root.
+func dagFileSize(ctx context.Context, dg ipld.NodeGetter, c cid.Cid, up *dagutil.Ancestry, budget *int) (int64, error) {
+	up, err := up.Child(c)
+	if err != nil {
+		return 0, err
+	}
+	if *budget <= 0 {
+		return 0, fmt.Errorf("more than %d nodes to look at", accurateSizeMaxNodes)
+	}
+	*budget--
+	nd, err := dg.Get(ctx, c)
+	if err != nil {
+		return 0, err
+	}
+	pn, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		if _, err := dagutil.FileData(nd); err != nil {
+			return 0, err
+		}
+		return int64(len(nd.RawData())), nil
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return 0, err
+	}
+	switch fsn.Type() {
+	case unixfspb.Data_File, unixfspb.Data_Raw:
+	default:
+		return 0, fmt.Errorf("%s is not a file", c)
+	}
+	if size := fsn.FileSize(); size > 0 {
+		return int64(size), nil
+	}
+
+	size := int64(len(fsn.Data()))
+	links := pn.Links()
+	sizes := fsn.BlockSizes()
+	for i, l := range links {
+		switch {
+		case len(sizes) == len(links) && sizes[i] > 0:
+			size += int64(sizes[i])
+		case l.Cid.Type() == cid.Raw:
+			size += int64(l.Size)
+		default:
+			n, err := dagFileSize(ctx, dg, l.Cid, up, budget)
+			if err != nil {
+				return 0, err
+			}
+			size += n
+		}
+	}
+	return size, nil
+}
@@ -0,0 +1,121 @@
+package ipget
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	host "github.com/libp2p/go-libp2p-core/host"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// addrRefresher gives the providers a spawned node fails to dial a second
+// chance. The addresses of a provider are those it had when its record
+// was stored, or when the DHT last heard of it, and a peer behind a NAT
+// whose mapping changed since can't be reached at them. When dialing a
+// provider fails, its current addresses are looked up with FIND_NODE and,
+// if there are new ones, it is dialed again at those.
+type addrRefresher struct {
+	mu sync.Mutex
+	// router is set once the routing is built, until then no address is
+	// refreshed.
+	router routing.PeerRouting
+	// providers are the peers found providing something, the only ones
+	// whose addresses are refreshed, once each.
+	providers map[peer.ID]bool
+	tried     map[peer.ID]bool
+	// reached are the providers dialed only after refreshing their
+	// addresses.
+	reached []peer.ID
+}
+
+func newAddrRefresher() *addrRefresher {
+	return &addrRefresher{providers: map[peer.ID]bool{}, tried: map[peer.ID]bool{}}
+}
+
+// wrap returns rt, which it looks up addresses through from then on,
+// noting the providers it finds.
+func (r *addrRefresher) wrap(rt routing.Routing) routing.Routing {
+	r.mu.Lock()
+	r.router = rt
+	r.mu.Unlock()
+	return &filteredProvidersRouting{Routing: rt, keep: r.noteProvider}
+}
+
+func (r *addrRefresher) noteProvider(info peer.AddrInfo) bool {
+	r.mu.Lock()
+	r.providers[info.ID] = true
+	r.mu.Unlock()
+	return true
+}
+
+// Reached returns the providers that were only dialed after refreshing
+// their addresses.
+func (r *addrRefresher) Reached() []peer.ID {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := append([]peer.ID(nil), r.reached...)
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// refresh looks up the current addresses of p, if it is a provider not
+// refreshed yet, and returns those that weren't known already.
+func (r *addrRefresher) refresh(ctx context.Context, ps peerstore.Peerstore, p peer.ID) []ma.Multiaddr {
+	r.mu.Lock()
+	router := r.router
+	if router == nil || !r.providers[p] || r.tried[p] {
+		r.mu.Unlock()
+		return nil
+	}
+	r.tried[p] = true
+	r.mu.Unlock()
+
+	info, err := router.FindPeer(ctx, p)
+	if err != nil {
+		return nil
+	}
+	known := map[string]bool{}
+	for _, a := range ps.Addrs(p) {
+		known[string(a.Bytes())] = true
+	}
+	var fresh []ma.Multiaddr
+	for _, a := range info.Addrs {
+		if !known[string(a.Bytes())] {
+			fresh = append(fresh, a)
+		}
+	}
+	return fresh
+}
+
+// refreshingHost dials the providers it fails to reach again at their
+// refreshed addresses.
+type refreshingHost struct {
+	host.Host
+	refresh *addrRefresher
+}
+
+func (h *refreshingHost) Connect(ctx context.Context, pi peer.AddrInfo) error {
+	err := h.Host.Connect(ctx, pi)
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	fresh := h.refresh.refresh(ctx, h.Peerstore(), pi.ID)
+	if len(fresh) == 0 {
+		return err
+	}
+	h.Peerstore().AddAddrs(pi.ID, fresh, peerstore.ProviderAddrTTL)
+	if err := h.Host.Connect(ctx, peer.AddrInfo{ID: pi.ID, Addrs: fresh}); err != nil {
+		return err
+	}
+	h.refresh.mu.Lock()
+	h.refresh.reached = append(h.refresh.reached, pi.ID)
+	h.refresh.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,168 @@
+package ipget
+
+import (
+	"fmt"
+	"sync"
+
+	network "github.com/libp2p/go-libp2p-core/network"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// providerTracker follows how far a spawned node gets with the providers
+// of a Get: how many lookups found, how many of those it connected to, and
+// whether any block arrived. A fetch that gets nothing can then tell a
+// path nobody provides from providers that are all behind NATs, or that
+// accept connections but don't send the blocks.
+//
+// The lookups bitswap repeats as it fetches find the same providers again
+// and again. The tracker passes each on once per Get, so it is dialed once,
+// and again only when it comes with addresses not seen before while it
+// isn't connected, those being added to what the peerstore has.
+//
+// Each Get begins a generation, and what a Get found is what was noted
+// since its own began. Gets made at once share what they find.
+type providerTracker struct {
+	mu  sync.Mutex
+	gen int
+	// found holds each provider found, with the addresses seen of it.
+	found map[peer.ID]*foundProvider
+	// connected holds the generation each peer was last connected in.
+	connected map[peer.ID]int
+	// records counts the provider records found, duplicates included.
+	records int
+	// n is set by watch.
+	n network.Network
+}
+
+// foundProvider is a provider some lookup found.
+type foundProvider struct {
+	addrs map[string]bool
+	// gen is the generation it was last found in.
+	gen int
+}
+
+// providerMark is where the counts of a providerTracker stood as a Get
+// began.
+type providerMark struct {
+	gen     int
+	records int
+	// fetched is the count of blocks fetched when the Get began.
+	fetched int
+}
+
+func newProviderTracker() *providerTracker {
+	return &providerTracker{found: map[peer.ID]*foundProvider{}, connected: map[peer.ID]int{}}
+}
+
+// wrap returns rt, noting the providers it finds and dropping those found
+// already. ps is where the new addresses of those go.
+func (t *providerTracker) wrap(rt routing.Routing, ps peerstore.Peerstore) routing.Routing {
+	return &filteredProvidersRouting{Routing: rt, keep: func(info peer.AddrInfo) bool {
+		return t.noteProvider(ps, info)
+	}}
+}
+
+func (t *providerTracker) noteProvider(ps peerstore.Peerstore, info peer.AddrInfo) bool {
+	t.mu.Lock()
+	t.records++
+	f, ok := t.found[info.ID]
+	if !ok {
+		f = &foundProvider{addrs: map[string]bool{}}
+		t.found[info.ID] = f
+	}
+	var fresh []ma.Multiaddr
+	for _, a := range info.Addrs {
+		if !f.addrs[string(a.Bytes())] {
+			f.addrs[string(a.Bytes())] = true
+			fresh = append(fresh, a)
+		}
+	}
+	first := !ok || f.gen != t.gen
+	f.gen = t.gen
+	n := t.n
+	t.mu.Unlock()
+	if first {
+		return true
+	}
+	if len(fresh) == 0 {
+		return false
+	}
+	ps.AddAddrs(info.ID, fresh, peerstore.ProviderAddrTTL)
+	// dialing it failed, or hasn't been tried yet, maybe at the others
+	return n == nil || n.Connectedness(info.ID) != network.Connected
+}
+
+// Records returns how many providers the lookups found since m, and how
+// many provider records they got in all, duplicates included.
+func (t *providerTracker) Records(m providerMark) (unique, total int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, f := range t.found {
+		if f.gen >= m.gen {
+			unique++
+		}
+	}
+	return unique, t.records - m.records
+}
+
+// watch notes the peers n connects to.
+func (t *providerTracker) watch(n network.Network) {
+	t.mu.Lock()
+	t.n = n
+	t.mu.Unlock()
+	n.Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, conn network.Conn) {
+			t.mu.Lock()
+			t.connected[conn.RemotePeer()] = t.gen
+			t.mu.Unlock()
+		},
+	})
+}
+
+// begin starts the generation of a Get, from which on the providers found
+// are passed on once more. fetched is the count of blocks fetched so far.
+func (t *providerTracker) begin(fetched int) providerMark {
+	if t == nil {
+		return providerMark{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.gen++
+	return providerMark{gen: t.gen, records: t.records, fetched: fetched}
+}
+
+// explain returns why the Get that began at m got nothing, as an *Error of
+// the step it got stuck at, or nil if blocks did arrive since. n is asked
+// for the providers that were already connected when it began. why is what
+// ended the Get, such as its deadline.
+func (t *providerTracker) explain(n network.Network, m providerMark, fetched int, why string) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if fetched > m.fetched {
+		return nil
+	}
+	found, dialed := 0, 0
+	for p, f := range t.found {
+		if f.gen < m.gen {
+			continue
+		}
+		found++
+		if gen, ok := t.connected[p]; ok && gen >= m.gen || n.Connectedness(p) == network.Connected {
+			dialed++
+		}
+	}
+	switch {
+	case found == 0:
+		return &Error{Kind: ErrNoProviders, Err: fmt.Errorf("%s: no providers found", why)}
+	case dialed == 0:
+		return &Error{Kind: ErrUndialable, Err: fmt.Errorf("%s: %d providers found, none could be dialed", why, found)}
+	default:
+		return &Error{Kind: ErrNotServed, Err: fmt.Errorf("%s: %d of %d providers dialed, none sent a block", why, dialed, found)}
+	}
+}
@@ -0,0 +1,187 @@
+package ipget
+
+import (
+	"encoding/binary"
+	"strings"
+	"sync"
+
+	bitswappb "github.com/ipfs/go-bitswap/message/pb"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	host "github.com/libp2p/go-libp2p-core/host"
+	network "github.com/libp2p/go-libp2p-core/network"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	protocol "github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// blockGuard looks out for peers sending a spawned node bad blocks. Bitswap
+// names a block after the hash of the data it receives, so corrupt or
+// forged data comes under a CID nobody asked for and is dropped, and the
+// block is asked for again from the other peers. But bitswap keeps talking
+// to the peer that sent it, which may well do it again. The guard spots
+// those blocks as they arrive and cuts their sender off: its bitswap streams
+// are reset and it is left out of provider lookups from then on.
+type blockGuard struct {
+	mu  sync.Mutex
+	bad map[peer.ID]int
+	// wants and has are set by start, until then every block is let
+	// through.
+	wants func() []cid.Cid
+	has   func(cid.Cid) (bool, error)
+}
+
+func newBlockGuard() *blockGuard {
+	return &blockGuard{bad: make(map[peer.ID]int)}
+}
+
+// start checks blocks against the wantlist of bitswap and the blocks
+// already stored, which a duplicate arriving late was.
+func (g *blockGuard) start(wants func() []cid.Cid, has func(cid.Cid) (bool, error)) {
+	g.mu.Lock()
+	g.wants, g.has = wants, has
+	g.mu.Unlock()
+}
+
+// trusted reports whether p hasn't sent any bad block.
+func (g *blockGuard) trusted(info peer.AddrInfo) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.bad[info.ID] == 0
+}
+
+// Bad returns how many bad blocks each peer sent before it was cut off.
+func (g *blockGuard) Bad() map[peer.ID]int {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make(map[peer.ID]int, len(g.bad))
+	for p, n := range g.bad {
+		out[p] = n
+	}
+	return out
+}
+
+// check counts the blocks of msg that match nothing asked for and reports
+// whether p sent any.
+func (g *blockGuard) check(p peer.ID, msg *bitswappb.Message) bool {
+	if len(msg.Blocks) == 0 && len(msg.Payload) == 0 {
+		return true
+	}
+	g.mu.Lock()
+	wants, has := g.wants, g.has
+	g.mu.Unlock()
+	if wants == nil {
+		return true
+	}
+
+	var received []cid.Cid
+	for _, d := range msg.Blocks {
+		// the deprecated field only carries CIDv0 blocks
+		received = append(received, blocks.NewBlock(d).Cid())
+	}
+	for _, b := range msg.Payload {
+		prefix, err := cid.PrefixFromBytes(b.Prefix)
+		if err != nil {
+			// bitswap rejects the whole message itself
+			return true
+		}
+		c, err := prefix.Sum(b.Data)
+		if err != nil {
+			return true
+		}
+		received = append(received, c)
+	}
+
+	wanted := cid.NewSet()
+	for _, c := range wants() {
+		wanted.Add(c)
+	}
+	bad := 0
+	for _, c := range received {
+		if wanted.Has(c) {
+			continue
+		}
+		if ok, err := has(c); err == nil && !ok {
+			bad++
+		}
+	}
+	if bad == 0 {
+		return true
+	}
+	g.mu.Lock()
+	g.bad[p] += bad
+	g.mu.Unlock()
+	return false
+}
+
+// guardedHost hands the bitswap stream handlers streams checked by guard.
+type guardedHost struct {
+	host.Host
+	guard *blockGuard
+}
+
+func (h *guardedHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	if strings.HasPrefix(string(pid), "/ipfs/bitswap") {
+		inner := handler
+		handler = func(s network.Stream) {
+			if !h.guard.trusted(peer.AddrInfo{ID: s.Conn().RemotePeer()}) {
+				s.Reset()
+				return
+			}
+			inner(&guardedStream{Stream: s, guard: h.guard})
+		}
+	}
+	h.Host.SetStreamHandler(pid, handler)
+}
+
+// guardedStream decodes the length prefixed bitswap messages read from it,
+// as they go by, and resets the stream once a message carries a bad block.
+// Should a message not decode, the stream is left alone from then on.
+type guardedStream struct {
+	network.Stream
+	guard  *blockGuard
+	buf    []byte
+	broken bool
+}
+
+func (s *guardedStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	if n > 0 && !s.broken {
+		s.buf = append(s.buf, p[:n]...)
+		if !s.decode() {
+			s.Stream.Reset()
+			s.Conn().Close()
+		}
+	}
+	return n, err
+}
+
+// decode checks the messages buffered in full, false if one carried a bad
+// block.
+func (s *guardedStream) decode() bool {
+	for {
+		l, k := binary.Uvarint(s.buf)
+		if k == 0 {
+			return true // the length isn't all there yet
+		}
+		if k < 0 || l > network.MessageSizeMax {
+			s.broken, s.buf = true, nil
+			return true
+		}
+		if uint64(len(s.buf)-k) < l {
+			return true
+		}
+		var msg bitswappb.Message
+		if err := msg.Unmarshal(s.buf[k : k+int(l)]); err != nil {
+			s.broken, s.buf = true, nil
+			return true
+		}
+		s.buf = append(s.buf[:0], s.buf[k+int(l):]...)
+		if !s.guard.check(s.Conn().RemotePeer(), &msg) {
+			s.broken, s.buf = true, nil
+			return false
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package ipget
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget/internal/dagutil"
+)
+
+// Block fetches the single block c as it is stored, without decoding it or
+// fetching anything it links to, so its codec needn't be one ipget can
+// read. Like Get, it falls back to the gateways should the node fail or not
+// get it within GatewayTimeout.
+func (s *Session) Block(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	if pref := c.Prefix(); !dagutil.SupportedHash(pref.MhType) {
+		return nil, fmt.Errorf("%s uses the hash function %s, which this build of ipget doesn't support (see ipget --list-supported)", c, dagutil.HashName(pref.MhType))
+	}
+	if s.Embedded() {
+		if err := s.WaitBootstrap(ctx); err != nil {
+			return nil, categorize(err)
+		}
+	}
+	nctx := ctx
+	if s.gateway != nil && s.gatewayTimeout > 0 {
+		var cancel context.CancelFunc
+		nctx, cancel = context.WithTimeout(ctx, s.gatewayTimeout)
+		defer cancel()
+	}
+	b, err := s.NodeBlock(nctx, c)
+	if err == nil || s.gateway == nil || ctx.Err() != nil {
+		return b, categorize(err)
+	}
+	logger(ctx, s.log).Printf("fetching block %s through the node failed, trying the gateways: %s", c, err)
+	b, err = s.gateway.block(ctx, c)
+	return b, categorize(err)
+}
+
+// NodeBlock fetches the single block c through the node only, never
+// falling back to the gateways.
+func (s *Session) NodeBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	r, err := s.api.Block().Get(ctx, ipath.IpfsPath(c))
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
@@ -0,0 +1,148 @@
+package ipget
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	datastore "github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	"github.com/ipfs/go-ipfs/repo"
+	"github.com/ipfs/ipget/internal/dagutil"
+)
+
+// DefaultMaxBlockSize is the largest block ipfs implementations produce and
+// exchange.
+const DefaultMaxBlockSize = dagutil.MaxBlockSize
+
+// blocksPrefix is the namespace the blockstore keeps its blocks under.
+var blocksPrefix = datastore.NewKey("/blocks")
+
+// blockLimit rejects blocks larger than max when the node stores them. The
+// first rejection is kept so the fetch can fail with it rather than stall
+// waiting for a block that will never arrive.
+type blockLimit struct {
+	max int
+
+	once     sync.Once
+	exceeded chan struct{}
+	err      error
+}
+
+// newBlockLimit returns a limit of max bytes per block, 0 or less disables
+// it.
+func newBlockLimit(max int) *blockLimit {
+	return &blockLimit{max: max, exceeded: make(chan struct{})}
+}
+
+func (l *blockLimit) enabled() bool {
+	return l != nil && l.max > 0
+}
+
+// check returns an error if a value of size bytes may not be stored at key.
+func (l *blockLimit) check(key datastore.Key, size int) error {
+	if !l.enabled() || size <= l.max || !key.IsDescendantOf(blocksPrefix) {
+		return nil
+	}
+	name := key.BaseNamespace()
+	if c, err := dshelp.DsKeyToCid(datastore.NewKey(name)); err == nil {
+		name = c.String()
+	}
+	err := fmt.Errorf("block %s is %d bytes, larger than --max-block-size of %d", name, size, l.max)
+	l.once.Do(func() {
+		l.err = err
+		close(l.exceeded)
+	})
+	return err
+}
+
+// Err returns the error of the first rejected block, if any.
+func (l *blockLimit) Err() error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case <-l.exceeded:
+		return l.err
+	default:
+		return nil
+	}
+}
+
+// watch returns a context that is cancelled as soon as a block is rejected.
+func (l *blockLimit) watch(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if l.enabled() {
+		go func() {
+			select {
+			case <-l.exceeded:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, cancel
+}
+
+// WatchBlockSize returns a context that is cancelled as soon as a spawned
+// node refuses a block larger than MaxBlockSize, so that a fetch fails
+// rather than waits for a block that will never arrive. BlockSizeErr then
+// tells why.
+func (s *Session) WatchBlockSize(ctx context.Context) (context.Context, context.CancelFunc) {
+	return s.limit.watch(ctx)
+}
+
+// BlockSizeErr returns the error of the first block a spawned node refused
+// for being larger than MaxBlockSize, if any.
+func (s *Session) BlockSizeErr() error {
+	return s.limit.Err()
+}
+
+// limitedRepo is a repo whose datastore refuses oversized blocks.
+type limitedRepo struct {
+	repo.Repo
+	ds *limitedDatastore
+}
+
+func newLimitedRepo(r repo.Repo, limit *blockLimit) *limitedRepo {
+	return &limitedRepo{
+		Repo: r,
+		ds:   &limitedDatastore{Batching: r.Datastore(), limit: limit},
+	}
+}
+
+func (r *limitedRepo) Datastore() repo.Datastore {
+	return r.ds
+}
+
+type limitedDatastore struct {
+	datastore.Batching
+	limit *blockLimit
+}
+
+func (d *limitedDatastore) Put(key datastore.Key, value []byte) error {
+	if err := d.limit.check(key, len(value)); err != nil {
+		return err
+	}
+	return d.Batching.Put(key, value)
+}
+
+func (d *limitedDatastore) Batch() (datastore.Batch, error) {
+	b, err := d.Batching.Batch()
+	if err != nil {
+		return nil, err
+	}
+	return &limitedBatch{Batch: b, limit: d.limit}, nil
+}
+
+type limitedBatch struct {
+	datastore.Batch
+	limit *blockLimit
+}
+
+func (b *limitedBatch) Put(key datastore.Key, value []byte) error {
+	if err := b.limit.check(key, len(value)); err != nil {
+		return err
+	}
+	return b.Batch.Put(key, value)
+}
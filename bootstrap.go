@@ -0,0 +1,109 @@
+package ipget
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	network "github.com/libp2p/go-libp2p-core/network"
+)
+
+// bootstrapWatch follows the connections of a spawned node as it
+// bootstraps, telling its progress to a callback and closing ready once it
+// is connected to enough peers to fetch. Readiness is latched: peers
+// dropping afterwards don't undo it.
+type bootstrapWatch struct {
+	want int
+	// progress, if set, is told of every change of the count of peers
+	// connected until ready.
+	progress func(connected, wanted int)
+
+	mu    sync.Mutex
+	last  int
+	ready chan struct{}
+	done  bool
+}
+
+func newBootstrapWatch(want int, progress func(connected, wanted int)) *bootstrapWatch {
+	return &bootstrapWatch{want: want, progress: progress, ready: make(chan struct{})}
+}
+
+// watch counts the peers n connects to, from those connected already.
+func (b *bootstrapWatch) watch(n network.Network) {
+	n.Notify(&network.NotifyBundle{
+		ConnectedF: func(n network.Network, _ network.Conn) {
+			b.update(len(n.Peers()))
+		},
+	})
+	b.update(len(n.Peers()))
+}
+
+func (b *bootstrapWatch) update(connected int) {
+	b.mu.Lock()
+	if b.done || connected == b.last {
+		b.mu.Unlock()
+		return
+	}
+	b.last = connected
+	if connected >= b.want {
+		b.done = true
+		close(b.ready)
+	}
+	b.mu.Unlock()
+	if b.progress != nil {
+		b.progress(connected, b.want)
+	}
+}
+
+// wait waits up to bootstrapTimeout for b to be ready. Running out of time
+// is not an error; the fetch may still succeed with whatever peers there
+// are.
+func (b *bootstrapWatch) wait(ctx context.Context) error {
+	t := time.NewTimer(bootstrapTimeout)
+	defer t.Stop()
+	select {
+	case <-b.ready:
+		return nil
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closedChan is ready from the start.
+var closedChan = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// Ready returns a channel closed once a spawned node is connected to
+// BootstrapMinPeers peers, and so ready to fetch. It is closed from the
+// start when fetching through a daemon.
+func (s *Session) Ready() <-chan struct{} {
+	if s.boot == nil {
+		return closedChan
+	}
+	return s.boot.ready
+}
+
+// WantedPeers returns how many peers a spawned node must be connected to
+// before it is ready, BootstrapMinPeers or its default. It is 0 when
+// fetching through a daemon.
+func (s *Session) WantedPeers() int {
+	if s.boot == nil {
+		return 0
+	}
+	return s.boot.want
+}
+
+// WaitBootstrap waits for a spawned node to be ready, as Ready tells, but
+// no longer than ten seconds: the fetch may still succeed with whatever
+// peers there are by then. It only fails if ctx is done first.
+func (s *Session) WaitBootstrap(ctx context.Context) error {
+	if s.boot == nil {
+		return nil
+	}
+	return s.boot.wait(ctx)
+}
@@ -0,0 +1,114 @@
+package ipget
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	cid "github.com/ipfs/go-cid"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	"github.com/ipfs/ipget/internal/dagutil"
+)
+
+// maxCARSection bounds the sections readCAR accepts, so a corrupt length
+// doesn't make us allocate gigabytes.
+const maxCARSection = 32 << 20
+
+// readCAR calls fn with every block of the CARv1 or CARv2 read from r, in
+// the order they are stored. The roots listed in the header are ignored.
+func readCAR(r io.Reader, fn func(c cid.Cid, data []byte) error) error {
+	br := bufio.NewReader(r)
+	header, err := readSection(br)
+	if err != nil {
+		return fmt.Errorf("reading CAR header: %s", err)
+	}
+
+	if bytes.Equal(header, dagutil.CARv2Pragma[1:]) {
+		fixed := make([]byte, dagutil.CARv2HeaderLen)
+		if _, err := io.ReadFull(br, fixed); err != nil {
+			return fmt.Errorf("reading CARv2 header: %s", err)
+		}
+		dataOffset := binary.LittleEndian.Uint64(fixed[16:])
+		dataSize := binary.LittleEndian.Uint64(fixed[24:])
+		read := uint64(len(dagutil.CARv2Pragma) + dagutil.CARv2HeaderLen)
+		if dataOffset < read {
+			return fmt.Errorf("invalid CARv2 data offset %d", dataOffset)
+		}
+		if _, err := io.CopyN(ioutil.Discard, br, int64(dataOffset-read)); err != nil {
+			return err
+		}
+		// the index after the data isn't needed when reading it all
+		br = bufio.NewReader(io.LimitReader(br, int64(dataSize)))
+		if _, err := readSection(br); err != nil {
+			return fmt.Errorf("reading CAR header: %s", err)
+		}
+	}
+
+	for {
+		section, err := readSection(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		n, c, err := cid.CidFromBytes(section)
+		if err != nil {
+			return fmt.Errorf("invalid CID in CAR section: %s", err)
+		}
+		if err := fn(c, section[n:]); err != nil {
+			return err
+		}
+	}
+}
+
+// readSection reads a varint length prefixed section. It returns io.EOF
+// only when there is nothing left at all.
+func readSection(br *bufio.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(br)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("reading CAR section length: %s", err)
+	}
+	if l == 0 {
+		// some writers pad the end of the data with zeros
+		return nil, io.EOF
+	}
+	if l > maxCARSection {
+		return nil, fmt.Errorf("CAR section of %d bytes is too large", l)
+	}
+	section := make([]byte, l)
+	if _, err := io.ReadFull(br, section); err != nil {
+		return nil, fmt.Errorf("reading CAR section: %s", err)
+	}
+	return section, nil
+}
+
+// putBlock stores data as the block c through ipfs, which hashes it again:
+// a mismatch means the CAR is corrupt.
+func putBlock(ctx context.Context, ipfs iface.CoreAPI, c cid.Cid, data []byte) error {
+	pref := c.Prefix()
+	format, ok := cid.CodecToStr[pref.Codec]
+	if !ok {
+		return fmt.Errorf("block %s has an unsupported codec", c)
+	}
+	if pref.Version == 0 {
+		format = "v0"
+	}
+	stat, err := ipfs.Block().Put(ctx, bytes.NewReader(data),
+		options.Block.Format(format), options.Block.Hash(pref.MhType, pref.MhLength))
+	if err != nil {
+		return err
+	}
+	if got := stat.Path().Cid(); !got.Equals(c) {
+		return fmt.Errorf("block %s in the CAR hashes to %s, the CAR is corrupt", c, got)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package ipget
+
+import (
+	"context"
+	"encoding/binary"
+	"strings"
+
+	host "github.com/libp2p/go-libp2p-core/host"
+	network "github.com/libp2p/go-libp2p-core/network"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	protocol "github.com/libp2p/go-libp2p-core/protocol"
+	dhtpb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+// clusterLevelHost is the host the DHT client of a spawned node is given
+// when --cluster-level is set. The DHT sends all its queries at cluster
+// level 0, the streams it opens through this host set the level of every
+// message written to them instead. Peers answer at the level they were
+// asked at, so nothing needs doing on the way back.
+type clusterLevelHost struct {
+	host.Host
+	level int
+}
+
+func (h *clusterLevelHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	s, err := h.Host.NewStream(ctx, p, pids...)
+	if err != nil || !strings.Contains(string(s.Protocol()), "/kad/") {
+		return s, err
+	}
+	return &clusterLevelStream{Stream: s, level: h.level}, nil
+}
+
+// clusterLevelStream decodes the length prefixed DHT messages written to it
+// and passes them on with their cluster level set. Should a message not
+// decode, the stream is left alone from then on.
+type clusterLevelStream struct {
+	network.Stream
+	level  int
+	buf    []byte
+	broken bool
+}
+
+func (s *clusterLevelStream) Write(p []byte) (int, error) {
+	if s.broken {
+		return s.Stream.Write(p)
+	}
+	s.buf = append(s.buf, p...)
+	for {
+		l, k := binary.Uvarint(s.buf)
+		if k == 0 {
+			return len(p), nil // the length isn't all there yet
+		}
+		if k < 0 || l > network.MessageSizeMax {
+			return len(p), s.passThrough()
+		}
+		if uint64(len(s.buf)-k) < l {
+			return len(p), nil
+		}
+		var msg dhtpb.Message
+		if err := msg.Unmarshal(s.buf[k : k+int(l)]); err != nil {
+			return len(p), s.passThrough()
+		}
+		msg.SetClusterLevel(s.level)
+		data, err := msg.Marshal()
+		if err != nil {
+			return len(p), s.passThrough()
+		}
+		out := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(data))
+		out = append(out[:binary.PutUvarint(out, uint64(len(data)))], data...)
+		if _, err := s.Stream.Write(out); err != nil {
+			return 0, err
+		}
+		s.buf = append(s.buf[:0], s.buf[k+int(l):]...)
+	}
+}
+
+// passThrough writes what is buffered as it came and stops rewriting.
+func (s *clusterLevelStream) passThrough() error {
+	buf := s.buf
+	s.broken, s.buf = true, nil
+	_, err := s.Stream.Write(buf)
+	return err
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget"
+)
+
+// announceInterval is how often we publish our provider record again by
+// default. DHT nodes drop provider records after providerRecordTTL, so this
+// is half of that, the same as go-ipfs's reprovider.
+const announceInterval = 12 * time.Hour
+
+// providerRecordTTL is how long DHT nodes keep a provider record.
+const providerRecordTTL = 24 * time.Hour
+
+// announceJitter is the fraction of the interval each wait is moved by at
+// random, so ipgets started together don't keep announcing in lockstep.
+const announceJitter = 0.1
+
+// announcedPrefix is where a spawned node's datastore records when each
+// CID was last announced, so a restarted ipget doesn't announce again a
+// record that is still fresh.
+var announcedPrefix = datastore.NewKey("/ipget/announced")
+
+// announcer publishes provider records, remembering when it last did so
+// for each CID.
+type announcer struct {
+	ipfs     iface.CoreAPI
+	ds       datastore.Datastore // nil when talking to a local daemon
+	interval time.Duration
+	// recursive provides every block under a root rather than the root
+	// alone.
+	recursive bool
+	rand      *rand.Rand
+
+	mu   sync.Mutex
+	last map[cid.Cid]time.Time
+}
+
+func newAnnouncer(s *ipget.Session, interval time.Duration, recursive bool) *announcer {
+	a := &announcer{
+		ipfs:      s.API(),
+		interval:  interval,
+		recursive: recursive,
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		last:      make(map[cid.Cid]time.Time),
+	}
+	if s.Embedded() {
+		a.ds = s.Node().Repo.Datastore()
+	}
+	return a
+}
+
+// checkAnnounceInterval makes sure records announced every interval don't
+// expire in between.
+func checkAnnounceInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("--announce-interval must be positive")
+	}
+	if interval >= providerRecordTTL {
+		return fmt.Errorf("--announce-interval must be shorter than the %s DHT nodes keep provider records for", providerRecordTTL)
+	}
+	return nil
+}
+
+// checkReprovideStrategy makes sure strategy is one --reprovide-strategy
+// accepts.
+func checkReprovideStrategy(strategy string) error {
+	switch strategy {
+	case "roots", "all":
+		return nil
+	}
+	return fmt.Errorf("unknown --reprovide-strategy %q, must be 'roots' or 'all'", strategy)
+}
+
+// announce publishes a provider record for root, or for every block under
+// it when recursive, to the DHT, and keeps publishing it again every
+// interval, give or take the jitter, until ctx is done. A record announced less than an interval ago isn't announced again
+// until the interval is up.
+func (a *announcer) announce(ctx context.Context, root ipath.Resolved) error {
+	c := root.Cid()
+	for {
+		wait := time.Duration(0)
+		if last, ok := a.lastAnnounced(c); ok && time.Since(last) < a.interval {
+			wait = a.interval - time.Since(last)
+			log.Printf("%s was announced %s ago, announcing again in %s",
+				c, time.Since(last).Round(time.Second), wait.Round(time.Second))
+		} else {
+			if err := a.ipfs.Dht().Provide(ctx, root, options.Dht.Recursive(a.recursive)); err != nil {
+				return err
+			}
+			a.record(c, time.Now())
+			log.Printf("announced %s as a provider", c)
+			wait = a.jittered()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// jittered returns the interval moved by up to announceJitter of it either
+// way.
+func (a *announcer) jittered() time.Duration {
+	j := int64(float64(a.interval) * announceJitter)
+	if j <= 0 {
+		return a.interval
+	}
+	return a.interval + time.Duration(a.rand.Int63n(2*j+1)-j)
+}
+
+// lastAnnounced returns when c was last announced, by us or by an earlier
+// ipget on the same repo.
+func (a *announcer) lastAnnounced(c cid.Cid) (time.Time, bool) {
+	a.mu.Lock()
+	t, ok := a.last[c]
+	a.mu.Unlock()
+	if ok || a.ds == nil {
+		return t, ok
+	}
+	data, err := a.ds.Get(a.key(c))
+	if err != nil {
+		return time.Time{}, false
+	}
+	if err := t.UnmarshalBinary(data); err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func (a *announcer) record(c cid.Cid, t time.Time) {
+	a.mu.Lock()
+	a.last[c] = t
+	a.mu.Unlock()
+	if a.ds == nil {
+		return
+	}
+	data, err := t.MarshalBinary()
+	if err != nil {
+		return
+	}
+	if err := a.ds.Put(a.key(c), data); err != nil {
+		log.Printf("recording the announce of %s: %s", c, err)
+	}
+}
+
+// key is where the time c was last announced is kept. Announcing the root
+// alone doesn't cover its blocks, so the two are kept apart.
+func (a *announcer) key(c cid.Cid) datastore.Key {
+	k := announcedPrefix.ChildString(c.String())
+	if a.recursive {
+		k = k.ChildString("all")
+	}
+	return k
+}
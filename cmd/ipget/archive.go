@@ -0,0 +1,457 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/ipget"
+	cli "github.com/urfave/cli"
+)
+
+// archiveFormat returns the format of the --into-archive file at fpath,
+// told by its extension: "tar" or "zip".
+func archiveFormat(fpath string) (string, error) {
+	switch strings.ToLower(filepath.Ext(fpath)) {
+	case ".tar":
+		return "tar", nil
+	case ".zip":
+		return "zip", nil
+	}
+	return "", fmt.Errorf("--into-archive %s: the archive must be a .tar or a .zip", fpath)
+}
+
+// fetchIntoArchive fetches t as fetchTarget would, then adds what was
+// written as entries of the --into-archive archive, creating it if needed.
+// The entries are named as the output would have been under --output-dir.
+// The fetch is written to a directory of its own beside the archive first,
+// and the archive is only replaced once rewritten in full with the new
+// entries, so it is valid whenever it is looked at, and left as it was if
+// the fetch fails.
+func fetchIntoArchive(ctx, fctx context.Context, c *cli.Context, s *ipget.Session, flags fetchFlags, t *target) error {
+	archive := c.String("into-archive")
+	format, err := archiveFormat(archive)
+	if err != nil {
+		return err
+	}
+	dir := c.String("output-dir")
+	if dir == "" {
+		dir = "."
+	}
+	rel, err := filepath.Rel(dir, t.outPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return cli.NewExitError(fmt.Sprintf("%s is not under --output-dir %s, it can't name an entry of %s", t.outPath, dir, archive), 2)
+	}
+	if err := makeParents(archive, c.Bool("normalize-permissions")); err != nil {
+		return cli.NewExitError(err, 2)
+	}
+	staging, err := ioutil.TempDir(filepath.Dir(archive), ".ipget-archive-")
+	if err != nil {
+		return cli.NewExitError(err, 2)
+	}
+	defer os.RemoveAll(staging)
+
+	outPath := t.outPath
+	t.outPath = filepath.Join(staging, rel)
+	err = fetchTarget(ctx, fctx, c, s, flags, t)
+	t.outPath = outPath
+	if err != nil {
+		return err
+	}
+	added, err := addToArchive(archive, format, staging, c.String("overwrite-policy"))
+	if err != nil {
+		return cli.NewExitError(err, 2)
+	}
+	if c.Bool("verbose") {
+		log.Printf("added %d entries to %s", added, archive)
+	}
+	return nil
+}
+
+// stagedEntry is a file, directory or symlink to add to an archive.
+type stagedEntry struct {
+	name  string
+	fpath string
+	fi    os.FileInfo
+}
+
+// stagedEntries lists what is under dir, parents first, named by their
+// slash-separated path relative to it.
+func stagedEntries(dir string) ([]stagedEntry, error) {
+	var entries []stagedEntry
+	err := filepath.Walk(dir, func(fpath string, fi os.FileInfo, err error) error {
+		if err != nil || fpath == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, fpath)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, stagedEntry{name: filepath.ToSlash(rel), fpath: fpath, fi: fi})
+		return nil
+	})
+	return entries, err
+}
+
+// archiveName is name as it is compared between entries, without a
+// leading ./ or the trailing / of directories.
+func archiveName(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+// addToArchive rewrites the archive at fpath with the entries staged under
+// dir added, and returns how many were. An entry with the name of one that
+// is there already is dealt with by policy, one of overwritePolicies, as
+// extraction deals with files that exist; a directory in both is merged.
+func addToArchive(fpath, format, dir, policy string) (int, error) {
+	staged, err := stagedEntries(dir)
+	if err != nil {
+		return 0, err
+	}
+	old, err := openArchive(fpath, format)
+	if err != nil {
+		return 0, err
+	}
+
+	// what the archive has already, and the sums of the files that may be
+	// compared with those staged
+	existing := map[string]bool{}
+	sums := map[string][]byte{}
+	stagedFiles := map[string]bool{}
+	for _, e := range staged {
+		if e.fi.Mode().IsRegular() {
+			stagedFiles[e.name] = true
+		}
+	}
+	err = old.each(func(name string, isDir bool, r io.Reader) error {
+		existing[name] = isDir || existing[name]
+		if policy == "if-different" && stagedFiles[name] && !isDir {
+			h := sha256.New()
+			if _, err := io.Copy(h, r); err != nil {
+				return err
+			}
+			sums[name] = h.Sum(nil)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("reading %s: %s", fpath, err)
+	}
+
+	dropped := map[string]bool{}
+	var add []stagedEntry
+	// renamed maps the directories the rename policy gave another name to
+	// that name, for everything below them; parents come first
+	renamed := map[string]string{}
+	for _, e := range staged {
+		name := renamedPath(e.name, renamed)
+		wasDir, taken := existing[name]
+		switch {
+		case !taken:
+		case wasDir && e.fi.IsDir():
+			// merged, the directory entry is there already
+			continue
+		case policy == "skip":
+			continue
+		case policy == "if-different" && e.fi.Mode().IsRegular() && sums[name] != nil:
+			sum, err := fileSum(e.fpath)
+			if err != nil {
+				return 0, err
+			}
+			if bytes.Equal(sum, sums[name]) {
+				continue
+			}
+			dropped[name] = true
+		case policy == "overwrite" || policy == "if-different":
+			dropped[name] = true
+		case policy == "rename":
+			ext := path.Ext(name)
+			base := strings.TrimSuffix(name, ext)
+			for i := 1; ; i++ {
+				candidate := fmt.Sprintf("%s.%d%s", base, i, ext)
+				if _, taken := existing[candidate]; !taken {
+					if e.fi.IsDir() {
+						renamed[e.name] = candidate
+					}
+					name = candidate
+					break
+				}
+			}
+			existing[name] = e.fi.IsDir()
+		default:
+			return 0, fmt.Errorf("%s is in %s already", name, fpath)
+		}
+		e.name = name
+		add = append(add, e)
+	}
+	if len(add) == 0 {
+		return 0, nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(fpath), "."+filepath.Base(fpath)+".")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	w := newArchiveWriter(tmp, format)
+	if err := old.copyTo(w, dropped); err != nil {
+		return 0, fmt.Errorf("copying the entries of %s: %s", fpath, err)
+	}
+	for _, e := range add {
+		if err := w.add(e); err != nil {
+			return 0, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	// TempFile creates it 0600, the archive keeps its mode
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(fpath); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return 0, err
+	}
+	return len(add), os.Rename(tmp.Name(), fpath)
+}
+
+// renamedPath returns name with its deepest directory that was renamed
+// replaced by its new name.
+func renamedPath(name string, renamed map[string]string) string {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if to, ok := renamed[dir]; ok {
+			return to + strings.TrimPrefix(name, dir)
+		}
+	}
+	return name
+}
+
+// fileSum returns the SHA-256 of the file at fpath.
+func fileSum(fpath string) ([]byte, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// oldArchive is the archive entries are added to, empty if it doesn't
+// exist yet.
+type oldArchive struct {
+	fpath, format string
+	missing       bool
+}
+
+func openArchive(fpath, format string) (*oldArchive, error) {
+	a := &oldArchive{fpath: fpath, format: format}
+	if _, err := os.Stat(fpath); os.IsNotExist(err) {
+		a.missing = true
+	} else if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// each calls fn with the name of every entry of the archive, whether it
+// is a directory, and its content.
+func (a *oldArchive) each(fn func(name string, isDir bool, r io.Reader) error) error {
+	if a.missing {
+		return nil
+	}
+	if a.format == "zip" {
+		zr, err := zip.OpenReader(a.fpath)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			r, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(archiveName(f.Name), f.FileInfo().IsDir(), r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	f, err := os.Open(a.fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(archiveName(hdr.Name), hdr.Typeflag == tar.TypeDir, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// copyTo copies the entries of the archive to w, but those dropped.
+func (a *oldArchive) copyTo(w *archiveWriter, dropped map[string]bool) error {
+	if a.missing {
+		return nil
+	}
+	if a.format == "zip" {
+		zr, err := zip.OpenReader(a.fpath)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if dropped[archiveName(f.Name)] {
+				continue
+			}
+			hdr := f.FileHeader
+			fw, err := w.zw.CreateHeader(&hdr)
+			if err != nil {
+				return err
+			}
+			r, err := f.Open()
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(fw, r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	f, err := os.Open(a.fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if dropped[archiveName(hdr.Name)] {
+			continue
+		}
+		if err := w.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w.tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// archiveWriter writes a tar or a zip archive.
+type archiveWriter struct {
+	tw *tar.Writer
+	zw *zip.Writer
+}
+
+func newArchiveWriter(w io.Writer, format string) *archiveWriter {
+	if format == "zip" {
+		return &archiveWriter{zw: zip.NewWriter(w)}
+	}
+	return &archiveWriter{tw: tar.NewWriter(w)}
+}
+
+// add writes e as an entry.
+func (w *archiveWriter) add(e stagedEntry) error {
+	var link string
+	if e.fi.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(e.fpath); err != nil {
+			return err
+		}
+	}
+	name := e.name
+	if e.fi.IsDir() {
+		name += "/"
+	}
+
+	var content io.Writer
+	if w.zw != nil {
+		hdr, err := zip.FileInfoHeader(e.fi)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if e.fi.Mode().IsRegular() {
+			hdr.Method = zip.Deflate
+		}
+		if content, err = w.zw.CreateHeader(hdr); err != nil {
+			return err
+		}
+		if link != "" {
+			_, err := io.WriteString(content, link)
+			return err
+		}
+	} else {
+		hdr, err := tar.FileInfoHeader(e.fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		// the staging directory's owner means nothing to whoever
+		// extracts the archive
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := w.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		content = w.tw
+	}
+	if !e.fi.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(e.fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(content, f)
+	return err
+}
+
+// Close writes the end of the archive.
+func (w *archiveWriter) Close() error {
+	if w.zw != nil {
+		return w.zw.Close()
+	}
+	return w.tw.Close()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ipfs/ipget"
+)
+
+// audit is the --audit-log of the run, nil when there is none.
+var audit *auditLog
+
+// auditFormat is the version of the audit log format, recorded at the
+// start of every run. It only changes if a field changes its meaning;
+// logs from before it was recorded are format 1.
+const auditFormat = 1
+
+// auditEvent is one line of an audit log. The format is documented in the
+// README and only ever grows new fields: empty ones are left out.
+type auditEvent struct {
+	Time time.Time `json:"time"`
+	// Event is one of run-start, resolve, fetch-start, fetch-complete,
+	// fetch-skip, fetch-fail and run-end.
+	Event string `json:"event"`
+	// PID tells apart the runs appending to the same log.
+	PID     int    `json:"pid"`
+	Format  int    `json:"format,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Dir is the working directory of the run, the outputs are relative
+	// to it.
+	Dir    string   `json:"dir,omitempty"`
+	Args   []string `json:"args,omitempty"`
+	Path   string   `json:"path,omitempty"`
+	CID    string   `json:"cid,omitempty"`
+	Output string   `json:"output,omitempty"`
+	Bytes  int64    `json:"bytes,omitempty"`
+	Files  int      `json:"files,omitempty"`
+	Peers  []string `json:"peers,omitempty"`
+	Error  string   `json:"error,omitempty"`
+	// Duration is in seconds.
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// auditLog appends a JSON line per event of the run to a file, whatever
+// is logged to stderr.
+type auditLog struct {
+	mu    sync.Mutex
+	f     *os.File
+	start time.Time
+	// failed is set once a write failed, which is only warned about once.
+	failed bool
+}
+
+// openAuditLog opens the audit log at fpath for appending and records the
+// start of the run, with args.
+func openAuditLog(fpath string, args []string) (*auditLog, error) {
+	f, err := os.OpenFile(fpath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	a := &auditLog{f: f, start: time.Now()}
+	dir, _ := os.Getwd()
+	a.log(auditEvent{Event: "run-start", Format: auditFormat, Version: version, Dir: dir, Args: args})
+	return a, nil
+}
+
+// log appends ev, stamped with the time and the process.
+func (a *auditLog) log(ev auditEvent) {
+	if a == nil {
+		return
+	}
+	ev.Time, ev.PID = time.Now().UTC(), os.Getpid()
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// a single write per line, so concurrent runs don't interleave
+	if _, err := a.f.Write(append(line, '\n')); err != nil && !a.failed {
+		a.failed = true
+		log.Printf("WARNING: writing the audit log: %s", err)
+	}
+}
+
+// fetchStart records that t is about to be fetched.
+func (a *auditLog) fetchStart(t *target) {
+	a.log(auditEvent{Event: "fetch-start", Path: t.name, Output: t.outPath})
+}
+
+// fetchEnd records what t resolved to, and whether fetching it succeeded,
+// was skipped by --since or failed, from its summary entry ps.
+func (a *auditLog) fetchEnd(t *target, ps *ipget.PathSummary, err error) {
+	if a == nil {
+		return
+	}
+	if ps.CID != "" {
+		a.log(auditEvent{Event: "resolve", Path: t.name, CID: ps.CID})
+	}
+	ev := auditEvent{
+		Event:  "fetch-complete",
+		Path:   t.name,
+		CID:    ps.CID,
+		Output: ps.Output,
+		Bytes:  ps.Bytes,
+		Files:  len(ps.Files),
+		Peers:  ps.Peers,
+	}
+	switch {
+	case ps.NotModified:
+		ev.Event = "fetch-skip"
+	case err != nil:
+		ev.Event, ev.Error = "fetch-fail", err.Error()
+	}
+	a.log(ev)
+}
+
+// close records the end of the run, with the error it ended with if any.
+func (a *auditLog) close(err error) {
+	if a == nil {
+		return
+	}
+	ev := auditEvent{Event: "run-end", Duration: time.Since(a.start).Seconds()}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	a.log(ev)
+	a.f.Sync()
+	a.f.Close()
+}
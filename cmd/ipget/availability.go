@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/ipfs/ipget"
+)
+
+// The exit codes of a fetch that got nothing, by how far it got.
+const (
+	// exitNoProviders is used when no provider of the content was found.
+	exitNoProviders = 5
+	// exitUndialable is used when providers were found but none could be
+	// dialed, and by connect when its peer can't be.
+	exitUndialable = 6
+	// exitNotServed is used when providers were dialed but none sent a
+	// block.
+	exitNotServed = 7
+)
+
+// unavailableExitCode returns the exit code of err, an error returned by
+// Session.Unavailable.
+func unavailableExitCode(err error) int {
+	switch {
+	case errors.Is(err, ipget.ErrNoProviders):
+		return exitNoProviders
+	case errors.Is(err, ipget.ErrUndialable):
+		return exitUndialable
+	default:
+		return exitNotServed
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	cli "github.com/urfave/cli"
+)
+
+// templatePlaceholder matches the placeholders of an --out-template.
+var templatePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// templateFields are the placeholders an --out-template may use.
+var templateFields = map[string]bool{
+	"cid":   true,
+	"name":  true,
+	"index": true,
+	"ipns":  true,
+}
+
+// outTemplate names the outputs of a batch fetch, e.g. "{index}-{cid}".
+type outTemplate struct {
+	tmpl string
+	uses map[string]bool
+}
+
+// templateVars are the values of the placeholders for one path.
+type templateVars struct {
+	// cid is the CID the path resolves to.
+	cid string
+	// name is the last segment of the path, the name of its directory
+	// entry if it has one.
+	name string
+	// index counts the paths from 1, in the order they were given.
+	index int
+	// ipns is the name an /ipns path resolves, a key or a domain.
+	ipns string
+}
+
+func parseOutTemplate(tmpl string) (*outTemplate, error) {
+	if tmpl == "" {
+		return nil, fmt.Errorf("--out-template must not be empty")
+	}
+	t := &outTemplate{tmpl: tmpl, uses: map[string]bool{}}
+	for _, ph := range templatePlaceholder.FindAllString(tmpl, -1) {
+		field := ph[1 : len(ph)-1]
+		if !templateFields[field] {
+			return nil, fmt.Errorf("unknown placeholder %s in --out-template, must be one of {cid}, {name}, {index} or {ipns}", ph)
+		}
+		t.uses[field] = true
+	}
+	if rest := templatePlaceholder.ReplaceAllString(tmpl, ""); strings.ContainsAny(rest, "{}") {
+		return nil, fmt.Errorf("unbalanced brace in --out-template %q", tmpl)
+	}
+	return t, nil
+}
+
+func (t *outTemplate) expand(v templateVars) string {
+	return templatePlaceholder.ReplaceAllStringFunc(t.tmpl, func(ph string) string {
+		switch ph {
+		case "{cid}":
+			return v.cid
+		case "{name}":
+			return v.name
+		case "{index}":
+			return strconv.Itoa(v.index)
+		default:
+			return v.ipns
+		}
+	})
+}
+
+// fetchBatch fetches every path given on the command line through a single
+// session, one after the other, naming the outputs after --out-template if
+// it is set. No two paths may be written to the same place. A path --since
+// finds unmodified is skipped.
+func fetchBatch(ctx context.Context, c *cli.Context) (err error) {
+	if outputFlag(c) != "" {
+		return fmt.Errorf("-o names a single output, it can't be used with --out-template or several paths")
+	}
+	if c.Bool("announce") && c.NArg() > 1 {
+		return fmt.Errorf("--announce serves a single path until interrupted, it can't be used with several")
+	}
+	var tmpl *outTemplate
+	if c.IsSet("out-template") {
+		var err error
+		if tmpl, err = parseOutTemplate(c.String("out-template")); err != nil {
+			return err
+		}
+		if c.NArg() > 1 && !tmpl.uses["cid"] && !tmpl.uses["index"] && !tmpl.uses["name"] && !tmpl.uses["ipns"] {
+			return fmt.Errorf("--out-template %q would write every path to the same place", tmpl.tmpl)
+		}
+	}
+
+	dnslink := newDNSLinkResolver(c.String("dns-server"), c.BoolT("dnslink"))
+	targets := make([]*target, c.NArg())
+	vars := make([]templateVars, c.NArg())
+	for i, arg := range c.Args() {
+		t, err := newTarget(ctx, c, dnslink, arg)
+		if err != nil {
+			return err
+		}
+		targets[i] = t
+		vars[i] = templateVars{name: t.entry, index: i + 1}
+		if tmpl != nil && tmpl.uses["ipns"] {
+			parts := strings.Split(strings.Trim(t.name, "/"), "/")
+			if parts[0] != "ipns" {
+				return fmt.Errorf("--out-template uses {ipns} but %s is not an /ipns path", t.name)
+			}
+			vars[i].ipns = parts[1]
+		}
+	}
+	if c.Bool("resolve-only") {
+		for _, t := range targets {
+			if err := resolveOnly(ctx, c, t.path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	sum := startSummary(c, targets)
+	defer func() { err = finishSummary(c, sum, err) }()
+	flags, err := checkFetchFlags(c)
+	if err != nil {
+		return err
+	}
+	if c.Int("max-connections-per-fetch") < 0 {
+		return fmt.Errorf("--max-connections-per-fetch must not be negative")
+	}
+
+	s, fctx, stop, err := openSession(ctx, c, flags.deadline)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	defer stop()
+
+	if tmpl != nil {
+		for i, t := range targets {
+			if tmpl.uses["cid"] {
+				// fetch what the output is named after, even if the
+				// path moves on meanwhile
+				if t.resolved, err = s.Resolve(fctx, t.path); err != nil {
+					if ctx.Err() != nil {
+						return cli.NewExitError("interrupted", exitInterrupted)
+					}
+					return cli.NewExitError(err, 2)
+				}
+				vars[i].cid = t.resolved.Cid().String()
+			}
+			if t.outPath, err = outputUnder(c.String("output-dir"), tmpl.expand(vars[i])); err != nil {
+				return cli.NewExitError(err, 2)
+			}
+			t.namedByHash = false
+		}
+	}
+	// resolved in the background meanwhile, the names are ready by the
+	// time their turn comes
+	rctx, cancel := context.WithCancel(fctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+	for _, t := range targets {
+		if len(targets) == 1 || t.resolved != nil || t.path.Namespace() != "ipns" {
+			continue
+		}
+		wg.Add(1)
+		go func(t *target) {
+			defer wg.Done()
+			// a failure is reported when the path is fetched
+			s.Resolve(rctx, t.path)
+		}(t)
+	}
+
+	written := map[string]string{}
+	for _, t := range targets {
+		out := filepath.Clean(t.outPath)
+		if other, ok := written[out]; ok {
+			return fmt.Errorf("%s and %s would both be written to %s", other, t.name, out)
+		}
+		written[out] = t.name
+	}
+
+	if c.Bool("trim-after") && !s.Embedded() {
+		log.Printf("WARNING: --trim-after only trims the repo of a spawned node, the daemon's is left as it is")
+	}
+	unmodified := 0
+	for i, t := range targets {
+		err := fetchOne(ctx, fctx, c, s, flags, t)
+		if ec, ok := err.(cli.ExitCoder); ok && ec.ExitCode() == exitNotModified {
+			log.Print(err)
+			unmodified++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if c.Bool("trim-after") {
+			trimAfter(fctx, c, s, t)
+		}
+		if c.IsSet("max-connections-per-fetch") && i < len(targets)-1 {
+			if closed := s.TrimConns(c.Int("max-connections-per-fetch")); closed > 0 {
+				log.Printf("closed %d connections before the next path", closed)
+			}
+		}
+	}
+	if unmodified == len(targets) {
+		return cli.NewExitError("nothing modified", exitNotModified)
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cli "github.com/urfave/cli"
+)
+
+// batchEntry is one path to fetch listed in a --batch file.
+type batchEntry struct {
+	// Path is an IPFS or IPNS path, or anything else ipget takes as one.
+	Path string `json:"path"`
+	// Output is where it is written, relative to --output-dir. Empty
+	// names it after the last segment of the path, as on the command line.
+	Output string `json:"output,omitempty"`
+	// Subpath, if set, is joined to Path.
+	Subpath string `json:"subpath,omitempty"`
+	// Checksum, if set, is the expected "<algo>:<hex>" sum of the file
+	// written, one of the --checksum algorithms.
+	Checksum string `json:"checksum,omitempty"`
+	// exactOutput takes Output as it is, rather than under --output-dir,
+	// for --replay.
+	exactOutput bool
+}
+
+// batchColumns are the columns of a CSV --batch file, named on its first
+// line. Only path is required.
+var batchColumns = []string{"path", "output", "subpath", "checksum"}
+
+// readBatchFile reads and validates the --batch file at fpath: a JSON
+// array of entries if it ends in .json, CSV otherwise.
+func readBatchFile(fpath string) ([]batchEntry, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []batchEntry
+	if strings.EqualFold(filepath.Ext(fpath), ".json") {
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&entries); err != nil {
+			return nil, fmt.Errorf("%s: %s", fpath, err)
+		}
+	} else if entries, err = readBatchCSV(f); err != nil {
+		return nil, fmt.Errorf("%s: %s", fpath, err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s lists nothing to fetch", fpath)
+	}
+	for i, e := range entries {
+		if err := e.check(); err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %s", fpath, i+1, err)
+		}
+	}
+	return entries, nil
+}
+
+func readBatchCSV(r io.Reader) ([]batchEntry, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cols := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !contains(batchColumns, name) {
+			return nil, fmt.Errorf("unknown column %q, the columns are %s", name, strings.Join(batchColumns, ", "))
+		}
+		cols[name] = i
+	}
+	if _, ok := cols["path"]; !ok {
+		return nil, fmt.Errorf("the first line must name the columns, with a path column")
+	}
+	var entries []batchEntry
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := cols[name]; ok {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		entries = append(entries, batchEntry{
+			Path:     field("path"),
+			Output:   field("output"),
+			Subpath:  field("subpath"),
+			Checksum: field("checksum"),
+		})
+	}
+}
+
+func (e batchEntry) check() error {
+	if e.Path == "" {
+		return fmt.Errorf("no path")
+	}
+	if e.Output == "-" {
+		return fmt.Errorf("stdout carries the results, it can't be written to")
+	}
+	if e.Checksum != "" {
+		if _, _, err := parseExpectedSum(e.Checksum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseExpectedSum parses a checksum given as "<algo>:<hex>".
+func parseExpectedSum(s string) (string, []byte, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return "", nil, fmt.Errorf("checksum %q must look like <algorithm>:<hex digest>", s)
+	}
+	algo := strings.ToLower(s[:i])
+	if err := checkChecksum(algo); err != nil {
+		return "", nil, err
+	}
+	sum, err := hex.DecodeString(s[i+1:])
+	if err != nil || len(sum) != checksums[algo]().Size() {
+		return "", nil, fmt.Errorf("checksum %q is not a hex %s digest", s, algo)
+	}
+	return algo, sum, nil
+}
+
+// checkFileSum makes sure the file at fpath hashes to the expected sum.
+func checkFileSum(fpath, expected string) error {
+	algo, want, err := parseExpectedSum(expected)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil {
+		return err
+	} else if fi.IsDir() {
+		return fmt.Errorf("%s is a directory, a checksum is for a file", fpath)
+	}
+	h := checksums[algo]()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, want) {
+		return fmt.Errorf("%s has the %s sum %x, not %x", fpath, algo, got, want)
+	}
+	return nil
+}
+
+// fetchBatchFile fetches the entries of the --batch file, the pins of the
+// --from-pinlist one, or the fetches of the --replay audit log, through a
+// single session, one after the other,
+// writing a result line to w for each as --server-stdin does: "ok <path>
+// <output>" or "error <path> <message>". A failed entry doesn't stop the
+// others, but makes the exit status 2.
+func fetchBatchFile(ctx context.Context, c *cli.Context, w io.Writer) (err error) {
+	flag, sources := "", 0
+	for _, name := range []string{"batch", "from-pinlist", "replay"} {
+		if c.String(name) != "" {
+			flag = "--" + name
+			sources++
+		}
+	}
+	switch {
+	case sources > 1:
+		return fmt.Errorf("--batch, --from-pinlist and --replay each list what to fetch, pick one")
+	case flag == "--replay" && c.IsSet("output-dir"):
+		return fmt.Errorf("--replay writes to the outputs the audit log records, not under --output-dir")
+	case c.Args().Present():
+		return fmt.Errorf("%s reads the paths to fetch from its file, not from arguments", flag)
+	case c.IsSet("output") || c.IsSet("out-template"):
+		return fmt.Errorf("%s takes the output of each path from its file, not from -o or --out-template", flag)
+	case c.Bool("watch") || c.Bool("announce"):
+		return fmt.Errorf("%s can't be combined with --watch or --announce, which never return", flag)
+	case c.Bool("estimate") && !c.Bool("yes"):
+		return fmt.Errorf("%s prints its results on stdout, --estimate can't ask there; add --yes", flag)
+	}
+	var (
+		entries []batchEntry
+		replay  []replayOp
+	)
+	if fpath := c.String("replay"); fpath != "" {
+		if replay, err = readReplayLog(fpath); err != nil {
+			return err
+		}
+		entries = replayEntries(replay)
+	} else if fpath := c.String("from-pinlist"); fpath != "" {
+		var indirect int
+		if entries, indirect, err = readPinList(fpath); err != nil {
+			return err
+		}
+		if indirect > 0 {
+			log.Printf("skipping %d indirect pins, they come with the recursive pins that hold them", indirect)
+		}
+	} else if entries, err = readBatchFile(c.String("batch")); err != nil {
+		return err
+	}
+	flags, err := checkFetchFlags(c)
+	if err != nil {
+		return err
+	}
+
+	// every entry is checked before anything is fetched
+	dnslink := newDNSLinkResolver(c.String("dns-server"), c.BoolT("dnslink"))
+	targets := make([]*target, len(entries))
+	written := map[string]string{}
+	for i, e := range entries {
+		if e.Checksum != "" && c.String("into-archive") != "" {
+			return fmt.Errorf("entry %d: checksums are checked against the files written, --into-archive writes none", i+1)
+		}
+		p := e.Path
+		if e.Subpath != "" {
+			p = strings.TrimSuffix(p, "/") + "/" + strings.TrimPrefix(e.Subpath, "/")
+		}
+		t, err := newTarget(ctx, c, dnslink, p)
+		if err != nil {
+			return fmt.Errorf("entry %d: %s", i+1, err)
+		}
+		if e.exactOutput {
+			t.outPath, t.namedByHash = e.Output, false
+		} else if e.Output != "" {
+			if t.outPath, err = outputUnder(c.String("output-dir"), e.Output); err != nil {
+				return fmt.Errorf("entry %d: %s", i+1, err)
+			}
+			t.namedByHash = false
+		}
+		out := filepath.Clean(t.outPath)
+		if other, ok := written[out]; ok {
+			return fmt.Errorf("%s and %s would both be written to %s", other, t.name, out)
+		}
+		written[out] = t.name
+		targets[i] = t
+	}
+
+	sum := startSummary(c, targets)
+	defer func() { err = finishSummary(c, sum, err) }()
+	s, fctx, stop, err := openSession(ctx, c, flags.deadline)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	defer stop()
+
+	if replay != nil {
+		checkReplayedNames(fctx, c, s, dnslink, replay)
+	}
+	if c.Bool("trim-after") && !s.Embedded() {
+		log.Printf("WARNING: --trim-after only trims the repo of a spawned node, the daemon's is left as it is")
+	}
+	failed := 0
+	for i, t := range targets {
+		err := fetchOne(ctx, fctx, c, s, flags, t)
+		if err == nil && entries[i].Checksum != "" {
+			if err = checkFileSum(t.outPath, entries[i].Checksum); err != nil && t.summary != nil {
+				t.summary.Error = err.Error()
+			}
+		}
+		if ctx.Err() != nil {
+			fmt.Fprintf(w, "error %s interrupted\n", t.name)
+			return cli.NewExitError("interrupted", exitInterrupted)
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "error %s %s\n", t.name, strings.TrimSpace(err.Error()))
+			continue
+		}
+		fmt.Fprintf(w, "ok %s %s\n", t.name, t.outPath)
+		if c.Bool("trim-after") {
+			trimAfter(fctx, c, s, t)
+		}
+	}
+	if failed > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d of %d entries failed", failed, len(targets)), 2)
+	}
+	return nil
+}
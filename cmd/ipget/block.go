@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/ipget/internal/dagutil"
+	cli "github.com/urfave/cli"
+)
+
+func blockCommand(ctx context.Context) cli.Command {
+	timeout := cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "give up on the block after this long, 0 waits for it",
+	}
+	return cli.Command{
+		Name:  "block",
+		Usage: "fetch or describe a single raw block, without reading it as a file or following its links",
+		Subcommands: []cli.Command{
+			{
+				Name:      "get",
+				Usage:     "write the bytes of a block as they are stored",
+				ArgsUsage: "<cid>",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "output,o",
+						Usage: "file to write the block to, stdout by default",
+					},
+					timeout,
+				},
+				Action: func(c *cli.Context) error {
+					b, err := fetchBlock(ctx, c, "get")
+					if err != nil {
+						return err
+					}
+					if out := c.String("output"); out != "" && out != "-" {
+						return ioutil.WriteFile(out, b.RawData(), 0666)
+					}
+					_, err = os.Stdout.Write(b.RawData())
+					return err
+				},
+			},
+			{
+				Name:      "stat",
+				Usage:     "print the size, codec and hash function of a block",
+				ArgsUsage: "<cid>",
+				Flags:     []cli.Flag{timeout},
+				Action: func(c *cli.Context) error {
+					b, err := fetchBlock(ctx, c, "stat")
+					if err != nil {
+						return err
+					}
+					pref := b.Cid().Prefix()
+					codec, ok := cid.CodecToStr[pref.Codec]
+					if !ok {
+						codec = fmt.Sprintf("0x%x", pref.Codec)
+					}
+					fmt.Printf("cid: %s\n", b.Cid())
+					fmt.Printf("size: %d\n", len(b.RawData()))
+					fmt.Printf("codec: %s\n", codec)
+					fmt.Printf("hash: %s\n", dagutil.HashName(pref.MhType))
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// fetchBlock fetches the block named by the argument of the block
+// subcommand cmd, a CID or an /ipfs/<cid> path.
+func fetchBlock(ctx context.Context, c *cli.Context, cmd string) (blocks.Block, error) {
+	if c.NArg() != 1 {
+		return nil, fmt.Errorf("usage: ipget block %s <cid>\n", cmd)
+	}
+	arg := strings.TrimPrefix(c.Args().First(), "/ipfs/")
+	bc, err := cid.Decode(arg)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a CID: %s", c.Args().First(), err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if t := c.Duration("timeout"); t > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t)
+		defer cancel()
+	}
+	s, err := setupNode(ctx, c, c.GlobalInt("max-block-size"))
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	start := time.Now()
+	b, err := s.Block(ctx, bc)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, cli.NewExitError(fmt.Sprintf("no block %s after %s", bc, time.Since(start).Round(time.Second)), 2)
+		}
+		if ctx.Err() != nil {
+			return nil, cli.NewExitError("interrupted", exitInterrupted)
+		}
+		return nil, cli.NewExitError(err, 2)
+	}
+	return b, nil
+}
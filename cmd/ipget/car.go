@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/ipget/internal/dagutil"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// carIndexSorted is the multicodec of the sorted CARv2 index.
+const carIndexSorted = 0x0400
+
+// checkCARVersion makes sure we know how to write the requested version.
+func checkCARVersion(version int) error {
+	if version != 1 && version != 2 {
+		return fmt.Errorf("unsupported CAR version %d, must be 1 or 2", version)
+	}
+	return nil
+}
+
+// writeCAR writes the DAGs under roots, fetched through dag, to w as a CAR
+// of the given version. Every root is listed in the header and every block
+// is written once, in depth-first order.
+//
+// CARv2 needs to go back and fill in its header once the data is written,
+// so w must also be an io.Seeker for it.
+func writeCAR(ctx context.Context, dag ipld.NodeGetter, roots []cid.Cid, w io.Writer, version int) error {
+	if err := checkCARVersion(version); err != nil {
+		return err
+	}
+	if version == 1 {
+		_, err := writeCARv1(ctx, dag, roots, w)
+		return err
+	}
+
+	ws, ok := w.(io.WriteSeeker)
+	if !ok {
+		return fmt.Errorf("CARv2 output must be seekable")
+	}
+
+	start, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	// Reserve room for the header, we only know the offsets at the end.
+	if _, err := ws.Write(dagutil.CARv2Pragma); err != nil {
+		return err
+	}
+	if _, err := ws.Write(make([]byte, dagutil.CARv2HeaderLen)); err != nil {
+		return err
+	}
+
+	cw := &countingWriter{w: ws}
+	offsets, err := writeCARv1(ctx, dag, roots, cw)
+	if err != nil {
+		return err
+	}
+	if err := writeCARIndex(ws, offsets); err != nil {
+		return err
+	}
+	end, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	dataOffset := uint64(len(dagutil.CARv2Pragma) + dagutil.CARv2HeaderLen)
+	header := make([]byte, dagutil.CARv2HeaderLen)
+	// the first 16 bytes are characteristics, none of which we set
+	binary.LittleEndian.PutUint64(header[16:], dataOffset)
+	binary.LittleEndian.PutUint64(header[24:], uint64(cw.n))
+	binary.LittleEndian.PutUint64(header[32:], dataOffset+uint64(cw.n))
+	if _, err := ws.Seek(start+int64(len(dagutil.CARv2Pragma)), io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := ws.Write(header); err != nil {
+		return err
+	}
+	_, err = ws.Seek(end, io.SeekStart)
+	return err
+}
+
+// writeCARv1 writes a CARv1 to w and returns the offset of every block's
+// section, relative to the start of the CAR.
+func writeCARv1(ctx context.Context, dag ipld.NodeGetter, roots []cid.Cid, w io.Writer) (map[cid.Cid]uint64, error) {
+	cw := &countingWriter{w: w}
+	if err := writeSection(cw, carHeader(roots)); err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[cid.Cid]uint64)
+	var walk func(c cid.Cid) error
+	walk = func(c cid.Cid) error {
+		if _, ok := offsets[c]; ok {
+			return nil
+		}
+		nd, err := dag.Get(ctx, c)
+		if err != nil {
+			return err
+		}
+		offsets[c] = uint64(cw.n)
+		if err := writeSection(cw, c.Bytes(), nd.RawData()); err != nil {
+			return err
+		}
+		for _, l := range nd.Links() {
+			if err := walk(l.Cid); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, root := range roots {
+		if err := walk(root); err != nil {
+			return nil, err
+		}
+	}
+	return offsets, nil
+}
+
+// writeSection writes the concatenation of parts prefixed by its varint
+// length.
+func writeSection(w io.Writer, parts ...[]byte) error {
+	size := 0
+	for _, p := range parts {
+		size += len(p)
+	}
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(size))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// carHeader returns the dag-cbor encoding of {"roots": roots, "version": 1}.
+func carHeader(roots []cid.Cid) []byte {
+	var b bytes.Buffer
+	b.WriteByte(0xa2) // map of two entries, keys in canonical order
+	b.Write(cborHead(3, 5))
+	b.WriteString("roots")
+	b.Write(cborHead(4, uint64(len(roots))))
+	for _, root := range roots {
+		// CIDs are tag 42 over a byte string with a leading zero byte
+		b.Write([]byte{0xd8, 0x2a})
+		raw := root.Bytes()
+		b.Write(cborHead(2, uint64(len(raw)+1)))
+		b.WriteByte(0)
+		b.Write(raw)
+	}
+	b.Write(cborHead(3, 7))
+	b.WriteString("version")
+	b.Write(cborHead(0, 1))
+	return b.Bytes()
+}
+
+// cborHead encodes the head of a CBOR item of the given major type.
+func cborHead(major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return []byte{major | byte(n)}
+	case n <= 0xff:
+		return []byte{major | 24, byte(n)}
+	case n <= 0xffff:
+		b := []byte{major | 25, 0, 0}
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		return b
+	case n <= 0xffffffff:
+		b := []byte{major | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		return b
+	default:
+		b := []byte{major | 27, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(b[1:], n)
+		return b
+	}
+}
+
+// writeCARIndex writes a sorted CARv2 index of the block offsets: one bucket
+// per digest length, each holding the digests in order followed by their
+// offset.
+func writeCARIndex(w io.Writer, offsets map[cid.Cid]uint64) error {
+	buckets := make(map[uint32][][]byte)
+	for c, off := range offsets {
+		dmh, err := mh.Decode(c.Hash())
+		if err != nil {
+			return err
+		}
+		entry := make([]byte, len(dmh.Digest)+8)
+		copy(entry, dmh.Digest)
+		binary.LittleEndian.PutUint64(entry[len(dmh.Digest):], off)
+		width := uint32(len(entry))
+		buckets[width] = append(buckets[width], entry)
+	}
+
+	widths := make([]uint32, 0, len(buckets))
+	for width := range buckets {
+		widths = append(widths, width)
+	}
+	sort.Slice(widths, func(i, j int) bool { return widths[i] < widths[j] })
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, carIndexSorted)
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, int32(len(widths))); err != nil {
+		return err
+	}
+	for _, width := range widths {
+		entries := buckets[width]
+		sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i], entries[j]) < 0 })
+		if err := binary.Write(w, binary.LittleEndian, width); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, int64(len(entries))*int64(width)); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if _, err := w.Write(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
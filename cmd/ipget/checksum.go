@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/ipget"
+	"golang.org/x/crypto/blake2b"
+)
+
+// checksums maps the algorithms accepted by --checksum to their hash. The
+// blake2b sums are the 512 bit ones b2sum writes by default.
+var checksums = map[string]func() hash.Hash{
+	"sha256":  sha256.New,
+	"sha512":  sha512.New,
+	"blake2b": newBlake2b,
+}
+
+func newBlake2b() hash.Hash {
+	// only fails for keys that are too long
+	h, _ := blake2b.New512(nil)
+	return h
+}
+
+// parseChecksums parses the comma separated list of algorithms given to
+// --checksum, dropping repeats.
+func parseChecksums(spec string) ([]string, error) {
+	var algos []string
+	seen := make(map[string]bool)
+	for _, algo := range strings.Split(spec, ",") {
+		algo = strings.ToLower(strings.TrimSpace(algo))
+		if err := checkChecksum(algo); err != nil {
+			return nil, err
+		}
+		if !seen[algo] {
+			seen[algo] = true
+			algos = append(algos, algo)
+		}
+	}
+	return algos, nil
+}
+
+// checkChecksum makes sure algo is one we know.
+func checkChecksum(algo string) error {
+	if _, ok := checksums[algo]; ok {
+		return nil
+	}
+	if algo == "blake3" {
+		// neither the standard library nor x/crypto has it, and a hash
+		// isn't something to write ourselves
+		return fmt.Errorf("--checksum blake3 isn't supported, ipget has no BLAKE3 implementation to use; sha256, sha512 and blake2b are")
+	}
+	known := make([]string, 0, len(checksums))
+	for name := range checksums {
+		known = append(known, name)
+	}
+	sort.Strings(known)
+	return fmt.Errorf("unknown checksum algorithm %q, must be one of %s", algo, strings.Join(known, ", "))
+}
+
+// writeSidecar writes sum for the file at fpath to fpath.<algo> on fs, in
+// the format of sha256sum and friends so it can be checked with their -c
+// flag.
+func writeSidecar(fs ipget.FS, fpath, algo string, sum []byte) error {
+	line := fmt.Sprintf("%x  %s\n", sum, filepath.Base(fpath))
+	f, err := fs.Create(fpath + "." + algo)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// sums hashes what is written to it with several algorithms at once.
+type sums struct {
+	algos  []string
+	hashes []hash.Hash
+}
+
+func newSums(algos []string) *sums {
+	s := &sums{algos: algos}
+	for _, algo := range algos {
+		s.hashes = append(s.hashes, checksums[algo]())
+	}
+	return s
+}
+
+// writer returns a writer feeding all the hashes.
+func (s *sums) writer() io.Writer {
+	ws := make([]io.Writer, len(s.hashes))
+	for i, h := range s.hashes {
+		ws[i] = h
+	}
+	return io.MultiWriter(ws...)
+}
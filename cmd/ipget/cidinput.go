@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// multibaseAlphabets are the characters each of the multibase encodings CIDs
+// commonly come in may contain, after their prefix. They are only used to
+// point at the culprit when a CID doesn't decode.
+var multibaseAlphabets = map[byte]struct {
+	name     string
+	alphabet string
+}{
+	'b': {"base32", "abcdefghijklmnopqrstuvwxyz234567"},
+	'B': {"base32upper", "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"},
+	'z': {"base58btc", base58Alphabet},
+	'f': {"base16", "0123456789abcdef"},
+	'F': {"base16upper", "0123456789ABCDEF"},
+	'm': {"base64", "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"},
+	'u': {"base64url", "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"},
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// caseInsensitiveBases are the multibase prefixes of encodings whose case
+// doesn't matter, so a CID in them survives having its case mangled.
+const caseInsensitiveBases = "bBfF"
+
+// normalizePath turns p, a path or a bare CID, into an IPFS path. The CID of
+// an /ipfs/ path is accepted in any case for encodings that don't depend on
+// it, and a CID that can't be decoded is reported with what's wrong with it.
+func normalizePath(p string) (ipath.Path, error) {
+	if ipfsPath := ipath.New(p); ipfsPath.IsValid() == nil {
+		return ipfsPath, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/ipfs/" + p
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 3)
+	if len(parts) < 2 || parts[1] == "" {
+		return nil, fmt.Errorf("%q is not an IPFS path", p)
+	}
+	switch parts[0] {
+	case "ipfs", "ipld":
+		c, err := parseCID(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		parts[1] = c.String()
+	case "ipns":
+	default:
+		return nil, fmt.Errorf("%q is not an IPFS path, %q is not a namespace", p, parts[0])
+	}
+	ipfsPath := ipath.New("/" + strings.Join(parts, "/"))
+	return ipfsPath, ipfsPath.IsValid()
+}
+
+// parseCID decodes s, trying it in lowercase too when its encoding is case
+// insensitive.
+func parseCID(s string) (cid.Cid, error) {
+	c, err := cid.Decode(s)
+	if err == nil {
+		return c, nil
+	}
+	if s != "" && strings.IndexByte(caseInsensitiveBases, s[0]) >= 0 {
+		if c, err := cid.Decode(strings.ToLower(s)); err == nil {
+			return c, nil
+		}
+	}
+	return cid.Undef, cidError(s, err)
+}
+
+// cidError explains why s isn't a CID, pointing at the first character that
+// doesn't belong when there is one.
+func cidError(s string, err error) error {
+	if len(s) < 2 {
+		return fmt.Errorf("invalid CID %q: too short", s)
+	}
+
+	// CIDv0 are 46 base58btc characters starting with Qm and have no
+	// multibase prefix.
+	if strings.HasPrefix(s, "Qm") {
+		if i := strings.IndexFunc(s, notIn(base58Alphabet)); i >= 0 {
+			return fmt.Errorf("invalid CID %q: %q at position %d is not a base58btc character", s, s[i], i+1)
+		}
+		if len(s) != 46 {
+			return fmt.Errorf("invalid CID %q: a CIDv0 is 46 characters long, not %d", s, len(s))
+		}
+		return fmt.Errorf("invalid CID %q: %s", s, err)
+	}
+
+	base, ok := multibaseAlphabets[s[0]]
+	if !ok {
+		return fmt.Errorf("invalid CID %q: %q is not a known multibase prefix and a CIDv0 starts with Qm", s, s[0])
+	}
+	if i := strings.IndexFunc(s[1:], notIn(base.alphabet)); i >= 0 {
+		return fmt.Errorf("invalid CID %q: %q at position %d is not a %s character", s, s[i+1], i+2, base.name)
+	}
+	return fmt.Errorf("invalid CID %q: %s", s, err)
+}
+
+func notIn(alphabet string) func(rune) bool {
+	return func(r rune) bool {
+		return !strings.ContainsRune(alphabet, r)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/ipfs/ipget"
+	cli "github.com/urfave/cli"
+)
+
+// exitDiffer is the exit code of verify when the file differs, as with cmp.
+const exitDiffer = 1
+
+func compareCommand(ctx context.Context) cli.Command {
+	return cli.Command{
+		Name:      "verify",
+		Usage:     "compare an IPFS file byte for byte with a local one, without writing anything",
+		ArgsUsage: "<ipfs ref> <local file>",
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 2 {
+				return fmt.Errorf("usage: ipget verify <ipfs ref> <local file>\n")
+			}
+			localPath := c.Args().Get(1)
+			local, err := os.Open(localPath)
+			if err != nil {
+				return err
+			}
+			defer local.Close()
+			fi, err := local.Stat()
+			if err != nil {
+				return err
+			}
+
+			iPath, err := parsePath(c.Args().First(), c.GlobalBool("use-url-host"))
+			if err != nil {
+				return err
+			}
+			dnslink := newDNSLinkResolver(c.GlobalString("dns-server"), c.GlobalBoolT("dnslink"))
+			iPath, err = dnslink.resolve(ctx, iPath)
+			if err != nil {
+				return err
+			}
+
+			s, err := setupNode(ctx, c, c.GlobalInt("max-block-size"))
+			if err != nil {
+				return err
+			}
+			defer s.Close()
+			fctx, stop := s.WatchBlockSize(ctx)
+			defer stop()
+
+			nd, err := s.Get(fctx, iPath)
+			if err != nil {
+				if err := s.BlockSizeErr(); err != nil {
+					return cli.NewExitError(err, 2)
+				}
+				if ctx.Err() != nil {
+					return cli.NewExitError("interrupted", exitInterrupted)
+				}
+				return cli.NewExitError(err, 2)
+			}
+			defer nd.Close()
+			f, ok := nd.(files.File)
+			if !ok {
+				return cli.NewExitError(fmt.Sprintf("%s is not a file", iPath), 2)
+			}
+			size, err := f.Size()
+			if err != nil {
+				return cli.NewExitError(err, 2)
+			}
+
+			off, same, err := firstDifference(f, local)
+			if err != nil {
+				if err := s.BlockSizeErr(); err != nil {
+					return cli.NewExitError(err, 2)
+				}
+				if ctx.Err() != nil {
+					return cli.NewExitError("interrupted", exitInterrupted)
+				}
+				return cli.NewExitError(err, 2)
+			}
+			if same {
+				fmt.Printf("%s matches %s (%d bytes)\n", localPath, iPath, size)
+				return nil
+			}
+			msg := fmt.Sprintf("%s differs from %s at offset %d", localPath, iPath, off)
+			if size != fi.Size() {
+				msg += fmt.Sprintf(" (%d bytes, %d locally)", size, fi.Size())
+			}
+			return cli.NewExitError(msg, exitDiffer)
+		},
+	}
+}
+
+// compareBufferSize is how much of each stream compare reads at a time.
+const compareBufferSize = 256 << 10
+
+// firstDifference reads a and b side by side, compareBufferSize bytes at a
+// time, and returns the offset of the first byte they differ at, or that
+// they are the same. A stream ending before the other differs at its end.
+func firstDifference(a, b io.Reader) (int64, bool, error) {
+	bufA := make([]byte, compareBufferSize)
+	bufB := make([]byte, compareBufferSize)
+	var off int64
+	for {
+		na, errA := io.ReadFull(a, bufA)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return 0, false, errA
+		}
+		nb, errB := io.ReadFull(b, bufB)
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return 0, false, errB
+		}
+
+		n := na
+		if nb < n {
+			n = nb
+		}
+		if !bytes.Equal(bufA[:n], bufB[:n]) {
+			for i := 0; i < n; i++ {
+				if bufA[i] != bufB[i] {
+					return off + int64(i), false, nil
+				}
+			}
+		}
+		if na != nb {
+			return off + int64(n), false, nil
+		}
+		if errA != nil {
+			// both ended at the same place
+			return 0, true, nil
+		}
+		off += int64(n)
+	}
+}
+
+// contentEqual compares the file written to outPath with other, a local
+// file or else an IPFS path fetched through s. Only the bytes count, so two
+// copies of a file added with different chunkers, and hence different CIDs,
+// are equal.
+func contentEqual(ctx context.Context, c *cli.Context, s *ipget.Session, outPath, other string) error {
+	if outPath == "-" {
+		return fmt.Errorf("--content-equal has to read the output back, it can't be written to stdout")
+	}
+	got, err := os.Open(outPath)
+	if err != nil {
+		return err
+	}
+	defer got.Close()
+
+	var want io.Reader
+	if _, err := os.Stat(other); err == nil {
+		f, err := os.Open(other)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		want = f
+	} else {
+		iPath, err := parsePath(other, c.Bool("use-url-host"))
+		if err != nil {
+			return fmt.Errorf("--content-equal %q is neither a local file nor an IPFS path: %s", other, err)
+		}
+		nd, err := s.Get(ctx, iPath)
+		if err != nil {
+			if ctx.Err() != nil {
+				return cli.NewExitError("interrupted", exitInterrupted)
+			}
+			return cli.NewExitError(err, 2)
+		}
+		defer nd.Close()
+		f, ok := nd.(files.File)
+		if !ok {
+			return cli.NewExitError(fmt.Sprintf("%s is not a file", iPath), 2)
+		}
+		want = f
+	}
+
+	off, same, err := firstDifference(got, want)
+	if err != nil {
+		if ctx.Err() != nil {
+			return cli.NewExitError("interrupted", exitInterrupted)
+		}
+		return cli.NewExitError(err, 2)
+	}
+	if !same {
+		return cli.NewExitError(fmt.Sprintf("%s differs from %s at offset %d", outPath, other, off), exitDiffer)
+	}
+	log.Printf("%s has the same content as %s", outPath, other)
+	return nil
+}
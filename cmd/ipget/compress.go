@@ -0,0 +1,50 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// compressions are the formats --compress accepts, with the extension
+// added to output named after what was fetched.
+var compressions = map[string]string{
+	"gzip": ".gz",
+}
+
+// checkCompression makes sure format can be written at level, 0 meaning
+// the format's default.
+func checkCompression(format string, level int) error {
+	if format == "zstd" {
+		// the standard library only reads and writes gzip, zlib and flate
+		return fmt.Errorf("--compress zstd isn't supported, ipget has no zstd encoder to use; pipe -o - through zstd instead, or use gzip")
+	}
+	if _, ok := compressions[format]; !ok {
+		return fmt.Errorf("unknown --compress format %q, must be gzip", format)
+	}
+	if level != 0 && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+		return fmt.Errorf("--compress-level must be between %d and %d for gzip", gzip.BestSpeed, gzip.BestCompression)
+	}
+	return nil
+}
+
+// compressTo returns a writer compressing what is written to it into w, or
+// w itself when format is empty. It must be closed to flush the end of the
+// stream, which doesn't close w.
+func compressTo(w io.Writer, format string, level int) (io.WriteCloser, error) {
+	switch format {
+	case "":
+		return nopWriteCloser{w}, nil
+	case "gzip":
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	default:
+		return nil, fmt.Errorf("unknown compression %q", format)
+	}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
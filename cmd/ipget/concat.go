@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	files "github.com/ipfs/go-ipfs-files"
+	cli "github.com/urfave/cli"
+)
+
+// fetchConcat writes the files of every path given on the command line one
+// after the other, with nothing between them, to stdout or the file -o
+// names, as when reassembling a file split into parts published
+// separately. The paths are written in the order given. A directory is
+// only taken with --flatten, its files then come in the order of their
+// paths within it, those of a subdirectory where its name sorts.
+func fetchConcat(ctx context.Context, c *cli.Context) error {
+	switch {
+	case c.NArg() == 0:
+		return fmt.Errorf("usage: ipget --concat <ipfs ref>...\n")
+	case c.IsSet("out-template") || c.Bool("server-stdin") || c.String("batch") != "" || c.String("from-pinlist") != "" || c.String("replay") != "":
+		return fmt.Errorf("--concat writes everything to a single output, it can't be combined with --out-template, --server-stdin, --batch, --from-pinlist or --replay")
+	case c.Bool("watch") || c.Bool("announce") || c.String("car") != "":
+		return fmt.Errorf("--concat can't be combined with --watch, --announce or --car")
+	case c.Bool("delta") || c.Bool("continue") || c.Bool("append-output"):
+		return fmt.Errorf("--concat writes its output from the start, it can't be combined with --delta, --continue or --append")
+	case len(copyOutputs(c)) > 0:
+		return fmt.Errorf("--concat writes to a single output")
+	}
+	outPath := outputFlag(c)
+	if outPath == "" {
+		outPath = "-"
+	}
+	flags, err := checkFetchFlags(c)
+	if err != nil {
+		return err
+	}
+
+	dnslink := newDNSLinkResolver(c.String("dns-server"), c.BoolT("dnslink"))
+	targets := make([]*target, c.NArg())
+	for i, arg := range c.Args() {
+		if targets[i], err = newTarget(ctx, c, dnslink, arg); err != nil {
+			return err
+		}
+	}
+	s, fctx, stop, err := openSession(ctx, c, flags.deadline)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	defer stop()
+
+	// every path is checked to be something to concatenate before
+	// anything is written
+	nodes := make([]files.Node, len(targets))
+	for i, t := range targets {
+		nd, err := s.Get(fctx, t.path)
+		if err != nil {
+			if ctx.Err() != nil {
+				return cli.NewExitError("interrupted", exitInterrupted)
+			}
+			return cli.NewExitError(err, 2)
+		}
+		defer nd.Close()
+		switch nd.(type) {
+		case *files.Symlink:
+			return cli.NewExitError(fmt.Sprintf("%s is a symlink, --concat writes the content of files", t.name), 2)
+		case files.File:
+		case files.Directory:
+			if !c.Bool("flatten") {
+				return cli.NewExitError(fmt.Sprintf("%s is a directory, --concat only takes files unless --flatten is set", t.name), 2)
+			}
+		default:
+			return cli.NewExitError(fmt.Sprintf("%s is neither a file nor a directory", t.name), 2)
+		}
+		nodes[i] = nd
+	}
+
+	w := io.Writer(os.Stdout)
+	if outPath != "-" {
+		if err := makeParents(outPath, c.Bool("normalize-permissions")); err != nil {
+			return cli.NewExitError(err, 2)
+		}
+		f, err := os.Create(outPath)
+		if err != nil {
+			return cli.NewExitError(err, 2)
+		}
+		defer f.Close()
+		w = f
+	}
+	for i, nd := range nodes {
+		err := concatNode(w, targets[i].name, nd)
+		if err == nil {
+			continue
+		}
+		switch {
+		case ctx.Err() != nil && outPath != "-":
+			return interrupted(outPath)
+		case ctx.Err() != nil:
+			return cli.NewExitError("interrupted", exitInterrupted)
+		case fctx.Err() == context.DeadlineExceeded && outPath != "-":
+			return deadlineReached(outPath)
+		}
+		return cli.NewExitError(err, 2)
+	}
+	return nil
+}
+
+// concatNode writes the file nd, or the files of the directory nd, to w.
+// name is where nd is, for errors.
+func concatNode(w io.Writer, name string, nd files.Node) error {
+	switch nd := nd.(type) {
+	case *files.Symlink:
+		return fmt.Errorf("%s is a symlink, --concat writes the content of files", name)
+	case files.File:
+		_, err := io.Copy(w, nd)
+		return err
+	case files.Directory:
+		type entry struct {
+			name string
+			nd   files.Node
+		}
+		var entries []entry
+		it := nd.Entries()
+		for it.Next() {
+			entries = append(entries, entry{it.Name(), it.Node()})
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+		for _, e := range entries {
+			err := concatNode(w, name+"/"+e.name, e.nd)
+			e.nd.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s is neither a file nor a directory, --concat can't write it", name)
+	}
+}
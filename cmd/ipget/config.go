@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	config "github.com/ipfs/go-ipfs-config"
+	"github.com/ipfs/ipget"
+	ma "github.com/multiformats/go-multiaddr"
+	mafilter "github.com/whyrusleeping/multiaddr-filter"
+)
+
+// nonPublicAddrs are the loopback, private and otherwise unroutable ranges.
+var nonPublicAddrs = []string{
+	"/ip4/0.0.0.0/ipcidr/32",
+	"/ip4/10.0.0.0/ipcidr/8",
+	"/ip4/100.64.0.0/ipcidr/10",
+	"/ip4/127.0.0.0/ipcidr/8",
+	"/ip4/169.254.0.0/ipcidr/16",
+	"/ip4/172.16.0.0/ipcidr/12",
+	"/ip4/192.0.0.0/ipcidr/24",
+	"/ip4/192.0.2.0/ipcidr/24",
+	"/ip4/192.168.0.0/ipcidr/16",
+	"/ip4/198.18.0.0/ipcidr/15",
+	"/ip4/198.51.100.0/ipcidr/24",
+	"/ip4/203.0.113.0/ipcidr/24",
+	"/ip4/240.0.0.0/ipcidr/4",
+	"/ip6/::/ipcidr/128",
+	"/ip6/::1/ipcidr/128",
+	"/ip6/100::/ipcidr/64",
+	"/ip6/2001:2::/ipcidr/48",
+	"/ip6/2001:db8::/ipcidr/32",
+	"/ip6/fc00::/ipcidr/7",
+	"/ip6/fe80::/ipcidr/10",
+}
+
+// publicAddrsOnly stops the node from announcing loopback and private
+// addresses, so the addresses we hand out about ourselves (including in DHT
+// responses for our own peer ID) are ones remote peers can actually dial.
+func publicAddrsOnly(cfg *config.Config) {
+	cfg.Addresses.NoAnnounce = append(cfg.Addresses.NoAnnounce, nonPublicAddrs...)
+}
+
+// allowLocal enables mDNS discovery of peers on the local network and lifts
+// any filters that would keep us from dialing them.
+func allowLocal(cfg *config.Config) {
+	config.Profiles["local-discovery"].Transform(cfg)
+	if cfg.Discovery.MDNS.Interval == 0 {
+		cfg.Discovery.MDNS.Interval = 10
+	}
+}
+
+// listenOn returns an option replacing the swarm listen addresses with
+// addrs. A single "none" disables listening altogether.
+func listenOn(addrs []string) (ipget.CfgOpt, error) {
+	if len(addrs) == 1 && addrs[0] == "none" {
+		addrs = []string{}
+	}
+	for _, addr := range addrs {
+		if _, err := ma.NewMultiaddr(addr); err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %s", addr, err)
+		}
+	}
+	return func(cfg *config.Config) {
+		cfg.Addresses.Swarm = addrs
+	}, nil
+}
+
+// localAddr returns an option making the node's outbound connections come
+// from ip, which must be an address of this host. libp2p dials TCP and QUIC
+// from the sockets it listens on, so the node listens on ip only, and stays
+// off the other IP family whose dials couldn't come from ip. This relies on
+// port reuse, which $LIBP2P_TCP_REUSEPORT=false turns off.
+func localAddr(ip string) (ipget.CfgOpt, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("invalid local address %q, it must be an IP address", ip)
+	}
+	ifaddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, ifaddr := range ifaddrs {
+		if ipnet, ok := ifaddr.(*net.IPNet); ok && ipnet.IP.Equal(addr) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("local address %s isn't an address of this host", addr)
+	}
+	if v := os.Getenv("LIBP2P_TCP_REUSEPORT"); strings.EqualFold(v, "false") || v == "0" {
+		return nil, fmt.Errorf("--local-addr needs port reuse, which LIBP2P_TCP_REUSEPORT=%s turns off", v)
+	}
+
+	family := "ip6"
+	if addr.To4() != nil {
+		family = "ip4"
+	}
+	only := onlyIP(family)
+	return func(cfg *config.Config) {
+		cfg.Addresses.Swarm = []string{
+			fmt.Sprintf("/%s/%s/tcp/0", family, addr),
+			fmt.Sprintf("/%s/%s/udp/0/quic", family, addr),
+		}
+		only(cfg)
+	}, nil
+}
+
+// natPortMap returns an option turning UPnP/NAT-PMP port mapping on or off.
+// Looking for a NAT device can slow down startup on networks without one.
+func natPortMap(enable bool) ipget.CfgOpt {
+	return func(cfg *config.Config) {
+		cfg.Swarm.DisableNatPortMap = !enable
+	}
+}
+
+// addrFilters returns an option that stops the node from dialing addresses
+// in the given ranges. Each one is either a CIDR block such as 10.0.0.0/8 or
+// its multiaddr form, /ip4/10.0.0.0/ipcidr/8.
+func addrFilters(cidrs []string) (ipget.CfgOpt, error) {
+	filters := make([]string, len(cidrs))
+	for i, cidr := range cidrs {
+		if strings.HasPrefix(cidr, "/") {
+			if _, err := mafilter.NewMask(cidr); err != nil {
+				return nil, fmt.Errorf("invalid address filter %q: %s", cidr, err)
+			}
+			filters[i] = cidr
+			continue
+		}
+		ip, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address filter %q: %s", cidr, err)
+		}
+		proto := "ip6"
+		if ip.To4() != nil {
+			proto = "ip4"
+		}
+		ones, _ := ipnet.Mask.Size()
+		filters[i] = fmt.Sprintf("/%s/%s/ipcidr/%d", proto, ipnet.IP, ones)
+	}
+	return func(cfg *config.Config) {
+		cfg.Swarm.AddrFilters = append(cfg.Swarm.AddrFilters, filters...)
+	}, nil
+}
+
+// connLimits returns an option setting the connection manager's watermarks.
+// Once above high connections it trims back down to low. A zero value keeps
+// the configured one.
+func connLimits(low, high int) ipget.CfgOpt {
+	return func(cfg *config.Config) {
+		cm := &cfg.Swarm.ConnMgr
+		cm.Type = "basic"
+		if low > 0 {
+			cm.LowWater = low
+		}
+		if high > 0 {
+			cm.HighWater = high
+		}
+		if cm.GracePeriod == "" {
+			cm.GracePeriod = config.DefaultConnMgrGracePeriod.String()
+		}
+	}
+}
+
+// ipFamilies maps each IP family to the filter matching all of its
+// addresses.
+var ipFamilies = map[string]string{
+	"ip4": "/ip4/0.0.0.0/ipcidr/0",
+	"ip6": "/ip6/::/ipcidr/0",
+}
+
+// onlyIP returns an option keeping the node to one IP family, "ip4" or
+// "ip6". Addresses of the other one are neither dialed nor listened on.
+func onlyIP(family string) ipget.CfgOpt {
+	other := "ip6"
+	if family == "ip6" {
+		other = "ip4"
+	}
+	return func(cfg *config.Config) {
+		cfg.Swarm.AddrFilters = append(cfg.Swarm.AddrFilters, ipFamilies[other])
+		var listen []string
+		for _, addr := range cfg.Addresses.Swarm {
+			if !strings.HasPrefix(addr, "/"+other+"/") {
+				listen = append(listen, addr)
+			}
+		}
+		cfg.Addresses.Swarm = listen
+	}
+}
+
+// bootstrapWith returns an option replacing the bootstrap peers with peers.
+// A single "none" leaves the node without any.
+func bootstrapWith(peers []string) (ipget.CfgOpt, error) {
+	if len(peers) == 1 && peers[0] == "none" {
+		peers = []string{}
+	}
+	for _, addr := range peers {
+		if _, err := ma.NewMultiaddr(addr); err != nil {
+			return nil, fmt.Errorf("invalid bootstrap peer %q: %s", addr, err)
+		}
+	}
+	return func(cfg *config.Config) {
+		cfg.Bootstrap = peers
+	}, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cli "github.com/urfave/cli"
+)
+
+// defaultConfigFile returns $XDG_CONFIG_HOME/ipget/config, falling back to
+// ~/.config/ipget/config.
+func defaultConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "ipget", "config")
+}
+
+// loadConfigFile sets the global flags that weren't given on the command
+// line from the config file named by --config, or the default one if it
+// exists.
+//
+// The file is a JSON object keyed by flag name, such as
+//
+//	{"node": "spawn", "peers": ["/ip4/10.0.0.1/tcp/4001/p2p/Qm..."]}
+//
+// Lists set repeatable flags, everything else is given as it would be on the
+// command line.
+func loadConfigFile(c *cli.Context) error {
+	fpath := c.GlobalString("config")
+	data, err := ioutil.ReadFile(fpath)
+	if os.IsNotExist(err) && !c.GlobalIsSet("config") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var values map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return fmt.Errorf("failed to parse %s: %s", fpath, err)
+	}
+
+	for name, value := range values {
+		f := globalFlag(c.App, name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("%s: unknown option %q", fpath, name)
+		}
+		if flagIsSet(c, f) {
+			continue
+		}
+		list, ok := value.([]interface{})
+		if !ok {
+			list = []interface{}{value}
+		}
+		for _, v := range list {
+			if err := c.GlobalSet(name, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("%s: invalid value for %q: %s", fpath, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// globalFlag returns the global flag called name, by any of its names.
+func globalFlag(app *cli.App, name string) cli.Flag {
+	for _, f := range app.Flags {
+		for _, n := range strings.Split(f.GetName(), ",") {
+			if strings.TrimSpace(n) == name {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// flagIsSet reports whether f was given on the command line under any of
+// its names.
+func flagIsSet(c *cli.Context, f cli.Flag) bool {
+	for _, n := range strings.Split(f.GetName(), ",") {
+		if c.GlobalIsSet(strings.TrimSpace(n)) {
+			return true
+		}
+	}
+	return false
+}
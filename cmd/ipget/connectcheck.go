@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/ipget"
+	event "github.com/libp2p/go-libp2p-core/event"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	cli "github.com/urfave/cli"
+)
+
+// connectCommand dials a peer and reports what identify tells of it, to
+// check that a would-be provider can be reached before looking into why
+// its content can't be fetched.
+func connectCommand(ctx context.Context) cli.Command {
+	return cli.Command{
+		Name:      "connect",
+		Usage:     "dial a peer and print the protocols and addresses it reports, without fetching anything",
+		ArgsUsage: "<multiaddr>/p2p/<peer id>",
+		Flags: []cli.Flag{
+			cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "give up on dialing and identifying the peer after this long",
+				Value: 30 * time.Second,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("usage: ipget connect <multiaddr>/p2p/<peer id>\n")
+			}
+			addr, err := ma.NewMultiaddr(c.Args().First())
+			if err != nil {
+				return err
+			}
+			info, err := peer.AddrInfoFromP2pAddr(addr)
+			if err != nil {
+				return fmt.Errorf("%s: %s, the address must end in /p2p/<peer id>", addr, err)
+			}
+
+			opts, err := sessionOptions(c, c.GlobalInt("max-block-size"))
+			if err != nil {
+				return err
+			}
+			switch opts.Node {
+			case "", "fallback":
+				// it is our own node whose reach is in question, not the
+				// daemon's
+				opts.Node = "spawn"
+			case "local":
+				return fmt.Errorf("connect dials from a node of its own, a daemon can't tell what identify reported")
+			}
+			s, err := ipget.New(ctx, opts)
+			if err != nil {
+				return err
+			}
+			defer s.Close()
+			h := s.Node().PeerHost
+
+			cctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			if timeout := c.Duration("timeout"); timeout > 0 {
+				cctx, cancel = context.WithTimeout(cctx, timeout)
+				defer cancel()
+			}
+			// subscribed to before dialing, identify may well be done by
+			// the time Connect returns
+			sub, err := h.EventBus().Subscribe([]interface{}{new(event.EvtPeerIdentificationCompleted), new(event.EvtPeerIdentificationFailed)})
+			if err != nil {
+				return err
+			}
+			defer sub.Close()
+
+			start := time.Now()
+			if err := h.Connect(cctx, *info); err != nil {
+				if ctx.Err() != nil {
+					return cli.NewExitError("interrupted", exitInterrupted)
+				}
+				return cli.NewExitError(fmt.Sprintf("%s can't be dialed: %s", info.ID, err), exitUndialable)
+			}
+			latency := time.Since(start).Round(time.Millisecond)
+			for _, conn := range h.Network().ConnsToPeer(info.ID) {
+				fmt.Printf("connected to %s at %s in %s\n", info.ID, conn.RemoteMultiaddr(), latency)
+			}
+
+			var identifyErr error
+		wait:
+			for {
+				select {
+				case e, ok := <-sub.Out():
+					if !ok {
+						break wait
+					}
+					switch e := e.(type) {
+					case event.EvtPeerIdentificationCompleted:
+						if e.Peer == info.ID {
+							break wait
+						}
+					case event.EvtPeerIdentificationFailed:
+						if e.Peer == info.ID {
+							identifyErr = e.Reason
+							break wait
+						}
+					}
+				case <-cctx.Done():
+					if ctx.Err() != nil {
+						return cli.NewExitError("interrupted", exitInterrupted)
+					}
+					identifyErr = fmt.Errorf("no answer after %s", c.Duration("timeout"))
+					break wait
+				}
+			}
+			if identifyErr != nil {
+				return cli.NewExitError(fmt.Sprintf("identifying %s failed: %s", info.ID, identifyErr), 2)
+			}
+
+			ps := h.Peerstore()
+			if agent, err := ps.Get(info.ID, "AgentVersion"); err == nil {
+				fmt.Printf("agent: %s\n", agent)
+			}
+			protos, err := ps.GetProtocols(info.ID)
+			if err != nil {
+				return err
+			}
+			sort.Strings(protos)
+			fmt.Printf("protocols: %s\n", strings.Join(protos, ", "))
+			var addrs []string
+			for _, a := range ps.Addrs(info.ID) {
+				addrs = append(addrs, a.String())
+			}
+			sort.Strings(addrs)
+			fmt.Printf("addresses: %s\n", strings.Join(addrs, ", "))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	"github.com/ipfs/go-unixfs/importer/helpers"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget"
+	"github.com/ipfs/ipget/internal/dagutil"
+)
+
+// delta brings fpath, an existing directory, up to date with the directory
+// at p. Files whose content on disk hashes to the CID they have in the DAG
+// are left alone and never fetched; everything else is written again, and
+// what isn't in the DAG anymore is removed. Files in the way of this are
+// replaced no matter the overwrite policy.
+func (e *extractor) delta(ctx context.Context, s *ipget.Session, p ipath.Path, fpath string) (syncStats, error) {
+	var st syncStats
+	rp, err := s.Resolve(ctx, p)
+	if err != nil {
+		return st, err
+	}
+	entries, err := lsDir(ctx, s.API(), rp.Cid())
+	if err != nil {
+		return st, err
+	}
+	if entries == nil {
+		return st, fmt.Errorf("%s is not a directory, delta extraction updates directories", p)
+	}
+	e.root, e.target = fpath, fpath
+	if err := e.deltaDir(ctx, s, entries, fpath, &st); err != nil {
+		return st, err
+	}
+	if len(e.failed) > 0 {
+		return st, fmt.Errorf("%d entries failed, kept going past them (--keep-going):\n  %s",
+			len(e.failed), strings.Join(e.failed, "\n  "))
+	}
+	return st, nil
+}
+
+func (e *extractor) deltaDir(ctx context.Context, s *ipget.Session, entries map[string]iface.DirEntry, dir string, st *syncStats) error {
+	for name, ent := range entries {
+		child := filepath.Join(dir, name)
+		if e.excluded(child) || !e.selectsEntry(ent, child) {
+			continue
+		}
+		fi, err := e.files().Lstat(child)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		exists := err == nil
+
+		if exists && fi.IsDir() && ent.Type == iface.TDirectory {
+			sub, err := lsDir(ctx, s.API(), ent.Cid)
+			if err != nil {
+				return err
+			}
+			if err := e.deltaDir(ctx, s, sub, child, st); err != nil {
+				return err
+			}
+			continue
+		}
+		if exists {
+			same, err := e.unchanged(ctx, s, ent, child, fi)
+			if err != nil {
+				return err
+			}
+			if same {
+				continue
+			}
+			st.updated++
+		} else {
+			st.added++
+		}
+		if err := replaceEntry(ctx, s, e, ent.Cid, child); err != nil {
+			if !e.keepGoing || isFatal(err) {
+				return err
+			}
+			e.failed = append(e.failed, fmt.Sprintf("%s: %s", child, err))
+		}
+	}
+
+	local, err := e.files().ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range local {
+		name := fi.Name()
+		if _, ok := entries[name]; ok || e.isSidecar(name, entries) {
+			continue
+		}
+		if err := e.removeUnlisted(filepath.Join(dir, name), fi, st); err != nil {
+			return err
+		}
+	}
+	return e.stamp(dir)
+}
+
+// selectsEntry reports whether the filters select ent, to be written at
+// fpath, as selects does for the nodes extraction writes.
+func (e *extractor) selectsEntry(ent iface.DirEntry, fpath string) bool {
+	switch ent.Type {
+	case iface.TDirectory:
+		return true
+	case iface.TSymlink:
+		return e.selected(fpath, -1)
+	default:
+		return e.selected(fpath, int64(ent.Size))
+	}
+}
+
+// removeUnlisted removes fpath, described by fi, which the DAG doesn't
+// have anymore. What the filters leave out is none of the fetch's
+// business and stays, so with filters a directory is only emptied of
+// what they select, and removed if nothing else is left in it.
+func (e *extractor) removeUnlisted(fpath string, fi os.FileInfo, st *syncStats) error {
+	if e.excluded(fpath) {
+		return nil
+	}
+	if !e.filtering() {
+		st.removed++
+		return e.files().RemoveAll(fpath)
+	}
+	if !fi.IsDir() {
+		size := fi.Size()
+		if fi.Mode()&os.ModeSymlink != 0 {
+			size = -1
+		}
+		if !e.selected(fpath, size) {
+			return nil
+		}
+		st.removed++
+		return e.files().Remove(fpath)
+	}
+	local, err := e.files().ReadDir(fpath)
+	if err != nil {
+		return err
+	}
+	for _, child := range local {
+		if err := e.removeUnlisted(filepath.Join(fpath, child.Name()), child, st); err != nil {
+			return err
+		}
+	}
+	if left, err := e.files().ReadDir(fpath); err != nil || len(left) > 0 {
+		return err
+	}
+	return e.files().Remove(fpath)
+}
+
+// findUnchanged notes the files of the DAG nd, whose root is root, that
+// are at their place under fpath already with the same content, for the
+// if-different overwrite policy to leave alone. Only the listings of the
+// directories that exist on disk and the first leaf of the files that do
+// are fetched to find out.
+func (e *extractor) findUnchanged(ctx context.Context, s *ipget.Session, root cid.Cid, nd files.Node, fpath string) error {
+	e.root, e.target = fpath, fpath
+	e.same = map[string]bool{}
+	entries, err := lsDir(ctx, s.API(), root)
+	if err != nil {
+		return err
+	}
+	if entries != nil {
+		return e.findUnchangedIn(ctx, s, entries, fpath)
+	}
+	f, ok := nd.(files.File)
+	if !ok {
+		return nil
+	}
+	size, err := f.Size()
+	if err != nil {
+		return err
+	}
+	return e.noteUnchanged(ctx, s, iface.DirEntry{Cid: root, Type: iface.TFile, Size: uint64(size)}, fpath)
+}
+
+func (e *extractor) findUnchangedIn(ctx context.Context, s *ipget.Session, entries map[string]iface.DirEntry, dir string) error {
+	for name, ent := range entries {
+		child := filepath.Join(dir, name)
+		if e.excluded(child) {
+			continue
+		}
+		if ent.Type != iface.TDirectory {
+			if err := e.noteUnchanged(ctx, s, ent, child); err != nil {
+				return err
+			}
+			continue
+		}
+		if !e.isExistingDir(child) {
+			continue
+		}
+		sub, err := lsDir(ctx, s.API(), ent.Cid)
+		if err != nil {
+			return err
+		}
+		if err := e.findUnchangedIn(ctx, s, sub, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *extractor) noteUnchanged(ctx context.Context, s *ipget.Session, ent iface.DirEntry, fpath string) error {
+	fi, err := e.files().Lstat(fpath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	same, err := e.unchanged(ctx, s, ent, fpath, fi)
+	if err != nil {
+		return err
+	}
+	if same {
+		e.same[fpath] = true
+	}
+	return nil
+}
+
+// isSidecar reports whether name is a checksum file of one of entries.
+func (e *extractor) isSidecar(name string, entries map[string]iface.DirEntry) bool {
+	for _, algo := range e.checksums {
+		if !strings.HasSuffix(name, "."+algo) {
+			continue
+		}
+		if _, ok := entries[strings.TrimSuffix(name, "."+algo)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// unchanged reports whether the entry at fpath, described by fi, is what
+// ent holds already.
+func (e *extractor) unchanged(ctx context.Context, s *ipget.Session, ent iface.DirEntry, fpath string, fi os.FileInfo) (bool, error) {
+	switch {
+	case ent.Type == iface.TFile && fi.Mode().IsRegular():
+		if ent.Size != uint64(fi.Size()) {
+			return false, nil
+		}
+		c, err := e.localCID(ctx, s, ent.Cid, fpath, fi.Size())
+		if err != nil {
+			return false, err
+		}
+		return c.Equals(ent.Cid), nil
+	case ent.Type == iface.TSymlink && fi.Mode()&os.ModeSymlink != 0:
+		target, err := e.files().Readlink(fpath)
+		if err != nil {
+			return false, err
+		}
+		return target == ent.Target, nil
+	default:
+		return false, nil
+	}
+}
+
+// localCID returns the CID the file at fpath would get if it was added the
+// way the file want was: with the same CID version and hash function, the
+// same kind of leaves and the same chunk size, laid out as a balanced DAG.
+// Only the first leaf of want is fetched to find out. A file added any other
+// way, such as with a rabin chunker or as a trickle DAG, gets a different
+// CID and is written again.
+func (e *extractor) localCID(ctx context.Context, s *ipget.Session, want cid.Cid, fpath string, size int64) (cid.Cid, error) {
+	prefix := want.Prefix()
+	rawLeaves := prefix.Codec == cid.Raw
+	// a single block holds the whole file, however large
+	chunk := chunker.DefaultBlockSize
+	if size > chunk {
+		chunk = size
+	}
+	nd, err := s.API().Dag().Get(ctx, want)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if len(nd.Links()) > 0 {
+		path := dagutil.Root(want)
+		for len(nd.Links()) > 0 {
+			next := nd.Links()[0].Cid
+			if path, err = path.Child(next); err != nil {
+				return cid.Undef, err
+			}
+			if nd, err = s.API().Dag().Get(ctx, next); err != nil {
+				return cid.Undef, err
+			}
+		}
+		rawLeaves = nd.Cid().Type() == cid.Raw
+		data, err := dagutil.FileData(nd)
+		if err != nil {
+			return cid.Undef, err
+		}
+		chunk = int64(len(data))
+	}
+	if chunk <= 0 {
+		return cid.Undef, fmt.Errorf("can't tell the chunk size of %s", want)
+	}
+
+	f, err := e.files().Open(fpath)
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer f.Close()
+	prefix.Codec = cid.DagProtobuf
+	prefix.MhLength = -1
+	params := helpers.DagBuilderParams{
+		Maxlinks:   helpers.DefaultLinksPerBlock,
+		RawLeaves:  rawLeaves,
+		CidBuilder: prefix,
+		Dagserv:    discardDAG{},
+	}
+	db, err := params.New(chunker.NewSizeSplitter(f, chunk))
+	if err != nil {
+		return cid.Undef, err
+	}
+	root, err := balanced.Layout(db)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return root.Cid(), nil
+}
+
+// discardDAG is a DAG service that drops what it is given, for hashing
+// files without storing them.
+type discardDAG struct{}
+
+func (discardDAG) Get(context.Context, cid.Cid) (ipld.Node, error) { return nil, ipld.ErrNotFound }
+
+func (discardDAG) GetMany(context.Context, []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption)
+	close(out)
+	return out
+}
+
+func (discardDAG) Add(context.Context, ipld.Node) error { return nil }
+
+func (discardDAG) AddMany(context.Context, []ipld.Node) error { return nil }
+
+func (discardDAG) Remove(context.Context, cid.Cid) error { return nil }
+
+func (discardDAG) RemoveMany(context.Context, []cid.Cid) error { return nil }
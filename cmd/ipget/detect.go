@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	nethttp "net/http"
+	"strings"
+
+	files "github.com/ipfs/go-ipfs-files"
+)
+
+// sniffLen is the number of bytes http.DetectContentType considers.
+const sniffLen = 512
+
+// emptyType is the type reported for an empty file, which gets no
+// extension.
+const emptyType = "inode/x-empty"
+
+// knownExts maps the types http.DetectContentType can report to the
+// extension most tools expect. mime.ExtensionsByType is consulted for
+// anything missing here, but its answers depend on the host's mime tables.
+var knownExts = map[string]string{
+	"application/ogg":               ".ogg",
+	"application/pdf":               ".pdf",
+	"application/postscript":        ".ps",
+	"application/vnd.ms-fontobject": ".eot",
+	"application/wasm":              ".wasm",
+	"application/x-gzip":            ".gz",
+	"application/x-rar-compressed":  ".rar",
+	"application/zip":               ".zip",
+	"audio/aiff":                    ".aiff",
+	"audio/basic":                   ".au",
+	"audio/midi":                    ".mid",
+	"audio/mpeg":                    ".mp3",
+	"audio/wave":                    ".wav",
+	"font/collection":               ".ttc",
+	"font/otf":                      ".otf",
+	"font/ttf":                      ".ttf",
+	"font/woff":                     ".woff",
+	"font/woff2":                    ".woff2",
+	"image/bmp":                     ".bmp",
+	"image/gif":                     ".gif",
+	"image/jpeg":                    ".jpg",
+	"image/png":                     ".png",
+	"image/webp":                    ".webp",
+	"image/x-icon":                  ".ico",
+	"text/html":                     ".html",
+	"text/plain":                    ".txt",
+	"text/xml":                      ".xml",
+	"video/avi":                     ".avi",
+	"video/mp4":                     ".mp4",
+	"video/webm":                    ".webm",
+}
+
+// detectType sniffs the content type of f. It returns the detected MIME
+// type, a matching file extension (possibly empty), and a file that still
+// yields the full content.
+func detectType(f files.File) (string, string, files.File, error) {
+	br := bufio.NewReaderSize(f, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", "", nil, err
+	}
+
+	if len(head) == 0 {
+		// DetectContentType calls nothing at all plain text
+		return emptyType, "", &wrappedFile{File: f, r: br}, nil
+	}
+	mimeType := nethttp.DetectContentType(head)
+	return mimeType, extensionFor(mimeType), &wrappedFile{File: f, r: br}, nil
+}
+
+func extensionFor(mimeType string) string {
+	base, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		base = strings.TrimSpace(strings.Split(mimeType, ";")[0])
+	}
+	if ext, ok := knownExts[base]; ok {
+		return ext
+	}
+	if base == "application/octet-stream" {
+		// nothing useful was detected
+		return ""
+	}
+	exts, err := mime.ExtensionsByType(base)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+	return exts[0]
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+
+	cid "github.com/ipfs/go-cid"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	cli "github.com/urfave/cli"
+)
+
+// treeChange is one difference ipget diff found between two trees.
+type treeChange struct {
+	// Path is relative to the roots, with forward slashes. It is empty when
+	// the roots themselves are files.
+	Path string `json:"path"`
+	// Change is added, removed or changed.
+	Change string `json:"change"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+func diffCommand(ctx context.Context) cli.Command {
+	return cli.Command{
+		Name:      "diff",
+		Usage:     "list the files added, removed and changed between two IPFS directories, fetching only their listings",
+		ArgsUsage: "<ipfs ref> <ipfs ref>",
+		Flags: []cli.Flag{
+			cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "give up after this long, 0 waits for every listing",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "print the changes as a JSON array",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 2 {
+				return fmt.Errorf("usage: ipget diff <ipfs ref> <ipfs ref>\n")
+			}
+			dnslink := newDNSLinkResolver(c.GlobalString("dns-server"), c.GlobalBoolT("dnslink"))
+			var refs [2]cid.Cid
+			s, err := setupNode(ctx, c, c.GlobalInt("max-block-size"))
+			if err != nil {
+				return err
+			}
+			defer s.Close()
+
+			dctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			if timeout := c.Duration("timeout"); timeout > 0 {
+				dctx, cancel = context.WithTimeout(dctx, timeout)
+				defer cancel()
+			}
+			for i, arg := range c.Args()[:2] {
+				iPath, err := parsePath(arg, c.GlobalBool("use-url-host"))
+				if err == nil {
+					iPath, err = dnslink.resolve(dctx, iPath)
+				}
+				if err != nil {
+					return err
+				}
+				rp, err := s.Resolve(dctx, iPath)
+				if err != nil {
+					return diffFailed(ctx, dctx, c, err)
+				}
+				refs[i] = rp.Cid()
+			}
+
+			var changes []treeChange
+			if err := diffTrees(dctx, s.API(), refs[0], refs[1], "", &changes); err != nil {
+				return diffFailed(ctx, dctx, c, err)
+			}
+			sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+			if c.Bool("json") {
+				if changes == nil {
+					changes = []treeChange{}
+				}
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(changes); err != nil {
+					return err
+				}
+			} else {
+				for _, ch := range changes {
+					switch ch.Change {
+					case "added":
+						fmt.Printf("A %s %s\n", ch.Path, ch.New)
+					case "removed":
+						fmt.Printf("D %s %s\n", ch.Path, ch.Old)
+					default:
+						fmt.Printf("M %s %s -> %s\n", ch.Path, ch.Old, ch.New)
+					}
+				}
+			}
+			if len(changes) > 0 {
+				return cli.NewExitError(fmt.Sprintf("%d paths differ", len(changes)), exitDiffer)
+			}
+			return nil
+		},
+	}
+}
+
+func diffFailed(ctx, dctx context.Context, c *cli.Context, err error) error {
+	if ctx.Err() != nil {
+		return cli.NewExitError("interrupted", exitInterrupted)
+	}
+	if dctx.Err() == context.DeadlineExceeded {
+		return cli.NewExitError(fmt.Sprintf("diff incomplete after %s", c.Duration("timeout")), 2)
+	}
+	return cli.NewExitError(err, 2)
+}
+
+// diffTrees adds to changes what differs between the trees a and b at dir.
+// Subtrees with the same CID are the same and aren't listed, so the cost
+// goes with the size of the change. Only directory listings are fetched,
+// the content of files never is.
+func diffTrees(ctx context.Context, ipfs iface.CoreAPI, a, b cid.Cid, dir string, changes *[]treeChange) error {
+	if a.Equals(b) {
+		return nil
+	}
+	oldEntries, err := lsDir(ctx, ipfs, a)
+	if err != nil {
+		return err
+	}
+	newEntries, err := lsDir(ctx, ipfs, b)
+	if err != nil {
+		return err
+	}
+	if oldEntries == nil || newEntries == nil {
+		// a file on either side: nothing to look into
+		if oldEntries == nil && newEntries == nil {
+			*changes = append(*changes, treeChange{Path: dir, Change: "changed", Old: a.String(), New: b.String()})
+			return nil
+		}
+		if err := listTree(ctx, ipfs, a, dir, "removed", changes); err != nil {
+			return err
+		}
+		return listTree(ctx, ipfs, b, dir, "added", changes)
+	}
+	for name, ne := range newEntries {
+		child := path.Join(dir, name)
+		oe, ok := oldEntries[name]
+		switch {
+		case !ok:
+			err = listTree(ctx, ipfs, ne.Cid, child, "added", changes)
+		case oe.Cid.Equals(ne.Cid):
+		case oe.Type == iface.TDirectory || ne.Type == iface.TDirectory:
+			err = diffTrees(ctx, ipfs, oe.Cid, ne.Cid, child, changes)
+		default:
+			*changes = append(*changes, treeChange{Path: child, Change: "changed", Old: oe.Cid.String(), New: ne.Cid.String()})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for name, oe := range oldEntries {
+		if _, ok := newEntries[name]; !ok {
+			if err := listTree(ctx, ipfs, oe.Cid, path.Join(dir, name), "removed", changes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// listTree adds every file of the tree c at dir to changes as added or
+// removed.
+func listTree(ctx context.Context, ipfs iface.CoreAPI, c cid.Cid, dir, change string, changes *[]treeChange) error {
+	entries, err := lsDir(ctx, ipfs, c)
+	if err != nil {
+		return err
+	}
+	if entries == nil {
+		ch := treeChange{Path: dir, Change: change}
+		if change == "added" {
+			ch.New = c.String()
+		} else {
+			ch.Old = c.String()
+		}
+		*changes = append(*changes, ch)
+		return nil
+	}
+	for name, ent := range entries {
+		if err := listTree(ctx, ipfs, ent.Cid, path.Join(dir, name), change, changes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	gopath "path"
+	"strings"
+	"sync"
+	"time"
+
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// maxDNSLinkDepth limits how many DNSLink records pointing at other DNSLink
+// names we follow, same as go-ipfs.
+const maxDNSLinkDepth = 32
+
+// dnslinkResolver resolves /ipns/<domain> paths through DNSLink TXT records.
+type dnslinkResolver struct {
+	resolver *net.Resolver
+	disabled bool
+
+	// links caches the records looked up, for DefaultResolveCacheTTL, unless
+	// noCache is set.
+	noCache bool
+	mu      sync.Mutex
+	links   map[string]dnslinkRecord
+}
+
+type dnslinkRecord struct {
+	links  []string
+	looked time.Time
+}
+
+// newDNSLinkResolver returns a resolver querying server, or the system
+// resolver when server is empty. If enabled is false, DNSLink names are
+// rejected and only peer IDs are accepted under /ipns/.
+func newDNSLinkResolver(server string, enabled bool) *dnslinkResolver {
+	r := &dnslinkResolver{
+		resolver: net.DefaultResolver,
+		disabled: !enabled,
+		links:    make(map[string]dnslinkRecord),
+	}
+	if server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		r.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return r
+}
+
+// resolve replaces a leading /ipns/<domain> in p with the path its DNSLink
+// record points to. Any other path is returned unchanged.
+func (r *dnslinkResolver) resolve(ctx context.Context, p ipath.Path) (ipath.Path, error) {
+	return r.resolveDepth(ctx, p, 0)
+}
+
+func (r *dnslinkResolver) resolveDepth(ctx context.Context, p ipath.Path, depth int) (ipath.Path, error) {
+	parts := strings.SplitN(strings.TrimPrefix(p.String(), "/"), "/", 3)
+	if len(parts) < 2 || parts[0] != "ipns" || !isDomain(parts[1]) {
+		return p, nil
+	}
+	domain := parts[1]
+	if r.disabled {
+		return nil, fmt.Errorf("%q is not a peer ID and DNSLink resolution is disabled", domain)
+	}
+	if depth >= maxDNSLinkDepth {
+		return nil, fmt.Errorf("too many DNSLink redirects while resolving %q", domain)
+	}
+
+	links, err := r.cachedLookup(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	var rest string
+	if len(parts) == 3 {
+		rest = parts[2]
+	}
+
+	// Try every dnslink= entry in turn, the first one that resolves wins.
+	for _, link := range links {
+		target := ipath.New(gopath.Join(link, rest))
+		if target.IsValid() != nil {
+			err = fmt.Errorf("invalid DNSLink %q for %q", link, domain)
+			continue
+		}
+		resolved, rerr := r.resolveDepth(ctx, target, depth+1)
+		if rerr == nil {
+			return resolved, nil
+		}
+		err = rerr
+	}
+	return nil, err
+}
+
+// cachedLookup is lookup, reusing what was found for domain recently.
+func (r *dnslinkResolver) cachedLookup(ctx context.Context, domain string) ([]string, error) {
+	r.mu.Lock()
+	rec, ok := r.links[domain]
+	r.mu.Unlock()
+	if ok && !r.noCache && time.Since(rec.looked) < ipget.DefaultResolveCacheTTL {
+		return rec.links, nil
+	}
+	links, err := r.lookup(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.links[domain] = dnslinkRecord{links: links, looked: time.Now()}
+	r.mu.Unlock()
+	return links, nil
+}
+
+// lookup returns the values of all dnslink= TXT entries for domain, checking
+// _dnslink.<domain> before the bare domain.
+func (r *dnslinkResolver) lookup(ctx context.Context, domain string) ([]string, error) {
+	var lastErr error
+	for _, name := range []string{"_dnslink." + domain, domain} {
+		txts, err := r.resolver.LookupTXT(ctx, name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var links []string
+		for _, txt := range txts {
+			if strings.HasPrefix(txt, "dnslink=") {
+				links = append(links, strings.TrimPrefix(txt, "dnslink="))
+			}
+		}
+		if len(links) > 0 {
+			return links, nil
+		}
+	}
+	if lastErr != nil {
+		return nil, fmt.Errorf("failed to resolve DNSLink for %q: %s", domain, lastErr)
+	}
+	return nil, fmt.Errorf("no DNSLink record found for %q", domain)
+}
+
+// isDomain reports whether an /ipns/ name looks like a domain rather than a
+// peer ID.
+func isDomain(name string) bool {
+	if _, err := peer.Decode(name); err == nil {
+		return false
+	}
+	return strings.Contains(name, ".")
+}
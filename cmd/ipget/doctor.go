@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	files "github.com/ipfs/go-ipfs-files"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget"
+	host "github.com/libp2p/go-libp2p-core/host"
+	manet "github.com/multiformats/go-multiaddr-net"
+	cli "github.com/urfave/cli"
+)
+
+// The defaults of the doctor checks, content that is always around.
+const (
+	doctorDNSLink = "docs.ipfs.io"
+	doctorPath    = "/ipfs/QmQ2r6iMNpky5f1m4cnm3Yqw8VSvjuKpTcK1X7dBR1LkJF/cat.gif"
+)
+
+// doctorCheck is the outcome of one of the doctor checks.
+type doctorCheck struct {
+	name   string
+	ok     bool
+	skip   bool
+	result string
+	// hint is what to look into when the check failed.
+	hint string
+}
+
+func (d doctorCheck) String() string {
+	switch {
+	case d.skip:
+		return fmt.Sprintf("SKIP %s: %s", d.name, d.result)
+	case d.ok:
+		return fmt.Sprintf("PASS %s: %s", d.name, d.result)
+	}
+	return fmt.Sprintf("FAIL %s: %s\n     %s", d.name, d.result, d.hint)
+}
+
+// doctorCommand checks that ipget can reach the network at all, so that a
+// fetch failing isn't blamed on its content when nothing would work. It
+// spawns a node as a fetch would, with the same global flags, and reports
+// how bootstrapping, port mapping, DNSLink and fetching known content go.
+func doctorCommand(ctx context.Context) cli.Command {
+	return cli.Command{
+		Name:  "doctor",
+		Usage: "check that ipget can bootstrap, map a port, resolve DNSLink and fetch content, with hints for what fails",
+		Flags: []cli.Flag{
+			cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "fail a check that takes longer than this",
+				Value: time.Minute,
+			},
+			cli.StringFlag{
+				Name:  "dnslink",
+				Usage: "domain whose DNSLink record is resolved",
+				Value: doctorDNSLink,
+			},
+			cli.StringFlag{
+				Name:  "path",
+				Usage: "small path fetched in full",
+				Value: doctorPath,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.Args().Present() {
+				return fmt.Errorf("usage: ipget doctor\n")
+			}
+			fpath, err := normalizePath(c.String("path"))
+			if err != nil {
+				return err
+			}
+			opts, err := sessionOptions(c, c.GlobalInt("max-block-size"))
+			if err != nil {
+				return err
+			}
+			switch opts.Node {
+			case "", "fallback":
+				// a daemon being reachable says nothing of whether a
+				// spawned node can be
+				opts.Node = "spawn"
+			case "local":
+				return fmt.Errorf("doctor checks a node of its own, drop --node=local")
+			}
+			nat := !c.GlobalBool("no-nat") && opts.SOCKS5 == ""
+			if nat {
+				opts.Config = append(opts.Config, natPortMap(true))
+			}
+
+			start := time.Now()
+			s, err := ipget.New(ctx, opts)
+			if err != nil {
+				return err
+			}
+			defer s.Close()
+			timeout := c.Duration("timeout")
+
+			checks := []doctorCheck{doctorBootstrap(ctx, s, start, timeout)}
+			if nat {
+				checks = append(checks, doctorNAT(ctx, s.Node().PeerHost, timeout))
+			} else {
+				checks = append(checks, doctorCheck{name: "port mapping", skip: true, result: "--no-nat or --socks5 is set"})
+			}
+			dnslink := newDNSLinkResolver(c.GlobalString("dns-server"), c.GlobalBoolT("dnslink"))
+			checks = append(checks, doctorDNS(ctx, dnslink, c.String("dnslink"), timeout))
+			checks = append(checks, doctorFetch(ctx, s, fpath, timeout))
+			if ctx.Err() != nil {
+				return cli.NewExitError("interrupted", exitInterrupted)
+			}
+
+			failed := 0
+			for _, check := range checks {
+				fmt.Println(check)
+				if !check.ok && !check.skip {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return cli.NewExitError(fmt.Sprintf("%d of %d checks failed", failed, len(checks)), 2)
+			}
+			return nil
+		},
+	}
+}
+
+// doctorBootstrap checks that the node connected to enough peers, start
+// being when it was started.
+func doctorBootstrap(ctx context.Context, s *ipget.Session, start time.Time, timeout time.Duration) doctorCheck {
+	check := doctorCheck{
+		name: "bootstrap",
+		hint: "check that outgoing connections on port 4001 aren't blocked by a firewall, or name peers to connect to with --peers",
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-s.Ready():
+	case <-t.C:
+		check.result = fmt.Sprintf("%d peers after %s, %d wanted", len(s.Node().PeerHost.Network().Peers()), timeout, s.WantedPeers())
+		return check
+	case <-ctx.Done():
+		return check
+	}
+	peers := len(s.Node().PeerHost.Network().Peers())
+	check.ok = true
+	check.result = fmt.Sprintf("%d peers in %s", peers, time.Since(start).Round(time.Millisecond))
+	return check
+}
+
+// doctorNAT checks that h can be reached at a public address, whether it
+// has one of its own or port mapping got it one.
+func doctorNAT(ctx context.Context, h host.Host, timeout time.Duration) doctorCheck {
+	check := doctorCheck{
+		name: "port mapping",
+		hint: "turn on UPnP or NAT-PMP on the router, or forward port 4001 to this machine; fetches still work without it, but peers behind NATs can't dial in",
+	}
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+	for {
+		for _, addr := range h.Addrs() {
+			if manet.IsPublicAddr(addr) {
+				check.ok = true
+				check.result = fmt.Sprintf("reachable at %s", addr)
+				return check
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			check.result = fmt.Sprintf("no public address after %s", timeout)
+			return check
+		case <-ctx.Done():
+			return check
+		}
+	}
+}
+
+// doctorDNS checks that the DNSLink record of domain resolves.
+func doctorDNS(ctx context.Context, r *dnslinkResolver, domain string, timeout time.Duration) doctorCheck {
+	check := doctorCheck{
+		name: "DNSLink",
+		hint: "check that DNS TXT lookups work from this machine, or name a resolver with --dns-server",
+	}
+	rctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	p, err := r.resolve(rctx, ipath.New("/ipns/"+domain))
+	if err != nil {
+		check.result = fmt.Sprintf("%s: %s", domain, err)
+		return check
+	}
+	check.ok = true
+	check.result = fmt.Sprintf("%s is %s, in %s", domain, p, time.Since(start).Round(time.Millisecond))
+	return check
+}
+
+// doctorFetch checks that all of p can be fetched.
+func doctorFetch(ctx context.Context, s *ipget.Session, p ipath.Path, timeout time.Duration) doctorCheck {
+	check := doctorCheck{
+		name: "fetch",
+		hint: "see whether one of its providers can be reached with `ipget connect`, or fall back to a --gateway",
+	}
+	fctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	nd, err := s.Get(fctx, p)
+	if err != nil {
+		check.result = fmt.Sprintf("%s: %s", p, err)
+		// how far it got with the providers, if it can tell
+		if why := s.Unavailable(p.String()); why != nil {
+			check.result = why.Error()
+		}
+		return check
+	}
+	defer nd.Close()
+	f, ok := nd.(files.File)
+	if !ok {
+		check.result = fmt.Sprintf("%s is not a file", p)
+		check.hint = "give --path a file"
+		return check
+	}
+	n, err := io.Copy(ioutil.Discard, f)
+	if err != nil {
+		check.result = fmt.Sprintf("%s: %s after %d bytes", p, err, n)
+		return check
+	}
+	check.ok = true
+	check.result = fmt.Sprintf("%d bytes of %s in %s", n, p, time.Since(start).Round(time.Millisecond))
+	return check
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget"
+)
+
+const (
+	// estimateSampleTime and estimateSampleBytes bound the bandwidth probe
+	// of --estimate, whichever comes first.
+	estimateSampleTime  = 5 * time.Second
+	estimateSampleBytes = 4 << 20
+	// estimateProviders is how many providers are dialed before probing,
+	// so that the probe doesn't time the provider search.
+	estimateProviders = 3
+)
+
+// estimate is the predicted size and duration of a fetch.
+type estimate struct {
+	size    uint64
+	sampled uint64
+	elapsed time.Duration
+}
+
+// rate is the throughput seen by the probe, in bytes per second.
+func (e estimate) rate() float64 {
+	if e.elapsed <= 0 {
+		return 0
+	}
+	return float64(e.sampled) / e.elapsed.Seconds()
+}
+
+func (e estimate) String() string {
+	if e.sampled >= e.size {
+		return fmt.Sprintf("%s, fetched by the probe already", humanize.Bytes(e.size))
+	}
+	rate := e.rate()
+	if rate == 0 {
+		return fmt.Sprintf("%s, nothing came in during the probe to estimate the time from", humanize.Bytes(e.size))
+	}
+	eta := time.Duration(float64(e.size-e.sampled) / rate * float64(time.Second))
+	return fmt.Sprintf("%s, about %s at %s/s (approximate, from a %s bandwidth probe)",
+		humanize.Bytes(e.size), eta.Round(time.Second), humanize.Bytes(uint64(rate)), e.elapsed.Round(100*time.Millisecond))
+}
+
+// estimateFetch predicts how big the fetch of p is and how long it will
+// take. The size is the cumulative size recorded in the root block, and the
+// rate is sampled by fetching the first levels of the DAG for a few seconds
+// from providers dialed beforehand. The blocks the probe fetches are kept,
+// the fetch doesn't get them again.
+func estimateFetch(ctx context.Context, s *ipget.Session, p ipath.Path) (estimate, error) {
+	var est estimate
+	if s.Embedded() {
+		if err := s.WaitBootstrap(ctx); err != nil {
+			return est, err
+		}
+		// bitswap finds providers on its own if none of these connect
+		if _, err := hedgeProviders(ctx, s.API(), p, estimateProviders); err != nil && ctx.Err() == nil {
+			log.Printf("dialing providers for the estimate: %s", err)
+		}
+	}
+	rp, err := s.Resolve(ctx, p)
+	if err != nil {
+		return est, err
+	}
+
+	sctx, cancel := context.WithTimeout(ctx, estimateSampleTime)
+	defer cancel()
+	start := time.Now()
+	root, err := s.API().Dag().Get(sctx, rp.Cid())
+	if err != nil {
+		if ctx.Err() == nil && sctx.Err() != nil {
+			return est, fmt.Errorf("the root block didn't come in within %s", estimateSampleTime)
+		}
+		return est, err
+	}
+	if est.size, err = root.Size(); err != nil {
+		return est, err
+	}
+	est.sampled = uint64(len(root.RawData()))
+
+	level := root.Links()
+	for len(level) > 0 && est.sampled < estimateSampleBytes && sctx.Err() == nil {
+		cids := make([]cid.Cid, len(level))
+		for i, l := range level {
+			cids[i] = l.Cid
+		}
+		var next []*ipld.Link
+		for opt := range s.API().Dag().GetMany(sctx, cids) {
+			if opt.Err != nil {
+				// the probe ran out of time, or the fetch will
+				// run into the same error
+				break
+			}
+			est.sampled += uint64(len(opt.Node.RawData()))
+			next = append(next, opt.Node.Links()...)
+			if est.sampled >= estimateSampleBytes {
+				break
+			}
+		}
+		level = next
+	}
+	est.elapsed = time.Since(start)
+	if ctx.Err() != nil {
+		return est, ctx.Err()
+	}
+	return est, nil
+}
+
+// confirmFetch asks on stderr and stdin whether to go ahead with a fetch,
+// anything but yes declines.
+func confirmFetch(r io.Reader, name string) (bool, error) {
+	fmt.Fprintf(os.Stderr, "fetch %s? [y/N] ", name)
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+)
+
+// explainProviders is how many providers --explain looks up.
+const explainProviders = 20
+
+// explainer traces the steps of a fetch for --explain: bootstrapping, name
+// resolution, provider lookup and the arrival of the first bytes. Its
+// methods do nothing on a nil explainer, so callers needn't check.
+type explainer struct {
+	start time.Time
+}
+
+func newExplainer(enabled bool) *explainer {
+	if !enabled {
+		return nil
+	}
+	return &explainer{start: time.Now()}
+}
+
+// step logs one line of the trace, with the time since the fetch started.
+func (e *explainer) step(format string, args ...interface{}) {
+	if e == nil {
+		return
+	}
+	args = append([]interface{}{time.Since(e.start).Round(time.Millisecond)}, args...)
+	log.Printf("explain +%s: "+format, args...)
+}
+
+// bootstrap waits for a spawned node to bootstrap and lists the peers it
+// connected to.
+func (e *explainer) bootstrap(ctx context.Context, s *ipget.Session) {
+	if e == nil {
+		return
+	}
+	if !s.Embedded() {
+		e.step("using the local daemon, its routing isn't traced")
+		return
+	}
+	if err := s.WaitBootstrap(ctx); err != nil {
+		e.step("bootstrap failed: %s", err)
+		return
+	}
+	conns, err := s.API().Swarm().Peers(ctx)
+	if err != nil {
+		e.step("listing peers failed: %s", err)
+		return
+	}
+	e.step("bootstrapped, %d peers connected", len(conns))
+	for _, conn := range conns {
+		e.step("  connected to %s at %s", conn.ID(), conn.Address())
+	}
+}
+
+// resolve resolves p, tracing the DHT queries made for any IPNS name on the
+// way.
+func (e *explainer) resolve(ctx context.Context, s *ipget.Session, p ipath.Path) {
+	if e == nil {
+		return
+	}
+	start := time.Now()
+	rp, err := s.Resolve(e.queries(ctx, "resolve"), p)
+	if err != nil {
+		e.step("resolving %s failed: %s", p, err)
+		return
+	}
+	e.step("%s resolves to %s, took %s", p, rp.Cid(), time.Since(start).Round(time.Millisecond))
+	if rp.Cid().Type() == cid.DagProtobuf {
+		if nd, err := s.API().Dag().Get(ctx, rp.Cid()); err == nil && isShardedDir(nd) {
+			e.step("%s is a HAMT-sharded directory, its entries are read from several blocks and come in hash order", rp.Cid())
+		}
+	}
+
+	if s.Embedded() {
+		go e.providers(ctx, s, rp)
+	}
+}
+
+// providers looks up the providers of rp alongside the fetch, tracing each
+// peer asked. Bitswap runs its own lookup, which can't be traced, but this
+// one asks the same peers.
+func (e *explainer) providers(ctx context.Context, s *ipget.Session, rp ipath.Resolved) {
+	qctx := e.queries(ctx, "providers")
+	provs, err := s.API().Dht().FindProviders(qctx, rp, options.Dht.NumProviders(explainProviders))
+	if err != nil {
+		e.step("provider lookup failed: %s", err)
+		return
+	}
+	found := 0
+	for prov := range provs {
+		found++
+		e.step("providers: found %s with %d addresses", prov.ID, len(prov.Addrs))
+	}
+	if ctx.Err() == nil {
+		e.step("providers: lookup done, %d found", found)
+	}
+}
+
+// queries returns a context tracing the DHT queries made with it.
+func (e *explainer) queries(ctx context.Context, what string) context.Context {
+	ctx, events := routing.RegisterForQueryEvents(ctx)
+	go func() {
+		for ev := range events {
+			switch ev.Type {
+			case routing.DialingPeer:
+				e.step("%s: dialing %s", what, ev.ID)
+			case routing.SendingQuery:
+				e.step("%s: asking %s", what, ev.ID)
+			case routing.PeerResponse:
+				e.step("%s: %s replied with %d closer peers", what, ev.ID, len(ev.Responses))
+			case routing.QueryError:
+				e.step("%s: %s failed: %s", what, ev.ID, ev.Extra)
+			case routing.Value:
+				e.step("%s: %s returned a record", what, ev.ID)
+			}
+		}
+	}()
+	return ctx
+}
+
+// firstBytes returns the progress function that reports when the first
+// bytes of content arrive, nil if not explaining.
+func (e *explainer) firstBytes() ipget.ProgressFunc {
+	if e == nil {
+		return nil
+	}
+	var once sync.Once
+	return func(fetched, total int64) {
+		if fetched > 0 {
+			once.Do(func() { e.step("first bytes arrived") })
+		}
+	}
+}
@@ -0,0 +1,741 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/ipfs/ipget"
+	"github.com/ipfs/ipget/internal/dagutil"
+	pb "gopkg.in/cheggaaa/pb.v1"
+)
+
+// wrappedFile is a files.File whose content is read from r instead.
+type wrappedFile struct {
+	files.File
+	r io.Reader
+}
+
+func (f *wrappedFile) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
+
+// extractor writes unixfs nodes to a filesystem.
+type extractor struct {
+	// fs is written to, the local filesystem if nil.
+	fs ipget.FS
+	// progress shows a progress bar for the whole extraction.
+	progress bool
+	// progressMin is the size below which a one-line summary is shown
+	// instead of the progress bar.
+	progressMin int64
+	// stdoutTaken keeps the progress bar on stderr, stdout carrying
+	// something else.
+	stdoutTaken bool
+	// stats, if set, counts the file data written.
+	stats *transferStats
+	// atomic writes everything to a temporary sibling of the output first
+	// and only moves it into place once it is complete.
+	atomic bool
+	// exclude lists patterns of paths, relative to the root of the
+	// extraction, that are skipped along with everything below them.
+	exclude []string
+	// include, if set, lists patterns of the files to write, the others
+	// are skipped. minSize and maxSize, if positive, bound the size of the
+	// files written.
+	include          []string
+	minSize, maxSize int64
+	// checksums name the algorithms of the sidecar checksum files written
+	// next to every file, one per algorithm.
+	checksums []string
+	// compress, if set, is the format files are compressed to as they are
+	// written, at compressLevel, 0 for its default.
+	compress      string
+	compressLevel int
+	// overwrite is the policy for files that already exist, one of
+	// overwritePolicies. Empty means "overwrite".
+	overwrite string
+	// same holds the files found to be on disk already with the content
+	// they have in the DAG, which the if-different overwrite policy leaves
+	// alone. See findUnchanged. kept counts those left alone, replaced the
+	// files that were there with other content.
+	same           map[string]bool
+	kept, replaced int
+	// appendTo appends a file fetched to the output rather than replacing
+	// it. See appendFile.
+	appendTo bool
+	// copies are more paths a file fetched is written to as it is written
+	// to the output, teeBestEffort carries on with the others should one
+	// of them fail. See teeWriter.
+	copies        []string
+	teeBestEffort bool
+	// preallocate reserves the size of every file on disk before writing
+	// it. See preallocate.
+	preallocate bool
+	// keepGoing carries on with the rest of a directory when one of its
+	// entries fails, rather than stopping there. The failures are reported
+	// once everything else is written.
+	keepGoing bool
+	// maxTotal, if positive, is the most file data the extraction may
+	// write. Going over it aborts the extraction and removes what it wrote.
+	maxTotal int64
+	// reproducible writes the entries of directories in lexicographic
+	// order and gives everything written the modification time mtime, so
+	// that fetching the same CID twice gives the same output, metadata
+	// included.
+	reproducible bool
+	mtime        time.Time
+	// manifest, if set, records every file written.
+	manifest *manifest
+	// resume, if set, lists the files an earlier run wrote in full, which
+	// are skipped, and records those written now.
+	resume *resumeState
+	// flatten writes every file straight into the root of the extraction,
+	// rather than recreating the directories they're in. Names clashing
+	// with a file written earlier are made unique, and listed in renamed.
+	flatten bool
+	renamed []string
+	// flat holds the names given out by flatten.
+	flat map[string]bool
+
+	failed  []string
+	written int64
+	// wrote counts the files and symlinks written, skipped the entries
+	// --exclude, --include or the size bounds left out, each excluded
+	// directory counting once.
+	wrote, skipped int
+	// created lists the files and directories written so far, parents
+	// first.
+	created []string
+
+	bar *pb.ProgressBar
+	// root is where the extraction is written to, target where it ends up.
+	// They only differ with atomic.
+	root, target string
+	// claimed is where the root was written to, once it was: root, or
+	// the name the rename overwrite policy gave it. An interrupted
+	// extraction leaves its partial output there.
+	claimed string
+}
+
+// WriteTo writes the given node to the local filesystem at fpath.
+func (e *extractor) WriteTo(nd files.Node, fpath string) (err error) {
+	if e.maxTotal > 0 {
+		// The size recorded in the DAG is cheap to get, though it also
+		// counts the DAG's own overhead.
+		if s, err := nd.Size(); err == nil && s > e.maxTotal {
+			return fmt.Errorf("%s is %s, more than --max-total-size of %s", fpath,
+				humanize.Bytes(uint64(s)), humanize.Bytes(uint64(e.maxTotal)))
+		}
+	}
+	if e.progress {
+		s, sizeErr := nd.Size()
+		if sizeErr != nil {
+			return sizeErr
+		}
+		if s < e.progressMin || s == 0 {
+			// not worth a bar, say what was written once it's done; an
+			// empty file would leave one at a meaningless 0%
+			start := time.Now()
+			defer func() {
+				if err == nil {
+					line := fmt.Sprintf("%s: %s in %s", fpath, humanize.Bytes(uint64(s)), time.Since(start).Round(time.Millisecond))
+					fmt.Fprintln(os.Stderr, paint(colorGreen, fitWidth(line)))
+				}
+			}()
+		} else {
+			e.bar = pb.New64(s)
+			if fpath == "-" || e.stdoutTaken {
+				e.bar.Output = os.Stderr
+			}
+			e.bar.Start()
+		}
+	}
+
+	if fpath == "-" {
+		// Written as it is read, so memory doesn't grow with the file:
+		// the unixfs reader only holds the block being read and the few it
+		// fetches ahead at each level of the DAG, and an orderedReader
+		// holds at most its window, or --max-memory.
+		f, ok := nd.(files.File)
+		if !ok {
+			return fmt.Errorf("only files can be written to stdout")
+		}
+		cw, err := compressTo(os.Stdout, e.compress, e.compressLevel)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(cw, e.reader(f)); err != nil {
+			return err
+		}
+		return cw.Close()
+	}
+
+	if e.atomic && e.overwrite != "" && e.overwrite != "overwrite" {
+		// Nothing is in the way of the temporary output, only of where
+		// it's moved to. Overwriting is left to the final rename.
+		target, err := e.claim(fpath)
+		if err != nil || target == "" {
+			return err
+		}
+		fpath = target
+	}
+
+	e.target = fpath
+	if !e.atomic {
+		e.root = fpath
+		return e.extract(nd, fpath)
+	}
+
+	tmp := fpath + ".tmp"
+	e.root = tmp
+	// clear out leftovers of an earlier crashed run
+	if err := e.files().RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := e.extract(nd, tmp); err != nil {
+		e.files().RemoveAll(tmp)
+		return err
+	}
+	if err := e.files().Rename(tmp, fpath); err != nil {
+		e.files().RemoveAll(tmp)
+		return err
+	}
+	return nil
+}
+
+// extract writes nd to fpath and reports the entries that failed along the
+// way, if any.
+func (e *extractor) extract(nd files.Node, fpath string) error {
+	if err := e.writeToRec(nd, fpath); err != nil {
+		if errors.Is(err, errTotalSize) {
+			e.removeCreated()
+			return fmt.Errorf("%s exceeded --max-total-size of %s, partial output removed",
+				fpath, humanize.Bytes(uint64(e.maxTotal)))
+		}
+		if _, isDir := nd.(files.Directory); isDir && !e.keepGoing && !isFatal(err) {
+			return fmt.Errorf("%s (stopped at the first error, --keep-going fetches the rest)", err)
+		}
+		return err
+	}
+	if len(e.failed) > 0 {
+		return fmt.Errorf("%d entries failed, kept going past them (--keep-going), %s:\n  %s",
+			len(e.failed), e.tally(), strings.Join(e.failed, "\n  "))
+	}
+	return nil
+}
+
+// tally says how many files were written and how many entries the filters
+// skipped.
+func (e *extractor) tally() string {
+	if e.skipped == 0 {
+		return fmt.Sprintf("%d written", e.wrote)
+	}
+	return fmt.Sprintf("%d written, %d skipped by filters", e.wrote, e.skipped)
+}
+
+func (e *extractor) writeToRec(nd files.Node, fpath string) error {
+	if e.excluded(fpath) || !e.selects(nd, fpath) {
+		e.skipped++
+		return nil
+	}
+	if e.flatten && fpath != e.root {
+		if dir, isDir := nd.(files.Directory); isDir {
+			// only the files in it are written, straight into the root
+			return e.writeEntries(dir, fpath)
+		}
+		fpath = e.flatPath(fpath)
+	}
+	if e.resume.has(e.rel(fpath)) {
+		if _, err := e.files().Lstat(fpath); err == nil {
+			return nil
+		}
+	}
+	if e.same[fpath] {
+		// never read, so never fetched
+		e.kept++
+		return nil
+	}
+	if f, ok := nd.(files.File); ok && e.appendTo && fpath == e.root {
+		return e.appendFile(f, fpath)
+	}
+	root := fpath == e.root
+	if _, isDir := nd.(files.Directory); !isDir || !e.isExistingDir(fpath) {
+		var err error
+		if fpath, err = e.claim(fpath); err != nil || fpath == "" {
+			// Skipped files are never read, so their blocks aren't fetched.
+			return err
+		}
+	}
+	if root {
+		e.claimed = fpath
+	}
+
+	switch nd := nd.(type) {
+	case *files.Symlink:
+		if err := e.files().Symlink(nd.Target, fpath); err != nil {
+			return err
+		}
+		e.created = append(e.created, fpath)
+		e.wrote++
+		return e.resume.add(e.rel(fpath))
+	case files.File:
+		f, err := e.files().Create(fpath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		e.created = append(e.created, fpath)
+
+		// the size of a compressed file isn't known until it is written
+		allocated := false
+		if e.preallocate && e.compress == "" {
+			if allocated, err = preallocate(f, nd); err != nil {
+				return e.dropFailed(fpath, err)
+			}
+		}
+
+		// the checksums are of what ends up on disk, compressed or not
+		var w io.Writer = f
+		var sum *sums
+		if len(e.checksums) > 0 {
+			sum = newSums(e.checksums)
+			w = io.MultiWriter(f, sum.writer())
+		}
+		var tee *teeWriter
+		if fpath == e.root && len(e.copies) > 0 {
+			if tee, err = e.openCopies(w, fpath); err != nil {
+				return err
+			}
+			w = tee
+		}
+		cw, err := compressTo(w, e.compress, e.compressLevel)
+		if err != nil {
+			e.closeCopies(tee, err)
+			return err
+		}
+		n, err := io.Copy(cw, e.reader(nd))
+		if err == nil {
+			err = cw.Close()
+		}
+		// with --tee-best-effort, the copies that failed are reported once
+		// the output is complete
+		var copyErr error
+		if cerr := e.closeCopies(tee, err); err == nil && cerr != nil {
+			if tee.dests[0].err != nil {
+				// the output itself failed, carrying on saved the copies
+				e.files().Remove(fpath)
+				return cerr
+			}
+			copyErr = cerr
+		}
+		if err == nil && e.reproducible {
+			// closing after stamping could still move the time
+			if err = f.Close(); err == nil {
+				err = e.stamp(fpath)
+			}
+		}
+		if err != nil {
+			if allocated {
+				trimAllocated(f, n)
+			}
+			return e.dropFailed(fpath, err)
+		}
+		if sum != nil {
+			for i, algo := range sum.algos {
+				sidecar := e.final(fpath) + "." + algo
+				if err := writeSidecar(e.files(), e.final(fpath), algo, sum.hashes[i].Sum(nil)); err != nil {
+					return err
+				}
+				e.created = append(e.created, sidecar)
+				if err := e.stamp(sidecar); err != nil {
+					return err
+				}
+			}
+		}
+		e.manifest.add(e.rel(fpath), n, sum)
+		e.wrote++
+		if copyErr != nil {
+			return copyErr
+		}
+		return e.resume.add(e.rel(fpath))
+	case files.Directory:
+		// existing directories are merged into
+		err := e.files().Mkdir(fpath, 0777)
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
+		if err == nil {
+			e.created = append(e.created, fpath)
+		}
+
+		if err := e.writeEntries(nd, fpath); err != nil {
+			return err
+		}
+		// after the entries, whose writing moves the time of the directory
+		return e.stamp(fpath)
+	default:
+		return fmt.Errorf("file type %T at %q is not supported", nd, fpath)
+	}
+}
+
+// openCopies creates the copies of the file written to fpath through w,
+// returning the writer feeding them all. With teeBestEffort a copy that
+// can't be created is only warned about.
+func (e *extractor) openCopies(w io.Writer, fpath string) (*teeWriter, error) {
+	tee := &teeWriter{dests: []*teeDest{{path: fpath, w: w}}, bestEffort: e.teeBestEffort}
+	for _, cp := range e.copies {
+		d := &teeDest{path: cp}
+		f, err := e.files().Create(cp)
+		if err != nil {
+			d.err = fmt.Errorf("creating %s: %s", cp, err)
+			if !e.teeBestEffort {
+				e.closeCopies(tee, d.err)
+				return nil, d.err
+			}
+			log.Printf("WARNING: %s, carrying on with the other outputs", d.err)
+		} else {
+			d.w = f
+			e.created = append(e.created, cp)
+		}
+		tee.dests = append(tee.dests, d)
+	}
+	return tee, nil
+}
+
+// closeCopies closes the copies of tee once err ended the write, nil if it
+// went through, and removes those that weren't written in full. It returns
+// the error the write ends with.
+func (e *extractor) closeCopies(tee *teeWriter, err error) error {
+	if tee == nil {
+		return err
+	}
+	for _, d := range tee.dests[1:] {
+		c, ok := d.w.(io.Closer)
+		if !ok {
+			continue
+		}
+		if cerr := c.Close(); cerr != nil && d.err == nil {
+			d.err = fmt.Errorf("writing %s: %s", d.path, cerr)
+		}
+		if err != nil || d.err != nil {
+			e.files().Remove(d.path)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return tee.Err()
+}
+
+// appendFile appends nd to the file at fpath, creating it if need be. The
+// content is written as it arrives and synced to disk once complete. Should
+// the fetch fail or be interrupted, the file is cut back to the size it had
+// before, so it is only ever extended by whole fetches.
+func (e *extractor) appendFile(nd files.File, fpath string) error {
+	afs, ok := e.files().(ipget.AppendFS)
+	if !ok {
+		return fmt.Errorf("the filesystem written to can't append to %s", fpath)
+	}
+	var before int64
+	if fi, err := e.files().Lstat(fpath); err == nil {
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file, it can't be appended to", fpath)
+		}
+		before = fi.Size()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	f, err := afs.Append(fpath)
+	if err != nil {
+		return err
+	}
+	cw, err := compressTo(f, e.compress, e.compressLevel)
+	var n int64
+	if err == nil {
+		n, err = io.Copy(cw, e.reader(nd))
+	}
+	if err == nil {
+		err = cw.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		if terr := f.Truncate(before); terr != nil {
+			err = fmt.Errorf("%s, and cutting %s back to %d bytes failed: %s", err, fpath, before, terr)
+		}
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := e.stamp(fpath); err != nil {
+		return err
+	}
+	e.manifest.add(e.rel(fpath), n, nil)
+	return nil
+}
+
+// writeEntries writes the entries of dir, at fpath, one after the other.
+func (e *extractor) writeEntries(dir files.Directory, fpath string) error {
+	entries := dir.Entries()
+	if e.reproducible {
+		// sharded directories list their entries in hash order
+		var err error
+		if entries, err = sortedEntries(entries); err != nil {
+			return err
+		}
+	}
+	for entries.Next() {
+		child := filepath.Join(fpath, entries.Name())
+		err := dagutil.CheckName(entries.Name())
+		if err == nil {
+			err = e.writeToRec(entries.Node(), child)
+		}
+		if err != nil {
+			if !e.keepGoing || isFatal(err) {
+				return err
+			}
+			e.failed = append(e.failed, fmt.Sprintf("%s: %s", child, err))
+			e.manifest.fail(e.rel(child), err)
+		}
+	}
+	return entries.Err()
+}
+
+// flatPath returns where a flattened extraction writes the file at fpath:
+// in the root, under its own name unless an earlier file took it. The names
+// of its parent directories are then put in front of it, one by one, until
+// it is unique, and failing that a counter is added.
+func (e *extractor) flatPath(fpath string) string {
+	if e.flat == nil {
+		e.flat = make(map[string]bool)
+	}
+	rel := e.rel(fpath)
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	base := parts[len(parts)-1]
+	name := base
+	for i := len(parts) - 2; i >= 0 && e.flat[name]; i-- {
+		name = parts[i] + "-" + name
+	}
+	if e.flat[name] {
+		ext := filepath.Ext(base)
+		prefix := strings.TrimSuffix(name, ext)
+		for i := 1; e.flat[name]; i++ {
+			name = fmt.Sprintf("%s.%d%s", prefix, i, ext)
+		}
+	}
+	e.flat[name] = true
+	if name != base {
+		e.renamed = append(e.renamed, fmt.Sprintf("%s as %s", rel, name))
+	}
+	return filepath.Join(e.root, name)
+}
+
+// stamp gives fpath the modification time of a reproducible extraction.
+// Symlinks are left alone, they can't be stamped without following them.
+func (e *extractor) stamp(fpath string) error {
+	if !e.reproducible {
+		return nil
+	}
+	return e.files().Chtimes(fpath, e.mtime, e.mtime)
+}
+
+// sortedEntries reads all of it and returns the same entries sorted by name.
+func sortedEntries(it files.DirIterator) (files.DirIterator, error) {
+	var names []string
+	nodes := make(map[string]files.Node)
+	for it.Next() {
+		names = append(names, it.Name())
+		nodes[it.Name()] = it.Node()
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	entries := make([]files.DirEntry, len(names))
+	for i, name := range names {
+		entries[i] = files.FileEntry(name, nodes[name])
+	}
+	return files.NewSliceDirectory(entries).Entries(), nil
+}
+
+// reproducibleTime is the modification time of a reproducible extraction:
+// $SOURCE_DATE_EPOCH if set, as is the convention for reproducible builds,
+// or else the Unix epoch.
+func reproducibleTime() (time.Time, error) {
+	v := os.Getenv("SOURCE_DATE_EPOCH")
+	if v == "" {
+		return time.Unix(0, 0), nil
+	}
+	secs, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q, it must be seconds since the Unix epoch", v)
+	}
+	return time.Unix(secs, 0), nil
+}
+
+// dropFailed removes the file at fpath if writing it failed with err and we
+// keep going, so that only complete files are left behind.
+func (e *extractor) dropFailed(fpath string, err error) error {
+	if err != nil && e.keepGoing && !isFatal(err) {
+		e.files().Remove(fpath)
+	}
+	return err
+}
+
+// isFatal reports whether err stops the whole extraction, even when keeping
+// going: the fetch was cancelled or there's no room left for more.
+func isFatal(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, errTotalSize)
+}
+
+// errTotalSize is returned by reads that take the extraction over maxTotal.
+var errTotalSize = errors.New("over --max-total-size")
+
+// removeCreated removes everything the extraction wrote, children first.
+// Directories that were already there are left alone.
+func (e *extractor) removeCreated() {
+	for i := len(e.created) - 1; i >= 0; i-- {
+		e.files().Remove(e.created[i])
+	}
+	e.created = nil
+}
+
+// overwritePolicies are the ways files that already exist can be dealt with.
+var overwritePolicies = []string{"error", "skip", "overwrite", "rename", "if-different"}
+
+// checkOverwritePolicy makes sure policy is one of overwritePolicies.
+func checkOverwritePolicy(policy string) error {
+	for _, p := range overwritePolicies {
+		if p == policy {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown overwrite policy %q, must be one of %s", policy, strings.Join(overwritePolicies, ", "))
+}
+
+// claim applies the overwrite policy to fpath, which is about to be written.
+// It returns the path to write to instead, or "" if it should be skipped.
+func (e *extractor) claim(fpath string) (string, error) {
+	if _, err := e.files().Lstat(fpath); os.IsNotExist(err) {
+		return fpath, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	switch e.overwrite {
+	case "", "overwrite":
+		return fpath, e.files().Remove(fpath)
+	case "if-different":
+		// those with the same content were left alone already
+		e.replaced++
+		return fpath, e.files().Remove(fpath)
+	case "skip":
+		return "", nil
+	case "rename":
+		ext := filepath.Ext(fpath)
+		base := strings.TrimSuffix(fpath, ext)
+		for i := 1; ; i++ {
+			renamed := fmt.Sprintf("%s.%d%s", base, i, ext)
+			if _, err := e.files().Lstat(renamed); os.IsNotExist(err) {
+				return renamed, nil
+			} else if err != nil {
+				return "", err
+			}
+		}
+	default:
+		return "", fmt.Errorf("%s already exists", fpath)
+	}
+}
+
+func (e *extractor) isExistingDir(fpath string) bool {
+	fi, err := e.files().Lstat(fpath)
+	return err == nil && fi.IsDir()
+}
+
+// files returns the filesystem written to.
+func (e *extractor) files() ipget.FS {
+	if e.fs == nil {
+		return ipget.OSFS
+	}
+	return e.fs
+}
+
+// final returns where fpath will end up once the extraction is complete.
+func (e *extractor) final(fpath string) string {
+	if fpath == e.root {
+		return e.target
+	}
+	return fpath
+}
+
+// rel returns fpath relative to the root of the extraction, or the name
+// the root ends up with for the root itself.
+func (e *extractor) rel(fpath string) string {
+	if fpath == e.root {
+		return filepath.Base(e.target)
+	}
+	rel, err := filepath.Rel(e.root, fpath)
+	if err != nil {
+		return fpath
+	}
+	return rel
+}
+
+// excluded reports whether fpath matches one of the exclude patterns. The
+// root itself is never excluded.
+func (e *extractor) excluded(fpath string) bool {
+	if len(e.exclude) == 0 || fpath == e.root {
+		return false
+	}
+	rel, err := filepath.Rel(e.root, fpath)
+	if err != nil {
+		return false
+	}
+	return matchesAny(e.exclude, filepath.ToSlash(rel))
+}
+
+// reader wraps r so that reads from it are reflected in the progress bar and
+// transfer stats, and count towards maxTotal.
+func (e *extractor) reader(r io.Reader) io.Reader {
+	if e.bar != nil {
+		r = e.bar.NewProxyReader(r)
+	}
+	if e.stats != nil {
+		r = e.stats.reader(r)
+	}
+	if e.maxTotal > 0 {
+		r = &totalSizeReader{r: r, e: e}
+	}
+	return r
+}
+
+// totalSizeReader fails once the extraction has read more than maxTotal
+// bytes, whatever size the DAG claimed.
+type totalSizeReader struct {
+	r io.Reader
+	e *extractor
+}
+
+func (t *totalSizeReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.e.written += int64(n)
+	if t.e.written > t.e.maxTotal {
+		return n, errTotalSize
+	}
+	return n, err
+}
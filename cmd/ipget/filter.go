@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+
+	humanize "github.com/dustin/go-humanize"
+	cid "github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+)
+
+// selects reports whether nd, to be written at fpath, passes the --include
+// patterns and the per-file size bounds. Directories are always walked, and
+// the root is always written, as with --exclude. Files that don't pass are
+// never read, so only their root block is fetched, for their size.
+func (e *extractor) selects(nd files.Node, fpath string) bool {
+	if fpath == e.root {
+		return true
+	}
+	switch nd := nd.(type) {
+	case files.Directory:
+		return true
+	case *files.Symlink:
+		return e.selected(fpath, -1)
+	case files.File:
+		size, err := nd.Size()
+		if err != nil {
+			// let the write report it
+			return true
+		}
+		return e.selected(fpath, size)
+	}
+	return true
+}
+
+// selected reports whether the file at fpath, of size bytes or -1 if it
+// has none such as a symlink, passes the --include patterns and the size
+// bounds. A pattern matches the path relative to the root of the
+// extraction or the name of the file alone, so *.csv picks CSV files at
+// any depth.
+func (e *extractor) selected(fpath string, size int64) bool {
+	if len(e.include) > 0 {
+		rel := filepath.ToSlash(e.rel(fpath))
+		if !matchesAny(e.include, rel) && !matchesAny(e.include, path.Base(rel)) {
+			return false
+		}
+	}
+	if size < 0 {
+		return true
+	}
+	return size >= e.minSize && (e.maxSize == 0 || size <= e.maxSize)
+}
+
+// filtering reports whether files are selected by --include or size.
+func (e *extractor) filtering() bool {
+	return len(e.include) > 0 || e.minSize > 0 || e.maxSize > 0
+}
+
+// selection is what the filters pick out of a directory.
+type selection struct {
+	files, total int
+	bytes, all   uint64
+}
+
+func (sel selection) String() string {
+	return fmt.Sprintf("%d of %d files selected, %s of %s", sel.files, sel.total,
+		humanize.Bytes(sel.bytes), humanize.Bytes(sel.all))
+}
+
+// countSelected adds up the files of the directory c, to be written at
+// dir, and those the filters select. Only the directory listings are
+// fetched, the sizes are those recorded in the DAG.
+func (e *extractor) countSelected(ctx context.Context, ipfs iface.CoreAPI, c cid.Cid, dir string, sel *selection) error {
+	entries, err := lsDir(ctx, ipfs, c)
+	if err != nil {
+		return err
+	}
+	for name, ent := range entries {
+		child := filepath.Join(dir, name)
+		if e.excluded(child) {
+			continue
+		}
+		if ent.Type == iface.TDirectory {
+			if err := e.countSelected(ctx, ipfs, ent.Cid, child, sel); err != nil {
+				return err
+			}
+			continue
+		}
+		size := int64(-1)
+		if ent.Type == iface.TFile {
+			size = int64(ent.Size)
+		}
+		sel.total++
+		sel.all += ent.Size
+		if e.selected(child, size) {
+			sel.files++
+			sel.bytes += ent.Size
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	nethttp "net/http"
+	"strings"
+)
+
+// defaultGateway is the public gateway --use-default-gateway falls back to.
+// Nothing is sent to it unless asked.
+const defaultGateway = "https://ipfs.io"
+
+// parseHeaders parses headers given as "Name: value", repeating a name
+// adds a value to it.
+func parseHeaders(lines []string) (nethttp.Header, error) {
+	h := nethttp.Header{}
+	for _, line := range lines {
+		i := strings.Index(line, ":")
+		name := ""
+		if i > 0 {
+			name = strings.TrimSpace(line[:i])
+		}
+		if name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header %q, it must look like \"Name: value\"", line)
+		}
+		h.Add(name, strings.TrimSpace(line[i+1:]))
+	}
+	return h, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// hedgeProviders dials the first n providers found for the root of p all
+// at once and returns the one that connects first, cancelling the other
+// dials, so that one slow provider can't hold up the start of the fetch.
+func hedgeProviders(ctx context.Context, ipfs iface.CoreAPI, p ipath.Path, n int) (peer.ID, error) {
+	root, err := providedRoot(ctx, ipfs, p)
+	if err != nil {
+		return "", err
+	}
+	hctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	provs, err := ipfs.Dht().FindProviders(hctx, root, options.Dht.NumProviders(n))
+	if err != nil {
+		return "", err
+	}
+
+	won := make(chan peer.ID, 1)
+	failed := make(chan error)
+	dialed, pending := 0, 0
+	var lastErr error
+	for {
+		select {
+		case pi, ok := <-provs:
+			if !ok {
+				provs = nil
+				break
+			}
+			dialed++
+			pending++
+			go func(pi peer.AddrInfo) {
+				dctx, dcancel := context.WithTimeout(hctx, providerDialTimeout)
+				defer dcancel()
+				if err := ipfs.Swarm().Connect(dctx, pi); err != nil {
+					select {
+					case failed <- err:
+					case <-hctx.Done():
+					}
+					return
+				}
+				select {
+				case won <- pi.ID:
+				default:
+				}
+			}(pi)
+		case id := <-won:
+			return id, nil
+		case lastErr = <-failed:
+			pending--
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		if provs == nil && pending == 0 {
+			if dialed == 0 {
+				return "", fmt.Errorf("no providers found to race")
+			}
+			return "", fmt.Errorf("none of the %d providers raced connected: %s", dialed, lastErr)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+
+	cli "github.com/urfave/cli"
+)
+
+// hookError is the failure of an --on-complete hook, which isn't a failed
+// fetch to run --on-error for.
+type hookError struct{ *cli.ExitError }
+
+// runHook runs cmd through the shell with env added to ipget's environment,
+// its output going to stderr so it doesn't mix with ours. A failing hook is
+// logged, and returned as well with --fail-on-hook.
+func runHook(ctx context.Context, c *cli.Context, flag, cmd string, env map[string]string) error {
+	hook := exec.CommandContext(ctx, "sh", "-c", cmd)
+	hook.Env = os.Environ()
+	for k, v := range env {
+		hook.Env = append(hook.Env, k+"="+v)
+	}
+	hook.Stdout, hook.Stderr = os.Stderr, os.Stderr
+	err := hook.Run()
+	if err == nil {
+		return nil
+	}
+	log.Printf("--%s hook failed: %s", flag, err)
+	if c.Bool("fail-on-hook") {
+		return hookError{cli.NewExitError(fmt.Sprintf("--%s hook failed: %s", flag, err), 2)}
+	}
+	return nil
+}
+
+// onComplete runs the --on-complete hook, if any, for t written to outPath.
+func onComplete(ctx context.Context, c *cli.Context, t *target, outPath, cid string) error {
+	cmd := c.String("on-complete")
+	if cmd == "" {
+		return nil
+	}
+	return runHook(ctx, c, "on-complete", cmd, map[string]string{
+		"IPGET_NAME":   t.name,
+		"IPGET_CID":    cid,
+		"IPGET_OUTPUT": outPath,
+	})
+}
+
+// onError runs the --on-error hook, if any, for t failing with err. The
+// error it returns is the one to fail with.
+func onError(ctx context.Context, c *cli.Context, t *target, err error) error {
+	cmd := c.String("on-error")
+	if cmd == "" || err == nil {
+		return err
+	}
+	if _, ok := err.(hookError); ok {
+		return err
+	}
+	if ec, ok := err.(cli.ExitCoder); ok && ec.ExitCode() == exitNotModified {
+		return err
+	}
+	// the fetch may have been interrupted, the hook shouldn't be
+	if herr := runHook(context.Background(), c, "on-error", cmd, map[string]string{
+		"IPGET_NAME":   t.name,
+		"IPGET_OUTPUT": t.outPath,
+		"IPGET_ERROR":  err.Error(),
+	}); herr != nil {
+		return herr
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/ipget"
+)
+
+// idleCheckInterval is the longest time between two checks of an idle
+// watch.
+const idleCheckInterval = time.Second
+
+// idleWatch gives up on a fetch that goes timeout without receiving any
+// block or reading any byte, which is what a provider that keeps the
+// connection open but stopped sending looks like. Unlike --timeout it lets a
+// fetch take as long as it needs, so long as it moves.
+type idleWatch struct {
+	timeout time.Duration
+	// blocks returns the number of blocks fetched so far, which a spawned
+	// node counts as they are stored.
+	blocks func() int
+
+	mu      sync.Mutex
+	last    time.Time
+	seen    int
+	stalled bool
+}
+
+// newIdleWatch returns a watch giving up after timeout without progress, nil
+// if timeout is 0.
+func newIdleWatch(timeout time.Duration, blocks func() int) *idleWatch {
+	if timeout <= 0 {
+		return nil
+	}
+	return &idleWatch{timeout: timeout, blocks: blocks}
+}
+
+// touch restarts the clock.
+func (w *idleWatch) touch() {
+	w.mu.Lock()
+	w.last = time.Now()
+	w.mu.Unlock()
+}
+
+// watch returns a context that is cancelled once the fetch has gone timeout
+// without progress, counting from now.
+func (w *idleWatch) watch(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if w == nil {
+		return ctx, cancel
+	}
+	w.mu.Lock()
+	w.last, w.seen = time.Now(), w.blocks()
+	w.mu.Unlock()
+	interval := w.timeout / 4
+	if interval > idleCheckInterval {
+		interval = idleCheckInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			n := w.blocks()
+			w.mu.Lock()
+			if n != w.seen {
+				w.last, w.seen = time.Now(), n
+			}
+			stalled := time.Since(w.last) >= w.timeout
+			w.stalled = w.stalled || stalled
+			w.mu.Unlock()
+			if stalled {
+				cancel()
+				return
+			}
+		}
+	}()
+	return ctx, cancel
+}
+
+// progress returns the progress function that restarts the clock as the
+// content is read, and then calls fn, if set.
+func (w *idleWatch) progress(fn ipget.ProgressFunc) ipget.ProgressFunc {
+	return func(fetched, total int64) {
+		w.touch()
+		if fn != nil {
+			fn(fetched, total)
+		}
+	}
+}
+
+// Err returns the error of a fetch given up on as stalled, if it was.
+func (w *idleWatch) Err() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.stalled {
+		return nil
+	}
+	return fmt.Errorf("stalled — no data for %s", w.timeout)
+}
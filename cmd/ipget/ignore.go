@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// defaultIgnoreFile is read from the working directory, if present, for
+// patterns of paths to leave out of directory extraction.
+const defaultIgnoreFile = ".ipgetignore"
+
+// readIgnoreFile returns the patterns listed in the file at fpath, one per
+// line. Blank lines and lines starting with '#' are skipped.
+func readIgnoreFile(fpath string) ([]string, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// checkPatterns makes sure all patterns are well formed, so a bad one is
+// reported before anything is fetched.
+func checkPatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %s", p, err)
+		}
+	}
+	return nil
+}
+
+// matchesAny reports whether the slash separated path rel, relative to the
+// root of the extraction, matches one of patterns.
+func matchesAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
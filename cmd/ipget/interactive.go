@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// providerChoice is the set of providers picked with --interactive. Until
+// a choice is made every provider is kept.
+type providerChoice struct {
+	mu      sync.Mutex
+	allowed map[peer.ID]bool
+}
+
+// chosenProviders is the choice the spawned node of an --interactive fetch
+// filters its providers through.
+var chosenProviders providerChoice
+
+func (pc *providerChoice) keep(pi peer.AddrInfo) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.allowed == nil || pc.allowed[pi.ID]
+}
+
+func (pc *providerChoice) set(ids []peer.ID) {
+	allowed := make(map[peer.ID]bool, len(ids))
+	for _, id := range ids {
+		allowed[id] = true
+	}
+	pc.mu.Lock()
+	pc.allowed = allowed
+	pc.mu.Unlock()
+}
+
+// stdinIsTerminal reports whether there is someone at stdin to ask.
+func stdinIsTerminal() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// chooseProviders lists the providers of the root of p on stderr and asks
+// on stdin which of them to fetch from. The session then only uses those:
+// it connects to them, drops its connections to the others and ignores
+// other providers found from then on.
+func chooseProviders(ctx context.Context, s *ipget.Session, p ipath.Path) error {
+	root, err := providedRoot(ctx, s.API(), p)
+	if err != nil {
+		return err
+	}
+	provs, err := s.API().Dht().FindProviders(ctx, root, options.Dht.NumProviders(verifyProvidersCount))
+	if err != nil {
+		return err
+	}
+	var found []peer.AddrInfo
+	for prov := range provs {
+		found = append(found, prov)
+		fmt.Fprintf(os.Stderr, "%3d  %s\n", len(found), prov.ID)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("no providers found for %s", root.Cid())
+	}
+
+	fmt.Fprintf(os.Stderr, "fetch from which providers (e.g. 1,3, empty for all)? ")
+	picked, err := readChoice(bufio.NewReader(os.Stdin), found)
+	if err != nil {
+		return err
+	}
+	if picked == nil {
+		return nil
+	}
+	ids := make([]peer.ID, len(picked))
+	for i, pi := range picked {
+		ids[i] = pi.ID
+	}
+	chosenProviders.set(ids)
+
+	for _, id := range s.Node().PeerHost.Network().Peers() {
+		if !chosenProviders.keep(peer.AddrInfo{ID: id}) {
+			s.Node().PeerHost.Network().ClosePeer(id)
+		}
+	}
+	for _, pi := range picked {
+		dctx, cancel := context.WithTimeout(ctx, providerDialTimeout)
+		err := s.API().Swarm().Connect(dctx, pi)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "provider %s is unreachable: %s\n", pi.ID, err)
+		}
+	}
+	return nil
+}
+
+// readChoice reads a line of comma separated provider numbers, counted
+// from 1, and returns those providers, or nil for an empty line.
+func readChoice(r *bufio.Reader, found []peer.AddrInfo) ([]peer.AddrInfo, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil, nil
+	}
+	var picked []peer.AddrInfo
+	for _, field := range strings.Split(line, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 || n > len(found) {
+			return nil, fmt.Errorf("no provider numbered %q, pick from 1 to %d", strings.TrimSpace(field), len(found))
+		}
+		picked = append(picked, found[n-1])
+	}
+	return picked, nil
+}
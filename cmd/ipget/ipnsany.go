@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	ipns "github.com/ipfs/go-ipns"
+	ipnspb "github.com/ipfs/go-ipns/pb"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	cli "github.com/urfave/cli"
+)
+
+// parseIPNSNames parses the comma separated list of --ipns-any. Only keys
+// have records to compare, so DNSLink names aren't accepted.
+func parseIPNSNames(list string) ([]peer.ID, error) {
+	var pids []peer.ID
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimPrefix(strings.TrimSpace(name), "/ipns/")
+		if name == "" {
+			continue
+		}
+		pid, err := peer.Decode(name)
+		if err != nil {
+			return nil, fmt.Errorf("--ipns-any takes IPNS keys, %q isn't one: %s", name, err)
+		}
+		pids = append(pids, pid)
+	}
+	if len(pids) == 0 {
+		return nil, fmt.Errorf("--ipns-any needs at least one IPNS key")
+	}
+	return pids, nil
+}
+
+// freshestRecord looks the records of pids up concurrently and returns the
+// key with the freshest valid one and its record. Names that don't resolve
+// are logged and left out.
+func freshestRecord(ctx context.Context, s *ipget.Session, pids []peer.ID) (peer.ID, *ipnspb.IpnsEntry, error) {
+	entries := make([]*ipnspb.IpnsEntry, len(pids))
+	var wg sync.WaitGroup
+	for i, pid := range pids {
+		wg.Add(1)
+		go func(i int, pid peer.ID) {
+			defer wg.Done()
+			entry, err := s.IPNSRecord(ctx, pid)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("resolving /ipns/%s failed: %s", pid, err)
+				}
+				return
+			}
+			entries[i] = entry
+		}(i, pid)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return "", nil, ctx.Err()
+	}
+
+	best := -1
+	for i, entry := range entries {
+		if entry == nil {
+			continue
+		}
+		if best < 0 {
+			best = i
+			continue
+		}
+		// ties go to the name given first
+		if cmp, err := ipns.Compare(entry, entries[best]); err == nil && cmp > 0 {
+			best = i
+		}
+	}
+	if best < 0 {
+		return "", nil, fmt.Errorf("none of the %d IPNS names resolved", len(pids))
+	}
+	return pids[best], entries[best], nil
+}
+
+// fetchIPNSAny fetches the path, if any is given, under whichever of the
+// --ipns-any keys has the freshest record.
+func fetchIPNSAny(ctx context.Context, c *cli.Context) error {
+	pids, err := parseIPNSNames(c.String("ipns-any"))
+	if err != nil {
+		return err
+	}
+	if c.NArg() > 1 {
+		return fmt.Errorf("--ipns-any takes at most one path, inside the names")
+	}
+	sub := strings.Trim(c.Args().First(), "/")
+	flags, err := checkFetchFlags(c)
+	if err != nil {
+		return err
+	}
+
+	s, fctx, stop, err := openSession(ctx, c, flags.deadline)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	defer stop()
+	if !s.Embedded() {
+		return fmt.Errorf("--ipns-any reads IPNS records itself, it needs a spawned node (--node=spawn or temp)")
+	}
+	if err := s.WaitBootstrap(fctx); err != nil {
+		if ctx.Err() != nil {
+			return cli.NewExitError("interrupted", exitInterrupted)
+		}
+		return cli.NewExitError(err, 2)
+	}
+
+	pid, entry, err := freshestRecord(fctx, s, pids)
+	if err != nil {
+		if ctx.Err() != nil {
+			return cli.NewExitError("interrupted", exitInterrupted)
+		}
+		return cli.NewExitError(err, 2)
+	}
+	value := ipath.New(string(entry.GetValue()))
+	if err := value.IsValid(); err != nil {
+		return cli.NewExitError(fmt.Errorf("the record of /ipns/%s points to an invalid path: %s", pid, err), 2)
+	}
+	log.Printf("/ipns/%s has the freshest record, sequence %d, pointing to %s", pid, entry.GetSequence(), value)
+
+	name := "/ipns/" + pid.String()
+	if sub != "" {
+		name += "/" + sub
+	}
+	dnslink := newDNSLinkResolver(c.String("dns-server"), c.BoolT("dnslink"))
+	t, err := newTarget(ctx, c, dnslink, name)
+	if err != nil {
+		return err
+	}
+	// fetch what the chosen record points to, rather than resolving the
+	// name again
+	if sub != "" {
+		t.path = ipath.Join(value, strings.Split(sub, "/")...)
+	} else {
+		t.path = value
+	}
+	return fetchOne(ctx, fctx, c, s, flags, t)
+}
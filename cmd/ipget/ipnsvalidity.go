@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	ipns "github.com/ipfs/go-ipns"
+	ipnspb "github.com/ipfs/go-ipns/pb"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// maxIPNSDepth bounds how many names pointing to names strictlyResolve
+// follows, as go-ipfs does.
+const maxIPNSDepth = 32
+
+// strictlyResolve resolves the IPNS name p starts with, if any, from its
+// record alone, checking the record itself rather than trusting whoever
+// answered: its signature must match the key of the name, its validity must
+// be an end of life, and that must be in the future. A name pointing to
+// another name is checked the same way. The records are fetched by a
+// spawned node, a daemon only hands out what it resolved. DNSLink names
+// have no record and are refused.
+func strictlyResolve(ctx context.Context, s *ipget.Session, p ipath.Path, verbose bool) (ipath.Path, error) {
+	for depth := 0; ; depth++ {
+		parts := strings.Split(strings.Trim(p.String(), "/"), "/")
+		if parts[0] != "ipns" || len(parts) < 2 {
+			return p, nil
+		}
+		if depth == maxIPNSDepth {
+			return nil, fmt.Errorf("%s: gave up after following %d names", p, maxIPNSDepth)
+		}
+		pid, err := peer.Decode(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("/ipns/%s has no record to check, only names that are keys do (--require-ipns-validity)", parts[1])
+		}
+		if !s.Embedded() {
+			return nil, fmt.Errorf("--require-ipns-validity checks the records itself, which takes a spawned node (--node=spawn or temp)")
+		}
+		if err := s.WaitBootstrap(ctx); err != nil {
+			return nil, err
+		}
+		entry, err := checkedRecord(ctx, s, pid)
+		if err != nil {
+			return nil, fmt.Errorf("/ipns/%s: %s (--require-ipns-validity)", parts[1], err)
+		}
+		if verbose {
+			eol, _ := ipns.GetEOL(entry)
+			log.Printf("/ipns/%s: record sequence %d, valid until %s, TTL %s", parts[1], entry.GetSequence(),
+				eol.Format(time.RFC3339), time.Duration(entry.GetTtl()))
+		}
+		target := ipath.New(string(entry.GetValue()))
+		if err := target.IsValid(); err != nil {
+			return nil, fmt.Errorf("/ipns/%s points to %q: %s", parts[1], entry.GetValue(), err)
+		}
+		p = ipath.Join(target, parts[2:]...)
+	}
+}
+
+// checkedRecord fetches the record of pid and checks its signature and end
+// of life.
+func checkedRecord(ctx context.Context, s *ipget.Session, pid peer.ID) (*ipnspb.IpnsEntry, error) {
+	value, err := s.Node().Routing.GetValue(ctx, ipns.RecordKey(pid))
+	if err != nil {
+		return nil, fmt.Errorf("fetching the record: %s", err)
+	}
+	entry := new(ipnspb.IpnsEntry)
+	if err := entry.Unmarshal(value); err != nil {
+		return nil, fmt.Errorf("the record doesn't decode: %s", err)
+	}
+	if entry.GetValidityType() != ipnspb.IpnsEntry_EOL {
+		return nil, fmt.Errorf("the record's validity isn't an end of life")
+	}
+	pk, err := ipns.ExtractPublicKey(pid, entry)
+	if err != nil {
+		return nil, fmt.Errorf("no public key to check the record against: %s", err)
+	}
+	if pk == nil {
+		return nil, fmt.Errorf("no public key to check the record against")
+	}
+	if err := ipns.Validate(pk, entry); err != nil {
+		if err == ipns.ErrExpiredRecord {
+			eol, _ := ipns.GetEOL(entry)
+			return nil, fmt.Errorf("the record expired at %s", eol.Format(time.RFC3339))
+		}
+		return nil, fmt.Errorf("the record is invalid: %s", err)
+	}
+	return entry, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	config "github.com/ipfs/go-ipfs-config"
+	keystore "github.com/ipfs/go-ipfs/keystore"
+	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// selfKey is the name of the repo's own identity, which isn't kept in the
+// keystore.
+const selfKey = "self"
+
+// keyPath returns the IPNS path published under the key called name in
+// the keystore of the local repo, as `ipfs name publish --key` does.
+func keyPath(name string) (string, error) {
+	root, err := config.PathRoot()
+	if err != nil {
+		return "", err
+	}
+	if !fsrepo.IsInitialized(root) {
+		return "", fmt.Errorf("--key names a key of the local repo, there is none at %s", root)
+	}
+	if name == selfKey {
+		cfg, err := fsrepo.ConfigAt(root)
+		if err != nil {
+			return "", err
+		}
+		return "/ipns/" + cfg.Identity.PeerID, nil
+	}
+
+	dir := filepath.Join(root, "keystore")
+	if _, err := os.Stat(dir); err != nil {
+		return "", fmt.Errorf("no key %q in %s, the keys are: %s", name, dir, selfKey)
+	}
+	ks, err := keystore.NewFSKeystore(dir)
+	if err != nil {
+		return "", err
+	}
+	sk, err := ks.Get(name)
+	if err == keystore.ErrNoSuchKey {
+		names, _ := ks.List()
+		names = append(names, selfKey)
+		sort.Strings(names)
+		return "", fmt.Errorf("no key %q in %s, the keys are: %s", name, dir, strings.Join(names, ", "))
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading the key %q: %s", name, err)
+	}
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return "", err
+	}
+	return "/ipns/" + id.Pretty(), nil
+}
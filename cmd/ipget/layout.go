@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/ipget/internal/dagutil"
+)
+
+// detectLayout tells how the unixfs file at root was laid out when it was
+// added. Both layouts are read the same way, depth first with a node's own
+// data before its children's, so this only matters when debugging.
+//
+// A balanced DAG has all its leaves at the same depth, so every child of the
+// root is as deep as the others. A trickle DAG starts with leaves right
+// under the root and continues with subtrees getting deeper and deeper. A
+// root with only leaves under it fits both.
+func detectLayout(ctx context.Context, dag ipld.NodeGetter, root cid.Cid) (string, error) {
+	nd, err := dag.Get(ctx, root)
+	if err != nil {
+		return "", err
+	}
+	if _, err := dagutil.FileData(nd); err != nil {
+		return "", err
+	}
+	links := nd.Links()
+	if len(links) == 0 {
+		return "single block", nil
+	}
+
+	depths := make([]int, len(links))
+	for i, l := range links {
+		if depths[i], err = dagDepth(ctx, dag, l.Cid); err != nil {
+			return "", err
+		}
+	}
+
+	balanced, trickle := true, depths[0] == 0
+	for i := 1; i < len(depths); i++ {
+		if depths[i] != depths[0] {
+			balanced = false
+		}
+		if depths[i] < depths[i-1] {
+			trickle = false
+		}
+	}
+	switch {
+	case balanced && trickle:
+		return "balanced or trickle, a single layer of leaves", nil
+	case balanced:
+		return "balanced", nil
+	case trickle:
+		return "trickle", nil
+	default:
+		return "unknown", nil
+	}
+}
+
+// dagDepth returns the depth of the DAG at c, 0 for a leaf. Both balanced and
+// trickle DAGs are deepest along their last links.
+func dagDepth(ctx context.Context, dag ipld.NodeGetter, c cid.Cid) (int, error) {
+	var path *dagutil.Ancestry
+	for depth := 0; ; depth++ {
+		var err error
+		if path, err = path.Child(c); err != nil {
+			return 0, err
+		}
+		nd, err := dag.Get(ctx, c)
+		if err != nil {
+			return 0, err
+		}
+		links := nd.Links()
+		if len(links) == 0 {
+			return depth, nil
+		}
+		c = links[len(links)-1].Cid
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// lchtimes sets the access and modification times of the symlink fpath
+// itself rather than of what it points to.
+func lchtimes(fpath string, atime, mtime time.Time) error {
+	ts := []unix.Timespec{unix.NsecToTimespec(atime.UnixNano()), unix.NsecToTimespec(mtime.UnixNano())}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, fpath, ts, unix.AT_SYMLINK_NOFOLLOW)
+}
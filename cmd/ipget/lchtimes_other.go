@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// lchtimes would set the times of the symlink fpath itself. Only Linux does
+// it here, elsewhere the symlink is left as it is.
+func lchtimes(fpath string, atime, mtime time.Time) error {
+	return fmt.Errorf("setting the time of a symlink isn't supported on this platform")
+}
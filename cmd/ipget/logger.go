@@ -0,0 +1,11 @@
+package main
+
+import (
+	"log"
+)
+
+// stdLogger logs through the standard log package, which is where the
+// command's own lines go too.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) { log.Printf(format, v...) }
@@ -0,0 +1,2380 @@
+// Command ipget fetches files and directories from IPFS, through the ipget
+// package.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	gopath "path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	cid "github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget"
+	"github.com/ipfs/ipget/internal/dagutil"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	cli "github.com/urfave/cli"
+)
+
+// exitInterrupted is the exit code used when a fetch is cut short by a signal.
+const exitInterrupted = 130
+
+// exitNotModified is the exit code used when --since finds the path still
+// resolving to what was fetched last time.
+const exitNotModified = 3
+
+func main() {
+	// Cancelled on SIGINT/SIGTERM so an interrupted fetch can clean up.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := cli.NewApp()
+	app.Name = "ipget"
+	app.Usage = "Retrieve and save IPFS objects."
+	app.Version = version
+	cli.VersionPrinter = func(c *cli.Context) {
+		printVersion(os.Stdout, c.Bool("json"))
+	}
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "config",
+			Usage: "read default flag values from this JSON file",
+			Value: defaultConfigFile(),
+		},
+		cli.StringSliceFlag{
+			Name:  "output,o",
+			Usage: "specify output location, \"-\" writes a single file to stdout; repeat it to write a file to several places in one fetch",
+		},
+		cli.BoolFlag{
+			Name:  "tee-best-effort",
+			Usage: "with several -o outputs, carry on with the others when writing to one fails, reporting it at the end",
+		},
+		cli.StringFlag{
+			Name:  "output-dir",
+			Usage: "directory the output is written to when -o isn't given, created along with any directories an --out-template or batch file output needs under it",
+		},
+		cli.StringFlag{
+			Name:  "out-template",
+			Usage: "name the output of each path after a template, with the placeholders {cid}, {name}, {index} and {ipns}",
+		},
+		cli.StringFlag{
+			Name:  "node,n",
+			Usage: "specify ipfs node strategy ('local', 'spawn', `temp` or 'fallback')",
+			Value: "fallback",
+		},
+		cli.StringFlag{
+			Name:  "api",
+			Usage: "multiaddr of the daemon API to fetch through with the 'local' and 'fallback' strategies, instead of the one in $IPFS_PATH/api",
+		},
+		cli.StringSliceFlag{
+			Name:  "peers,p",
+			Usage: "specify a set of IPFS peers to connect to",
+		},
+		cli.StringSliceFlag{
+			Name:  "gateway",
+			Usage: "URL of an HTTP gateway to fetch verified blocks from when fetching through the node fails, can be repeated; replaces the comma-separated list in $IPGET_GATEWAYS",
+		},
+		cli.BoolFlag{
+			Name:  "use-default-gateway",
+			Usage: "fall back to " + defaultGateway + " as well, after any other gateway",
+		},
+		cli.StringSliceFlag{
+			Name:  "gateway-header",
+			Usage: "header to send with every request to the gateways, as \"Name: value\", can be repeated",
+		},
+		cli.StringFlag{
+			Name:   "gateway-token-file",
+			Usage:  "file holding a bearer token to send to the gateways in an Authorization header, keeping it out of the command line",
+			EnvVar: "IPGET_GATEWAY_TOKEN_FILE",
+		},
+		cli.IntFlag{
+			Name:  "http-retries",
+			Usage: "how many times a block request to the gateways is retried, with backoff, before giving up",
+			Value: 3,
+		},
+		cli.DurationFlag{
+			Name:  "gateway-timeout",
+			Usage: "how long fetching through the node may take to get to the root before falling back to the gateways",
+			Value: time.Minute,
+		},
+		cli.DurationFlag{
+			Name:  "gateway-block-timeout",
+			Usage: "fall back to the gateways block by block: fetch through the node and only ask the gateways for the blocks that don't come within this long",
+		},
+		cli.BoolFlag{
+			Name:  "trust-gateway",
+			Usage: "UNSAFE: don't hash the blocks from the gateways to check them against their CID, which is faster but lets a gateway send anything",
+		},
+		cli.StringSliceFlag{
+			Name:  "bootstrap",
+			Usage: "bootstrap the spawned node from these peers instead of its configured ones (repeatable), \"none\" disables bootstrapping",
+		},
+		cli.StringFlag{
+			Name:  "swarm-key",
+			Usage: "join the private network of this swarm.key file",
+		},
+		cli.BoolFlag{
+			Name:  "progress",
+			Usage: "show a progress bar",
+		},
+		cli.BoolFlag{
+			Name:  "accurate-size",
+			Usage: "work out the size of files that don't record it from their DAG before fetching their data, so --progress and --max-total-size have a total; costs fetching the DAG's inner nodes first",
+		},
+		cli.StringFlag{
+			Name:  "progress-min",
+			Usage: "with --progress, only show the bar for objects of at least this size, e.g. 1MB, and a one-line summary for smaller ones",
+		},
+		cli.StringFlag{
+			Name:  "user-agent",
+			Usage: "agent version announced to peers and User-Agent sent to a local daemon",
+			Value: "ipget/" + app.Version,
+		},
+		cli.BoolFlag{
+			Name:  "no-verify-records",
+			Usage: "UNSAFE, for debugging only: accept DHT records that fail validation, such as forged or expired IPNS records",
+		},
+		cli.BoolFlag{
+			Name:  "require-ipns-validity",
+			Usage: "resolve IPNS names only from a record checked here, refusing records that are expired, badly signed or without an end of life, whoever sent them; --verbose logs their sequence, validity and TTL",
+		},
+		cli.StringSliceFlag{
+			Name:  "record-validator",
+			Usage: "validate DHT records under a custom namespace with a command, as <namespace>=<command> (repeatable)",
+		},
+		cli.BoolFlag{
+			Name:  "allow-local",
+			Usage: "discover and fetch from peers on the local network through mDNS",
+		},
+		cli.StringSliceFlag{
+			Name:  "listen",
+			Usage: "multiaddr the spawned node listens on instead of its configured ones (repeatable), \"none\" disables listening",
+		},
+		cli.StringFlag{
+			Name:  "local-addr",
+			Usage: "IP address of this host the spawned node's outbound connections come from, for multi-homed hosts",
+		},
+		cli.BoolFlag{
+			Name:  "nat",
+			Usage: "map a port on the router through UPnP/NAT-PMP and log the external address",
+		},
+		cli.BoolFlag{
+			Name:  "no-nat",
+			Usage: "don't look for a router to map a port on, which can slow down startup",
+		},
+		cli.StringSliceFlag{
+			Name:  "addr-filter",
+			Usage: "never dial addresses in this range, as a CIDR block or /ip4/<ip>/ipcidr/<bits> (repeatable)",
+		},
+		cli.IntFlag{
+			Name:  "conn-low",
+			Usage: "number of connections the spawned node trims down to",
+		},
+		cli.IntFlag{
+			Name:  "conn-high",
+			Usage: "number of connections above which the spawned node starts closing some",
+		},
+		cli.StringFlag{
+			Name:  "socks5",
+			Usage: "make every connection of the spawned node through the SOCKS5 proxy at this host:port, e.g. Tor's at 127.0.0.1:9050; the node doesn't listen, and the local daemon isn't used",
+		},
+		cli.BoolFlag{
+			Name:  "onion-only",
+			Usage: "with --socks5, only dial peers at onion addresses",
+		},
+		cli.IntFlag{
+			Name:  "max-dials",
+			Usage: "maximum number of outbound dials the spawned node has in flight at once",
+		},
+		cli.DurationFlag{
+			Name:  "peerstore-ttl",
+			Usage: "how long the spawned node keeps the addresses it learns of other peers, instead of libp2p's few minutes",
+		},
+		cli.IntFlag{
+			Name:  "max-connections",
+			Usage: "never keep more than this many connections open on the spawned node, however many paths it fetches; past it, the least useful are closed",
+		},
+		cli.IntFlag{
+			Name:  "max-connections-per-fetch",
+			Usage: "between the paths of a batch, close the spawned node's connections down to this many, besides those to the peers that sent blocks; unset keeps them all",
+		},
+		cli.DurationFlag{
+			Name:  "resolve-cache-ttl",
+			Usage: "reuse a resolved IPNS name for this long, rather than a minute, also across --watch polls; no longer than its record's own TTL and validity allow",
+		},
+		cli.IntFlag{
+			Name:  "resolve-concurrency",
+			Usage: "how many IPNS names are resolved at once; a batch resolves its names ahead of fetching them",
+			Value: 4,
+		},
+		cli.IntFlag{
+			Name:  "bootstrap-min-peers",
+			Usage: "how many peers the spawned node must be connected to before it starts fetching; it waits up to 10s for them, and --verbose logs how far it got",
+			Value: ipget.DefaultBootstrapPeers,
+		},
+		cli.StringFlag{
+			Name:  "exchange",
+			Usage: "how blocks missing from the blockstore are got: 'bitswap', or 'none' to only use what is there already or comes from --seed-car, failing at the first block missing",
+			Value: "bitswap",
+		},
+		cli.StringFlag{
+			Name:  "routing",
+			Usage: "how the spawned node finds content: 'dht', 'http' through the delegated routing --routing-endpoint, or 'none' to only fetch from the peers it connects to",
+			Value: "dht",
+		},
+		cli.StringFlag{
+			Name:  "routing-endpoint",
+			Usage: "URL of the HTTP routing v1 endpoint --routing=http asks for providers, peers and IPNS records",
+		},
+		cli.StringFlag{
+			Name:  "routing-table-cache",
+			Usage: "file to save the DHT peers a spawned node is connected to in on exit, and to dial them from on the next run, to get going sooner",
+		},
+		cli.IntFlag{
+			Name:  "cluster-level",
+			Usage: "cluster level the spawned node sets on its DHT queries, for experimenting with hierarchical routing",
+		},
+		cli.DurationFlag{
+			Name:  "block-timeout",
+			Usage: "when a spawned node gets no block within this long, look up other providers of that block and ask them too, rather than wait on a slow peer; 0 waits",
+		},
+		cli.IntFlag{
+			Name:  "max-lookup-rounds",
+			Usage: "end each DHT lookup of a spawned node after this many rounds of asking closer peers, using the providers found by then, for a bounded latency; 0 for no bound",
+		},
+		cli.StringSliceFlag{
+			Name:  "min-protocol",
+			Usage: "cut a spawned node off from peers speaking a protocol only in versions older than this, as <protocol>=<version> with bitswap or kad, e.g. bitswap=1.2.0; can be repeated",
+		},
+		cli.StringFlag{
+			Name:  "provider-allowlist",
+			Usage: "file of peer IDs, one per line, that a spawned node only takes blocks from; other providers are ignored",
+		},
+		cli.StringFlag{
+			Name:  "provider-denylist",
+			Usage: "file of peer IDs, one per line, that a spawned node never takes blocks from",
+		},
+		cli.BoolFlag{
+			Name:  "no-addr-refresh",
+			Usage: "give up on a provider a spawned node can't dial at the addresses it was found with, rather than looking up its current ones",
+		},
+		cli.BoolFlag{
+			Name:  "strict-provider-addrs",
+			Usage: "ignore providers the spawned node could only reach through a circuit relay",
+		},
+		cli.BoolFlag{
+			Name:  "ip4-only",
+			Usage: "only dial and listen on IPv4 addresses",
+		},
+		cli.BoolFlag{
+			Name:  "ip6-only",
+			Usage: "only dial and listen on IPv6 addresses",
+		},
+		cli.BoolFlag{
+			Name:  "public-addrs-only",
+			Usage: "only announce publicly routable addresses of the spawned node",
+		},
+		cli.StringSliceFlag{
+			Name:  "exclude",
+			Usage: "skip paths matching this glob, relative to the root of a directory (repeatable)",
+		},
+		cli.StringSliceFlag{
+			Name:  "include",
+			Usage: "only write the files of a directory matching this glob, against their path from its root or their name alone (repeatable)",
+		},
+		cli.StringFlag{
+			Name:  "min-size",
+			Usage: "only write the files of a directory of at least this size, e.g. 1MB, going by the size recorded in the DAG",
+		},
+		cli.StringFlag{
+			Name:  "max-size",
+			Usage: "only write the files of a directory of at most this size, e.g. 100MB, going by the size recorded in the DAG",
+		},
+		cli.StringFlag{
+			Name:  "ignore-file",
+			Usage: "read exclude patterns from this file, one per line",
+			Value: defaultIgnoreFile,
+		},
+		cli.StringFlag{
+			Name:  "checksum",
+			Usage: "write a <file>.<algorithm> checksum next to every file, computed while writing; sha256, sha512 or blake2b, or several separated by commas",
+		},
+		cli.StringFlag{
+			Name:  "manifest",
+			Usage: "write a manifest of the files fetched, with their path, CID, size and any --checksum, as JSON or as CSV for a path ending in .csv",
+		},
+		cli.BoolFlag{
+			Name:  "server-stdin",
+			Usage: "keep the node up and fetch the '<ipfs ref> [output path]' lines read from stdin, printing a result line for each, until stdin ends",
+		},
+		cli.StringFlag{
+			Name:  "compress",
+			Usage: "compress files as they are written, or the stream with -o -: gzip",
+		},
+		cli.IntFlag{
+			Name:  "compress-level",
+			Usage: "compression level of --compress, 0 for the format's default",
+		},
+		cli.StringFlag{
+			Name:  "on-complete",
+			Usage: "run this shell command after each successful fetch, with $IPGET_NAME, $IPGET_CID and $IPGET_OUTPUT set",
+		},
+		cli.StringFlag{
+			Name:  "on-error",
+			Usage: "run this shell command after each failed fetch, with $IPGET_NAME, $IPGET_OUTPUT and $IPGET_ERROR set",
+		},
+		cli.BoolFlag{
+			Name:  "fail-on-hook",
+			Usage: "fail the run when an --on-complete or --on-error command fails, rather than only logging it",
+		},
+		cli.StringFlag{
+			Name:  "content-equal",
+			Usage: "after fetching a file, compare its bytes with this local file or IPFS path, however either was chunked",
+		},
+		cli.StringFlag{
+			Name:  "overwrite-policy",
+			Usage: "what to do with files that already exist: 'error', 'skip' (without fetching them), 'overwrite', 'rename' to a numbered name, or 'if-different' to only replace those whose content differs, without fetching the others",
+			Value: "overwrite",
+		},
+		cli.StringFlag{
+			Name:  "into-archive",
+			Usage: "add what is fetched as entries of this .tar or .zip, creating it if needed, named as under --output-dir; entries already there are dealt with by --overwrite-policy",
+		},
+		cli.BoolFlag{
+			Name:  "fail-fast",
+			Usage: "stop a directory fetch at the first entry that fails",
+		},
+		cli.BoolFlag{
+			Name:  "keep-going",
+			Usage: "fetch everything possible and report failures at the end, the default for directories",
+		},
+		cli.BoolFlag{
+			Name:  "print-cid",
+			Usage: "once the fetch is written, print the /ipfs path of the CID it resolved to, and only that, on stdout",
+		},
+		cli.BoolFlag{
+			Name:  "fail-on-partial",
+			Usage: "check a directory fetch against the directory's listing once done, and fail listing each path missing from the output",
+		},
+		cli.StringFlag{
+			Name:  "max-total-size",
+			Usage: "refuse to fetch more than this much data, e.g. 5GB; output written before finding out is removed",
+		},
+		cli.BoolFlag{
+			Name:  "atomic",
+			Usage: "write to a temporary file or directory and move it into place once complete, nothing is kept on failure",
+		},
+		cli.BoolFlag{
+			Name:  "append-output,append",
+			Usage: "append the fetched file to the output instead of replacing it; a failed or interrupted fetch leaves the output as it was",
+		},
+		cli.BoolFlag{
+			Name:  "delta",
+			Usage: "when the output directory exists, only write the files that differ from the ones there and remove the ones that aren't in the fetched directory",
+		},
+		cli.BoolFlag{
+			Name:  "continue",
+			Usage: "resume an interrupted directory fetch, skipping the files it wrote in full; starts over if the directory changed since",
+		},
+		cli.BoolFlag{
+			Name:  "flatten",
+			Usage: "write all the files of a directory straight into the output directory, without the directories they're in; clashing names get their parent directories' names in front",
+		},
+		cli.BoolFlag{
+			Name:  "reproducible",
+			Usage: "write directory entries in name order and give everything the same modification time, $SOURCE_DATE_EPOCH or else the Unix epoch, so the output of a CID is always the same",
+		},
+		cli.StringFlag{
+			Name:  "preserve",
+			Usage: "apply the unixfs metadata recorded in the DAG to what is written, a comma separated list of mode and mtime, or all",
+		},
+		cli.BoolFlag{
+			Name:  "preallocate",
+			Usage: "reserve the size of each file on disk before writing it, as the root block gives it, so large files aren't fragmented and running out of space fails at the start",
+		},
+		cli.BoolFlag{
+			Name:  "normalize-permissions",
+			Usage: "give every file written mode 0644 and every directory 0755, whatever the umask, clearing setuid, setgid and sticky bits",
+		},
+		cli.BoolFlag{
+			Name:  "preserve-strict",
+			Usage: "warn about each --preserve field that couldn't be set and fail, rather than ignoring it as some filesystems refuse chmod",
+		},
+		cli.DurationFlag{
+			Name:  "stats-interval",
+			Usage: "log a line of transfer stats to stderr at this interval, 0 disables it",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "give up on the fetch after this long",
+		},
+		cli.StringFlag{
+			Name:  "deadline",
+			Usage: "give up on the fetch at this RFC 3339 time, e.g. 2020-06-01T02:00:00Z; the earlier of --timeout and --deadline wins",
+		},
+		cli.DurationFlag{
+			Name:  "idle-timeout",
+			Usage: "give up on a fetch that receives nothing for this long, however long it has been going; 0 waits forever",
+		},
+		cli.BoolFlag{
+			Name:  "complete-on-first-provider",
+			Usage: "for small content, stop looking for providers once one is found and fetch from it, only widening the search if that doesn't finish within a few seconds",
+		},
+		cli.BoolFlag{
+			Name:  "verify-providers",
+			Usage: "look up and dial the providers first, failing early if none of them is reachable",
+		},
+		cli.IntFlag{
+			Name:  "require-providers",
+			Usage: "refuse to start unless this many distinct providers of the root are found, reachable ones with --verify-providers",
+		},
+		cli.BoolFlag{
+			Name:  "interactive",
+			Usage: "list the providers found and ask which to fetch from, for debugging; ignored unless stdin is a terminal",
+		},
+		cli.BoolFlag{
+			Name:  "watch",
+			Usage: "keep running after the fetch and update the output whenever the IPNS name or DNSLink points somewhere new, only fetching the entries that changed",
+		},
+		cli.DurationFlag{
+			Name:  "watch-interval",
+			Usage: "with --watch, how often the name is resolved again",
+			Value: time.Minute,
+		},
+		cli.BoolFlag{
+			Name:  "estimate",
+			Usage: "print the size of the object and how long fetching it should take, from a short bandwidth probe, and ask before fetching",
+		},
+		cli.BoolFlag{
+			Name:  "yes,y",
+			Usage: "with --estimate, fetch without asking",
+		},
+		cli.BoolFlag{
+			Name:  "no-bootstrap-wait",
+			Usage: "start fetching as soon as one peer is connected instead of waiting for bootstrap",
+		},
+		cli.BoolFlag{
+			Name:  "use-url-host",
+			Usage: "read an http(s) URL that isn't a gateway URL as the DNSLink name of its host and its path",
+		},
+		cli.StringFlag{
+			Name:  "dns-server",
+			Usage: "DNS server (host[:port]) used to resolve DNSLink names",
+		},
+		cli.BoolTFlag{
+			Name:  "dnslink",
+			Usage: "resolve /ipns/ domain names through DNSLink, use --dnslink=false to only accept peer IDs",
+		},
+		cli.StringFlag{
+			Name:  "key",
+			Usage: "fetch what is published under this key of the local repo's keystore, as named by ipfs key list, rather than a path",
+		},
+		cli.StringFlag{
+			Name:  "ipns-any",
+			Usage: "comma separated IPNS keys publishing the same content, fetch through whichever has the freshest record; the path given, if any, is looked up inside it",
+		},
+		cli.BoolFlag{
+			Name:  "resolve-only",
+			Usage: "resolve the path through IPNS, DNSLink and its subpath, print the result and exit without downloading",
+		},
+		cli.StringFlag{
+			Name:  "out-format",
+			Usage: "how --resolve-only prints the result: 'cid', 'path' or 'json'",
+			Value: "path",
+		},
+		cli.StringFlag{
+			Name:  "car",
+			Usage: "export the DAGs of all given paths into a single CAR file instead of extracting them",
+		},
+		cli.StringFlag{
+			Name:  "seed-car",
+			Usage: "import the blocks of this CAR file first, so only the ones it lacks are fetched",
+		},
+		cli.IntFlag{
+			Name:  "car-version",
+			Usage: "CAR version to write with --car, 2 adds an index for random access",
+			Value: 1,
+		},
+		cli.StringFlag{
+			Name:  "select",
+			Usage: "only export to --car the blocks this IPLD selector, in dag-json, walks through",
+		},
+		cli.BoolFlag{
+			Name:  "decode",
+			Usage: "write dag-cbor nodes, or a field inside them, as JSON instead of the raw block",
+		},
+		cli.IntFlag{
+			Name:  "hedge",
+			Usage: "dial this many providers at once before fetching and go with the first to connect, 0 disables",
+		},
+		cli.BoolFlag{
+			Name:  "unwrap",
+			Usage: "when the object is a directory holding a single file, write that file instead",
+		},
+		cli.BoolFlag{
+			Name:  "unwrap-first",
+			Usage: "like --unwrap, but take the first entry of a directory holding several",
+		},
+		cli.BoolFlag{
+			Name:  "ordered",
+			Usage: "request file blocks strictly in order, so output starts early and streams with bounded buffering",
+		},
+		cli.IntFlag{
+			Name:  "parallel-blocks",
+			Usage: "how many blocks of a file to have in flight at once, spread over its providers; implies --ordered (default 32)",
+		},
+		cli.StringFlag{
+			Name:  "max-memory",
+			Usage: "bound the bytes of file blocks in flight or fetched but not yet written, e.g. 64MB, holding the fetch back when it is reached; implies --ordered",
+		},
+		cli.BoolFlag{
+			Name:  "announce",
+			Usage: "once fetched, announce ourselves as a provider of the object and keep serving it until interrupted",
+		},
+		cli.DurationFlag{
+			Name:  "announce-interval,reprovide-interval",
+			Usage: "how often --announce publishes the provider records again, give or take 10%",
+			Value: announceInterval,
+		},
+		cli.StringFlag{
+			Name:  "reprovide-strategy",
+			Usage: "what --announce provides: 'roots', only the fetched object, or 'all' of its blocks",
+			Value: "roots",
+		},
+		cli.IntFlag{
+			Name:  "max-block-size",
+			Usage: "fail the fetch on any block larger than this many bytes, 0 disables the check",
+			Value: ipget.DefaultMaxBlockSize,
+		},
+		cli.BoolFlag{
+			Name:  "detect-type",
+			Usage: "append an extension matching the sniffed content type when the output is named after a hash",
+		},
+		cli.BoolFlag{
+			Name:  "verify-layout",
+			Usage: "once fetched, report whether a file was added as a balanced or a trickle DAG",
+		},
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "state file recording what the path last resolved to; exit with code 3 without downloading if that hasn't changed",
+		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "with --version, print the versions as JSON",
+		},
+		cli.StringFlag{
+			Name:  "batch",
+			Usage: "fetch the paths listed in this CSV file, or JSON if it ends in .json, each with its output and optionally a subpath and an expected checksum, printing a result line for each",
+		},
+		cli.BoolFlag{
+			Name:  "trim-after",
+			Usage: "in a batch, remove the blocks of each path from the spawned node's repo once it is written, except the pinned ones and those already there, so a persistent repo doesn't grow",
+		},
+		cli.BoolFlag{
+			Name:  "concat",
+			Usage: "write the files of all the paths given one after the other, with nothing between them, to stdout or -o, in the order given; a directory is only taken with --flatten, its files in the order of their paths",
+		},
+		cli.StringFlag{
+			Name:  "from-pinlist",
+			Usage: "fetch the recursive and direct pins listed in this file, as `ipfs pin ls` prints them, each into --output-dir under its CID, printing a result line for each; indirect pins are skipped",
+		},
+		cli.StringFlag{
+			Name:  "replay",
+			Usage: "fetch again what the last run recorded in this --audit-log fetched, the CIDs it resolved to into the same outputs, printing a result line for each; names that resolve to something else since are warned about",
+		},
+		cli.StringFlag{
+			Name:  "summary-json",
+			Usage: "write a JSON summary of the run once it ends, with the paths asked for, what they resolved to, the files written, the bytes, peers and errors",
+		},
+		cli.BoolFlag{
+			Name:  "list-supported",
+			Usage: "print the codecs and hash functions this build can fetch and verify",
+		},
+		cli.BoolFlag{
+			Name:  "verbose",
+			Usage: "once fetched, log how many bytes and blocks each peer sent",
+		},
+		cli.BoolFlag{
+			Name:  "verbose-peers",
+			Usage: "list the connected peers every 10s during the fetch, or every --stats-interval, marking those sending blocks, only on a terminal unless --stats-interval is set; also log the bitswap and DHT protocol versions each peer speaks as it connects",
+		},
+		cli.StringFlag{
+			Name:  "color",
+			Usage: "color errors, warnings and completed fetches: 'always', 'never' or 'auto', when stderr is a terminal and $NO_COLOR isn't set",
+			Value: "auto",
+		},
+		cli.BoolFlag{
+			Name:  "explain",
+			Usage: "trace bootstrapping, name resolution, provider lookup and the arrival of the first bytes",
+		},
+		cli.StringFlag{
+			Name:  "audit-log",
+			Usage: "append a JSON line to this file for every significant event of the run, as documented in the README",
+		},
+	}
+
+	app.Before = func(c *cli.Context) error {
+		if err := loadConfigFile(c); err != nil {
+			return err
+		}
+		if err := setupColor(c.GlobalString("color")); err != nil {
+			return err
+		}
+		if fpath := c.GlobalString("audit-log"); fpath != "" {
+			var err error
+			if audit, err = openAuditLog(fpath, os.Args); err != nil {
+				return fmt.Errorf("opening the audit log: %s", err)
+			}
+		}
+		return nil
+	}
+
+	app.Action = func(c *cli.Context) error {
+		if c.Bool("list-supported") {
+			return printSupported(os.Stdout)
+		}
+		ref := c.Args().First()
+		if name := c.String("key"); name != "" {
+			switch {
+			case c.Args().Present():
+				return fmt.Errorf("--key names what to fetch, it takes no path")
+			case c.String("ipns-any") != "" || c.Bool("server-stdin") || c.String("batch") != "" || c.String("from-pinlist") != "" || c.String("replay") != "":
+				return fmt.Errorf("--key names a single path to fetch, it can't be combined with --ipns-any, --server-stdin, --batch, --from-pinlist or --replay")
+			case c.String("car") != "" || c.IsSet("out-template"):
+				return fmt.Errorf("--key can't be combined with --car or --out-template")
+			}
+			var err error
+			if ref, err = keyPath(name); err != nil {
+				return err
+			}
+		}
+		if c.String("ipns-any") != "" {
+			return fetchIPNSAny(ctx, c)
+		}
+		if c.Bool("server-stdin") {
+			return serveStdin(ctx, c, os.Stdin, os.Stdout)
+		}
+		if c.Bool("concat") {
+			return fetchConcat(ctx, c)
+		}
+		if c.String("batch") != "" || c.String("from-pinlist") != "" || c.String("replay") != "" {
+			return fetchBatchFile(ctx, c, os.Stdout)
+		}
+		if ref == "" {
+			return fmt.Errorf("usage: ipget <ipfs ref>\n")
+		}
+
+		if carPath := c.String("car"); carPath != "" {
+			return fetchCAR(ctx, c, carPath)
+		}
+		if c.IsSet("select") {
+			return fmt.Errorf("--select picks the blocks to export, it needs --car")
+		}
+		if c.NArg() > 1 || c.IsSet("out-template") {
+			if c.Bool("watch") {
+				return fmt.Errorf("--watch follows a single name")
+			}
+			return fetchBatch(ctx, c)
+		}
+
+		dnslink := newDNSLinkResolver(c.String("dns-server"), c.BoolT("dnslink"))
+		t, err := newTarget(ctx, c, dnslink, ref)
+		if err != nil {
+			return err
+		}
+		if c.Bool("resolve-only") {
+			return resolveOnly(ctx, c, t.path)
+		}
+		flags, err := checkFetchFlags(c)
+		if err != nil {
+			return err
+		}
+		if c.Bool("watch") {
+			if err := checkWatch(c, t); err != nil {
+				return err
+			}
+		}
+
+		sum := startSummary(c, []*target{t})
+		s, fctx, stop, err := openSession(ctx, c, flags.deadline)
+		if err != nil {
+			return finishSummary(c, sum, err)
+		}
+		defer s.Close()
+		defer stop()
+		if c.Bool("watch") {
+			return watchTarget(ctx, fctx, c, s, flags, t, c.Duration("watch-interval"))
+		}
+		return finishSummary(c, sum, fetchOne(ctx, fctx, c, s, flags, t))
+	}
+
+	// Catch interrupt signal. The first one stops the fetch so the partial
+	// output can be set aside, a second one exits right away.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+		<-sigs
+		os.Exit(1)
+	}()
+
+	app.Commands = []cli.Command{
+		providersCommand(ctx),
+		repairCommand(ctx),
+		serveCommand(ctx),
+		compareCommand(ctx),
+		probeCommand(ctx),
+		scanCommand(ctx),
+		diffCommand(ctx),
+		blockCommand(ctx),
+		connectCommand(ctx),
+		doctorCommand(ctx),
+		versionCommand(),
+	}
+
+	// TODO(noffle): remove this once https://github.com/urfave/cli/issues/427 is
+	// fixed.
+	args := os.Args
+	if i := commandIndex(app, args); i > 0 {
+		// keep the sub-command in front of its own flags
+		args = append(args[:i:i], movePostfixOptions(args[i:])...)
+	} else {
+		args = movePostfixOptions(args)
+	}
+
+	err := app.Run(args)
+	audit.close(err)
+	if err != nil {
+		log.Fatal(paint(colorRed, err.Error()))
+	}
+}
+
+// target is one path to fetch and where to write it.
+type target struct {
+	// name is the path as given, before DNSLink resolution. --since keys
+	// its state by it.
+	name string
+	// entry is the last segment of name, the default output name.
+	entry string
+	path  ipath.Path
+	// resolved, if set, is what path resolved to when the output was named
+	// after it, and is fetched instead.
+	resolved    ipath.Resolved
+	outPath     string
+	namedByHash bool
+	explain     *explainer
+	// summary, if set, is the entry of the path in the --summary-json.
+	summary *ipget.PathSummary
+}
+
+// newTarget parses arg and resolves any DNSLink it names. The output is
+// written where -o says, or after the last segment of the path.
+func newTarget(ctx context.Context, c *cli.Context, dnslink *dnslinkResolver, arg string) (*target, error) {
+	iPath, err := parsePath(arg, c.Bool("use-url-host"))
+	if err != nil {
+		return nil, err
+	}
+	t := &target{name: iPath.String(), outPath: outputFlag(c)}
+	_, t.entry = filepath.Split(strings.TrimRight(t.name, "/"))
+
+	// Only names derived from a bare hash are candidates for an
+	// extension; names coming from directory entries are kept as is.
+	t.namedByHash = t.outPath == "" && isHashPath(t.name)
+
+	// Use the final segment of the object's path if no path was given.
+	if t.outPath == "" {
+		t.outPath = filepath.Join(c.String("output-dir"), filepath.Clean(t.entry))
+	}
+
+	t.explain = newExplainer(c.Bool("explain"))
+	if t.path, err = dnslink.resolve(ctx, iPath); err != nil {
+		return nil, err
+	}
+	if t.path.String() != t.name {
+		t.explain.step("DNSLink points %s to %s", t.name, t.path)
+	}
+	return t, nil
+}
+
+// isHashPath tells whether name is a bare hash, /ipfs/<cid> or
+// /ipns/<peer ID>. A DNSLink name such as /ipns/example.com was chosen by
+// someone and is kept as it is.
+func isHashPath(name string) bool {
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	if len(parts) != 2 {
+		return false
+	}
+	switch parts[0] {
+	case "ipfs":
+		return true
+	case "ipns":
+		_, err := peer.Decode(parts[1])
+		return err == nil
+	}
+	return false
+}
+
+// outputUnder joins dir, --output-dir, and rel, an output named by a
+// template or a batch file, refusing a rel that climbs out of dir.
+func outputUnder(dir, rel string) (string, error) {
+	clean := filepath.Clean(rel)
+	if clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("the output %s would be outside the output directory", rel)
+	}
+	return filepath.Join(dir, clean), nil
+}
+
+// makeParents creates the missing directories above fpath, as mkdir -p
+// does. With normalize they get normalDirMode, as the directories of the
+// output do with --normalize-permissions.
+func makeParents(fpath string, normalize bool) error {
+	var missing []string
+	for dir := filepath.Dir(fpath); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		missing = append(missing, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(missing[0], 0777); err != nil {
+		return err
+	}
+	if normalize {
+		for _, dir := range missing {
+			if err := os.Chmod(dir, normalDirMode); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fetchFlags are the checked flags that shape how each target is written.
+type fetchFlags struct {
+	exclude  []string
+	maxTotal uint64
+	// include lists the patterns of the files written, all if empty.
+	include []string
+	// minSize and maxSize bound the size of the files written, 0 for no
+	// bound.
+	minSize, maxSize uint64
+	// progressMin is the smallest size a progress bar is shown for.
+	progressMin uint64
+	// deadline is when the fetch gives up, zero for never.
+	deadline time.Time
+	// mtime is the modification time of everything a --reproducible
+	// fetch writes.
+	mtime time.Time
+	// checksums are the algorithms of the sidecars --checksum writes.
+	checksums []string
+	// preserve are the unixfs metadata fields applied to the output.
+	preserve preserveFields
+	// maxMemory bounds the file blocks held by --ordered, 0 for no bound.
+	maxMemory uint64
+}
+
+// checkFetchFlags validates the flags of a fetch before a node is started.
+func checkFetchFlags(c *cli.Context) (fetchFlags, error) {
+	var flags fetchFlags
+	exclude := c.StringSlice("exclude")
+	ignored, err := readIgnoreFile(c.String("ignore-file"))
+	if err != nil && !(os.IsNotExist(err) && !c.IsSet("ignore-file")) {
+		return flags, err
+	}
+	flags.exclude = append(exclude, ignored...)
+	if err := checkPatterns(flags.exclude); err != nil {
+		return flags, err
+	}
+	flags.include = c.StringSlice("include")
+	if err := checkPatterns(flags.include); err != nil {
+		return flags, err
+	}
+	if size := c.String("min-size"); size != "" {
+		if flags.minSize, err = humanize.ParseBytes(size); err != nil {
+			return flags, fmt.Errorf("invalid --min-size %q: %s", size, err)
+		}
+	}
+	if size := c.String("max-size"); size != "" {
+		if flags.maxSize, err = humanize.ParseBytes(size); err != nil {
+			return flags, fmt.Errorf("invalid --max-size %q: %s", size, err)
+		}
+		if flags.maxSize < flags.minSize {
+			return flags, fmt.Errorf("--max-size can't be below --min-size")
+		}
+	}
+	if err := checkOverwritePolicy(c.String("overwrite-policy")); err != nil {
+		return flags, err
+	}
+	if c.Bool("fail-fast") && c.Bool("keep-going") {
+		return flags, fmt.Errorf("--fail-fast and --keep-going are mutually exclusive")
+	}
+	if c.Bool("print-cid") {
+		switch {
+		case outputFlag(c) == "-":
+			return flags, fmt.Errorf("--print-cid prints on stdout, the content can't be written there too")
+		case c.NArg() > 1 || c.IsSet("out-template") || c.Bool("server-stdin") || c.String("batch") != "" || c.String("from-pinlist") != "" || c.String("replay") != "":
+			return flags, fmt.Errorf("--print-cid prints the CID of a single path")
+		case c.Bool("watch"):
+			return flags, fmt.Errorf("--print-cid prints the CID once the fetch is done, --watch never is")
+		}
+	}
+	if c.Bool("fail-on-partial") {
+		switch {
+		case c.Bool("flatten"):
+			return flags, fmt.Errorf("--fail-on-partial checks the output against the directory's layout, which --flatten doesn't keep")
+		case c.Bool("watch"):
+			return flags, fmt.Errorf("--fail-on-partial checks a single fetch, not --watch")
+		case outputFlag(c) == "-":
+			return flags, fmt.Errorf("--fail-on-partial checks a directory written to disk, not stdout")
+		}
+	}
+	if size := c.String("progress-min"); size != "" {
+		if flags.progressMin, err = humanize.ParseBytes(size); err != nil {
+			return flags, fmt.Errorf("invalid --progress-min %q: %s", size, err)
+		}
+	}
+	if size := c.String("max-total-size"); size != "" {
+		if flags.maxTotal, err = humanize.ParseBytes(size); err != nil {
+			return flags, fmt.Errorf("invalid --max-total-size %q: %s", size, err)
+		}
+	}
+	if c.Bool("trim-after") && c.NArg() <= 1 && !c.IsSet("out-template") && c.String("batch") == "" && c.String("from-pinlist") == "" && c.String("replay") == "" {
+		return flags, fmt.Errorf("--trim-after trims between the paths of a batch, give several paths, --batch, --from-pinlist or --replay")
+	}
+	if c.String("manifest") != "" {
+		switch {
+		case c.NArg() > 1 || c.Bool("server-stdin") || c.String("batch") != "" || c.String("from-pinlist") != "" || c.String("replay") != "" || c.Bool("watch"):
+			return flags, fmt.Errorf("--manifest describes a single fetch")
+		case c.Bool("delta"):
+			return flags, fmt.Errorf("--manifest lists the files written, with --delta that's only those that changed")
+		case outputFlag(c) == "-":
+			return flags, fmt.Errorf("--manifest lists the files written, not what goes to stdout")
+		}
+	}
+	if c.Bool("append-output") {
+		switch {
+		case c.Bool("atomic"):
+			return flags, fmt.Errorf("--append extends the output in place, it can't be combined with --atomic")
+		case c.Bool("delta"), c.Bool("continue"), c.Bool("watch"):
+			return flags, fmt.Errorf("--append writes a single file, it can't be combined with --delta, --continue or --watch")
+		case outputFlag(c) == "-":
+			return flags, fmt.Errorf("--append needs a file to append to, not stdout")
+		case c.IsSet("overwrite-policy"):
+			return flags, fmt.Errorf("--append never replaces the output, --overwrite-policy doesn't apply to it")
+		case c.String("checksum") != "":
+			return flags, fmt.Errorf("--checksum sidecars describe whole files, they can't be written with --append")
+		}
+	}
+	if archive := c.String("into-archive"); archive != "" {
+		if _, err := archiveFormat(archive); err != nil {
+			return flags, err
+		}
+		switch {
+		case outputFlag(c) == "-":
+			return flags, fmt.Errorf("--into-archive writes the entries to the archive, not stdout")
+		case c.String("compress") != "" || c.Bool("append-output"):
+			return flags, fmt.Errorf("--into-archive can't be combined with --compress or --append")
+		case c.Bool("delta") || c.Bool("continue") || c.Bool("watch"):
+			return flags, fmt.Errorf("--into-archive adds whole entries, it can't be combined with --delta, --continue or --watch")
+		case c.String("manifest") != "" || c.String("checksum") != "" || len(copyOutputs(c)) > 0:
+			return flags, fmt.Errorf("--into-archive writes nothing outside the archive, it can't be combined with --manifest, --checksum or copies of the output")
+		}
+	}
+	if err := checkCopyOutputs(c); err != nil {
+		return flags, err
+	}
+	if c.Bool("delta") && c.Bool("atomic") {
+		return flags, fmt.Errorf("--delta updates the output in place, it can't be combined with --atomic")
+	}
+	if c.Bool("continue") {
+		switch {
+		case c.Bool("delta"):
+			return flags, fmt.Errorf("--continue and --delta both skip what's there already, use one of them")
+		case c.Bool("atomic"):
+			return flags, fmt.Errorf("--atomic leaves nothing behind to continue from")
+		case c.IsSet("overwrite-policy"):
+			return flags, fmt.Errorf("--continue writes again the files it didn't finish, --overwrite-policy doesn't apply to it")
+		case c.String("manifest") != "":
+			return flags, fmt.Errorf("--manifest lists the files written, with --continue that's only those left")
+		}
+	}
+	if c.Bool("flatten") {
+		switch {
+		case c.Bool("delta"), c.Bool("watch"):
+			return flags, fmt.Errorf("--flatten can't be combined with --delta or --watch, which keep the output in step with the directory's layout")
+		case c.Bool("continue"):
+			return flags, fmt.Errorf("--flatten can't be combined with --continue, the names given to clashing files depend on what's fetched before them")
+		}
+	}
+	if c.String("overwrite-policy") == "if-different" {
+		switch {
+		case c.Bool("atomic"):
+			return flags, fmt.Errorf("--atomic writes everything anew and moves it into place, --overwrite-policy=if-different can't leave files alone with it")
+		case c.Bool("flatten"):
+			return flags, fmt.Errorf("--overwrite-policy=if-different compares files at their place in the directory's layout, which --flatten doesn't keep")
+		case c.Bool("unwrap"), c.Bool("unwrap-first"):
+			return flags, fmt.Errorf("--overwrite-policy=if-different can't be combined with --unwrap or --unwrap-first, it compares files with the path fetched")
+		}
+	}
+	if c.Bool("delta") && c.IsSet("overwrite-policy") {
+		return flags, fmt.Errorf("--delta replaces the files that changed, --overwrite-policy doesn't apply to it")
+	}
+	if c.Bool("reproducible") {
+		if flags.mtime, err = reproducibleTime(); err != nil {
+			return flags, err
+		}
+	}
+	if spec := c.String("preserve"); spec != "" {
+		if flags.preserve, err = parsePreserve(spec); err != nil {
+			return flags, err
+		}
+		switch {
+		case flags.preserve.mtime && c.Bool("reproducible"):
+			return flags, fmt.Errorf("--reproducible sets its own modification times, it can't be combined with --preserve=mtime")
+		case c.Bool("flatten"):
+			return flags, fmt.Errorf("--preserve applies the metadata of the directory's layout, which --flatten doesn't keep")
+		case outputFlag(c) == "-":
+			return flags, fmt.Errorf("--preserve applies metadata to files, not to stdout")
+		}
+	} else if c.Bool("preserve-strict") {
+		return flags, fmt.Errorf("--preserve-strict needs --preserve")
+	}
+	if c.Bool("normalize-permissions") {
+		switch {
+		case c.String("preserve") != "":
+			return flags, fmt.Errorf("--normalize-permissions and --preserve are mutually exclusive, one sets fixed permissions and the other those recorded in the DAG")
+		case outputFlag(c) == "-":
+			return flags, fmt.Errorf("--normalize-permissions applies to files, not to stdout")
+		}
+	}
+	if c.Bool("require-ipns-validity") {
+		switch {
+		case c.Bool("no-verify-records"):
+			return flags, fmt.Errorf("--require-ipns-validity and --no-verify-records are mutually exclusive, one refuses the records the other accepts")
+		case c.Bool("watch"):
+			return flags, fmt.Errorf("--watch resolves the name anew every round, it can't be combined with --require-ipns-validity")
+		}
+	}
+	if c.Bool("preallocate") {
+		switch {
+		case outputFlag(c) == "-":
+			return flags, fmt.Errorf("--preallocate applies to files, not to stdout")
+		case c.Bool("append-output"):
+			return flags, fmt.Errorf("--preallocate and --append-output are mutually exclusive, an appended file is only extended by whole fetches")
+		case c.String("compress") != "":
+			return flags, fmt.Errorf("--preallocate needs the size of what is written, which --compress only tells once done")
+		}
+	}
+	if c.Int("require-providers") < 0 {
+		return flags, fmt.Errorf("--require-providers must not be negative")
+	}
+	if c.Int("hedge") < 0 {
+		return flags, fmt.Errorf("--hedge must not be negative")
+	}
+	if c.Int("parallel-blocks") < 0 {
+		return flags, fmt.Errorf("--parallel-blocks must not be negative")
+	}
+	if size := c.String("max-memory"); size != "" {
+		if flags.maxMemory, err = humanize.ParseBytes(size); err != nil {
+			return flags, fmt.Errorf("invalid --max-memory %q: %s", size, err)
+		}
+	}
+	if flags.deadline, err = fetchDeadline(c); err != nil {
+		return flags, err
+	}
+	if c.Duration("idle-timeout") < 0 {
+		return flags, fmt.Errorf("--idle-timeout must not be negative")
+	}
+	if c.Bool("announce") {
+		if err := checkAnnounceInterval(c.Duration("announce-interval")); err != nil {
+			return flags, err
+		}
+		if err := checkReprovideStrategy(c.String("reprovide-strategy")); err != nil {
+			return flags, err
+		}
+	}
+	if spec := c.String("checksum"); spec != "" {
+		if flags.checksums, err = parseChecksums(spec); err != nil {
+			return flags, err
+		}
+	}
+	if format := c.String("compress"); format != "" {
+		if err := checkCompression(format, c.Int("compress-level")); err != nil {
+			return flags, err
+		}
+		if c.IsSet("content-equal") {
+			return flags, fmt.Errorf("--content-equal compares the written bytes, it can't be combined with --compress")
+		}
+	}
+	return flags, nil
+}
+
+// fetchDeadline returns the earlier of --deadline and the end of --timeout,
+// zero if neither is set.
+func fetchDeadline(c *cli.Context) (time.Time, error) {
+	var deadline time.Time
+	if d := c.String("deadline"); d != "" {
+		t, err := time.Parse(time.RFC3339, d)
+		if err != nil {
+			return deadline, fmt.Errorf("invalid --deadline %q, it must be an RFC 3339 time such as 2020-06-01T02:00:00+02:00", d)
+		}
+		if !t.After(time.Now()) {
+			return deadline, fmt.Errorf("--deadline %s has already passed", d)
+		}
+		deadline = t
+	}
+	if timeout := c.Duration("timeout"); timeout > 0 {
+		if t := time.Now().Add(timeout); deadline.IsZero() || t.Before(deadline) {
+			deadline = t
+		}
+	}
+	return deadline, nil
+}
+
+// openSession sets up the node and imports any --seed-car into it. The
+// returned context is cut short as soon as an oversized block is refused,
+// and at deadline unless it is zero; stop releases it.
+func openSession(ctx context.Context, c *cli.Context, deadline time.Time) (*ipget.Session, context.Context, func(), error) {
+	s, err := setupNode(ctx, c, c.Int("max-block-size"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if c.GlobalBool("verbose") {
+		log.Printf("block exchange: %s", c.GlobalString("exchange"))
+	}
+	fctx, stop := s.WatchBlockSize(ctx)
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		fctx, cancel = context.WithDeadline(fctx, deadline)
+		watchStop := stop
+		stop = func() {
+			cancel()
+			watchStop()
+		}
+	}
+
+	if seed := c.String("seed-car"); seed != "" {
+		if err := seedCAR(fctx, s, seed); err != nil {
+			stop()
+			s.Close()
+			if ctx.Err() != nil {
+				return nil, nil, nil, cli.NewExitError("interrupted", exitInterrupted)
+			}
+			return nil, nil, nil, cli.NewExitError(err, 2)
+		}
+	}
+	return s, fctx, stop, nil
+}
+
+// fetchOne fetches t through s and writes it out, then runs the hooks. fctx
+// is the context of the fetch itself, ctx the one interrupted by signals.
+func fetchOne(ctx, fctx context.Context, c *cli.Context, s *ipget.Session, flags fetchFlags, t *target) error {
+	if audit != nil && c.String("summary-json") == "" {
+		// the audit log takes the CID, bytes and peers from an entry of
+		// its own
+		t.summary = &ipget.PathSummary{Requested: t.name}
+	}
+	audit.fetchStart(t)
+	fetch := fetchTarget
+	if c.String("into-archive") != "" {
+		fetch = fetchIntoArchive
+	}
+	err := onError(ctx, c, t, fetch(ctx, fctx, c, s, flags, t))
+	if t.summary != nil && err != nil {
+		if ec, ok := err.(cli.ExitCoder); ok && ec.ExitCode() == exitNotModified {
+			t.summary.NotModified = true
+		} else {
+			t.summary.Error = err.Error()
+		}
+	}
+	audit.fetchEnd(t, t.summary, err)
+	return err
+}
+
+func fetchTarget(ctx, fctx context.Context, c *cli.Context, s *ipget.Session, flags fetchFlags, t *target) error {
+	ipfs := s.API()
+	iPath, name, outPath, explain := t.path, t.name, t.outPath, t.explain
+	exclude, maxTotal := flags.exclude, flags.maxTotal
+	var err error
+
+	if c.Bool("require-ipns-validity") {
+		if iPath, err = strictlyResolve(fctx, s, iPath, c.Bool("verbose")); err != nil {
+			if ctx.Err() != nil {
+				return cli.NewExitError("interrupted", exitInterrupted)
+			}
+			return cli.NewExitError(err, 2)
+		}
+	}
+
+	required := c.Int("require-providers")
+	if c.Bool("verify-providers") || required > 0 {
+		var reachable, found int
+		err = s.WaitBootstrap(fctx)
+		if err == nil && c.Bool("verify-providers") {
+			count := verifyProvidersCount
+			if required > count {
+				count = required
+			}
+			reachable, found, err = verifyProviders(fctx, ipfs, iPath, count)
+		} else if err == nil {
+			found, err = countProviders(fctx, ipfs, iPath, required)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return cli.NewExitError("interrupted", exitInterrupted)
+			}
+			return cli.NewExitError(err, 2)
+		}
+		if c.Bool("verify-providers") {
+			log.Printf("%d of %d providers reachable", reachable, found)
+			if reachable == 0 {
+				return cli.NewExitError("no reachable providers found", 2)
+			}
+			if reachable < required {
+				return cli.NewExitError(fmt.Sprintf("only %d reachable providers, --require-providers wants %d", reachable, required), 2)
+			}
+		} else if found < required {
+			return cli.NewExitError(fmt.Sprintf("only %d providers found, --require-providers wants %d", found, required), 2)
+		}
+	}
+
+	if c.Bool("interactive") && stdinIsTerminal() {
+		if !s.Embedded() {
+			return fmt.Errorf("--interactive picks the providers of a spawned node (--node=spawn or temp)")
+		}
+		err := s.WaitBootstrap(fctx)
+		if err == nil {
+			err = chooseProviders(fctx, s, iPath)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return cli.NewExitError("interrupted", exitInterrupted)
+			}
+			return cli.NewExitError(err, 2)
+		}
+	}
+
+	if n := c.Int("hedge"); n > 0 {
+		var herr error
+		if s.Embedded() {
+			herr = s.WaitBootstrap(fctx)
+		}
+		var winner peer.ID
+		if herr == nil {
+			winner, herr = hedgeProviders(fctx, ipfs, iPath, n)
+		}
+		if ctx.Err() != nil {
+			return cli.NewExitError("interrupted", exitInterrupted)
+		}
+		// bitswap still looks for providers on its own
+		if herr != nil {
+			log.Printf("racing providers: %s", herr)
+		} else if c.Bool("verbose") {
+			log.Printf("provider %s connected first", winner)
+		}
+	}
+
+	statePath := c.String("since")
+	var state fetchState
+	var seen stateEntry
+	if statePath != "" {
+		if state, err = readState(statePath); err != nil {
+			return err
+		}
+		rp := t.resolved
+		if rp == nil {
+			if rp, err = s.Resolve(fctx, iPath); err != nil {
+				if ctx.Err() != nil {
+					return cli.NewExitError("interrupted", exitInterrupted)
+				}
+				return cli.NewExitError(err, 2)
+			}
+		}
+		seen.CID = rp.Cid().String()
+		seen.Sequence, _ = ipnsSequence(fctx, s, iPath)
+		last, ok := state[name]
+		if ok && last.CID == seen.CID {
+			return cli.NewExitError(fmt.Sprintf("%s still resolves to %s, not modified", name, seen.CID), exitNotModified)
+		}
+		if ok && seen.Sequence != 0 && seen.Sequence < last.Sequence {
+			return cli.NewExitError(fmt.Sprintf("%s resolved through an older record than last time (sequence %d < %d), not modified", name, seen.Sequence, last.Sequence), exitNotModified)
+		}
+		// Fetch what was resolved, so the state records what we got
+		// even if the name moves on meanwhile.
+		iPath = rp
+	}
+	if t.resolved != nil {
+		iPath = t.resolved
+	}
+
+	if c.Bool("estimate") {
+		est, err := estimateFetch(fctx, s, iPath)
+		if err != nil {
+			if ctx.Err() != nil {
+				return cli.NewExitError("interrupted", exitInterrupted)
+			}
+			return cli.NewExitError(fmt.Sprintf("estimating the fetch: %s", err), 2)
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s\n", name, est)
+		if !c.Bool("yes") {
+			ok, err := confirmFetch(os.Stdin, name)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return cli.NewExitError(fmt.Sprintf("not fetching %s", name), 1)
+			}
+		}
+	}
+
+	// the clock of --idle-timeout runs from here until the output is written
+	idle := newIdleWatch(c.Duration("idle-timeout"), func() int {
+		fetched, _, _ := s.BlockCounts()
+		return fetched
+	})
+	fctx, stopIdle := idle.watch(fctx)
+	defer stopIdle()
+	getOpts := []ipget.GetOption{ipget.WithSummary(t.summary)}
+	progress := explain.firstBytes()
+	if idle != nil {
+		progress = idle.progress(progress)
+	}
+	if progress != nil {
+		getOpts = append(getOpts, ipget.WithProgress(progress))
+	}
+	if c.Bool("accurate-size") {
+		getOpts = append(getOpts, ipget.WithAccurateSize())
+	}
+
+	explain.bootstrap(fctx, s)
+	explain.resolve(fctx, s, iPath)
+
+	out, err := s.Get(fctx, iPath, getOpts...)
+	if err != nil {
+		if err := s.BlockSizeErr(); err != nil {
+			return cli.NewExitError(err, 2)
+		}
+		if ctx.Err() != nil {
+			return cli.NewExitError("interrupted", exitInterrupted)
+		}
+		if err := idle.Err(); err != nil {
+			if uerr := s.Unavailable(err.Error()); uerr != nil {
+				return cli.NewExitError(uerr, unavailableExitCode(uerr))
+			}
+			return cli.NewExitError(err, 2)
+		}
+		if fctx.Err() == context.DeadlineExceeded {
+			if uerr := s.Unavailable("deadline reached"); uerr != nil {
+				return cli.NewExitError(uerr, unavailableExitCode(uerr))
+			}
+			return cli.NewExitError("deadline reached", 2)
+		}
+		return cli.NewExitError(err, 2)
+	}
+
+	namedByHash := t.namedByHash
+	if dir, ok := out.(files.Directory); ok && (c.Bool("unwrap") || c.Bool("unwrap-first")) {
+		entry, f, err := unwrap(dir, c.Bool("unwrap-first"))
+		if err != nil {
+			if ctx.Err() != nil {
+				return cli.NewExitError("interrupted", exitInterrupted)
+			}
+			return cli.NewExitError(err, 2)
+		}
+		out = f
+		iPath = ipath.Join(iPath, entry)
+		if namedByHash && entry != "" && entry != "." && entry != ".." && !strings.ContainsAny(entry, `/\`) {
+			// a hash says less than the name of the file it wraps
+			outPath = filepath.Join(c.String("output-dir"), entry)
+			namedByHash = false
+		}
+	}
+
+	if f, ok := out.(files.File); ok && (c.Bool("ordered") || c.IsSet("parallel-blocks") || flags.maxMemory > 0) {
+		rp, err := ipfs.ResolvePath(fctx, iPath)
+		if err != nil {
+			return cli.NewExitError(err, 2)
+		}
+		// plain IPLD blocks are read in one go anyway
+		if t := rp.Cid().Type(); t == cid.DagProtobuf || t == cid.Raw {
+			out = &wrappedFile{File: f, r: dagutil.NewOrderedReader(fctx, ipfs.Dag(), rp.Cid(), c.Int("parallel-blocks"), int64(flags.maxMemory), int64(c.Int("max-block-size")))}
+		}
+	}
+
+	var mimeType string
+	if f, ok := out.(files.File); ok && c.Bool("detect-type") {
+		var ext string
+		mimeType, ext, out, err = detectType(f)
+		if err != nil {
+			return cli.NewExitError(err, 2)
+		}
+		if namedByHash {
+			outPath += ext
+		}
+	}
+
+	// A partial tree is still useful, a partial file isn't.
+	_, isDir := out.(files.Directory)
+	compress := c.String("compress")
+	if compress != "" {
+		if isDir {
+			return cli.NewExitError(fmt.Sprintf("--compress writes single files, %s is a directory", name), 2)
+		}
+		if !c.IsSet("output") {
+			outPath += compressions[compress]
+		}
+	}
+	if isDir && c.Bool("append-output") {
+		return cli.NewExitError(fmt.Sprintf("--append extends a single file, %s is a directory", name), 2)
+	}
+	if isDir && len(copyOutputs(c)) > 0 {
+		return cli.NewExitError(fmt.Sprintf("several -o outputs are for a single file, %s is a directory", name), 2)
+	}
+	if outPath != "-" {
+		if err := makeParents(outPath, c.Bool("normalize-permissions")); err != nil {
+			return cli.NewExitError(err, 2)
+		}
+	}
+	ex := &extractor{
+		progress:      c.Bool("progress"),
+		progressMin:   int64(flags.progressMin),
+		stdoutTaken:   c.Bool("print-cid"),
+		atomic:        c.Bool("atomic"),
+		exclude:       exclude,
+		include:       flags.include,
+		minSize:       int64(flags.minSize),
+		maxSize:       int64(flags.maxSize),
+		checksums:     flags.checksums,
+		compress:      compress,
+		compressLevel: c.Int("compress-level"),
+		overwrite:     c.String("overwrite-policy"),
+		appendTo:      c.Bool("append-output"),
+		copies:        copyOutputs(c),
+		teeBestEffort: c.Bool("tee-best-effort"),
+		preallocate:   c.Bool("preallocate"),
+		keepGoing:     c.Bool("keep-going") || (isDir && !c.Bool("fail-fast")),
+		maxTotal:      int64(maxTotal),
+		reproducible:  c.Bool("reproducible"),
+		mtime:         flags.mtime,
+		flatten:       c.Bool("flatten"),
+	}
+	if c.String("manifest") != "" || t.summary != nil {
+		ex.manifest = newManifest(flags.checksums)
+	}
+	if interval := c.Duration("stats-interval"); interval > 0 {
+		ex.stats = newTransferStats()
+		sctx, stop := context.WithCancel(ctx)
+		defer stop()
+		go ex.stats.logEvery(sctx, ipfs, interval)
+	}
+	if c.Bool("verbose-peers") && (c.IsSet("stats-interval") || stderrIsTerminal()) {
+		interval := c.Duration("stats-interval")
+		if interval <= 0 {
+			interval = peerListInterval
+		} else if interval < peerListMinInterval {
+			interval = peerListMinInterval
+		}
+		pctx, stop := context.WithCancel(ctx)
+		defer stop()
+		go logPeersEvery(pctx, s, interval)
+	}
+
+	if isDir && ex.filtering() {
+		rp, err := ipfs.ResolvePath(fctx, iPath)
+		var sel selection
+		if err == nil {
+			ex.root = outPath
+			err = ex.countSelected(fctx, ipfs, rp.Cid(), outPath, &sel)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return cli.NewExitError("interrupted", exitInterrupted)
+			}
+			return cli.NewExitError(err, 2)
+		}
+		log.Printf("%s: %s", name, sel)
+	}
+
+	if c.Bool("continue") && isDir {
+		if ex.resume, err = continueDir(fctx, ipfs, iPath, outPath); err != nil {
+			return cli.NewExitError(err, 2)
+		}
+		// what's there and not recorded as written is unfinished
+		ex.overwrite = "overwrite"
+	}
+
+	ifDifferent := ex.overwrite == "if-different" && outPath != "-"
+	if ifDifferent {
+		var rp ipath.Resolved
+		if rp, err = ipfs.ResolvePath(fctx, iPath); err == nil {
+			err = ex.findUnchanged(fctx, s, rp.Cid(), out, outPath)
+		}
+	}
+	switch {
+	case err != nil:
+		// comparing with what's on disk failed, nothing was written
+	case c.Bool("delta") && isDir && ex.isExistingDir(outPath):
+		// updated in place
+		ex.claimed = outPath
+		var st syncStats
+		if st, err = ex.delta(fctx, s, iPath, outPath); err == nil {
+			log.Printf("%s: %s", outPath, st)
+		}
+	default:
+		err = ex.WriteTo(out, outPath)
+	}
+	if ifDifferent && err == nil {
+		switch {
+		case isDir:
+			log.Printf("%s: %d updated, %d unchanged", outPath, ex.replaced, ex.kept)
+		case ex.kept > 0:
+			log.Printf("%s: unchanged", outPath)
+		case ex.replaced > 0:
+			log.Printf("%s: updated", outPath)
+		}
+	}
+	for _, r := range ex.renamed {
+		log.Printf("flattened %s, its name was taken", r)
+	}
+	var missing []string
+	if c.Bool("fail-on-partial") && isDir && fctx.Err() == nil && idle.Err() == nil && s.BlockSizeErr() == nil {
+		if rp, rerr := ipfs.ResolvePath(ctx, iPath); rerr == nil {
+			missing = ex.missing(ctx, ipfs, rp.Cid(), outPath)
+		}
+		if len(missing) > 0 && err == nil {
+			err = partialError(missing)
+		}
+	}
+	if t.summary != nil {
+		recordSummary(ctx, t.summary, ipfs, s, iPath, outPath, ex)
+		t.summary.Missing = missing
+	}
+	if err != nil {
+		ex.resume.close()
+		// what the fetch left behind to set aside as partial: where it
+		// wrote, which --overwrite-policy=rename may have made another
+		// name than outPath, and nothing with --atomic, which only ever
+		// wrote the temporary output, removed already
+		partial := ex.claimed
+		if ex.atomic {
+			partial = ""
+		}
+		if err := s.BlockSizeErr(); err != nil {
+			return cli.NewExitError(err, 2)
+		}
+		if ex.appendTo && ctx.Err() != nil {
+			return cli.NewExitError(fmt.Sprintf("interrupted, %s left as it was", outPath), exitInterrupted)
+		}
+		if ctx.Err() != nil {
+			return interrupted(partial)
+		}
+		if ierr := idle.Err(); ierr != nil && ex.appendTo {
+			return cli.NewExitError(fmt.Sprintf("%s, %s left as it was", ierr, outPath), 2)
+		}
+		if ierr := idle.Err(); ierr != nil {
+			return stalled(partial, ierr)
+		}
+		if ex.appendTo && fctx.Err() == context.DeadlineExceeded {
+			return cli.NewExitError(fmt.Sprintf("deadline reached, %s left as it was", outPath), 2)
+		}
+		if fctx.Err() == context.DeadlineExceeded {
+			return deadlineReached(partial)
+		}
+		return cli.NewExitError(err, 2)
+	}
+	if err := ex.resume.finish(); err != nil {
+		return cli.NewExitError(err, 2)
+	}
+	if mimeType != "" {
+		fmt.Fprintln(os.Stderr, paint(colorGreen, fitWidth(fmt.Sprintf("saved %s (%s)", outPath, mimeType))))
+	}
+	if c.Bool("normalize-permissions") {
+		if err := normalizePermissions(outPath); err != nil {
+			return cli.NewExitError(fmt.Sprintf("normalizing the permissions: %s", err), 2)
+		}
+		for _, cp := range ex.copies {
+			if err := normalizePermissions(cp); err != nil && !ex.teeBestEffort {
+				return cli.NewExitError(fmt.Sprintf("normalizing the permissions: %s", err), 2)
+			}
+		}
+	}
+	if flags.preserve.any() {
+		rp, err := ipfs.ResolvePath(ctx, iPath)
+		var failed []string
+		if err == nil {
+			failed, err = applyMetadata(ctx, ipfs, rp.Cid(), outPath, flags.preserve)
+		}
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("applying the metadata: %s", err), 2)
+		}
+		if c.Bool("preserve-strict") && len(failed) > 0 {
+			for _, f := range failed {
+				log.Printf("WARNING: couldn't set the %s", f)
+			}
+			return cli.NewExitError(fmt.Sprintf("couldn't set %d metadata fields", len(failed)), 2)
+		}
+	}
+	if manifestPath := c.String("manifest"); manifestPath != "" {
+		rp, err := ipfs.ResolvePath(ctx, iPath)
+		if err == nil {
+			err = ex.manifest.fillCIDs(ctx, ipfs, rp.Cid(), filepath.Base(outPath))
+		}
+		if err == nil {
+			err = ex.manifest.write(manifestPath)
+		}
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("writing the manifest: %s", err), 2)
+		}
+	}
+	if statePath != "" {
+		state[name] = seen
+		if err := state.write(statePath); err != nil {
+			return err
+		}
+	}
+	logBlockCounts(s)
+	if n := s.RefusedPeers(); n > 0 {
+		log.Printf("cut off %d peers speaking only protocol versions older than --min-protocol", n)
+	}
+	if n := s.ExcludedProviders(); n > 0 {
+		log.Printf("excluded %d providers by --provider-allowlist or --provider-denylist", n)
+	}
+	if c.Bool("verbose") {
+		logPeerTraffic(s)
+		if n := s.MalformedRecords(); n > 0 {
+			log.Printf("ignored %d malformed provider records", n)
+		}
+		if unique, total := s.ProviderRecords(); total > 0 {
+			log.Printf("%d provider records found, from %d providers", total, unique)
+		}
+		if n := s.ReroutedBlocks(); n > 0 {
+			log.Printf("rerouted %d blocks that didn't come within --block-timeout", n)
+		}
+		if n := s.CappedLookups(); n > 0 {
+			log.Printf("%d DHT lookups stopped at --max-lookup-rounds", n)
+		}
+		for _, p := range s.RefreshedProviders() {
+			log.Printf("reached provider %s only after refreshing its addresses", p)
+		}
+	}
+
+	if other := c.String("content-equal"); other != "" {
+		if _, ok := out.(files.File); !ok {
+			return cli.NewExitError(fmt.Sprintf("--content-equal compares files, %s is a directory", name), 2)
+		}
+		if err := contentEqual(ctx, c, s, outPath, other); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := out.(files.File); ok && c.Bool("verify-layout") {
+		rp, err := ipfs.ResolvePath(ctx, iPath)
+		if err != nil {
+			return cli.NewExitError(err, 2)
+		}
+		if t := rp.Cid().Type(); t == cid.DagProtobuf || t == cid.Raw {
+			layout, err := detectLayout(ctx, ipfs.Dag(), rp.Cid())
+			if err != nil {
+				return cli.NewExitError(err, 2)
+			}
+			log.Printf("layout: %s", layout)
+		}
+	}
+
+	if c.IsSet("on-complete") {
+		rp, err := ipfs.ResolvePath(ctx, iPath)
+		if err != nil {
+			return cli.NewExitError(err, 2)
+		}
+		if err := onComplete(ctx, c, t, outPath, rp.Cid().String()); err != nil {
+			return err
+		}
+	}
+
+	if c.Bool("print-cid") {
+		rp, err := ipfs.ResolvePath(ctx, iPath)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("resolving the CID to print: %s", err), 2)
+		}
+		fmt.Println(ipath.IpfsPath(rp.Cid()))
+	}
+
+	if c.Bool("announce") {
+		rp, err := ipfs.ResolvePath(ctx, iPath)
+		if err != nil {
+			return cli.NewExitError(err, 2)
+		}
+		if !s.Embedded() {
+			// the daemon keeps the record published on its own
+			if err := ipfs.Dht().Provide(ctx, rp, options.Dht.Recursive(c.String("reprovide-strategy") == "all")); err != nil {
+				return cli.NewExitError(err, 2)
+			}
+			return nil
+		}
+		// Our node is the one holding the blocks, so it has to stay up
+		// for the record to be of any use.
+		log.Printf("serving %s until interrupted", rp.Cid())
+		if err := newAnnouncer(s, c.Duration("announce-interval"), c.String("reprovide-strategy") == "all").announce(ctx, rp); err != nil && ctx.Err() == nil {
+			return cli.NewExitError(err, 2)
+		}
+	}
+	return nil
+}
+
+// setupNode starts a session on the node selected by the 'node' strategy
+// flag, configured from the global flags. maxBlockSize is enforced on the
+// blocks a spawned node stores, 0 disables it.
+func setupNode(ctx context.Context, c *cli.Context, maxBlockSize int) (*ipget.Session, error) {
+	opts, err := sessionOptions(c, maxBlockSize)
+	if err != nil {
+		return nil, err
+	}
+	return ipget.New(ctx, opts)
+}
+
+// sessionOptions maps the global flags onto session options.
+func sessionOptions(c *cli.Context, maxBlockSize int) (ipget.Options, error) {
+	opts := ipget.Options{
+		Node:                    c.GlobalString("node"),
+		API:                     c.GlobalString("api"),
+		Peers:                   c.GlobalStringSlice("peers"),
+		UserAgent:               c.GlobalString("user-agent"),
+		NoBootstrapWait:         c.GlobalBool("no-bootstrap-wait"),
+		Decode:                  c.GlobalBool("decode"),
+		MaxBlockSize:            maxBlockSize,
+		MaxDials:                c.GlobalInt("max-dials"),
+		PeerstoreTTL:            c.GlobalDuration("peerstore-ttl"),
+		Gateways:                gatewayURLs(c),
+		StrictProviderAddrs:     c.GlobalBool("strict-provider-addrs"),
+		NoVerifyRecords:         c.GlobalBool("no-verify-records"),
+		PeerStats:               c.GlobalBool("verbose") || c.GlobalBool("verbose-peers") || c.GlobalIsSet("max-connections-per-fetch") || c.GlobalString("summary-json") != "" || c.GlobalString("audit-log") != "",
+		HTTPRetries:             c.GlobalInt("http-retries"),
+		GatewayTimeout:          c.GlobalDuration("gateway-timeout"),
+		GatewayBlockTimeout:     c.GlobalDuration("gateway-block-timeout"),
+		TrustGateway:            c.GlobalBool("trust-gateway"),
+		ResolveConcurrency:      c.GlobalInt("resolve-concurrency"),
+		ResolveCacheTTL:         c.GlobalDuration("resolve-cache-ttl"),
+		ClusterLevel:            c.GlobalInt("cluster-level"),
+		Routing:                 c.GlobalString("routing"),
+		RoutingEndpoint:         c.GlobalString("routing-endpoint"),
+		RoutingTableCache:       c.GlobalString("routing-table-cache"),
+		SOCKS5:                  c.GlobalString("socks5"),
+		CompleteOnFirstProvider: c.GlobalBool("complete-on-first-provider"),
+		NoAddrRefresh:           c.GlobalBool("no-addr-refresh"),
+		MinProtocol:             c.GlobalStringSlice("min-protocol"),
+		MaxLookupRounds:         c.GlobalInt("max-lookup-rounds"),
+		LogProtocols:            c.GlobalBool("verbose-peers"),
+		BlockTimeout:            c.GlobalDuration("block-timeout"),
+		LogReroutes:             c.GlobalBool("verbose"),
+		MaxConnections:          c.GlobalInt("max-connections"),
+		Exchange:                c.GlobalString("exchange"),
+		BootstrapMinPeers:       c.GlobalInt("bootstrap-min-peers"),
+		Logger:                  stdLogger{},
+		OnionOnly:               c.GlobalBool("onion-only"),
+	}
+	if c.GlobalBool("interactive") && stdinIsTerminal() {
+		opts.ProviderFilter = chosenProviders.keep
+	}
+	if opts.NoVerifyRecords {
+		log.Printf("WARNING: --no-verify-records is set, DHT records that fail validation will be accepted; names may resolve to content their owner never published")
+	}
+	if opts.HTTPRetries < 0 {
+		return opts, fmt.Errorf("--http-retries must not be negative")
+	}
+	if opts.ResolveConcurrency < 1 {
+		return opts, fmt.Errorf("--resolve-concurrency must be at least 1")
+	}
+	if opts.ResolveCacheTTL < 0 {
+		return opts, fmt.Errorf("--resolve-cache-ttl must not be negative")
+	}
+	if opts.ClusterLevel < 0 {
+		return opts, fmt.Errorf("--cluster-level must not be negative")
+	}
+	if opts.ClusterLevel > 0 && opts.Routing != "dht" {
+		return opts, fmt.Errorf("--cluster-level is set on DHT queries, it needs --routing=dht")
+	}
+	if opts.BlockTimeout < 0 {
+		return opts, fmt.Errorf("--block-timeout must not be negative")
+	}
+	if opts.MaxLookupRounds < 0 {
+		return opts, fmt.Errorf("--max-lookup-rounds must not be negative")
+	}
+	if opts.MaxLookupRounds > 0 && opts.Routing != "dht" {
+		return opts, fmt.Errorf("--max-lookup-rounds bounds DHT lookups, it needs --routing=dht")
+	}
+	if opts.BootstrapMinPeers < 1 {
+		return opts, fmt.Errorf("--bootstrap-min-peers must be at least 1")
+	}
+	if c.GlobalBool("verbose") {
+		start := time.Now()
+		opts.BootstrapProgress = func(connected, wanted int) {
+			if connected >= wanted {
+				log.Printf("bootstrapped, %d peers connected in %s", connected, time.Since(start).Round(time.Millisecond))
+				return
+			}
+			log.Printf("bootstrapping (%d/%d peers connected)", connected, wanted)
+		}
+	}
+	if opts.Exchange == "none" && len(opts.Gateways) > 0 {
+		return opts, fmt.Errorf("--exchange=none only uses the blocks at hand, it can't fall back to a --gateway")
+	}
+	if fpath := c.GlobalString("provider-allowlist"); fpath != "" {
+		var err error
+		if opts.ProviderAllowlist, err = readPeerIDs(fpath); err != nil {
+			return opts, fmt.Errorf("--provider-allowlist: %s", err)
+		}
+		if len(opts.ProviderAllowlist) == 0 {
+			return opts, fmt.Errorf("--provider-allowlist %s names no peers, nothing could be fetched", fpath)
+		}
+	}
+	if fpath := c.GlobalString("provider-denylist"); fpath != "" {
+		var err error
+		if opts.ProviderDenylist, err = readPeerIDs(fpath); err != nil {
+			return opts, fmt.Errorf("--provider-denylist: %s", err)
+		}
+	}
+	if (opts.ProviderAllowlist != nil || len(opts.ProviderDenylist) > 0) && len(opts.Gateways) > 0 {
+		return opts, fmt.Errorf("--provider-allowlist and --provider-denylist restrict the peers blocks come from, they can't be combined with a --gateway")
+	}
+	if opts.MaxConnections < 0 {
+		return opts, fmt.Errorf("--max-connections must not be negative")
+	}
+	if opts.MaxConnections > 0 && c.GlobalInt("conn-low") > opts.MaxConnections {
+		return opts, fmt.Errorf("--conn-low can't be above --max-connections")
+	}
+	if opts.SOCKS5 != "" {
+		if err := checkSocksAddr(opts.SOCKS5); err != nil {
+			return opts, err
+		}
+		if c.GlobalIsSet("listen") || c.GlobalIsSet("local-addr") || c.GlobalBool("nat") || c.GlobalBool("allow-local") {
+			return opts, fmt.Errorf("a node going through --socks5 doesn't listen or look for local peers, drop --listen, --local-addr, --nat and --allow-local")
+		}
+	} else if opts.OnionOnly {
+		return opts, fmt.Errorf("--onion-only needs --socks5, onion addresses can only be dialed through a proxy")
+	}
+	headers, err := parseHeaders(c.GlobalStringSlice("gateway-header"))
+	if err != nil {
+		return opts, err
+	}
+	if tokenPath := c.GlobalString("gateway-token-file"); tokenPath != "" {
+		data, err := ioutil.ReadFile(tokenPath)
+		if err != nil {
+			return opts, err
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return opts, fmt.Errorf("the gateway token file %s is empty", tokenPath)
+		}
+		headers.Set("Authorization", "Bearer "+token)
+	}
+	if opts.GatewayBlockTimeout < 0 {
+		return opts, fmt.Errorf("--gateway-block-timeout must not be negative")
+	}
+	if opts.GatewayBlockTimeout > 0 && len(opts.Gateways) == 0 {
+		return opts, fmt.Errorf("--gateway-block-timeout needs a --gateway to fall back to")
+	}
+	if opts.TrustGateway {
+		if len(opts.Gateways) == 0 {
+			return opts, fmt.Errorf("--trust-gateway applies to the gateways, and no --gateway is set")
+		}
+		log.Printf("WARNING: --trust-gateway is set, blocks from the gateways aren't checked against their CID; a gateway can send content other than what was asked for")
+	}
+	if len(headers) > 0 && len(opts.Gateways) == 0 {
+		return opts, fmt.Errorf("gateway headers are only sent to the gateways, and no --gateway is set")
+	}
+	opts.GatewayHeaders = headers
+	for _, gw := range opts.Gateways {
+		if u, err := url.Parse(gw); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return opts, fmt.Errorf("invalid gateway URL %q", gw)
+		}
+	}
+	if opts.API != "" {
+		// don't let a typo silently fall back to spawning a node
+		if _, err := ma.NewMultiaddr(opts.API); err != nil {
+			return opts, fmt.Errorf("invalid API address %q: %s", opts.API, err)
+		}
+	}
+	if c.GlobalBool("allow-local") {
+		opts.Config = append(opts.Config, allowLocal)
+	}
+	if c.GlobalBool("public-addrs-only") {
+		opts.Config = append(opts.Config, publicAddrsOnly)
+	}
+	switch {
+	case c.GlobalBool("nat") && c.GlobalBool("no-nat"):
+		return opts, fmt.Errorf("--nat and --no-nat are mutually exclusive")
+	case c.GlobalBool("nat"):
+		opts.Config = append(opts.Config, natPortMap(true))
+		opts.LogExternalAddrs = true
+	case c.GlobalBool("no-nat"):
+		opts.Config = append(opts.Config, natPortMap(false))
+	}
+	if addrs := c.GlobalStringSlice("listen"); len(addrs) > 0 {
+		listen, err := listenOn(addrs)
+		if err != nil {
+			return opts, err
+		}
+		opts.Config = append(opts.Config, listen)
+	}
+	if ip := c.GlobalString("local-addr"); ip != "" {
+		if c.GlobalIsSet("listen") {
+			return opts, fmt.Errorf("--local-addr sets the listen addresses, it can't be combined with --listen")
+		}
+		if c.GlobalBool("ip4-only") || c.GlobalBool("ip6-only") {
+			return opts, fmt.Errorf("--local-addr keeps to the IP family of its address, drop --ip4-only and --ip6-only")
+		}
+		local, err := localAddr(ip)
+		if err != nil {
+			return opts, err
+		}
+		opts.Config = append(opts.Config, local)
+	}
+	switch {
+	case c.GlobalBool("ip4-only") && c.GlobalBool("ip6-only"):
+		return opts, fmt.Errorf("--ip4-only and --ip6-only are mutually exclusive")
+	case c.GlobalBool("ip4-only"):
+		opts.Config = append(opts.Config, onlyIP("ip4"))
+	case c.GlobalBool("ip6-only"):
+		opts.Config = append(opts.Config, onlyIP("ip6"))
+	}
+	if peers := c.GlobalStringSlice("bootstrap"); len(peers) > 0 {
+		bootstrap, err := bootstrapWith(peers)
+		if err != nil {
+			return opts, err
+		}
+		opts.Config = append(opts.Config, bootstrap)
+	}
+	if keyPath := c.GlobalString("swarm-key"); keyPath != "" {
+		key, err := ioutil.ReadFile(keyPath)
+		if err != nil {
+			return opts, err
+		}
+		opts.SwarmKey = key
+	}
+	if low, high := c.GlobalInt("conn-low"), c.GlobalInt("conn-high"); low > 0 || high > 0 {
+		if low > 0 && high > 0 && low > high {
+			return opts, fmt.Errorf("--conn-low can't be above --conn-high")
+		}
+		opts.Config = append(opts.Config, connLimits(low, high))
+	}
+	if cidrs := c.GlobalStringSlice("addr-filter"); len(cidrs) > 0 {
+		filters, err := addrFilters(cidrs)
+		if err != nil {
+			return opts, err
+		}
+		opts.Config = append(opts.Config, filters)
+	}
+	validators, err := parseValidators(c.GlobalStringSlice("record-validator"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Validators = validators
+	return opts, nil
+}
+
+// gatewayURLs returns the gateways to fall back to: those given with
+// --gateway or, failing that, those listed in $IPGET_GATEWAYS, followed by
+// defaultGateway with --use-default-gateway.
+func gatewayURLs(c *cli.Context) []string {
+	urls := c.GlobalStringSlice("gateway")
+	if !c.GlobalIsSet("gateway") {
+		urls = nil
+		for _, u := range strings.Split(os.Getenv("IPGET_GATEWAYS"), ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				urls = append(urls, u)
+			}
+		}
+	}
+	if c.GlobalBool("use-default-gateway") && !contains(urls, defaultGateway) {
+		urls = append(urls, defaultGateway)
+	}
+	return urls
+}
+
+// fetchCAR exports the DAGs of all the paths given on the command line into
+// a single CAR file at carPath, listing each of them as a root.
+func fetchCAR(ctx context.Context, c *cli.Context, carPath string) error {
+	version := c.Int("car-version")
+	if err := checkCARVersion(version); err != nil {
+		return err
+	}
+	var sel selector.Selector
+	if expr := c.String("select"); expr != "" {
+		var err error
+		if sel, err = parseSelector(expr); err != nil {
+			return err
+		}
+	}
+
+	dnslink := newDNSLinkResolver(c.String("dns-server"), c.BoolT("dnslink"))
+	paths := make([]ipath.Path, len(c.Args()))
+	for i, arg := range c.Args() {
+		p, err := parsePath(arg, c.Bool("use-url-host"))
+		if err != nil {
+			return err
+		}
+		if paths[i], err = dnslink.resolve(ctx, p); err != nil {
+			return err
+		}
+	}
+
+	s, err := setupNode(ctx, c, c.Int("max-block-size"))
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	ipfs := s.API()
+	if s.Embedded() {
+		if err := s.WaitBootstrap(ctx); err != nil {
+			if ctx.Err() != nil {
+				return cli.NewExitError("interrupted", exitInterrupted)
+			}
+			return err
+		}
+	}
+
+	fctx, stop := s.WatchBlockSize(ctx)
+	defer stop()
+
+	roots := make([]cid.Cid, len(paths))
+	for i, p := range paths {
+		rp, err := ipfs.ResolvePath(fctx, p)
+		if err != nil {
+			if err := s.BlockSizeErr(); err != nil {
+				return cli.NewExitError(err, 2)
+			}
+			if ctx.Err() != nil {
+				return cli.NewExitError("interrupted", exitInterrupted)
+			}
+			return cli.NewExitError(err, 2)
+		}
+		roots[i] = rp.Cid()
+	}
+
+	var dag ipld.NodeGetter = ipfs.Dag()
+	if sel != nil {
+		keep := make(map[cid.Cid]bool)
+		for _, root := range roots {
+			selected, err := selectBlocks(fctx, ipfs, root, sel)
+			if err != nil {
+				if err := s.BlockSizeErr(); err != nil {
+					return cli.NewExitError(err, 2)
+				}
+				if ctx.Err() != nil {
+					return cli.NewExitError("interrupted", exitInterrupted)
+				}
+				return cli.NewExitError(err, 2)
+			}
+			for c := range selected {
+				keep[c] = true
+			}
+		}
+		dag = selectedDAG{NodeGetter: dag, keep: keep}
+	}
+
+	f, err := os.Create(carPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := writeCAR(fctx, dag, roots, f, version); err != nil {
+		if err := s.BlockSizeErr(); err != nil {
+			return cli.NewExitError(err, 2)
+		}
+		if ctx.Err() != nil {
+			f.Close()
+			return interrupted(carPath)
+		}
+		return cli.NewExitError(err, 2)
+	}
+	logBlockCounts(s)
+	return f.Close()
+}
+
+// seedCAR imports the blocks of the CAR file at path into the session.
+func seedCAR(ctx context.Context, s *ipget.Session, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := s.Seed(ctx, f)
+	if err != nil {
+		return fmt.Errorf("seeding from %s: %s", path, err)
+	}
+	log.Printf("imported %d blocks from %s", n, path)
+	return nil
+}
+
+// logBlockCounts reports how many blocks the session fetched and how many it
+// found in its blockstore already, which only a spawned node can tell.
+func logBlockCounts(s *ipget.Session) {
+	if n := s.GatewayBlocks(); n > 0 {
+		log.Printf("fetched %d blocks from the gateways", n)
+	}
+	for p, n := range s.BadBlocks() {
+		log.Printf("WARNING: peer %s sent %d corrupt or unrequested blocks, it was cut off and the blocks fetched from other peers", p, n)
+	}
+	if !s.Embedded() {
+		return
+	}
+	if n := s.RelayOnlyProviders(); n > 0 {
+		log.Printf("ignored %d relay-only providers", n)
+	}
+	fetched, deduplicated, seeded := s.BlockCounts()
+	if seeded > 0 {
+		log.Printf("fetched %d blocks, %d deduplicated, %d from the seed CAR", fetched, deduplicated, seeded)
+		return
+	}
+	log.Printf("fetched %d blocks, %d deduplicated", fetched, deduplicated)
+}
+
+// logPeerTraffic logs what each peer sent during the fetch, for --verbose.
+func logPeerTraffic(s *ipget.Session) {
+	for _, t := range s.PeerTraffic() {
+		log.Printf("peer %s sent %s in %d blocks", t.Peer, humanize.Bytes(uint64(t.Bytes)), t.Blocks)
+	}
+}
+
+// resolveOnly prints the CID iPath resolves to, in the format given by
+// --out-format.
+func resolveOnly(ctx context.Context, c *cli.Context, iPath ipath.Path) error {
+	format := c.String("out-format")
+	switch format {
+	case "cid", "path", "json":
+	default:
+		return fmt.Errorf("unknown output format %q, must be 'cid', 'path' or 'json'", format)
+	}
+
+	// A bare /ipfs/<cid> is already resolved, there's no need for a node.
+	var root cid.Cid
+	if parts := strings.Split(strings.Trim(iPath.String(), "/"), "/"); len(parts) == 2 && parts[0] == "ipfs" {
+		var err error
+		if root, err = cid.Decode(parts[1]); err != nil {
+			return err
+		}
+	} else {
+		s, err := setupNode(ctx, c, c.Int("max-block-size"))
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		rp, err := s.Resolve(ctx, iPath)
+		if err != nil {
+			if ctx.Err() != nil {
+				return cli.NewExitError("interrupted", exitInterrupted)
+			}
+			return cli.NewExitError(err, 2)
+		}
+		root = rp.Cid()
+	}
+
+	switch format {
+	case "cid":
+		fmt.Println(root)
+	case "path":
+		fmt.Println(ipath.IpfsPath(root))
+	case "json":
+		return json.NewEncoder(os.Stdout).Encode(struct {
+			Cid  string `json:"cid"`
+			Path string `json:"path"`
+		}{root.String(), ipath.IpfsPath(root).String()})
+	}
+	return nil
+}
+
+// commandIndex returns the index of the sub-command named in args, or -1 if
+// the first non-flag argument isn't one.
+func commandIndex(app *cli.App, args []string) int {
+	for idx := 1; idx < len(args); idx++ {
+		if strings.HasPrefix(args[idx], "-") {
+			if !strings.Contains(args[idx], "=") {
+				idx++
+			}
+			continue
+		}
+		if cmd := app.Command(args[idx]); cmd != nil {
+			// with a sub-command of its own, that's the one whose flags
+			// follow
+			for _, sub := range cmd.Subcommands {
+				if idx+1 < len(args) && sub.HasName(args[idx+1]) {
+					return idx + 1
+				}
+			}
+			return idx
+		}
+		return -1
+	}
+	return -1
+}
+
+// movePostfixOptions finds the Qmfoobar hash argument and moves it to the end
+// of the argument array.
+func movePostfixOptions(args []string) []string {
+	var idx = 1
+	the_args := make([]string, 0)
+	for {
+		if idx >= len(args) {
+			break
+		}
+
+		if strings.HasPrefix(args[idx], "-") {
+			if !strings.Contains(args[idx], "=") {
+				idx++
+			}
+		} else {
+			// add to args accumulator
+			the_args = append(the_args, args[idx])
+
+			// remove from real args list
+			new_args := make([]string, 0)
+			new_args = append(new_args, args[:idx]...)
+			new_args = append(new_args, args[idx+1:]...)
+			args = new_args
+			idx--
+		}
+
+		idx++
+	}
+
+	// append extracted arguments to the real args
+	return append(args, the_args...)
+}
+
+// parsePath turns an IPFS path or URL given on the command line into a path.
+// Gateway URLs are accepted both path style, https://<gateway>/ipfs/<cid>,
+// and subdomain style, https://<cid>.ipfs.<gateway>. The gateway itself is
+// ignored, unless useHost is set, in which case a URL of neither style is
+// read as the DNSLink name of its host.
+func parsePath(path string, useHost bool) (ipath.Path, error) {
+	path = strings.TrimSpace(path)
+	ipfsPath := ipath.New(path)
+	if ipfsPath.IsValid() == nil {
+		return ipfsPath, nil
+	}
+
+	u, err := url.Parse(path)
+	if err != nil || u.Scheme == "" {
+		return normalizePath(path)
+	}
+
+	switch proto := u.Scheme; proto {
+	case "ipfs", "ipld", "ipns":
+		ipfsPath = ipath.New(gopath.Join("/", proto, u.Host, u.Path))
+	case "http", "https":
+		if ns, name, ok := gatewaySubdomain(u.Hostname()); ok {
+			ipfsPath = ipath.New(gopath.Join("/", ns, name, u.Path))
+		} else if useHost && !strings.HasPrefix(u.Path, "/ipfs/") && !strings.HasPrefix(u.Path, "/ipns/") {
+			ipfsPath = ipath.New(gopath.Join("/ipns", u.Hostname(), u.Path))
+		} else {
+			ipfsPath = ipath.New(u.Path)
+		}
+	default:
+		return nil, fmt.Errorf("%q is not recognized as an IPFS path", path)
+	}
+	return normalizePath(ipfsPath.String())
+}
+
+// gatewaySubdomain splits a subdomain gateway host such as
+// <cid>.ipfs.dweb.link into its namespace and name. DNSLink names are
+// inlined by gateways with their dots turned into dashes, and their dashes
+// doubled, which is undone.
+func gatewaySubdomain(host string) (string, string, bool) {
+	labels := strings.SplitN(host, ".", 3)
+	if len(labels) < 3 || (labels[1] != "ipfs" && labels[1] != "ipns") {
+		return "", "", false
+	}
+	// Only a CID or an inlined DNSLink name makes it a gateway, so a site
+	// like docs.ipfs.io isn't mistaken for one.
+	ns, name := labels[1], labels[0]
+	if _, err := cid.Decode(name); err == nil {
+		return ns, name, true
+	}
+	if ns == "ipns" && strings.Contains(name, "-") {
+		name = strings.Replace(name, "--", "\x00", -1)
+		name = strings.Replace(name, "-", ".", -1)
+		name = strings.Replace(name, "\x00", "-", -1)
+		return ns, name, true
+	}
+	return "", "", false
+}
+
+// interrupted returns the error to exit with when a signal cut writing
+// outPath short, after setting aside whatever was written.
+func interrupted(outPath string) error {
+	partial, err := markPartial(outPath)
+	if err != nil {
+		return cli.NewExitError(err, exitInterrupted)
+	}
+	if partial != "" {
+		return cli.NewExitError("interrupted, partial output saved to "+partial, exitInterrupted)
+	}
+	return cli.NewExitError("interrupted", exitInterrupted)
+}
+
+// deadlineReached is what a fetch cut short by --timeout or --deadline
+// returns, after setting aside whatever was written to outPath.
+func deadlineReached(outPath string) error {
+	partial, err := markPartial(outPath)
+	if err != nil {
+		return cli.NewExitError(err, 2)
+	}
+	if partial != "" {
+		return cli.NewExitError("deadline reached, partial output saved to "+partial, 2)
+	}
+	return cli.NewExitError("deadline reached", 2)
+}
+
+// stalled is what a fetch given up on by --idle-timeout returns, after
+// setting aside whatever was written to outPath.
+func stalled(outPath string, err error) error {
+	partial, perr := markPartial(outPath)
+	if perr != nil {
+		return cli.NewExitError(perr, 2)
+	}
+	if partial != "" {
+		return cli.NewExitError(fmt.Sprintf("%s, partial output saved to %s", err, partial), 2)
+	}
+	return cli.NewExitError(err, 2)
+}
+
+// markPartial renames the interrupted output at fpath to fpath.partial so it
+// can't be mistaken for a complete download. It returns the new name, or ""
+// if nothing had been written yet, or fpath is empty because nothing is to
+// be set aside.
+func markPartial(fpath string) (string, error) {
+	if fpath == "" {
+		return "", nil
+	}
+	if _, err := os.Lstat(fpath); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	partial := fpath + ".partial"
+	return partial, os.Rename(fpath, partial)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+)
+
+// manifestEntry describes one file an extraction wrote.
+type manifestEntry struct {
+	// Path is relative to the output, with forward slashes.
+	Path string `json:"path"`
+	CID  string `json:"cid"`
+	// Size is the size of the file's content, before any compression.
+	Size int64 `json:"size"`
+	// Checksums are those of the file as written, by algorithm.
+	Checksums map[string]string `json:"checksums,omitempty"`
+}
+
+// manifest collects the files an extraction writes, for --manifest.
+type manifest struct {
+	mu      sync.Mutex
+	entries []manifestEntry
+	algos   []string
+	// failures are the files that couldn't be written, by path, with
+	// why. They are only reported in a --summary-json.
+	failures map[string]string
+}
+
+func newManifest(algos []string) *manifest {
+	return &manifest{algos: algos}
+}
+
+// add records that rel was written with size bytes of content and the
+// given sums, which may be nil.
+func (m *manifest) add(rel string, size int64, sum *sums) {
+	if m == nil {
+		return
+	}
+	e := manifestEntry{Path: filepath.ToSlash(rel), Size: size}
+	if sum != nil {
+		e.Checksums = make(map[string]string, len(sum.algos))
+		for i, algo := range sum.algos {
+			e.Checksums[algo] = fmt.Sprintf("%x", sum.hashes[i].Sum(nil))
+		}
+	}
+	m.mu.Lock()
+	m.entries = append(m.entries, e)
+	m.mu.Unlock()
+}
+
+// fail records that rel couldn't be written because of err.
+func (m *manifest) fail(rel string, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	if m.failures == nil {
+		m.failures = make(map[string]string)
+	}
+	m.failures[filepath.ToSlash(rel)] = err.Error()
+	m.mu.Unlock()
+}
+
+// fillCIDs looks up the CID of every file recorded in the DAG at root,
+// which was extracted as rootName. Only directory nodes are read, and those
+// were fetched by the extraction already.
+func (m *manifest) fillCIDs(ctx context.Context, ipfs iface.CoreAPI, root cid.Cid, rootName string) error {
+	cids := make(map[string]cid.Cid)
+	if err := walkFiles(ctx, ipfs, root, "", cids); err != nil {
+		return err
+	}
+	if c, ok := cids[""]; ok {
+		// a single file
+		delete(cids, "")
+		cids[filepath.ToSlash(rootName)] = c
+	}
+	for i, e := range m.entries {
+		if c, ok := cids[e.Path]; ok {
+			m.entries[i].CID = c.String()
+		}
+	}
+	return nil
+}
+
+// walkFiles adds the CIDs of the files in the DAG at c to cids, by their
+// path below prefix.
+func walkFiles(ctx context.Context, ipfs iface.CoreAPI, c cid.Cid, prefix string, cids map[string]cid.Cid) error {
+	entries, err := lsDir(ctx, ipfs, c)
+	if err != nil {
+		return err
+	}
+	if entries == nil {
+		cids[prefix] = c
+		return nil
+	}
+	for name, e := range entries {
+		p := path.Join(prefix, name)
+		if e.Type == iface.TDirectory {
+			if err := walkFiles(ctx, ipfs, e.Cid, p, cids); err != nil {
+				return err
+			}
+			continue
+		}
+		cids[p] = e.Cid
+	}
+	return nil
+}
+
+// write writes the manifest to fpath, sorted by path, as CSV if fpath ends
+// in .csv and as JSON otherwise.
+func (m *manifest) write(fpath string) error {
+	if m.entries == nil {
+		m.entries = []manifestEntry{}
+	}
+	sort.Slice(m.entries, func(i, j int) bool { return m.entries[i].Path < m.entries[j].Path })
+	f, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(fpath), ".csv") {
+		err = m.writeCSV(f)
+	} else {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(m.entries)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (m *manifest) writeCSV(f *os.File) error {
+	w := csv.NewWriter(f)
+	if err := w.Write(append([]string{"path", "cid", "size"}, m.algos...)); err != nil {
+		return err
+	}
+	for _, e := range m.entries {
+		row := []string{e.Path, e.CID, strconv.FormatInt(e.Size, 10)}
+		for _, algo := range m.algos {
+			row = append(row, e.Checksums[algo])
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	dag "github.com/ipfs/go-merkledag"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+)
+
+// metadataFields are the unixfs metadata fields --preserve can apply.
+var metadataFields = []string{"mode", "mtime"}
+
+// preserveFields says which unixfs metadata fields are applied to the
+// files and directories written.
+type preserveFields struct {
+	mode, mtime bool
+}
+
+func (p preserveFields) any() bool {
+	return p.mode || p.mtime
+}
+
+// parsePreserve parses the comma separated fields of --preserve, "all" for
+// every one of them.
+func parsePreserve(spec string) (preserveFields, error) {
+	var p preserveFields
+	for _, f := range strings.Split(spec, ",") {
+		switch strings.ToLower(strings.TrimSpace(f)) {
+		case "mode":
+			p.mode = true
+		case "mtime":
+			p.mtime = true
+		case "all":
+			p.mode, p.mtime = true, true
+		default:
+			return p, fmt.Errorf("unknown --preserve field %q, the fields are %s or all", f, strings.Join(metadataFields, ", "))
+		}
+	}
+	return p, nil
+}
+
+// unixfsMetadata is the optional metadata of a unixfs node.
+type unixfsMetadata struct {
+	mode     uint32
+	hasMode  bool
+	mtime    time.Time
+	hasMtime bool
+}
+
+// parseUnixfsMetadata reads the mode (field 7) and mtime (field 8) of the
+// unixfs Data message data. The go-unixfs this is built with predates them,
+// so they are picked out of the wire format by hand; the other fields are
+// skipped.
+func parseUnixfsMetadata(data []byte) (unixfsMetadata, error) {
+	var md unixfsMetadata
+	err := walkProto(data, func(field int, wire int, v uint64, b []byte) error {
+		switch {
+		case field == 7 && wire == 0:
+			md.mode, md.hasMode = uint32(v), true
+		case field == 8 && wire == 2:
+			var sec int64
+			var nsec uint32
+			err := walkProto(b, func(field int, wire int, v uint64, _ []byte) error {
+				switch {
+				case field == 1 && wire == 0:
+					sec = int64(v)
+				case field == 2 && wire == 5:
+					nsec = uint32(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			md.mtime, md.hasMtime = time.Unix(sec, int64(nsec)), true
+		}
+		return nil
+	})
+	return md, err
+}
+
+// walkProto calls fn with every field of the protobuf message data: its
+// number, its wire type and its value, in v for numbers and in b for
+// length delimited fields.
+func walkProto(data []byte, fn func(field int, wire int, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("malformed unixfs data")
+		}
+		data = data[n:]
+		field, wire := int(key>>3), int(key&7)
+		var v uint64
+		var b []byte
+		switch wire {
+		case 0:
+			if v, n = binary.Uvarint(data); n <= 0 {
+				return fmt.Errorf("malformed unixfs data")
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return fmt.Errorf("malformed unixfs data")
+			}
+			v, data = binary.LittleEndian.Uint64(data), data[8:]
+		case 2:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return fmt.Errorf("malformed unixfs data")
+			}
+			b, data = data[n:n+int(l)], data[n+int(l):]
+		case 5:
+			if len(data) < 4 {
+				return fmt.Errorf("malformed unixfs data")
+			}
+			v, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		default:
+			return fmt.Errorf("malformed unixfs data")
+		}
+		if err := fn(field, wire, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// posixMode converts the POSIX permission bits of a unixfs mode.
+func posixMode(m uint32) os.FileMode {
+	mode := os.FileMode(m & 0777)
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// applyMetadata applies the fields of the unixfs metadata of the DAG at
+// root, which was written to fpath, to what was written. Symlinks only get
+// their modification time, set on the symlink itself, there being no chmod
+// that doesn't follow them. Nodes without the metadata and entries that
+// weren't written are left alone. Directories are done after their content,
+// which would change their modification time. It returns the fields that couldn't be set, some
+// filesystems refusing chmod but not the modification time.
+func applyMetadata(ctx context.Context, ipfs iface.CoreAPI, root cid.Cid, fpath string, fields preserveFields) ([]string, error) {
+	entries, err := lsDir(ctx, ipfs, root)
+	if err != nil {
+		return nil, err
+	}
+	var failed []string
+	for name, e := range entries {
+		f, err := applyMetadata(ctx, ipfs, e.Cid, filepath.Join(fpath, name), fields)
+		if err != nil {
+			return nil, err
+		}
+		failed = append(failed, f...)
+	}
+
+	fi, err := os.Lstat(fpath)
+	if os.IsNotExist(err) {
+		return failed, nil
+	}
+	if err != nil {
+		return failed, err
+	}
+	symlink := fi.Mode()&os.ModeSymlink != 0
+	nd, err := ipfs.Dag().Get(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	pn, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		// raw leaves have no metadata
+		return failed, nil
+	}
+	md, err := parseUnixfsMetadata(pn.Data())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", root, err)
+	}
+	if fields.mode && md.hasMode && !symlink {
+		if err := os.Chmod(fpath, posixMode(md.mode)); err != nil {
+			failed = append(failed, fmt.Sprintf("mode of %s: %s", fpath, err))
+		}
+	}
+	if fields.mtime && md.hasMtime {
+		chtimes := os.Chtimes
+		if symlink {
+			chtimes = lchtimes
+		}
+		if err := chtimes(fpath, md.mtime, md.mtime); err != nil {
+			failed = append(failed, fmt.Sprintf("mtime of %s: %s", fpath, err))
+		}
+	}
+	return failed, nil
+}
+
+// The modes --normalize-permissions gives everything it writes.
+const (
+	normalFileMode os.FileMode = 0644
+	normalDirMode  os.FileMode = 0755
+)
+
+// normalizePermissions sets the mode of fpath, and of everything under it
+// if it is a directory, to normalFileMode or normalDirMode, whatever the
+// umask made of them. Setuid, setgid and sticky bits are cleared along the
+// way. Symlinks are left alone, chmod would follow them.
+func normalizePermissions(fpath string) error {
+	return filepath.Walk(fpath, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case fi.IsDir():
+			return os.Chmod(p, normalDirMode)
+		case fi.Mode().IsRegular():
+			return os.Chmod(p, normalFileMode)
+		}
+		return nil
+	})
+}
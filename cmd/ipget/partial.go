@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+)
+
+// partialCheckTimeout bounds listing the directories of a fetch for
+// --fail-on-partial. They were fetched for the extraction already, save
+// those that failed.
+const partialCheckTimeout = time.Minute
+
+// missing lists what the directory c should have left at dir but didn't:
+// the files and symlinks the filters select that aren't there, or not in
+// full, and the directories that couldn't be listed, with a trailing
+// slash. Paths are relative to the root of the extraction, sorted. Only
+// the directory listings are fetched, as for countSelected.
+func (e *extractor) missing(ctx context.Context, ipfs iface.CoreAPI, c cid.Cid, dir string) []string {
+	// the extraction is over, whatever it wrote is at dir now
+	e.root, e.target = dir, dir
+	ctx, cancel := context.WithTimeout(ctx, partialCheckTimeout)
+	defer cancel()
+	var out []string
+	e.findMissing(ctx, ipfs, c, dir, &out)
+	sort.Strings(out)
+	return out
+}
+
+func (e *extractor) findMissing(ctx context.Context, ipfs iface.CoreAPI, c cid.Cid, dir string, out *[]string) {
+	entries, err := lsDir(ctx, ipfs, c)
+	if err != nil {
+		*out = append(*out, filepath.ToSlash(e.rel(dir))+"/")
+		return
+	}
+	for name, ent := range entries {
+		child := filepath.Join(dir, name)
+		if e.excluded(child) {
+			continue
+		}
+		if ent.Type == iface.TDirectory {
+			e.findMissing(ctx, ipfs, ent.Cid, child, out)
+			continue
+		}
+		size := int64(-1)
+		if ent.Type == iface.TFile {
+			size = int64(ent.Size)
+		}
+		if !e.selected(child, size) {
+			continue
+		}
+		fi, err := e.files().Lstat(child)
+		if err != nil || (size >= 0 && fi.Mode().IsRegular() && fi.Size() != size) {
+			*out = append(*out, filepath.ToSlash(e.rel(child)))
+		}
+	}
+}
+
+// partialError reports the paths an extraction left missing.
+func partialError(missing []string) error {
+	return fmt.Errorf("%d paths missing from the output (--fail-on-partial):\n  %s",
+		len(missing), strings.Join(missing, "\n  "))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ipfs/ipget"
+)
+
+const (
+	// peerListInterval is how often --verbose-peers lists the peers
+	// without --stats-interval, and peerListMinInterval the most often it
+	// does with it.
+	peerListInterval    = 10 * time.Second
+	peerListMinInterval = 5 * time.Second
+	// peerListMax is how many peers a listing shows at most, those sending
+	// blocks first.
+	peerListMax = 20
+)
+
+// stderrIsTerminal reports whether someone is watching the log as it goes.
+func stderrIsTerminal() bool {
+	fi, err := os.Stderr.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// logPeersEvery lists the peers the session is connected to every interval
+// until ctx is done, with one address each, marking with a * those that sent
+// blocks for the fetch.
+func logPeersEvery(ctx context.Context, s *ipget.Session, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		conns, err := s.API().Swarm().Peers(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("listing peers: %s", err)
+			}
+			continue
+		}
+		active := make(map[string]bool)
+		for _, t := range s.PeerTraffic() {
+			active[t.Peer.String()] = true
+		}
+
+		var used, others []string
+		for _, conn := range conns {
+			id := conn.ID().String()
+			if active[id] {
+				used = append(used, fmt.Sprintf("* %s %s", id, conn.Address()))
+			} else {
+				others = append(others, fmt.Sprintf("  %s %s", id, conn.Address()))
+			}
+		}
+		lines := append(used, others...)
+		more := ""
+		if len(lines) > peerListMax {
+			more = fmt.Sprintf("\n  ... and %d more", len(lines)-peerListMax)
+			lines = lines[:peerListMax]
+		}
+		log.Print(fitWidth(fmt.Sprintf("%d peers connected, %d sending blocks:\n%s%s", len(conns), len(used), strings.Join(lines, "\n"), more)))
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// readPeerIDs reads a file of peer IDs, one per line. Blank lines and
+// those starting with # are skipped, and so is anything after the ID on a
+// line, so a note can follow it.
+func readPeerIDs(fpath string) (map[peer.ID]bool, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ids := map[peer.ID]bool{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		id, err := peer.Decode(strings.TrimPrefix(fields[0], "/p2p/"))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %q is not a peer ID", fpath, n, fields[0])
+		}
+		ids[id] = true
+	}
+	return ids, sc.Err()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// readPinList reads the pins listed in the --from-pinlist file at fpath, as
+// `ipfs pin ls` prints them: a CID and its pin type on each line, or the
+// bare CIDs of --quiet, or the JSON of --enc=json. The recursive and direct
+// pins become entries fetched into --output-dir, named after their CID. A
+// direct pin is fetched whole like the others, what a directly pinned root
+// links to is only missing from a node that doesn't have it. indirect is
+// how many indirect pins were skipped, they are fetched with the recursive
+// pin they come from.
+func readPinList(fpath string) (entries []batchEntry, indirect int, err error) {
+	data, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, 0, err
+	}
+	var pins []pin
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		pins, err = parsePinListJSON(trimmed)
+	} else {
+		pins, err = parsePinList(data)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %s", fpath, err)
+	}
+
+	seen := map[string]bool{}
+	for _, p := range pins {
+		switch p.typ {
+		case "indirect":
+			indirect++
+			continue
+		case "", "recursive", "direct":
+		default:
+			return nil, 0, fmt.Errorf("%s: %s: unknown pin type %q", fpath, p.cid, p.typ)
+		}
+		c, err := parseCID(p.cid)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %s", fpath, err)
+		}
+		if seen[c.String()] {
+			continue
+		}
+		seen[c.String()] = true
+		entries = append(entries, batchEntry{Path: "/ipfs/" + c.String(), Output: c.String()})
+	}
+	if len(entries) == 0 {
+		return nil, indirect, fmt.Errorf("%s lists no recursive or direct pins to fetch", fpath)
+	}
+	return entries, indirect, nil
+}
+
+// pin is a CID listed by `ipfs pin ls`, with its pin type if given.
+type pin struct {
+	cid, typ string
+}
+
+// parsePinList parses the text `ipfs pin ls` prints. An indirect pin may be
+// followed by the pin it comes from, "indirect through <cid>".
+func parsePinList(data []byte) ([]pin, error) {
+	var pins []pin
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		switch {
+		case len(fields) == 0 || strings.HasPrefix(fields[0], "#"):
+			continue
+		case len(fields) == 1:
+			pins = append(pins, pin{cid: fields[0]})
+		case len(fields) == 2, fields[1] == "indirect":
+			pins = append(pins, pin{cid: fields[0], typ: fields[1]})
+		default:
+			return nil, fmt.Errorf("line %d: %q isn't a CID and a pin type", line, sc.Text())
+		}
+	}
+	return pins, sc.Err()
+}
+
+// parsePinListJSON parses what `ipfs pin ls --enc=json` prints,
+// {"Keys": {"<cid>": {"Type": "recursive"}, ...}}.
+func parsePinListJSON(data []byte) ([]pin, error) {
+	var out struct {
+		Keys map[string]struct {
+			Type string
+		}
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	pins := make([]pin, 0, len(out.Keys))
+	for c, info := range out.Keys {
+		pins = append(pins, pin{cid: c, typ: info.Type})
+	}
+	sort.Slice(pins, func(i, j int) bool { return pins[i].cid < pins[j].cid })
+	return pins, nil
+}
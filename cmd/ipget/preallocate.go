@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	files "github.com/ipfs/go-ipfs-files"
+)
+
+// preallocate reserves on disk the size of nd for f, which it is about to
+// be written to, so the filesystem can lay it out in one piece rather than
+// growing it block by block. The size comes from the root of the file, no
+// other block is fetched for it. It reports whether anything was reserved:
+// nothing is for files of unknown size or not on the local disk.
+func preallocate(w io.Writer, nd files.File) (bool, error) {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false, nil
+	}
+	size, err := nd.Size()
+	if err != nil || size <= 0 {
+		return false, nil
+	}
+	if err := allocate(f, size); err != nil {
+		return false, fmt.Errorf("preallocating %d bytes for %s: %s", size, f.Name(), err)
+	}
+	return true, nil
+}
+
+// trimAllocated cuts the preallocated file w back to the n bytes written
+// to it, so a file left incomplete doesn't end in zeros passing for data.
+func trimAllocated(w io.Writer, n int64) {
+	if f, ok := w.(*os.File); ok {
+		f.Truncate(n)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "os"
+
+// allocate sets the size of f to size. Only Linux has its blocks allocated
+// as well, elsewhere the file is sparse until written.
+func allocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
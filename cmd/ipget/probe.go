@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget"
+	cli "github.com/urfave/cli"
+)
+
+// exitUnavailable is the exit code of probe when the root block couldn't be
+// fetched in time.
+const exitUnavailable = 4
+
+func probeCommand(ctx context.Context) cli.Command {
+	return cli.Command{
+		Name:      "probe",
+		Usage:     "check that the root block of an IPFS object can be fetched, without fetching the rest",
+		ArgsUsage: "<ipfs ref>",
+		Flags: []cli.Flag{
+			cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "report the object unavailable after this long",
+				Value: time.Minute,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if !c.Args().Present() {
+				return fmt.Errorf("usage: ipget probe <ipfs ref>\n")
+			}
+			iPath, err := parsePath(c.Args().First(), c.GlobalBool("use-url-host"))
+			if err != nil {
+				return err
+			}
+			dnslink := newDNSLinkResolver(c.GlobalString("dns-server"), c.GlobalBoolT("dnslink"))
+			iPath, err = dnslink.resolve(ctx, iPath)
+			if err != nil {
+				return err
+			}
+
+			opts, err := sessionOptions(c, c.GlobalInt("max-block-size"))
+			if err != nil {
+				return err
+			}
+			// to tell who served the block
+			opts.PeerStats = true
+			s, err := ipget.New(ctx, opts)
+			if err != nil {
+				return err
+			}
+			defer s.Close()
+
+			pctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			if timeout := c.Duration("timeout"); timeout > 0 {
+				pctx, cancel = context.WithTimeout(pctx, timeout)
+				defer cancel()
+			}
+			// only time the fetch, not the bootstrap
+			err = s.WaitBootstrap(pctx)
+			start := time.Now()
+			var rp ipath.Resolved
+			if err == nil {
+				rp, err = s.Resolve(pctx, iPath)
+			}
+			if err == nil {
+				_, err = s.API().Block().Stat(pctx, ipath.IpfsPath(rp.Cid()))
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return cli.NewExitError("interrupted", exitInterrupted)
+				}
+				if pctx.Err() != nil {
+					return cli.NewExitError(fmt.Sprintf("%s unavailable: no root block after %s", iPath, c.Duration("timeout")), exitUnavailable)
+				}
+				return cli.NewExitError(fmt.Sprintf("%s unavailable: %s", iPath, err), exitUnavailable)
+			}
+			latency := time.Since(start).Round(time.Millisecond)
+
+			from := "the local blockstore"
+			if traffic := s.PeerTraffic(); len(traffic) > 0 {
+				from = traffic[0].Peer.String()
+			} else if !s.Embedded() {
+				from = "the local daemon"
+			}
+			fmt.Printf("%s available: root block %s in %s from %s\n", iPath, rp.Cid(), latency, from)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	cli "github.com/urfave/cli"
+)
+
+func providersCommand(ctx context.Context) cli.Command {
+	return cli.Command{
+		Name:      "providers",
+		Usage:     "list the providers of an IPFS object without downloading it",
+		ArgsUsage: "<ipfs ref>",
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "count,c",
+				Usage: "stop after finding this many providers",
+				Value: 20,
+			},
+			cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "give up looking for providers after this long, 0 waits for the query to finish",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if !c.Args().Present() {
+				return fmt.Errorf("usage: ipget providers <ipfs ref>\n")
+			}
+
+			iPath, err := parsePath(c.Args().First(), c.GlobalBool("use-url-host"))
+			if err != nil {
+				return err
+			}
+			dnslink := newDNSLinkResolver(c.GlobalString("dns-server"), c.GlobalBoolT("dnslink"))
+			iPath, err = dnslink.resolve(ctx, iPath)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			if timeout := c.Duration("timeout"); timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+
+			s, err := setupNode(ctx, c, 0)
+			if err != nil {
+				return err
+			}
+			defer s.Close()
+			ipfs := s.API()
+			if s.Embedded() {
+				if err := s.WaitBootstrap(ctx); err != nil {
+					return err
+				}
+			}
+
+			provs, err := ipfs.Dht().FindProviders(ctx, iPath, options.Dht.NumProviders(c.Int("count")))
+			if err != nil {
+				return cli.NewExitError(err, 2)
+			}
+
+			found := 0
+			for prov := range provs {
+				found++
+				addrs := make([]string, len(prov.Addrs))
+				for i, addr := range prov.Addrs {
+					addrs[i] = addr.String()
+				}
+				fmt.Println(strings.TrimSpace(prov.ID.Pretty() + " " + strings.Join(addrs, " ")))
+			}
+			if found == 0 {
+				return cli.NewExitError("no providers found", 2)
+			}
+			return nil
+		},
+	}
+}
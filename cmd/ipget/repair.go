@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/ipget"
+	cli "github.com/urfave/cli"
+)
+
+func repairCommand(ctx context.Context) cli.Command {
+	return cli.Command{
+		Name:      "repair",
+		Usage:     "check the blocks of an IPFS object in the local repo and re-fetch the corrupted or missing ones",
+		ArgsUsage: "<ipfs ref>",
+		Flags: []cli.Flag{
+			cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "give up re-fetching a block after this long, 0 waits indefinitely",
+				Value: time.Minute,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if !c.Args().Present() {
+				return fmt.Errorf("usage: ipget repair <ipfs ref>\n")
+			}
+
+			iPath, err := parsePath(c.Args().First(), c.GlobalBool("use-url-host"))
+			if err != nil {
+				return err
+			}
+			dnslink := newDNSLinkResolver(c.GlobalString("dns-server"), c.GlobalBoolT("dnslink"))
+			iPath, err = dnslink.resolve(ctx, iPath)
+			if err != nil {
+				return err
+			}
+
+			opts, err := sessionOptions(c, 0)
+			if err != nil {
+				return err
+			}
+			// Repairing only makes sense on the repo that is kept around.
+			opts.Node = "repo"
+			s, err := ipget.New(ctx, opts)
+			if err != nil {
+				return fmt.Errorf("failed to open the local repo: %s", err)
+			}
+			defer s.Close()
+			node := s.Node()
+
+			rp, err := s.API().ResolvePath(ctx, iPath)
+			if err != nil {
+				return cli.NewExitError(err, 2)
+			}
+
+			bootstrapped := false
+			timeout := c.Duration("timeout")
+			fetch := func(c cid.Cid) (blocks.Block, error) {
+				if !bootstrapped {
+					if err := s.WaitBootstrap(ctx); err != nil {
+						return nil, err
+					}
+					bootstrapped = true
+				}
+				fctx := ctx
+				if timeout > 0 {
+					var cancel context.CancelFunc
+					fctx, cancel = context.WithTimeout(ctx, timeout)
+					defer cancel()
+				}
+				return node.Blocks.GetBlock(fctx, c)
+			}
+
+			st, err := repairDAG(ctx, node.Blockstore, fetch, rp.Cid())
+			fmt.Printf("checked %d blocks, %d bad, %d repaired\n", st.checked, st.bad, st.repaired)
+			if err != nil {
+				if ctx.Err() != nil {
+					return cli.NewExitError("interrupted", exitInterrupted)
+				}
+				return cli.NewExitError(err, 2)
+			}
+			if st.repaired < st.bad {
+				return cli.NewExitError(fmt.Sprintf("%d blocks could not be repaired", st.bad-st.repaired), 2)
+			}
+			return nil
+		},
+	}
+}
+
+type repairStats struct {
+	checked, bad, repaired int
+}
+
+// repairDAG walks the DAG under root in bs, checking every block against its
+// CID. Blocks that are corrupted are removed, and those along with the
+// missing ones are fetched again. The children of a block that can't be
+// fetched are not visited.
+func repairDAG(ctx context.Context, bs blockstore.Blockstore, fetch func(cid.Cid) (blocks.Block, error), root cid.Cid) (repairStats, error) {
+	var st repairStats
+	seen := cid.NewSet()
+	stack := []cid.Cid{root}
+	for len(stack) > 0 {
+		if ctx.Err() != nil {
+			return st, ctx.Err()
+		}
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !seen.Visit(c) {
+			continue
+		}
+		st.checked++
+
+		blk, err := bs.Get(c)
+		switch {
+		case err == blockstore.ErrNotFound:
+			log.Printf("block %s is missing", c)
+			blk = nil
+		case err == blockstore.ErrHashMismatch || err == nil && !intact(blk):
+			log.Printf("block %s is corrupted", c)
+			if err := bs.DeleteBlock(c); err != nil {
+				return st, err
+			}
+			blk = nil
+		case err != nil:
+			return st, err
+		}
+		if blk == nil {
+			st.bad++
+			if blk, err = fetch(c); err != nil {
+				log.Printf("failed to re-fetch %s: %s", c, err)
+				continue
+			}
+			st.repaired++
+		}
+
+		nd, err := ipld.Decode(blk)
+		if err != nil {
+			return st, err
+		}
+		for _, l := range nd.Links() {
+			stack = append(stack, l.Cid)
+		}
+	}
+	return st, nil
+}
+
+// intact reports whether the data of blk hashes to its CID.
+func intact(blk blocks.Block) bool {
+	c, err := blk.Cid().Prefix().Sum(blk.RawData())
+	return err == nil && c.Equals(blk.Cid())
+}
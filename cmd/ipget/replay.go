@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget"
+	cli "github.com/urfave/cli"
+)
+
+// replayOp is a fetch recorded in an audit log, to be made again.
+type replayOp struct {
+	// path is the path as it was given, output where it was written.
+	path, output string
+	// cid is what path resolved to, empty if the fetch failed before it
+	// was resolved.
+	cid string
+}
+
+// readReplayLog reads the fetches of the last run recorded in the
+// --replay audit log at fpath, in the order they were made. Runs sharing
+// the log are told apart by their pid, so only the lines of the last one
+// are taken, other than this run's own. Relative outputs are made
+// absolute from the directory the run was in, when it was logged.
+func readReplayLog(fpath string) ([]replayOp, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		ops   []replayOp
+		start *auditEvent
+	)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var ev auditEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil || ev.Event == "" {
+			return nil, fmt.Errorf("%s:%d: not an audit log line", fpath, n)
+		}
+		if ev.PID == os.Getpid() {
+			// this run, when --audit-log is the log replayed
+			continue
+		}
+		if ev.Event == "run-start" {
+			if ev.Format > auditFormat {
+				return nil, fmt.Errorf("%s:%d: the run was logged by ipget %s in audit log format %d, this ipget reads up to format %d", fpath, n, ev.Version, ev.Format, auditFormat)
+			}
+			start, ops = &ev, nil
+			continue
+		}
+		if start == nil || ev.PID != start.PID {
+			continue
+		}
+		switch ev.Event {
+		case "fetch-start":
+			if ev.Output == "-" {
+				return nil, fmt.Errorf("%s:%d: %s was written to stdout, a replay prints its results there", fpath, n, ev.Path)
+			}
+			op := replayOp{path: ev.Path, output: ev.Output}
+			if start.Dir != "" && !filepath.IsAbs(op.output) {
+				op.output = filepath.Join(start.Dir, op.output)
+			}
+			// fetched again, as --watch does: the latest fetch is the one
+			// replayed
+			replaced := false
+			for i := range ops {
+				if ops[i].output == op.output {
+					ops[i], replaced = op, true
+				}
+			}
+			if !replaced {
+				ops = append(ops, op)
+			}
+		case "resolve", "fetch-complete", "fetch-skip", "fetch-fail":
+			if ev.CID == "" {
+				continue
+			}
+			// the latest fetch of the path is the one that resolved
+			for i := len(ops) - 1; i >= 0; i-- {
+				if ops[i].path == ev.Path {
+					ops[i].cid = ev.CID
+					break
+				}
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	switch {
+	case start == nil:
+		return nil, fmt.Errorf("%s records no run", fpath)
+	case len(ops) == 0:
+		return nil, fmt.Errorf("the last run in %s, started %s, fetched nothing", fpath, start.Time.Local().Format("2006-01-02 15:04:05"))
+	}
+	return ops, nil
+}
+
+// replayEntries turns ops into batch entries fetching what they resolved
+// to then, so that names that changed since still get the same content.
+// Those that never resolved are fetched by their path again. The entries
+// are written where the run wrote them, wherever that is.
+func replayEntries(ops []replayOp) []batchEntry {
+	entries := make([]batchEntry, len(ops))
+	for i, op := range ops {
+		entries[i] = batchEntry{Path: op.path, Output: op.output, exactOutput: true}
+		if op.cid != "" {
+			entries[i].Path = "/ipfs/" + op.cid
+		}
+	}
+	return entries
+}
+
+// checkReplayedNames resolves again the IPNS names of ops, and warns about
+// those that now resolve to something else than was logged.
+func checkReplayedNames(ctx context.Context, c *cli.Context, s *ipget.Session, dnslink *dnslinkResolver, ops []replayOp) {
+	for _, op := range ops {
+		if op.cid == "" {
+			continue
+		}
+		if p, err := parsePath(op.path, c.Bool("use-url-host")); err != nil || p.Namespace() == "ipfs" {
+			// immutable, or a path given as this ipget doesn't take it
+			continue
+		}
+		t, err := newTarget(ctx, c, dnslink, op.path)
+		var rp ipath.Resolved
+		if err == nil {
+			rp, err = s.Resolve(ctx, t.path)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("WARNING: %s no longer resolves: %s; fetching %s as logged", op.path, err, op.cid)
+			continue
+		}
+		if now := rp.Cid().String(); now != op.cid {
+			log.Printf("WARNING: %s now resolves to %s; fetching %s as logged", op.path, now, op.cid)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+package main
+
+// watchResize does nothing, there is no resize signal on Windows. The width
+// read at startup is kept.
+func watchResize() {}
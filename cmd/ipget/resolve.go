@@ -0,0 +1,20 @@
+package main
+
+import (
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	unixfs "github.com/ipfs/go-unixfs"
+)
+
+// isShardedDir reports whether nd is the root of a HAMT-sharded unixfs
+// directory, whose entries are spread over a tree of shards rather than
+// linked from the node itself. go-unixfs walks the shards to list them, in
+// the order of the hashes of their names.
+func isShardedDir(nd ipld.Node) bool {
+	pn, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		return false
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	return err == nil && fsn.Type() == unixfs.THAMTShard
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// resumeStateName is the file --continue keeps its progress in, at the top
+// of the output directory.
+const resumeStateName = ".ipget-continue"
+
+// resumeState records which files of a directory fetch were written in
+// full, so that a fetch with --continue can skip them. The state file holds
+// the CID of the directory, then the paths of the completed files relative
+// to it, quoted, one per line.
+type resumeState struct {
+	fpath string
+	f     *os.File
+	done  map[string]bool
+}
+
+// openResume picks up the state of an earlier fetch of root into dir, which
+// must exist. A state left by the fetch of another CID, such as an older
+// version of an IPNS name, is discarded and everything is written again.
+func openResume(dir string, root cid.Cid) (*resumeState, error) {
+	rs := &resumeState{fpath: filepath.Join(dir, resumeStateName), done: make(map[string]bool)}
+	f, err := os.Open(rs.fpath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		same, err := rs.read(f, root)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %s", rs.fpath, err)
+		}
+		if same {
+			rs.f, err = os.OpenFile(rs.fpath, os.O_WRONLY|os.O_APPEND, 0666)
+			if err != nil {
+				return nil, err
+			}
+			if len(rs.done) > 0 {
+				log.Printf("continuing %s, %d files already written", dir, len(rs.done))
+			}
+			return rs, nil
+		}
+		log.Printf("%s was left by the fetch of another CID, fetching everything again", rs.fpath)
+	}
+
+	if rs.f, err = os.Create(rs.fpath); err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintln(rs.f, root); err != nil {
+		rs.f.Close()
+		return nil, err
+	}
+	return rs, nil
+}
+
+// continueDir sets up the fetch of the directory at p into dir to carry on
+// from where an earlier one stopped. The output of a run that was cut short
+// and set aside as dir.partial is moved back into place first.
+func continueDir(ctx context.Context, ipfs iface.CoreAPI, p ipath.Path, dir string) (*resumeState, error) {
+	rp, err := ipfs.ResolvePath(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Lstat(dir); os.IsNotExist(err) {
+		partial := dir + ".partial"
+		if _, err := os.Lstat(partial); err == nil {
+			if err := os.Rename(partial, dir); err != nil {
+				return nil, err
+			}
+			log.Printf("continuing from %s", partial)
+		}
+	}
+	if err := os.Mkdir(dir, 0777); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	return openResume(dir, rp.Cid())
+}
+
+// read loads the completed files from f if it was written for root, and
+// reports whether it was.
+func (rs *resumeState) read(f *os.File, root cid.Cid) (bool, error) {
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		return false, sc.Err()
+	}
+	if strings.TrimSpace(sc.Text()) != root.String() {
+		return false, nil
+	}
+	for sc.Scan() {
+		rel, err := strconv.Unquote(sc.Text())
+		if err != nil {
+			// the last line of a run that was killed mid-write
+			continue
+		}
+		rs.done[rel] = true
+	}
+	return true, sc.Err()
+}
+
+// has reports whether rel was written in full by an earlier run.
+func (rs *resumeState) has(rel string) bool {
+	return rs != nil && rs.done[filepath.ToSlash(rel)]
+}
+
+// add records that rel was written in full.
+func (rs *resumeState) add(rel string) error {
+	if rs == nil {
+		return nil
+	}
+	rel = filepath.ToSlash(rel)
+	rs.done[rel] = true
+	_, err := fmt.Fprintln(rs.f, strconv.Quote(rel))
+	return err
+}
+
+// close closes the state file, keeping it for the next --continue.
+func (rs *resumeState) close() error {
+	if rs == nil {
+		return nil
+	}
+	return rs.f.Close()
+}
+
+// finish removes the state file once the fetch is complete.
+func (rs *resumeState) finish() error {
+	if rs == nil {
+		return nil
+	}
+	rs.f.Close()
+	return os.Remove(rs.fpath)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/ipget"
+	"github.com/ipfs/ipget/internal/dagutil"
+	cli "github.com/urfave/cli"
+)
+
+// scanConcurrency is how many blocks a scan fetches at once.
+const scanConcurrency = 32
+
+// scanResult is what ipget scan found, printed as JSON with --json.
+type scanResult struct {
+	Path string `json:"path"`
+	CID  string `json:"cid,omitempty"`
+	// Blocks and Bytes count the blocks fetched and verified.
+	Blocks int   `json:"blocks"`
+	Bytes  int64 `json:"bytes"`
+	// Failures are the blocks that couldn't be fetched, or didn't hash to
+	// their CID or decode. What they link to wasn't scanned.
+	Failures []scanFailure `json:"failures"`
+	// Complete is false if the scan was cut short by --timeout.
+	Complete bool `json:"complete"`
+	// Duration is in seconds.
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+}
+
+type scanFailure struct {
+	CID   string `json:"cid"`
+	Error string `json:"error"`
+}
+
+func scanCommand(ctx context.Context) cli.Command {
+	return cli.Command{
+		Name:      "scan",
+		Usage:     "fetch every block of an IPFS object and check it against its CID, without writing anything, to confirm it is all retrievable and intact",
+		ArgsUsage: "<ipfs ref>",
+		Flags: []cli.Flag{
+			cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "stop scanning after this long and report the object incomplete, 0 waits for every block",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "print the result as JSON",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if !c.Args().Present() {
+				return fmt.Errorf("usage: ipget scan <ipfs ref>\n")
+			}
+			iPath, err := parsePath(c.Args().First(), c.GlobalBool("use-url-host"))
+			if err != nil {
+				return err
+			}
+			dnslink := newDNSLinkResolver(c.GlobalString("dns-server"), c.GlobalBoolT("dnslink"))
+			iPath, err = dnslink.resolve(ctx, iPath)
+			if err != nil {
+				return err
+			}
+
+			s, err := setupNode(ctx, c, c.GlobalInt("max-block-size"))
+			if err != nil {
+				return err
+			}
+			defer s.Close()
+
+			sctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			if timeout := c.Duration("timeout"); timeout > 0 {
+				sctx, cancel = context.WithTimeout(sctx, timeout)
+				defer cancel()
+			}
+			res := scanResult{Path: iPath.String(), Failures: []scanFailure{}}
+			start := time.Now()
+			rp, err := s.Resolve(sctx, iPath)
+			if err == nil {
+				res.CID = rp.Cid().String()
+				err = scanDAG(sctx, s, rp.Cid(), &res)
+			}
+			res.Duration = time.Since(start).Seconds()
+			res.Complete = err == nil
+			if ctx.Err() != nil {
+				return cli.NewExitError("interrupted", exitInterrupted)
+			}
+			if err != nil {
+				if sctx.Err() != nil {
+					err = fmt.Errorf("scan incomplete after %s", c.Duration("timeout"))
+				}
+				res.Error = err.Error()
+			}
+
+			if c.Bool("json") {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(&res); err != nil {
+					return err
+				}
+			} else {
+				for _, f := range res.Failures {
+					log.Printf("block %s: %s", f.CID, f.Error)
+				}
+				fmt.Printf("%s: %d blocks, %s, %d failed, in %s\n", iPath, res.Blocks, humanize.Bytes(uint64(res.Bytes)),
+					len(res.Failures), time.Duration(res.Duration*float64(time.Second)).Round(time.Millisecond))
+			}
+			switch {
+			case res.Error != "":
+				return cli.NewExitError(fmt.Sprintf("%s unavailable: %s", iPath, res.Error), exitUnavailable)
+			case len(res.Failures) > 0:
+				return cli.NewExitError(fmt.Sprintf("%s unavailable: %d blocks failed", iPath, len(res.Failures)), exitUnavailable)
+			}
+			return nil
+		},
+	}
+}
+
+// scanDAG fetches every block of the DAG at root through s, checks it
+// against its CID and counts it in res. A block that fails is recorded in
+// res and the walk carries on without what it links to.
+func scanDAG(ctx context.Context, s *ipget.Session, root cid.Cid, res *scanResult) error {
+	if s.Embedded() {
+		if err := s.WaitBootstrap(ctx); err != nil {
+			return err
+		}
+	}
+	var mu sync.Mutex
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		if !dagutil.SupportedHash(c.Prefix().MhType) {
+			return nil, fmt.Errorf("hash function %s is not supported by this build", dagutil.HashName(c.Prefix().MhType))
+		}
+		b, err := s.NodeBlock(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if !intact(b) {
+			return nil, fmt.Errorf("data doesn't hash to the CID")
+		}
+		nd, err := ipld.Decode(b)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		res.Blocks++
+		res.Bytes += int64(len(b.RawData()))
+		mu.Unlock()
+		return nd.Links(), nil
+	}
+	onError := func(c cid.Cid, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		mu.Lock()
+		res.Failures = append(res.Failures, scanFailure{CID: c.String(), Error: err.Error()})
+		mu.Unlock()
+		return nil
+	}
+	seen := cid.NewSet()
+	err := dag.Walk(ctx, getLinks, root, seen.Visit, dag.Concurrency(scanConcurrency), dag.OnError(onError))
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget/internal/dagutil"
+	ipldprime "github.com/ipld/go-ipld-prime"
+	dagpb "github.com/ipld/go-ipld-prime-proto"
+	_ "github.com/ipld/go-ipld-prime/encoding/dagcbor"
+	"github.com/ipld/go-ipld-prime/encoding/dagjson"
+	ipldfree "github.com/ipld/go-ipld-prime/impl/free"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+)
+
+// parseSelector parses the dag-json encoding of an IPLD selector, such as
+// {"a":{">":{".":{}}}} for a node and its direct children.
+func parseSelector(expr string) (selector.Selector, error) {
+	nd, err := dagjson.Decoder(ipldfree.NodeBuilder(), strings.NewReader(expr))
+	if err == nil && nd == nil {
+		err = fmt.Errorf("no JSON value in %q", expr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid --select, it must be a dag-json selector: %s", err)
+	}
+	sel, err := selector.ParseSelector(nd)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --select: %s", err)
+	}
+	return sel, nil
+}
+
+// selectBlocks walks the DAG under root along sel and returns the CIDs of
+// the blocks it had to load to do so, root included. Those are the blocks a
+// CAR needs for a reader to walk the same selection.
+func selectBlocks(ctx context.Context, ipfs iface.CoreAPI, root cid.Cid, sel selector.Selector) (map[cid.Cid]bool, error) {
+	var mu sync.Mutex
+	loaded := make(map[cid.Cid]bool)
+	loader := func(lnk ipldprime.Link, _ ipldprime.LinkContext) (io.Reader, error) {
+		c := lnk.(cidlink.Link).Cid
+		if err := dagutil.CheckSupported(c); err != nil {
+			return nil, err
+		}
+		blk, err := ipfs.Block().Get(ctx, ipath.IpfsPath(c))
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(blk); err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		loaded[c] = true
+		mu.Unlock()
+		return &buf, nil
+	}
+	chooser := dagpb.AddDagPBSupportToChooser(func(ipldprime.Link, ipldprime.LinkContext) ipldprime.NodeBuilder {
+		return ipldfree.NodeBuilder()
+	})
+
+	lnk := cidlink.Link{Cid: root}
+	nd, err := lnk.Load(ctx, ipldprime.LinkContext{}, chooser(lnk, ipldprime.LinkContext{}), loader)
+	if err != nil {
+		return nil, err
+	}
+	prog := traversal.Progress{Cfg: &traversal.Config{
+		Ctx:                    ctx,
+		LinkLoader:             loader,
+		LinkNodeBuilderChooser: chooser,
+	}}
+	err = prog.WalkAdv(nd, sel, func(traversal.Progress, ipldprime.Node, traversal.VisitReason) error {
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+// selectedDAG serves the nodes of a DAG with their links cut down to the
+// blocks in keep, so that writeCAR only writes the selected subgraph.
+type selectedDAG struct {
+	ipld.NodeGetter
+	keep map[cid.Cid]bool
+}
+
+func (d selectedDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	nd, err := d.NodeGetter.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	var links []*ipld.Link
+	for _, l := range nd.Links() {
+		if d.keep[l.Cid] {
+			links = append(links, l)
+		}
+	}
+	return &prunedNode{Node: nd, links: links}, nil
+}
+
+// prunedNode is a node reporting only some of its links.
+type prunedNode struct {
+	ipld.Node
+	links []*ipld.Link
+}
+
+func (n *prunedNode) Links() []*ipld.Link {
+	return n.links
+}
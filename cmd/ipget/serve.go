@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	nethttp "net/http"
+	"net/url"
+	gopath "path"
+	"strings"
+	"time"
+
+	files "github.com/ipfs/go-ipfs-files"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget"
+	cli "github.com/urfave/cli"
+)
+
+// serveShutdownTimeout bounds how long the server waits for requests in
+// flight once interrupted.
+const serveShutdownTimeout = 5 * time.Second
+
+func serveCommand(ctx context.Context) cli.Command {
+	return cli.Command{
+		Name:      "serve",
+		Usage:     "serve an IPFS tree over HTTP, fetching it on demand",
+		ArgsUsage: "<ipfs ref>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "bind",
+				Usage: "address to listen on",
+				Value: "127.0.0.1:8080",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if !c.Args().Present() {
+				return fmt.Errorf("usage: ipget serve <ipfs ref>\n")
+			}
+
+			iPath, err := parsePath(c.Args().First(), c.GlobalBool("use-url-host"))
+			if err != nil {
+				return err
+			}
+			dnslink := newDNSLinkResolver(c.GlobalString("dns-server"), c.GlobalBoolT("dnslink"))
+			iPath, err = dnslink.resolve(ctx, iPath)
+			if err != nil {
+				return err
+			}
+
+			s, err := setupNode(ctx, c, c.GlobalInt("max-block-size"))
+			if err != nil {
+				return err
+			}
+			defer s.Close()
+
+			// Serve a single tree, even if the name it came from moves on.
+			root, err := s.Resolve(ctx, iPath)
+			if err != nil {
+				if ctx.Err() != nil {
+					return cli.NewExitError("interrupted", exitInterrupted)
+				}
+				return cli.NewExitError(err, 2)
+			}
+
+			l, err := net.Listen("tcp", c.String("bind"))
+			if err != nil {
+				return err
+			}
+			srv := &nethttp.Server{Handler: &treeServer{s: s, root: root}}
+			go func() {
+				<-ctx.Done()
+				sctx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+				defer cancel()
+				srv.Shutdown(sctx)
+			}()
+
+			log.Printf("serving %s at http://%s/", root.Cid(), l.Addr())
+			if err := srv.Serve(l); err != nil && err != nethttp.ErrServerClosed {
+				return err
+			}
+			return nil
+		},
+	}
+}
+
+// treeServer serves the unixfs tree under root, resolving each request
+// through the DAG as it comes. Blocks stay in the session's blockstore, so
+// nothing is fetched twice.
+type treeServer struct {
+	s    *ipget.Session
+	root ipath.Resolved
+}
+
+func (t *treeServer) ServeHTTP(w nethttp.ResponseWriter, r *nethttp.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		nethttp.Error(w, "read only", nethttp.StatusMethodNotAllowed)
+		return
+	}
+
+	urlPath := gopath.Clean("/" + r.URL.Path)
+	var segments []string
+	if urlPath != "/" {
+		segments = strings.Split(strings.TrimPrefix(urlPath, "/"), "/")
+	}
+	p := ipath.Join(t.root, segments...)
+
+	nd, err := t.s.Get(r.Context(), p)
+	if err != nil {
+		if errors.Is(err, ipget.ErrNotFound) {
+			nethttp.Error(w, err.Error(), nethttp.StatusNotFound)
+			return
+		}
+		if r.Context().Err() == nil {
+			log.Printf("serving %s: %s", p, err)
+		}
+		nethttp.Error(w, err.Error(), nethttp.StatusBadGateway)
+		return
+	}
+	defer nd.Close()
+
+	switch nd := nd.(type) {
+	case files.File:
+		// the type is guessed from the name, then from the content
+		nethttp.ServeContent(w, r, gopath.Base(urlPath), time.Time{}, nd)
+	case files.Directory:
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			nethttp.Redirect(w, r, r.URL.Path+"/", nethttp.StatusMovedPermanently)
+			return
+		}
+		t.serveDir(w, r, urlPath, nd)
+	default:
+		nethttp.Error(w, "can't serve this kind of node", nethttp.StatusNotImplemented)
+	}
+}
+
+// dirListing is the page listing a directory.
+var dirListing = template.Must(template.New("dir").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Path}}</title></head>
+<body>
+<h1>{{.Path}}</h1>
+<ul>
+{{if ne .Path "/"}}<li><a href="../">../</a></li>
+{{end}}{{range .Entries}}<li><a href="{{.Href}}">{{.Name}}</a> {{.Size}}</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+type dirEntry struct {
+	Name, Href, Size string
+}
+
+// serveDir serves the index.html of dir if it has one, and a listing of its
+// entries otherwise.
+func (t *treeServer) serveDir(w nethttp.ResponseWriter, r *nethttp.Request, urlPath string, dir files.Directory) {
+	var entries []dirEntry
+	it := dir.Entries()
+	for it.Next() {
+		name := it.Name()
+		if f, ok := it.Node().(files.File); ok && name == "index.html" {
+			nethttp.ServeContent(w, r, name, time.Time{}, f)
+			return
+		}
+		e := dirEntry{Name: name, Href: (&url.URL{Path: name}).String()}
+		if _, ok := it.Node().(files.Directory); ok {
+			e.Name += "/"
+			e.Href += "/"
+		} else if size, err := it.Node().Size(); err == nil {
+			e.Size = fmt.Sprintf("(%d bytes)", size)
+		}
+		entries = append(entries, e)
+	}
+	if err := it.Err(); err != nil {
+		nethttp.Error(w, err.Error(), nethttp.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == "HEAD" {
+		return
+	}
+	dirListing.Execute(w, struct {
+		Path    string
+		Entries []dirEntry
+	}{urlPath, entries})
+}
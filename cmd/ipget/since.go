@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// fetchState is what --since remembers of past fetches, keyed by the path
+// given on the command line.
+type fetchState map[string]stateEntry
+
+type stateEntry struct {
+	CID string `json:"cid"`
+	// Sequence is the sequence number of the IPNS record the name resolved
+	// through, 0 if unknown.
+	Sequence uint64 `json:"sequence,omitempty"`
+}
+
+// readState reads the state file at path. A missing file is an empty state.
+func readState(path string) (fetchState, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fetchState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	st := fetchState{}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// write replaces the state file at path, through a temporary file so an
+// interrupted write doesn't lose what was recorded before.
+func (st fetchState) write(path string) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ipnsSequence returns the sequence number of the IPNS record p starts with.
+// Only a spawned node can look records up, and only /ipns/<peer id> paths
+// have one.
+func ipnsSequence(ctx context.Context, s *ipget.Session, p ipath.Path) (uint64, bool) {
+	if !s.Embedded() {
+		return 0, false
+	}
+	parts := strings.Split(strings.Trim(p.String(), "/"), "/")
+	if len(parts) < 2 || parts[0] != "ipns" {
+		return 0, false
+	}
+	pid, err := peer.Decode(parts[1])
+	if err != nil {
+		return 0, false
+	}
+	entry, err := s.IPNSRecord(ctx, pid)
+	if err != nil {
+		return 0, false
+	}
+	return entry.GetSequence(), true
+}
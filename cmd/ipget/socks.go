@@ -0,0 +1,14 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// checkSocksAddr checks that addr is the host:port of a proxy.
+func checkSocksAddr(addr string) error {
+	if _, port, err := net.SplitHostPort(addr); err != nil || port == "" {
+		return fmt.Errorf("invalid --socks5 %q, it must be the host:port of the proxy, such as 127.0.0.1:9050 for Tor", addr)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+
+	iface "github.com/ipfs/interface-go-ipfs-core"
+)
+
+// transferStats counts the bytes received during a fetch.
+type transferStats struct {
+	bytes int64
+	start time.Time
+}
+
+func newTransferStats() *transferStats {
+	return &transferStats{start: time.Now()}
+}
+
+// Bytes returns the number of bytes received so far.
+func (s *transferStats) Bytes() int64 {
+	return atomic.LoadInt64(&s.bytes)
+}
+
+func (s *transferStats) reader(r io.Reader) io.Reader {
+	return &countingReader{r: r, n: &s.bytes}
+}
+
+// logEvery writes a single line of stats to the log every interval until the
+// context is done. It is meant for logs where a progress bar is useless.
+func (s *transferStats) logEvery(ctx context.Context, ipfs iface.CoreAPI, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n := s.Bytes()
+		peers := -1
+		if conns, err := ipfs.Swarm().Peers(ctx); err == nil {
+			peers = len(conns)
+		}
+		rate := float64(n-last) / interval.Seconds()
+		last = n
+		log.Printf("bytes=%d rate=%.0fB/s peers=%d elapsed=%s",
+			n, rate, peers, time.Since(s.start).Round(time.Second))
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	cli "github.com/urfave/cli"
+)
+
+// serveStdin keeps one session up and runs the fetch commands read from r,
+// one per line: an IPFS ref, optionally followed by the path to write it to.
+// A result line is written to w for each: "ok <ref> <path>" or
+// "error <ref> <message>". The session is closed once r ends.
+func serveStdin(ctx context.Context, c *cli.Context, r io.Reader, w io.Writer) error {
+	if c.Args().Present() {
+		return fmt.Errorf("--server-stdin reads the paths to fetch from stdin, not from arguments")
+	}
+	if c.Bool("announce") {
+		return fmt.Errorf("--server-stdin can't be combined with --announce, which never returns")
+	}
+	if c.Bool("estimate") && !c.Bool("yes") {
+		return fmt.Errorf("--server-stdin reads fetch commands from stdin, --estimate can't ask there; add --yes")
+	}
+	if c.IsSet("output") {
+		return fmt.Errorf("--server-stdin takes the output path of each fetch on its line, not from -o")
+	}
+	flags, err := checkFetchFlags(c)
+	if err != nil {
+		return err
+	}
+
+	s, fctx, stop, err := openSession(ctx, c, flags.deadline)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	defer stop()
+
+	dnslink := newDNSLinkResolver(c.String("dns-server"), c.BoolT("dnslink"))
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ref, outPath := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			ref, outPath = line[:i], strings.TrimSpace(line[i:])
+		}
+
+		t, err := newTarget(ctx, c, dnslink, ref)
+		if err == nil && outPath == "-" {
+			err = fmt.Errorf("stdout carries the results, it can't be written to")
+		}
+		if err == nil {
+			if outPath != "" {
+				t.outPath, t.namedByHash = outPath, false
+			}
+			err = fetchOne(ctx, fctx, c, s, flags, t)
+		}
+		if ctx.Err() != nil {
+			fmt.Fprintf(w, "error %s interrupted\n", ref)
+			return cli.NewExitError("interrupted", exitInterrupted)
+		}
+		if err != nil {
+			fmt.Fprintf(w, "error %s %s\n", ref, strings.TrimSpace(err.Error()))
+			continue
+		}
+		fmt.Fprintf(w, "ok %s %s\n", ref, t.outPath)
+	}
+	return scanner.Err()
+}
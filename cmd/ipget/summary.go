@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget"
+	cli "github.com/urfave/cli"
+)
+
+// startSummary returns the summary --summary-json asks for, with an entry
+// for each of targets, or nil if it isn't set.
+func startSummary(c *cli.Context, targets []*target) *ipget.Summary {
+	if c.String("summary-json") == "" {
+		return nil
+	}
+	sum := ipget.NewSummary()
+	for _, t := range targets {
+		t.summary = sum.Add(t.name)
+	}
+	return sum
+}
+
+// finishSummary writes sum, if any, to the --summary-json file, whether
+// the run succeeded or ended with err, which it returns.
+func finishSummary(c *cli.Context, sum *ipget.Summary, err error) error {
+	if sum == nil {
+		return err
+	}
+	sum.Finish(err)
+	if werr := writeSummary(sum, c.String("summary-json")); werr != nil && err == nil {
+		return cli.NewExitError("writing the summary: "+werr.Error(), 2)
+	}
+	return err
+}
+
+// writeSummary writes sum to fpath as indented JSON.
+func writeSummary(sum *ipget.Summary, fpath string) error {
+	f, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(sum); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// recordSummary fills in ps from the extraction ex of p to outPath,
+// whether it succeeded or not: what p resolved to, the files written and
+// failed, and the peers that sent them. CIDs are only looked up while ctx
+// is live.
+func recordSummary(ctx context.Context, ps *ipget.PathSummary, ipfs iface.CoreAPI, s *ipget.Session, p ipath.Path, outPath string, ex *extractor) {
+	ps.Output = outPath
+	for _, t := range s.PeerTraffic() {
+		ps.Peers = append(ps.Peers, t.Peer.String())
+	}
+	if ctx.Err() == nil {
+		if rp, err := ipfs.ResolvePath(ctx, p); err == nil {
+			ps.CID = rp.Cid().String()
+			ex.manifest.fillCIDs(ctx, ipfs, rp.Cid(), filepath.Base(outPath))
+		}
+	}
+	ps.Files = ps.Files[:0]
+	for _, e := range ex.manifest.entries {
+		ps.Files = append(ps.Files, ipget.FileSummary{Path: e.Path, CID: e.CID, Size: e.Size})
+	}
+	ps.FailedPaths = ps.FailedPaths[:0]
+	for rel, msg := range ex.manifest.failures {
+		ps.Files = append(ps.Files, ipget.FileSummary{Path: rel, Error: msg})
+		ps.FailedPaths = append(ps.FailedPaths, rel)
+	}
+	sort.Slice(ps.Files, func(i, j int) bool { return ps.Files[i].Path < ps.Files[j].Path })
+	sort.Strings(ps.FailedPaths)
+	ps.Written, ps.Skipped, ps.Failed = ex.wrote, ex.skipped, len(ps.FailedPaths)
+}
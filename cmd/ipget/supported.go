@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/ipget/internal/dagutil"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// printSupported lists the codecs and multihash functions this build can
+// fetch and verify.
+func printSupported(w io.Writer) error {
+	var codecs []string
+	for _, name := range dagutil.SupportedCodecs {
+		codecs = append(codecs, name)
+	}
+	sort.Strings(codecs)
+
+	var codes []uint64
+	for code := range mh.Codes {
+		if dagutil.SupportedHash(code) {
+			codes = append(codes, code)
+		}
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = dagutil.HashName(code)
+	}
+
+	fmt.Fprintf(w, "codecs: %s\n", strings.Join(codecs, ", "))
+	_, err := fmt.Fprintf(w, "hash functions: %s\n", strings.Join(hashes, ", "))
+	return err
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	cli "github.com/urfave/cli"
+)
+
+// outputFlag returns the first -o given, where the fetch is written, or ""
+// if there is none.
+func outputFlag(c *cli.Context) string {
+	if outs := c.StringSlice("output"); len(outs) > 0 {
+		return outs[0]
+	}
+	return ""
+}
+
+// copyOutputs returns the -o given after the first, which a file fetched is
+// written to as well.
+func copyOutputs(c *cli.Context) []string {
+	if outs := c.StringSlice("output"); len(outs) > 1 {
+		return outs[1:]
+	}
+	return nil
+}
+
+// checkCopyOutputs validates a fetch written to several outputs.
+func checkCopyOutputs(c *cli.Context) error {
+	outs := c.StringSlice("output")
+	if len(outs) < 2 {
+		if c.Bool("tee-best-effort") {
+			return fmt.Errorf("--tee-best-effort applies to several -o outputs")
+		}
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, out := range outs {
+		if out == "-" {
+			return fmt.Errorf("stdout can't be one of several -o outputs")
+		}
+		if seen[out] {
+			return fmt.Errorf("%s is given twice with -o", out)
+		}
+		seen[out] = true
+	}
+	switch {
+	case c.Bool("atomic") || c.Bool("append-output"):
+		return fmt.Errorf("several -o outputs are written in place, they can't be combined with --atomic or --append")
+	case c.Bool("delta") || c.Bool("continue") || c.Bool("watch"):
+		return fmt.Errorf("several -o outputs are for a single file, they can't be combined with --delta, --continue or --watch")
+	case c.String("checksum") != "":
+		return fmt.Errorf("--checksum sidecars can't be written for several -o outputs")
+	case c.IsSet("overwrite-policy"):
+		return fmt.Errorf("the copies of several -o outputs are always overwritten, --overwrite-policy doesn't apply to them")
+	}
+	return nil
+}
+
+// teeWriter writes what a file fetched is written with to several
+// destinations, the output and its copies. Should one fail the write fails,
+// unless bestEffort is set: the failed destination is then dropped and the
+// others carried on with, as long as there are any.
+type teeWriter struct {
+	dests      []*teeDest
+	bestEffort bool
+}
+
+type teeDest struct {
+	path string
+	w    io.Writer
+	err  error
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	live := 0
+	for _, d := range t.dests {
+		if d.err != nil {
+			continue
+		}
+		if _, err := d.w.Write(p); err != nil {
+			d.err = fmt.Errorf("writing %s: %s", d.path, err)
+			if !t.bestEffort {
+				return 0, d.err
+			}
+			log.Printf("WARNING: %s, carrying on with the other outputs", d.err)
+			continue
+		}
+		live++
+	}
+	if live == 0 {
+		return 0, t.Err()
+	}
+	return len(p), nil
+}
+
+// failed returns the destinations that failed.
+func (t *teeWriter) failed() []*teeDest {
+	var failed []*teeDest
+	for _, d := range t.dests {
+		if d.err != nil {
+			failed = append(failed, d)
+		}
+	}
+	return failed
+}
+
+// Err returns an error naming the destinations that failed, if any.
+func (t *teeWriter) Err() error {
+	failed := t.failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(failed))
+	for i, d := range failed {
+		msgs[i] = d.err.Error()
+	}
+	return fmt.Errorf("%d of %d outputs failed: %s", len(failed), len(t.dests), strings.Join(msgs, "; "))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	cli "github.com/urfave/cli"
+	pb "gopkg.in/cheggaaa/pb.v1"
+)
+
+// ANSI colors of the messages written to stderr.
+const (
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+// colorEnabled is whether messages are colored, as decided by setupColor.
+var colorEnabled bool
+
+// width is the width of the terminal, kept up to date as it is resized, 0
+// when stderr isn't one.
+var width int64
+
+// setupColor decides, from --color, whether the messages written to
+// stderr are colored: errors in red, warnings in yellow and completed
+// fetches in green. "auto" colors them when stderr is a terminal, unless
+// $NO_COLOR is set or $TERM is dumb.
+func setupColor(mode string) error {
+	switch mode {
+	case "always":
+		colorEnabled = true
+	case "never":
+		colorEnabled = false
+	case "", "auto":
+		colorEnabled = stderrIsTerminal() && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+	default:
+		return fmt.Errorf("unknown --color %q, must be 'always', 'auto' or 'never'", mode)
+	}
+	if stderrIsTerminal() {
+		updateWidth()
+		watchResize()
+	}
+	if colorEnabled {
+		log.SetOutput(&warningWriter{w: os.Stderr})
+		cli.ErrWriter = &paintWriter{w: cli.ErrWriter, color: colorRed}
+	}
+	return nil
+}
+
+// paint returns s in color, or as is if colors are off.
+func paint(color, s string) string {
+	if !colorEnabled {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// updateWidth reads the width of the terminal again.
+func updateWidth() {
+	w, err := pb.GetTerminalWidth()
+	if err != nil {
+		w = 0
+	}
+	atomic.StoreInt64(&width, int64(w))
+}
+
+// fitWidth cuts each line of s down to the width of the terminal, so that
+// long addresses and paths don't wrap and mess up what is shown after them.
+// s is returned as is when stderr isn't a terminal.
+func fitWidth(s string) string {
+	w := int(atomic.LoadInt64(&width))
+	if w <= 1 {
+		return s
+	}
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if r := []rune(line); len(r) > w {
+			lines[i] = string(r[:w-1]) + "…"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// paintWriter writes everything in one color.
+type paintWriter struct {
+	w     io.Writer
+	color string
+}
+
+func (p *paintWriter) Write(b []byte) (int, error) {
+	if _, err := io.WriteString(p.w, p.color+strings.TrimSuffix(string(b), "\n")+colorReset+"\n"); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// warningWriter colors the log lines that are warnings. The log writes one
+// line at a time.
+type warningWriter struct {
+	w io.Writer
+}
+
+func (ww *warningWriter) Write(b []byte) (int, error) {
+	if !bytes.Contains(b, []byte("WARNING")) {
+		return ww.w.Write(b)
+	}
+	return (&paintWriter{w: ww.w, color: colorYellow}).Write(b)
+}
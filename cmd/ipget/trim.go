@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/ipfs/ipget"
+	cli "github.com/urfave/cli"
+)
+
+// trimAfter removes the blocks of the batch item just written, for
+// --trim-after. Failing to is only worth a warning, the item was written.
+func trimAfter(ctx context.Context, c *cli.Context, s *ipget.Session, t *target) {
+	removed, pinned, err := s.Trim(ctx)
+	if err != nil {
+		log.Printf("WARNING: trimming the blocks of %s: %s", t.name, err)
+		return
+	}
+	if c.Bool("verbose") {
+		log.Printf("trimmed %d blocks of %s, kept %d pinned ones", removed, t.name, pinned)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	files "github.com/ipfs/go-ipfs-files"
+)
+
+// unwrap returns the file the directory dir wraps, and its name. A
+// directory holding anything but a single file is an error, unless first is
+// set, in which case its first entry is taken as long as it is a file.
+func unwrap(dir files.Directory, first bool) (string, files.File, error) {
+	it := dir.Entries()
+	if !it.Next() {
+		if err := it.Err(); err != nil {
+			return "", nil, err
+		}
+		return "", nil, fmt.Errorf("--unwrap: the directory is empty")
+	}
+	name, nd := it.Name(), it.Node()
+	f, ok := nd.(files.File)
+	if !ok {
+		nd.Close()
+		return "", nil, fmt.Errorf("--unwrap: %s is a directory, not a file", name)
+	}
+	if !first {
+		if it.Next() {
+			f.Close()
+			return "", nil, fmt.Errorf("--unwrap: the directory holds more than one entry, use --unwrap-first to take %s", name)
+		}
+		if err := it.Err(); err != nil {
+			f.Close()
+			return "", nil, err
+		}
+	}
+	return name, f, nil
+}
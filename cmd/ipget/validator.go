@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	record "github.com/libp2p/go-libp2p-record"
+)
+
+// builtinNamespaces are validated by go-ipfs itself and can't be replaced.
+var builtinNamespaces = map[string]bool{"pk": true, "ipns": true}
+
+// commandValidator validates DHT records by running a shell command with the
+// record value on stdin and its key in $IPGET_RECORD_KEY. A zero exit status
+// means the record is valid.
+type commandValidator struct {
+	cmd string
+}
+
+func (v commandValidator) Validate(key string, value []byte) error {
+	cmd := exec.Command("sh", "-c", v.cmd)
+	cmd.Env = append(os.Environ(), "IPGET_RECORD_KEY="+key)
+	cmd.Stdin = bytes.NewReader(value)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("record %q rejected by %q: %s", key, v.cmd, err)
+	}
+	return nil
+}
+
+// Select picks the first record; they have all been validated by then and
+// the command has no way of ranking them.
+func (v commandValidator) Select(key string, values [][]byte) (int, error) {
+	return 0, nil
+}
+
+// parseValidators parses namespace=command pairs into validators.
+func parseValidators(specs []string) (map[string]record.Validator, error) {
+	validators := make(map[string]record.Validator, len(specs))
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid record validator %q, expected <namespace>=<command>", spec)
+		}
+		if builtinNamespaces[parts[0]] {
+			return nil, fmt.Errorf("the %q namespace is validated by ipfs and can't be overridden", parts[0])
+		}
+		validators[parts[0]] = commandValidator{cmd: parts[1]}
+	}
+	return validators, nil
+}
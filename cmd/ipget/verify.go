@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+	// verifyProvidersCount is how many providers --verify-providers looks
+	// up and dials, unless --require-providers asks for more.
+	verifyProvidersCount = 20
+	// providerDialTimeout bounds each of those dials.
+	providerDialTimeout = 5 * time.Second
+)
+
+// verifyProviders looks up count providers of the object at the root of p
+// and dials each of them. It returns how many of them connected, out of how many
+// were found. The connected ones are kept, so the fetch starts with them.
+func verifyProviders(ctx context.Context, ipfs iface.CoreAPI, p ipath.Path, count int) (int, int, error) {
+	root, err := providedRoot(ctx, ipfs, p)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	provs, err := ipfs.Dht().FindProviders(ctx, root, options.Dht.NumProviders(count))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var wg sync.WaitGroup
+	var reachable int32
+	found := 0
+	for prov := range provs {
+		found++
+		wg.Add(1)
+		go func(pi peer.AddrInfo) {
+			defer wg.Done()
+			dctx, cancel := context.WithTimeout(ctx, providerDialTimeout)
+			defer cancel()
+			if err := ipfs.Swarm().Connect(dctx, pi); err != nil {
+				log.Printf("provider %s is unreachable: %s", pi.ID, err)
+				return
+			}
+			atomic.AddInt32(&reachable, 1)
+		}(prov)
+	}
+	wg.Wait()
+	return int(reachable), found, ctx.Err()
+}
+
+// countProviders looks up the providers of the object at the root of p
+// until n distinct ones are found or the lookup ends, and returns how many
+// it found.
+func countProviders(ctx context.Context, ipfs iface.CoreAPI, p ipath.Path, n int) (int, error) {
+	root, err := providedRoot(ctx, ipfs, p)
+	if err != nil {
+		return 0, err
+	}
+	provs, err := ipfs.Dht().FindProviders(ctx, root, options.Dht.NumProviders(n))
+	if err != nil {
+		return 0, err
+	}
+	seen := make(map[peer.ID]bool)
+	for prov := range provs {
+		seen[prov.ID] = true
+	}
+	return len(seen), ctx.Err()
+}
+
+// providedRoot resolves the root of p. Providers are announced for the
+// root, its subpath may not be fetched from anyone else anyway.
+func providedRoot(ctx context.Context, ipfs iface.CoreAPI, p ipath.Path) (ipath.Resolved, error) {
+	parts := strings.SplitN(strings.Trim(p.String(), "/"), "/", 3)
+	return ipfs.ResolvePath(ctx, ipath.New("/"+parts[0]+"/"+parts[1]))
+}
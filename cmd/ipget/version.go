@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	ipfs "github.com/ipfs/go-ipfs"
+	cli "github.com/urfave/cli"
+)
+
+// version is ipget's version.
+const version = "0.5.0"
+
+// commit is the git commit ipget was built from, set by the Makefile with
+// -ldflags "-X main.commit=...".
+var commit string
+
+// versionInfo describes the build, down to the versions of the go-ipfs and
+// libp2p code a spawned node runs.
+type versionInfo struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit,omitempty"`
+	GoIPFS     string `json:"go-ipfs"`
+	GoLibp2p   string `json:"go-libp2p,omitempty"`
+	KadDHT     string `json:"go-libp2p-kad-dht,omitempty"`
+	GoVersion  string `json:"go"`
+	GoPlatform string `json:"platform"`
+}
+
+func currentVersion() versionInfo {
+	v := versionInfo{
+		Version:    version,
+		Commit:     commit,
+		GoIPFS:     ipfs.CurrentVersionNumber,
+		GoVersion:  runtime.Version(),
+		GoPlatform: runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	for _, m := range bi.Deps {
+		if m.Replace != nil {
+			m = m.Replace
+		}
+		switch m.Path {
+		case "github.com/ipfs/go-ipfs":
+			v.GoIPFS = m.Version
+		case "github.com/libp2p/go-libp2p":
+			v.GoLibp2p = m.Version
+		case "github.com/libp2p/go-libp2p-kad-dht":
+			v.KadDHT = m.Version
+		}
+	}
+	return v
+}
+
+// printVersion writes the version information to w, as JSON if asJSON is
+// set.
+func printVersion(w io.Writer, asJSON bool) error {
+	v := currentVersion()
+	if asJSON {
+		return json.NewEncoder(w).Encode(v)
+	}
+
+	build := v.Version
+	if v.Commit != "" {
+		build += " (" + v.Commit + ")"
+	}
+	fmt.Fprintf(w, "ipget version %s\n", build)
+	fmt.Fprintf(w, "go-ipfs %s\n", v.GoIPFS)
+	if v.GoLibp2p != "" {
+		fmt.Fprintf(w, "go-libp2p %s\n", v.GoLibp2p)
+	}
+	if v.KadDHT != "" {
+		fmt.Fprintf(w, "go-libp2p-kad-dht %s\n", v.KadDHT)
+	}
+	_, err := fmt.Fprintf(w, "%s %s\n", v.GoVersion, v.GoPlatform)
+	return err
+}
+
+func versionCommand() cli.Command {
+	return cli.Command{
+		Name:  "version",
+		Usage: "print the versions of ipget, the go-ipfs and libp2p code it embeds, and Go",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "print them as JSON",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			return printVersion(os.Stdout, c.Bool("json") || c.GlobalBool("json"))
+		},
+	}
+}
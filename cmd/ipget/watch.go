@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget"
+	"github.com/ipfs/ipget/internal/dagutil"
+	cli "github.com/urfave/cli"
+)
+
+// checkWatch makes sure what --watch is asked to follow can change and can
+// be updated in place.
+func checkWatch(c *cli.Context, t *target) error {
+	if !strings.HasPrefix(t.name, "/ipns/") {
+		return fmt.Errorf("--watch follows an IPNS name or DNSLink, %s never changes", t.name)
+	}
+	switch {
+	case t.outPath == "-":
+		return fmt.Errorf("--watch updates its output in place, it can't write to stdout")
+	case c.Duration("watch-interval") <= 0:
+		return fmt.Errorf("--watch-interval must be positive")
+	case c.IsSet("deadline"):
+		return fmt.Errorf("--deadline bounds a single fetch, --watch never ends")
+	case c.String("since") != "":
+		return fmt.Errorf("--since and --watch both skip unchanged content, use one of them")
+	case c.String("compress") != "" || c.Bool("unwrap") || c.Bool("unwrap-first") || c.Bool("detect-type"):
+		return fmt.Errorf("--watch writes the tree as published, without --compress, --unwrap or --detect-type")
+	case c.Bool("announce"):
+		return fmt.Errorf("--watch can't be combined with --announce, which never returns")
+	case c.String("summary-json") != "":
+		return fmt.Errorf("--summary-json is written once the run ends, --watch never does")
+	case c.String("preserve") != "" || c.Bool("normalize-permissions"):
+		return fmt.Errorf("--watch only writes the entries that changed, --preserve and --normalize-permissions can't be combined with it")
+	}
+	return nil
+}
+
+// watchTarget fetches t, then resolves it again every interval and brings
+// the output up to date whenever it points somewhere new. Only the entries
+// whose CID changed are fetched and written again. It returns once ctx is
+// done.
+func watchTarget(ctx, fctx context.Context, c *cli.Context, s *ipget.Session, flags fetchFlags, t *target, interval time.Duration) error {
+	dnslink := newDNSLinkResolver(c.String("dns-server"), c.BoolT("dnslink"))
+	dnslink.noCache = true
+	current, err := resolveFresh(fctx, s, dnslink, t.name)
+	if err != nil {
+		if ctx.Err() != nil {
+			return cli.NewExitError("interrupted", exitInterrupted)
+		}
+		return cli.NewExitError(err, 2)
+	}
+	t.resolved = current
+	if err := fetchOne(ctx, fctx, c, s, flags, t); err != nil {
+		return err
+	}
+
+	ex := &extractor{
+		exclude:      flags.exclude,
+		include:      flags.include,
+		minSize:      int64(flags.minSize),
+		maxSize:      int64(flags.maxSize),
+		checksums:    flags.checksums,
+		keepGoing:    !c.Bool("fail-fast"),
+		maxTotal:     int64(flags.maxTotal),
+		reproducible: c.Bool("reproducible"),
+		mtime:        flags.mtime,
+		root:         t.outPath,
+		target:       t.outPath,
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+		rp, err := resolveFresh(ctx, s, dnslink, t.name)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("resolving %s: %s", t.name, err)
+			continue
+		}
+		if rp.Cid().Equals(current.Cid()) {
+			continue
+		}
+		log.Printf("%s now points to %s, updating %s", t.name, rp.Cid(), t.outPath)
+		ex.failed, ex.written = nil, 0
+		st, err := syncTree(ctx, s, ex, current, rp, t.outPath)
+		if err == nil && len(ex.failed) > 0 {
+			err = fmt.Errorf("%d entries failed:\n  %s", len(ex.failed), strings.Join(ex.failed, "\n  "))
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			// what was written is redone from the old tree next time
+			log.Printf("updating %s: %s", t.outPath, err)
+			continue
+		}
+		log.Printf("updated %s: %s", t.outPath, st)
+		current = rp
+	}
+}
+
+// resolveFresh resolves name, a DNSLink or IPNS path, bypassing the cache
+// of IPNS records as Session.ResolveFresh does.
+func resolveFresh(ctx context.Context, s *ipget.Session, dnslink *dnslinkResolver, name string) (ipath.Resolved, error) {
+	p, err := dnslink.resolve(ctx, ipath.New(name))
+	if err != nil {
+		return nil, err
+	}
+	return s.ResolveFresh(ctx, p)
+}
+
+// syncStats counts the entries a sync touched.
+type syncStats struct {
+	updated, added, removed int
+}
+
+func (st syncStats) String() string {
+	return fmt.Sprintf("%d updated, %d added, %d removed", st.updated, st.added, st.removed)
+}
+
+// syncTree brings fpath, an extraction of old, up to date with new. Entries
+// with the same CID in both are left alone, neither fetched nor written.
+func syncTree(ctx context.Context, s *ipget.Session, ex *extractor, old, new ipath.Resolved, fpath string) (syncStats, error) {
+	var st syncStats
+	oldEntries, oldErr := lsDir(ctx, s.API(), old.Cid())
+	newEntries, err := lsDir(ctx, s.API(), new.Cid())
+	if err != nil {
+		return st, err
+	}
+	if oldErr != nil || oldEntries == nil || newEntries == nil {
+		// a file, or no longer a directory: nothing to diff against
+		st.updated++
+		return st, replaceEntry(ctx, s, ex, new.Cid(), fpath)
+	}
+	err = syncDir(ctx, s, ex, oldEntries, newEntries, fpath, &st)
+	return st, err
+}
+
+func syncDir(ctx context.Context, s *ipget.Session, ex *extractor, oldEntries, newEntries map[string]iface.DirEntry, dir string, st *syncStats) error {
+	for name, ne := range newEntries {
+		child := filepath.Join(dir, name)
+		if err := dagutil.CheckName(name); err != nil {
+			if !ex.keepGoing {
+				return err
+			}
+			ex.failed = append(ex.failed, fmt.Sprintf("%s: %s", child, err))
+			continue
+		}
+		if ex.excluded(child) {
+			continue
+		}
+		oe, ok := oldEntries[name]
+		if ok && oe.Cid.Equals(ne.Cid) {
+			continue
+		}
+		if ok && oe.Type == iface.TDirectory && ne.Type == iface.TDirectory {
+			oldSub, err := lsDir(ctx, s.API(), oe.Cid)
+			if err != nil {
+				return err
+			}
+			newSub, err := lsDir(ctx, s.API(), ne.Cid)
+			if err != nil {
+				return err
+			}
+			if err := syncDir(ctx, s, ex, oldSub, newSub, child, st); err != nil {
+				return err
+			}
+			continue
+		}
+		if ok {
+			st.updated++
+		} else {
+			st.added++
+		}
+		if err := replaceEntry(ctx, s, ex, ne.Cid, child); err != nil {
+			if !ex.keepGoing || isFatal(err) {
+				return err
+			}
+			ex.failed = append(ex.failed, fmt.Sprintf("%s: %s", child, err))
+		}
+	}
+	for name := range oldEntries {
+		child := filepath.Join(dir, name)
+		if _, ok := newEntries[name]; ok || ex.excluded(child) {
+			continue
+		}
+		if dagutil.CheckName(name) != nil {
+			// never written, nothing to remove
+			continue
+		}
+		st.removed++
+		if err := removeEntry(ex, child); err != nil {
+			return err
+		}
+	}
+	// the changes moved the time of the directory
+	return ex.stamp(dir)
+}
+
+// replaceEntry fetches c and writes it at fpath in place of what was there.
+func replaceEntry(ctx context.Context, s *ipget.Session, ex *extractor, c cid.Cid, fpath string) error {
+	nd, err := s.Get(ctx, ipath.IpfsPath(c))
+	if err != nil {
+		return err
+	}
+	if err := removeEntry(ex, fpath); err != nil {
+		return err
+	}
+	return ex.writeToRec(nd, fpath)
+}
+
+// removeEntry removes fpath and its checksum sidecars, if any.
+func removeEntry(ex *extractor, fpath string) error {
+	if err := ex.files().RemoveAll(fpath); err != nil {
+		return err
+	}
+	for _, algo := range ex.checksums {
+		if err := ex.files().RemoveAll(fpath + "." + algo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lsDir lists the unixfs directory c by name, or returns nil if c isn't a
+// directory.
+func lsDir(ctx context.Context, ipfs iface.CoreAPI, c cid.Cid) (map[string]iface.DirEntry, error) {
+	nd, err := ipfs.Unixfs().Get(ctx, ipath.IpfsPath(c))
+	if err != nil {
+		return nil, err
+	}
+	defer nd.Close()
+	if _, ok := nd.(files.Directory); !ok {
+		return nil, nil
+	}
+	list, err := ipfs.Unixfs().Ls(ctx, ipath.IpfsPath(c))
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]iface.DirEntry)
+	for e := range list {
+		if e.Err != nil {
+			// keep draining so the listing can finish
+			err = e.Err
+			continue
+		}
+		entries[e.Name] = e
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,124 @@
+package ipget
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	config "github.com/ipfs/go-ipfs-config"
+	network "github.com/libp2p/go-libp2p-core/network"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// connCapLogEvery is how often at most connCap logs that it trimmed
+// connections.
+const connCapLogEvery = 10 * time.Second
+
+// connCap holds a spawned node to a number of connections, whatever the
+// fetches going through it ask for. The connection manager only trims once
+// in a while, and only down to its low watermark, so connections can pile
+// up far above its high one in between. Every time one more peer connects
+// past the cap, the cap closes those the connection manager values least
+// until the node is back within it.
+type connCap struct {
+	max int
+	log Logger
+
+	mu       sync.Mutex
+	trimming bool
+	// unlogged is how many were trimmed since the last line logged, at
+	// logged.
+	unlogged int
+	logged   time.Time
+}
+
+// cfg returns an option bringing the connection manager's watermarks
+// within the cap, so it trims before the cap has to.
+func (c *connCap) cfg() CfgOpt {
+	return func(cfg *config.Config) {
+		cm := &cfg.Swarm.ConnMgr
+		if cm.HighWater == 0 || cm.HighWater > c.max {
+			cm.HighWater = c.max
+		}
+		if low := c.max * 3 / 4; cm.LowWater == 0 || cm.LowWater > low {
+			cm.LowWater = low
+		}
+		if cm.Type == "" || cm.Type == "none" {
+			cm.Type = "basic"
+		}
+		if cm.GracePeriod == "" {
+			cm.GracePeriod = config.DefaultConnMgrGracePeriod.String()
+		}
+	}
+}
+
+// watch trims the connections of n every time a peer connects past the
+// cap. values is asked how much the connection manager values each peer.
+func (c *connCap) watch(n network.Network, values func(peer.ID) int) {
+	n.Notify(&network.NotifyBundle{
+		ConnectedF: func(n network.Network, conn network.Conn) {
+			if len(n.Peers()) <= c.max {
+				return
+			}
+			c.mu.Lock()
+			busy := c.trimming
+			c.trimming = true
+			c.mu.Unlock()
+			if !busy {
+				// notifiees mustn't block the swarm
+				go c.trim(n, conn.RemotePeer(), values)
+			}
+		},
+	})
+}
+
+// trim closes the connections to the peers valued least, other than the
+// one that just connected, until n is within the cap.
+func (c *connCap) trim(n network.Network, newest peer.ID, values func(peer.ID) int) {
+	closed := 0
+	// peers may connect while closing the others, so it goes on until
+	// there is nothing over
+	for {
+		peers := n.Peers()
+		over := len(peers) - c.max
+		if over <= 0 {
+			break
+		}
+		value := make(map[peer.ID]int, len(peers))
+		var others []peer.ID
+		for _, p := range peers {
+			if p != newest {
+				others = append(others, p)
+				value[p] = values(p)
+			}
+		}
+		sort.SliceStable(others, func(i, j int) bool { return value[others[i]] < value[others[j]] })
+		done := 0
+		for _, p := range others {
+			if done == over {
+				break
+			}
+			if err := n.ClosePeer(p); err == nil {
+				done++
+			}
+		}
+		if done == 0 {
+			break
+		}
+		closed += done
+	}
+
+	c.mu.Lock()
+	c.trimming = false
+	c.unlogged += closed
+	var report int
+	if c.unlogged > 0 && time.Since(c.logged) >= connCapLogEvery {
+		report = c.unlogged
+		c.unlogged = 0
+		c.logged = time.Now()
+	}
+	c.mu.Unlock()
+	if report > 0 {
+		c.log.Printf("closed %d connections to stay within the cap of %d", report, c.max)
+	}
+}
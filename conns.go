@@ -0,0 +1,48 @@
+package ipget
+
+import (
+	"sort"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// TrimConns closes connections of a spawned node until at most keep are
+// left besides those to the peers that sent blocks during the last Get.
+// The connections kept are the ones the connection manager values most,
+// such as those to the peers in the DHT routing table. It returns how many
+// peers were disconnected.
+func (s *Session) TrimConns(keep int) int {
+	if !s.Embedded() {
+		return 0
+	}
+	active := make(map[peer.ID]bool)
+	for _, t := range s.PeerTraffic() {
+		active[t.Peer] = true
+	}
+
+	h := s.node.PeerHost
+	cm := h.ConnManager()
+	var others []peer.ID
+	values := make(map[peer.ID]int)
+	for _, p := range h.Network().Peers() {
+		if active[p] {
+			continue
+		}
+		others = append(others, p)
+		if info := cm.GetTagInfo(p); info != nil {
+			values[p] = info.Value
+		}
+	}
+	if len(others) <= keep {
+		return 0
+	}
+	sort.SliceStable(others, func(i, j int) bool { return values[others[i]] > values[others[j]] })
+
+	closed := 0
+	for _, p := range others[keep:] {
+		if err := h.Network().ClosePeer(p); err == nil {
+			closed++
+		}
+	}
+	return closed
+}
@@ -0,0 +1,116 @@
+package ipget
+
+import (
+	"context"
+	"io"
+
+	cid "github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	unixfs "github.com/ipfs/go-unixfs"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	uio "github.com/ipfs/go-unixfs/io"
+	"github.com/ipfs/ipget/internal/dagutil"
+)
+
+// unixfsNode returns the unixfs node nd, got from dg, for extraction, as
+// go-unixfs would but walked by ipget: each directory entry and each block
+// of a file is checked against the path down to it, and a walk that would
+// loop fails with a cycle error. up is the path down to nd's parent, nil
+// for the root.
+func unixfsNode(ctx context.Context, dg ipld.DAGService, nd ipld.Node, up *dagutil.Ancestry) (files.Node, error) {
+	path, err := up.Child(nd.Cid())
+	if err != nil {
+		return nil, err
+	}
+	out, err := unixfile.NewUnixfsFile(ctx, dg, nd)
+	if err != nil {
+		return nil, err
+	}
+	switch out := out.(type) {
+	case files.Directory:
+		dir, err := uio.NewDirectoryFromNode(dg, nd)
+		if err != nil {
+			return nil, err
+		}
+		return &acyclicDir{Directory: out, ctx: ctx, dg: dg, dir: dir, path: path}, nil
+	case files.File:
+		if len(nd.Links()) == 0 {
+			return out, nil
+		}
+		return &acyclicFile{File: out, ctx: ctx, dg: dg, root: nd.Cid()}, nil
+	}
+	return out, nil
+}
+
+// acyclicDir is a unixfs directory whose entries are checked for links
+// back to the directories they are in.
+type acyclicDir struct {
+	files.Directory
+	ctx  context.Context
+	dg   ipld.DAGService
+	dir  uio.Directory
+	path *dagutil.Ancestry
+}
+
+func (d *acyclicDir) Entries() files.DirIterator {
+	return &acyclicIterator{d: d, links: d.dir.EnumLinksAsync(d.ctx)}
+}
+
+type acyclicIterator struct {
+	d     *acyclicDir
+	links <-chan unixfs.LinkResult
+	name  string
+	node  files.Node
+	err   error
+}
+
+func (it *acyclicIterator) Name() string { return it.name }
+
+func (it *acyclicIterator) Node() files.Node { return it.node }
+
+func (it *acyclicIterator) Err() error { return it.err }
+
+func (it *acyclicIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	it.node = nil
+	res, ok := <-it.links
+	if !ok {
+		return false
+	}
+	if it.err = res.Err; it.err != nil {
+		return false
+	}
+	if _, it.err = it.d.path.Child(res.Link.Cid); it.err != nil {
+		return false
+	}
+	nd, err := res.Link.GetNode(it.d.ctx, it.d.dg)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.name = res.Link.Name
+	it.node, it.err = unixfsNode(it.d.ctx, it.d.dg, nd, it.d.path)
+	return it.err == nil
+}
+
+// acyclicFile is a unixfs file of more than one block, read through
+// dagutil.NewOrderedReader, which checks each block against the path down
+// to it. The reader is only started by the first read, the file may be
+// read another way.
+type acyclicFile struct {
+	files.File
+	ctx  context.Context
+	dg   ipld.NodeGetter
+	root cid.Cid
+	r    io.Reader
+}
+
+func (f *acyclicFile) Read(p []byte) (int, error) {
+	if f.r == nil {
+		f.r = dagutil.NewOrderedReader(f.ctx, f.dg, f.root, 0, 0, 0)
+	}
+	return f.r.Read(p)
+}
@@ -0,0 +1,180 @@
+package ipget
+
+import (
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	"github.com/ipfs/go-ipfs/repo"
+)
+
+// blockCounter counts the blocks a spawned node stores and the ones it is
+// able to serve from its blockstore instead, so a session fetching several
+// overlapping DAGs can tell how much it saved. Reads are counted against the
+// latest Get: a block stored by an earlier Get, or already in the repo, is
+// deduplicated the first time each Get reads it.
+type blockCounter struct {
+	mu  sync.Mutex
+	job int
+	// stored maps the blocks stored during the session to the Get that
+	// stored them.
+	stored map[datastore.Key]int
+	// hit maps the deduplicated blocks to the last Get that read them.
+	hit          map[datastore.Key]int
+	deduplicated int
+	// seeded holds the blocks imported from a seed CAR, and whether they
+	// have been read since.
+	seeded map[datastore.Key]bool
+	// trimmed is the last Get whose blocks were handed out for trimming.
+	trimmed int
+}
+
+func newBlockCounter() *blockCounter {
+	return &blockCounter{
+		stored: make(map[datastore.Key]int),
+		hit:    make(map[datastore.Key]int),
+		seeded: make(map[datastore.Key]bool),
+	}
+}
+
+// reset forgets everything counted so far, such as the blocks a node stores
+// for itself as it starts.
+func (b *blockCounter) reset() {
+	b.mu.Lock()
+	b.stored = make(map[datastore.Key]int)
+	b.hit = make(map[datastore.Key]int)
+	b.deduplicated = 0
+	b.seeded = make(map[datastore.Key]bool)
+	b.trimmed = b.job
+	b.mu.Unlock()
+}
+
+// markSeeded counts the blocks of cids as seeded rather than fetched.
+func (b *blockCounter) markSeeded(cids []cid.Cid) {
+	b.mu.Lock()
+	for _, c := range cids {
+		key := blocksPrefix.Child(dshelp.CidToDsKey(c))
+		b.seeded[key] = false
+		delete(b.stored, key)
+	}
+	b.mu.Unlock()
+}
+
+// startJob marks the start of a new Get.
+func (b *blockCounter) startJob() {
+	b.mu.Lock()
+	b.job++
+	b.mu.Unlock()
+}
+
+func (b *blockCounter) put(key datastore.Key) {
+	if !key.IsDescendantOf(blocksPrefix) {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.stored[key]; !ok {
+		b.stored[key] = b.job
+	}
+}
+
+func (b *blockCounter) get(key datastore.Key) {
+	if !key.IsDescendantOf(blocksPrefix) {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.seeded[key]; ok {
+		b.seeded[key] = true
+		return
+	}
+	if job, ok := b.stored[key]; ok && job == b.job {
+		// fetched by this very Get
+		return
+	}
+	if job, ok := b.hit[key]; ok && job == b.job {
+		return
+	}
+	b.hit[key] = b.job
+	b.deduplicated++
+}
+
+// counts returns the number of blocks fetched from the network, the number
+// of block references served from the blockstore instead, and the number of
+// seeded blocks that were used.
+func (b *blockCounter) counts() (fetched, deduplicated, seeded int) {
+	if b == nil {
+		return 0, 0, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, used := range b.seeded {
+		if used {
+			seeded++
+		}
+	}
+	return len(b.stored), b.deduplicated, seeded
+}
+
+// countingRepo is a repo whose datastore reports block reads and writes to a
+// blockCounter.
+type countingRepo struct {
+	repo.Repo
+	ds *countingDatastore
+}
+
+func newCountingRepo(r repo.Repo, blocks *blockCounter) *countingRepo {
+	return &countingRepo{
+		Repo: r,
+		ds:   &countingDatastore{Batching: r.Datastore(), blocks: blocks},
+	}
+}
+
+func (r *countingRepo) Datastore() repo.Datastore {
+	return r.ds
+}
+
+type countingDatastore struct {
+	datastore.Batching
+	blocks *blockCounter
+}
+
+func (d *countingDatastore) Get(key datastore.Key) ([]byte, error) {
+	value, err := d.Batching.Get(key)
+	if err == nil {
+		d.blocks.get(key)
+	}
+	return value, err
+}
+
+func (d *countingDatastore) Put(key datastore.Key, value []byte) error {
+	if err := d.Batching.Put(key, value); err != nil {
+		return err
+	}
+	d.blocks.put(key)
+	return nil
+}
+
+func (d *countingDatastore) Batch() (datastore.Batch, error) {
+	b, err := d.Batching.Batch()
+	if err != nil {
+		return nil, err
+	}
+	return &countingBatch{Batch: b, blocks: d.blocks}, nil
+}
+
+// countingBatch counts blocks as they are queued rather than committed, a
+// failed commit fails the fetch anyway.
+type countingBatch struct {
+	datastore.Batch
+	blocks *blockCounter
+}
+
+func (b *countingBatch) Put(key datastore.Key, value []byte) error {
+	if err := b.Batch.Put(key, value); err != nil {
+		return err
+	}
+	b.blocks.put(key)
+	return nil
+}
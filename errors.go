@@ -0,0 +1,119 @@
+package ipget
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	ipld "github.com/ipfs/go-ipld-format"
+	resolver "github.com/ipfs/go-path/resolver"
+)
+
+// The categories of the errors returned by Session.Get, GetTo, Resolve and
+// WriteTo, for use with errors.Is. Errors fitting none of them are returned
+// as they are.
+var (
+	// ErrNotFound is a path naming an entry or block that doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrTimeout is a fetch that ran out of time, its context's deadline
+	// having passed.
+	ErrTimeout = errors.New("timed out")
+	// ErrNetwork is a failure to reach the daemon, the gateways or peers.
+	ErrNetwork = errors.New("network error")
+	// ErrInvalidPath is a path that isn't a valid IPFS path, or that
+	// names a directory where a file is needed.
+	ErrInvalidPath = errors.New("invalid path")
+	// ErrLocalIO is a failure to write the output locally.
+	ErrLocalIO = errors.New("local I/O error")
+)
+
+// The categories of the errors returned by Session.Unavailable, telling how
+// far a Get that got no block at all went.
+var (
+	// ErrNoProviders is no provider of the content being found.
+	ErrNoProviders = errors.New("no providers found")
+	// ErrUndialable is providers being found but none of them dialed.
+	ErrUndialable = errors.New("no provider could be dialed")
+	// ErrNotServed is providers being dialed but none of them sending a
+	// block.
+	ErrNotServed = errors.New("no provider sent a block")
+)
+
+// Error is an error of one of the categories above. Err is the error as it
+// was returned by the layer that failed, and can be unwrapped with errors.As
+// as well.
+type Error struct {
+	// Kind is one of the categories above.
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is makes errors.Is match the category as well as the wrapped error.
+func (e *Error) Is(target error) bool { return target == e.Kind }
+
+// categorize wraps err in an *Error of its category, if it has one.
+func categorize(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	if kind := errorKind(err); kind != nil {
+		return &Error{Kind: kind, Err: err}
+	}
+	return err
+}
+
+func errorKind(err error) error {
+	var nf notFoundError
+	var noLink resolver.ErrNoLink
+	var pathErr *os.PathError
+	var writeErr errLocalWrite
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrTimeout
+	case errors.As(err, &nf), errors.As(err, &noLink), errors.Is(err, ipld.ErrNotFound):
+		return ErrNotFound
+	case errors.As(err, &pathErr), errors.As(err, &writeErr):
+		return ErrLocalIO
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return ErrTimeout
+		}
+		return ErrNetwork
+	}
+	// a daemon's errors only come back as text
+	if msg := err.Error(); strings.Contains(msg, "no link named") || strings.Contains(msg, "not found") {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// errLocalWrite marks an error writing to the io.Writer given to GetTo, so
+// it isn't taken for one reading from the network.
+type errLocalWrite struct{ err error }
+
+func (e errLocalWrite) Error() string { return e.err.Error() }
+
+func (e errLocalWrite) Unwrap() error { return e.err }
+
+// localWriter marks the errors of w as local ones.
+type localWriter struct{ w io.Writer }
+
+func (w localWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil {
+		err = errLocalWrite{err}
+	}
+	return n, err
+}
@@ -0,0 +1,38 @@
+package ipget
+
+import (
+	"fmt"
+	"strings"
+
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+)
+
+// exchanges are the ways blocks missing from the blockstore can be got:
+// over bitswap, or not at all, in which case everything fetched must be in
+// the blockstore already or come from a --seed-car.
+var exchanges = []string{"bitswap", "none"}
+
+// checkExchange makes sure name is one of exchanges.
+func checkExchange(name string) error {
+	switch name {
+	case "", "bitswap", "none":
+		return nil
+	}
+	return fmt.Errorf("unknown exchange %q, must be one of %s", name, strings.Join(exchanges, ", "))
+}
+
+// withExchange returns api fetching its blocks through the exchange name,
+// checked by checkExchange. embedded tells whether api is that of a spawned
+// node. Without an exchange, a spawned node still resolves names over the
+// network, but a daemon can only be asked to work offline, so its names
+// are only resolved from its cache.
+func withExchange(api iface.CoreAPI, name string, embedded bool) (iface.CoreAPI, error) {
+	if name != "none" {
+		return api, nil
+	}
+	if embedded {
+		return api.WithOptions(options.Api.FetchBlocks(false))
+	}
+	return api.WithOptions(options.Api.Offline(true))
+}
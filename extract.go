@@ -0,0 +1,108 @@
+package ipget
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/ipfs/ipget/internal/dagutil"
+	pb "gopkg.in/cheggaaa/pb.v1"
+)
+
+// WriteTo writes the given node to the local filesystem at fpath. A file or
+// symlink already where a file or symlink goes is replaced, but nothing is
+// written into a directory that is there already, or in place of one.
+// Errors are categorized as described at ErrNotFound.
+func WriteTo(nd files.Node, fpath string, progress bool) error {
+	return WriteToFS(OSFS, nd, fpath, progress)
+}
+
+// WriteToFS writes the given node to fs at fpath, like WriteTo.
+func WriteToFS(fs FS, nd files.Node, fpath string, progress bool) error {
+	var bar *pb.ProgressBar
+	if progress {
+		s, err := nd.Size()
+		if err != nil {
+			return categorize(err)
+		}
+		bar = pb.New64(s).Start()
+		defer bar.Finish()
+	}
+	return categorize(writeToRec(fs, nd, fpath, bar))
+}
+
+func writeToRec(fs FS, nd files.Node, fpath string, bar *pb.ProgressBar) error {
+	switch nd := nd.(type) {
+	case *files.Symlink:
+		if err := removeFile(fs, fpath); err != nil {
+			return err
+		}
+		return fs.Symlink(nd.Target, fpath)
+	case files.File:
+		if err := removeFile(fs, fpath); err != nil {
+			return err
+		}
+		f, err := fs.Create(fpath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		var r io.Reader = nd
+		if bar != nil {
+			r = bar.NewProxyReader(r)
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			return err
+		}
+		return f.Close()
+	case files.Directory:
+		err := fs.Mkdir(fpath, 0777)
+		if err != nil {
+			return err
+		}
+
+		entries := nd.Entries()
+		for entries.Next() {
+			if err := dagutil.CheckName(entries.Name()); err != nil {
+				return err
+			}
+			child := filepath.Join(fpath, entries.Name())
+			if err := writeToRec(fs, entries.Node(), child, bar); err != nil {
+				return err
+			}
+		}
+		return entries.Err()
+	default:
+		return fmt.Errorf("file type %T at %q is not supported", nd, fpath)
+	}
+}
+
+// removeFile removes the file or symlink at fpath, if any, so that a
+// symlink there isn't written through. A directory is left alone and makes
+// it fail.
+func removeFile(fs FS, fpath string) error {
+	fi, err := fs.Lstat(fpath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%s is a directory already", fpath)
+	}
+	return fs.Remove(fpath)
+}
+
+// wrappedFile is a files.File whose content is read from r instead.
+type wrappedFile struct {
+	files.File
+	r io.Reader
+}
+
+func (f *wrappedFile) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
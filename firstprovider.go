@@ -0,0 +1,102 @@
+package ipget
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+)
+
+const (
+	// firstProviderWindow is how long a Get is given to complete from the
+	// first provider found before the provider search is widened.
+	firstProviderWindow = 10 * time.Second
+	// firstProviderMaxSize is the largest content the first provider is
+	// left to supply on its own, a block or two.
+	firstProviderMaxSize = 1 << 20
+)
+
+// firstProvider narrows the provider lookups of a spawned node to the first
+// provider found, for a while at the start of each Get. For small content
+// the DHT walk collecting more providers takes longer than the fetch from
+// the first one; stopping at the first saves that, and if it supplies
+// everything no more lookups are made. Should the content turn out larger,
+// or the window pass, lookups go back to finding as many providers as
+// bitswap asks for. Lookups are narrowed while any Get is in its window.
+type firstProvider struct {
+	mu sync.Mutex
+	// until holds when the window of each Get ends, by the id begin gave
+	// it.
+	until map[int]time.Time
+	next  int
+}
+
+// begin narrows the lookups for firstProviderWindow, and returns the id
+// of that window for widen.
+func (f *firstProvider) begin() int {
+	if f == nil {
+		return 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.until == nil {
+		f.until = make(map[int]time.Time)
+	}
+	f.next++
+	f.until[f.next] = time.Now().Add(firstProviderWindow)
+	return f.next
+}
+
+// widen ends the window id early, for content too large for one provider
+// to be worth waiting on.
+func (f *firstProvider) widen(id int) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	delete(f.until, id)
+	f.mu.Unlock()
+}
+
+func (f *firstProvider) narrowed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	narrowed := false
+	for id, until := range f.until {
+		if now.Before(until) {
+			narrowed = true
+		} else {
+			delete(f.until, id)
+		}
+	}
+	return narrowed
+}
+
+// firstProviderRouting ends provider lookups at the first provider while
+// its firstProvider is narrowed. Cancelling the lookup stops the DHT walk
+// too.
+type firstProviderRouting struct {
+	routing.Routing
+	first *firstProvider
+}
+
+func (r *firstProviderRouting) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	if !r.first.narrowed() {
+		return r.Routing.FindProvidersAsync(ctx, c, count)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	in := r.Routing.FindProvidersAsync(ctx, c, count)
+	out := make(chan peer.AddrInfo, 1)
+	go func() {
+		defer close(out)
+		defer cancel()
+		if prov, ok := <-in; ok {
+			out <- prov
+		}
+	}()
+	return out
+}
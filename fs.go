@@ -0,0 +1,93 @@
+package ipget
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// FS is the filesystem WriteToFS and the ipget command write to, so that
+// embedders can have fetched content written somewhere other than the
+// local disk, such as to memory in tests. Its methods behave like the os functions of the same
+// name, including errors that os.IsNotExist and os.IsExist recognize;
+// afero filesystems fit it with a thin adapter.
+type FS interface {
+	Create(name string) (io.WriteCloser, error)
+	Mkdir(name string, perm os.FileMode) error
+	Symlink(oldname, newname string) error
+	Lstat(name string) (os.FileInfo, error)
+	Remove(name string) error
+	RemoveAll(path string) error
+	Rename(oldpath, newpath string) error
+	Chtimes(name string, atime, mtime time.Time) error
+	// ReadDir, Readlink and Open are only used by the command's delta
+	// extraction and if-different overwrite policy, to compare with what
+	// is there.
+	ReadDir(name string) ([]os.FileInfo, error)
+	Readlink(name string) (string, error)
+	Open(name string) (io.ReadCloser, error)
+}
+
+// AppendFS is implemented by the filesystems that can append to a file,
+// which the command's --append needs.
+type AppendFS interface {
+	// Append opens name for appending, creating it if it doesn't exist.
+	Append(name string) (AppendFile, error)
+}
+
+// AppendFile is a file opened by AppendFS. Sync makes sure what was
+// written is stored, Truncate cuts it back should writing fail.
+type AppendFile interface {
+	io.WriteCloser
+	Sync() error
+	Truncate(size int64) error
+}
+
+// OSFS is the local filesystem.
+var OSFS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Create(name string) (io.WriteCloser, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		// keep the interface nil rather than holding a nil *os.File
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Append(name string) (AppendFile, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Mkdir(name string, perm os.FileMode) error { return os.Mkdir(name, perm) }
+
+func (osFS) Symlink(oldname, newname string) error { return os.Symlink(oldname, newname) }
+
+func (osFS) Lstat(name string) (os.FileInfo, error) { return os.Lstat(name) }
+
+func (osFS) Remove(name string) error { return os.Remove(name) }
+
+func (osFS) RemoveAll(path string) error { return os.RemoveAll(path) }
+
+func (osFS) Rename(oldpath, newpath string) error { return os.Rename(oldpath, newpath) }
+
+func (osFS) Chtimes(name string, atime, mtime time.Time) error { return os.Chtimes(name, atime, mtime) }
+
+func (osFS) ReadDir(name string) ([]os.FileInfo, error) { return ioutil.ReadDir(name) }
+
+func (osFS) Readlink(name string) (string, error) { return os.Readlink(name) }
+
+func (osFS) Open(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,278 @@
+package ipget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	nethttp "net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+const (
+	// gatewayAttempts is how many times in a row a gateway may fail with
+	// a retryable error before we move on to the next one.
+	gatewayAttempts = 2
+	// gatewayBackoff is the first delay between retries, doubled on each
+	// retry up to gatewayMaxBackoff.
+	gatewayBackoff    = time.Second
+	gatewayMaxBackoff = 30 * time.Second
+)
+
+// errGatewayReadOnly is returned by the methods of gatewayDAG that would
+// store nodes.
+var errGatewayReadOnly = errors.New("the gateway fallback is read only")
+
+// gatewayFetcher fetches blocks from HTTP gateways as a last resort. Every
+// block is requested raw and hashed again, so a gateway can't serve us
+// anything but what we asked for, unless trust is set. It is safe for
+// concurrent use.
+type gatewayFetcher struct {
+	urls      []string
+	client    *nethttp.Client
+	retries   int
+	userAgent string
+	maxBlock  int
+	// headers are added to every block request, such as the credentials
+	// of a private gateway.
+	headers nethttp.Header
+	// trust skips hashing the blocks, taking the gateways' word for it
+	// that they are what was asked for.
+	trust bool
+
+	mu sync.Mutex
+	// current is the gateway blocks are requested from, until it fails.
+	current int
+}
+
+func newGatewayFetcher(urls []string, retries int, userAgent string, maxBlock int, headers nethttp.Header) *gatewayFetcher {
+	if maxBlock <= 0 {
+		maxBlock = maxCARSection
+	}
+	g := &gatewayFetcher{
+		client:    &nethttp.Client{},
+		retries:   retries,
+		userAgent: userAgent,
+		maxBlock:  maxBlock,
+		headers:   headers,
+	}
+	for _, u := range urls {
+		g.urls = append(g.urls, strings.TrimSuffix(u, "/"))
+	}
+	return g
+}
+
+// get fetches p from the gateways. Only /ipfs paths can be fetched: the
+// gateway's answer for a name couldn't be verified.
+func (g *gatewayFetcher) get(ctx context.Context, p ipath.Path) (files.Node, error) {
+	return getFromDAG(ctx, &gatewayDAG{g}, p)
+}
+
+// getFromDAG fetches the unixfs node at p, an /ipfs path, through dag. Its
+// blocks may have been taken on trust, so its walk is checked for cycles.
+func getFromDAG(ctx context.Context, dag ipld.DAGService, p ipath.Path) (files.Node, error) {
+	if p.Namespace() != "ipfs" {
+		return nil, fmt.Errorf("the gateway fallback can only fetch /ipfs paths, not %s", p)
+	}
+	resolved, err := resolveSubpath(ctx, dag, p)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(strings.Trim(resolved.String(), "/"), "/")
+	if len(parts) > 2 {
+		return nil, fmt.Errorf("the gateway fallback can't resolve %s inside a non-unixfs node", resolved)
+	}
+	c, err := cid.Decode(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	nd, err := dag.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return unixfsNode(ctx, dag, nd, nil)
+}
+
+// block fetches the block c, retrying retryable failures with backoff and
+// moving on to the next gateway after gatewayAttempts of them, or right away
+// when a gateway answers with anything else. Retries are counted across
+// gateways.
+func (g *gatewayFetcher) block(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	g.mu.Lock()
+	gw := g.current
+	g.mu.Unlock()
+
+	var lastErr error
+	failures := 0
+	delay := gatewayBackoff
+	for attempt := 0; attempt <= g.retries; attempt++ {
+		b, retryAfter, retryable, err := g.try(ctx, g.urls[gw], c)
+		if err == nil {
+			return b, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = fmt.Errorf("gateway %s: %w", g.urls[gw], err)
+
+		failures++
+		if !retryable || failures >= gatewayAttempts {
+			if len(g.urls) > 1 {
+				gw = g.rotate(gw)
+				failures = 0
+				continue
+			}
+			if !retryable {
+				return nil, lastErr
+			}
+		}
+		if attempt == g.retries {
+			break
+		}
+
+		wait := delay
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		if wait > gatewayMaxBackoff {
+			wait = gatewayMaxBackoff
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if delay *= 2; delay > gatewayMaxBackoff {
+			delay = gatewayMaxBackoff
+		}
+	}
+	return nil, lastErr
+}
+
+// rotate moves on from the gateway gw, unless another request already did.
+func (g *gatewayFetcher) rotate(gw int) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.current == gw {
+		g.current = (gw + 1) % len(g.urls)
+	}
+	return g.current
+}
+
+// try asks the gateway at base for the block c once. Besides the error, it
+// reports whether trying again may help and how long the gateway asked us
+// to wait first.
+func (g *gatewayFetcher) try(ctx context.Context, base string, c cid.Cid) (b blocks.Block, retryAfter time.Duration, retryable bool, err error) {
+	req, err := nethttp.NewRequest("GET", base+"/ipfs/"+c.String()+"?format=raw", nil)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	req = req.WithContext(ctx)
+	for name, values := range g.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.raw")
+	if g.userAgent != "" {
+		req.Header.Set("User-Agent", g.userAgent)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, 0, true, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case nethttp.StatusOK:
+	case nethttp.StatusTooManyRequests, nethttp.StatusBadGateway, nethttp.StatusServiceUnavailable, nethttp.StatusGatewayTimeout:
+		return nil, parseRetryAfter(resp.Header.Get("Retry-After")), true, errors.New(resp.Status)
+	default:
+		return nil, 0, false, errors.New(resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(g.maxBlock)+1))
+	if err != nil {
+		return nil, 0, true, err
+	}
+	if len(data) > g.maxBlock {
+		return nil, 0, false, fmt.Errorf("block %s is larger than %d bytes", c, g.maxBlock)
+	}
+	if !g.trust {
+		sum, err := c.Prefix().Sum(data)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if !sum.Equals(c) {
+			return nil, 0, false, fmt.Errorf("returned data for %s that hashes to %s", c, sum)
+		}
+	}
+	b, err = blocks.NewBlockWithCid(data, c)
+	return b, 0, false, err
+}
+
+// parseRetryAfter parses a Retry-After header, in seconds or as a date. It
+// returns 0 when there is none or it can't be parsed.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := nethttp.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// gatewayDAG is a read only DAG service fetching every node through a
+// gatewayFetcher.
+type gatewayDAG struct {
+	g *gatewayFetcher
+}
+
+func (d *gatewayDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	b, err := d.g.block(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return ipld.Decode(b)
+}
+
+func (d *gatewayDAG) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, len(cids))
+	var wg sync.WaitGroup
+	for _, c := range cids {
+		wg.Add(1)
+		go func(c cid.Cid) {
+			defer wg.Done()
+			nd, err := d.Get(ctx, c)
+			out <- &ipld.NodeOption{Node: nd, Err: err}
+		}(c)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+func (d *gatewayDAG) Add(context.Context, ipld.Node) error { return errGatewayReadOnly }
+
+func (d *gatewayDAG) AddMany(context.Context, []ipld.Node) error { return errGatewayReadOnly }
+
+func (d *gatewayDAG) Remove(context.Context, cid.Cid) error { return errGatewayReadOnly }
+
+func (d *gatewayDAG) RemoveMany(context.Context, []cid.Cid) error { return errGatewayReadOnly }
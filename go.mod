@@ -3,13 +3,40 @@ module github.com/ipfs/ipget
 go 1.13
 
 require (
+	github.com/dustin/go-humanize v1.0.0
+	github.com/ipfs/go-bitswap v0.2.13
+	github.com/ipfs/go-block-format v0.0.2
+	github.com/ipfs/go-cid v0.0.5
+	github.com/ipfs/go-datastore v0.4.4
 	github.com/ipfs/go-ipfs v0.5.1
+	github.com/ipfs/go-ipfs-blockstore v0.1.4
+	github.com/ipfs/go-ipfs-chunker v0.0.5
 	github.com/ipfs/go-ipfs-config v0.5.3
+	github.com/ipfs/go-ipfs-ds-help v0.1.1
 	github.com/ipfs/go-ipfs-files v0.0.8
 	github.com/ipfs/go-ipfs-http-client v0.0.5
+	github.com/ipfs/go-ipld-cbor v0.0.4
+	github.com/ipfs/go-ipld-format v0.2.0
+	github.com/ipfs/go-ipns v0.0.2
+	github.com/ipfs/go-merkledag v0.3.2
+	github.com/ipfs/go-path v0.0.7
+	github.com/ipfs/go-unixfs v0.2.4
+	github.com/ipfs/go-verifcid v0.0.1
 	github.com/ipfs/interface-go-ipfs-core v0.2.7
+	github.com/ipld/go-ipld-prime v0.0.2-0.20191108012745-28a82f04c785
+	github.com/ipld/go-ipld-prime-proto v0.0.0-20191113031812-e32bd156a1e5
+	github.com/libp2p/go-libp2p v0.8.3
 	github.com/libp2p/go-libp2p-core v0.5.3
+	github.com/libp2p/go-libp2p-kad-dht v0.7.11
+	github.com/libp2p/go-libp2p-record v0.1.2
+	github.com/libp2p/go-libp2p-transport-upgrader v0.2.0
 	github.com/multiformats/go-multiaddr v0.2.1
+	github.com/multiformats/go-multiaddr-net v0.1.5
+	github.com/multiformats/go-multihash v0.0.13
 	github.com/urfave/cli v1.21.0
+	github.com/whyrusleeping/multiaddr-filter v0.0.0-20160516205228-e903e4adabd7
+	golang.org/x/crypto v0.0.0-20200423211502-4bdfaf469ed5
+	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e
+	golang.org/x/sys v0.0.0-20200420163511-1957bb5e6d1f
 	gopkg.in/cheggaaa/pb.v1 v1.0.28
 )
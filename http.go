@@ -1,17 +1,34 @@
-package main
+package ipget
 
 import (
 	"context"
+	"fmt"
 
 	ipfshttp "github.com/ipfs/go-ipfs-http-client"
 	iface "github.com/ipfs/interface-go-ipfs-core"
+	ma "github.com/multiformats/go-multiaddr"
 )
 
-func http(ctx context.Context) (iface.CoreAPI, error) {
-	httpApi, err := ipfshttp.NewLocalApi()
+// http connects to the API of a running daemon at addr, or to the one whose
+// address is recorded in the local repo if addr is empty.
+func http(ctx context.Context, addr, userAgent string) (iface.CoreAPI, error) {
+	var httpApi *ipfshttp.HttpApi
+	var err error
+	if addr != "" {
+		maddr, merr := ma.NewMultiaddr(addr)
+		if merr != nil {
+			return nil, fmt.Errorf("invalid API address %q: %s", addr, merr)
+		}
+		httpApi, err = ipfshttp.NewApi(maddr)
+	} else {
+		httpApi, err = ipfshttp.NewLocalApi()
+	}
 	if err != nil {
 		return nil, err
 	}
+	if userAgent != "" {
+		httpApi.Headers.Set("User-Agent", userAgent)
+	}
 	err = httpApi.Request("version").Exec(ctx, nil)
 	if err != nil {
 		return nil, err
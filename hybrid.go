@@ -0,0 +1,85 @@
+package ipget
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+)
+
+// hybridDAG fetches every node through the node, and only the ones that
+// don't come within timeout from the gateways. Blocks from the gateways are
+// verified, then stored so that the node has them too. Its GetMany fetches
+// each node on its own, so one missing block doesn't hold up the others.
+type hybridDAG struct {
+	api     iface.CoreAPI
+	g       *gatewayFetcher
+	timeout time.Duration
+	log     Logger
+	// fromGateway counts the blocks the gateways had to send.
+	fromGateway int64
+}
+
+func (d *hybridDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	nctx, cancel := context.WithTimeout(ctx, d.timeout)
+	nd, err := d.api.Dag().Get(nctx, c)
+	cancel()
+	if err == nil || ctx.Err() != nil {
+		return nd, err
+	}
+	b, gerr := d.g.block(ctx, c)
+	if gerr != nil {
+		logger(ctx, d.log).Printf("block %s: not through the node (%s), nor from the gateways: %s", c, err, gerr)
+		return nil, gerr
+	}
+	atomic.AddInt64(&d.fromGateway, 1)
+	if err := putBlock(ctx, d.api, c, b.RawData()); err != nil {
+		return nil, err
+	}
+	return ipld.Decode(b)
+}
+
+func (d *hybridDAG) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, len(cids))
+	var wg sync.WaitGroup
+	for _, c := range cids {
+		wg.Add(1)
+		go func(c cid.Cid) {
+			defer wg.Done()
+			nd, err := d.Get(ctx, c)
+			out <- &ipld.NodeOption{Node: nd, Err: err}
+		}(c)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+func (d *hybridDAG) Add(context.Context, ipld.Node) error { return errGatewayReadOnly }
+
+func (d *hybridDAG) AddMany(context.Context, []ipld.Node) error { return errGatewayReadOnly }
+
+func (d *hybridDAG) Remove(context.Context, cid.Cid) error { return errGatewayReadOnly }
+
+func (d *hybridDAG) RemoveMany(context.Context, []cid.Cid) error { return errGatewayReadOnly }
+
+// GatewayBlocks returns how many blocks had to come from the gateways, one
+// at a time, during the latest Get. It is 0 unless GatewayBlockTimeout is
+// set.
+func (s *Session) GatewayBlocks() int {
+	return s.latest().GatewayBlocks()
+}
+
+// gatewayBlocks returns how many blocks came from the gateways so far.
+func (d *hybridDAG) gatewayBlocks() int64 {
+	if d == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&d.fromGateway)
+}
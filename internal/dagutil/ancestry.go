@@ -0,0 +1,42 @@
+package dagutil
+
+import (
+	"fmt"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// cycleError is what a walk of a DAG returns when a block links back to one
+// above it. A block can't link to itself, even through others, when it
+// hashes to its CID; one that does was taken on trust, such as from a
+// --trust-gateway, and following it would never end.
+type cycleError struct {
+	c cid.Cid
+}
+
+func (e *cycleError) Error() string {
+	return fmt.Sprintf("cycle detected at CID %s", e.c)
+}
+
+// Ancestry is the path from the root of a walk down to a block, the block
+// last. A nil Ancestry is that of the root, which has nothing above it.
+type Ancestry struct {
+	up *Ancestry
+	c  cid.Cid
+}
+
+// Root returns the ancestry of c, the root of a walk.
+func Root(c cid.Cid) *Ancestry {
+	return &Ancestry{c: c}
+}
+
+// Child returns the ancestry of c, reached from the last block of a, or a
+// cycle error if c is on the path already.
+func (a *Ancestry) Child(c cid.Cid) (*Ancestry, error) {
+	for p := a; p != nil; p = p.up {
+		if p.c.Equals(c) {
+			return nil, &cycleError{c: c}
+		}
+	}
+	return &Ancestry{up: a, c: c}, nil
+}
@@ -0,0 +1,9 @@
+package dagutil
+
+// CARv2Pragma starts every CARv2 file. It reads as a CARv1 header announcing
+// version 2, so v1-only readers fail cleanly.
+var CARv2Pragma = []byte{0x0a, 0xa1, 0x67, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x02}
+
+// CARv2HeaderLen is the size of the fixed CARv2 header following the
+// pragma: 16 bytes of characteristics and three uint64 offsets.
+const CARv2HeaderLen = 40
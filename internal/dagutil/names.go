@@ -0,0 +1,19 @@
+package dagutil
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// CheckName makes sure name, that of a directory entry in a DAG, names a
+// file in that directory and nothing else. Names are whatever the maker of
+// the DAG chose, and one such as ".." or "../x", joined to the path of its
+// directory, would be written outside of it.
+func CheckName(name string) error {
+	if name == "" || name == "." || name == ".." ||
+		strings.ContainsRune(name, '/') || strings.ContainsRune(name, filepath.Separator) {
+		return fmt.Errorf("refusing the directory entry %q, it isn't a plain file name", name)
+	}
+	return nil
+}
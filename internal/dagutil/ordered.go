@@ -0,0 +1,187 @@
+// Package dagutil holds the helpers walking and checking IPFS DAGs that
+// the ipget library and command share.
+package dagutil
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	unixfs "github.com/ipfs/go-unixfs"
+	unixfspb "github.com/ipfs/go-unixfs/pb"
+)
+
+// MaxBlockSize is the largest block ipfs implementations produce and
+// exchange.
+const MaxBlockSize = 2 << 20
+
+// orderedWindow is how many blocks an orderedReader requests ahead of the
+// one it is currently returning, unless told otherwise.
+const orderedWindow = 32
+
+// orderedReader streams a unixfs file by walking its DAG depth first. Blocks
+// are requested strictly in the order they are needed, leftmost first, and
+// at most window of them are in flight or buffered at once. This gets the
+// first bytes out quickly and keeps memory bounded no matter how the blocks
+// arrive. Each block is requested on its own, so they are spread over
+// whichever providers have them.
+//
+// maxMemory, if positive, also bounds the bytes of the blocks in flight or
+// buffered. A block is counted from when it is requested until its data is
+// read, at the size its parent's link gives, capped to maxBlock, until it
+// arrives. Once the bound is reached no more blocks are requested, which
+// holds the fetch back until the data is read; one block is always let
+// through so a bound below the block size still makes progress.
+type orderedReader struct {
+	ctx       context.Context
+	getter    ipld.NodeGetter
+	window    int
+	maxMemory int64
+	maxBlock  int64
+
+	// held is the bytes counted against maxMemory, current those of the
+	// block whose data is in buf.
+	held, current int64
+
+	// queue holds the blocks still to be read, in file order.
+	queue []*pendingBlock
+	buf   []byte
+	err   error
+}
+
+type pendingBlock struct {
+	c cid.Cid
+	// up is the path from the root down to the block's parent.
+	up *Ancestry
+	// size is what the block is counted as against maxMemory until it
+	// arrives.
+	size int64
+	done chan struct{}
+	nd   ipld.Node
+	err  error
+}
+
+// NewOrderedReader reads the unixfs file at root through an orderedReader,
+// with up to window blocks in flight, orderedWindow if 0, and up to
+// maxMemory bytes of blocks held, no bound if 0. maxBlock is the largest
+// block expected, MaxBlockSize if 0.
+func NewOrderedReader(ctx context.Context, getter ipld.NodeGetter, root cid.Cid, window int, maxMemory, maxBlock int64) io.Reader {
+	if window <= 0 {
+		window = orderedWindow
+	}
+	if maxBlock <= 0 {
+		maxBlock = MaxBlockSize
+	}
+	r := &orderedReader{ctx: ctx, getter: getter, window: window, maxMemory: maxMemory, maxBlock: maxBlock}
+	r.queue = []*pendingBlock{{c: root, size: maxBlock}}
+	r.fill()
+	return r
+}
+
+// fill starts fetching the blocks at the front of the queue that fall within
+// the window and the memory bound and haven't been requested yet.
+func (r *orderedReader) fill() {
+	for i := 0; i < len(r.queue) && i < r.window; i++ {
+		pb := r.queue[i]
+		if pb.done != nil {
+			continue
+		}
+		if r.maxMemory > 0 && r.held > 0 && r.held+pb.size > r.maxMemory {
+			return
+		}
+		r.held += pb.size
+		pb.done = make(chan struct{})
+		go func(pb *pendingBlock) {
+			pb.nd, pb.err = r.getter.Get(r.ctx, pb.c)
+			close(pb.done)
+		}(pb)
+	}
+}
+
+// next waits for the block at the front of the queue, replaces it by its
+// children, and buffers its own data.
+func (r *orderedReader) next() error {
+	// the data of the block before has been read
+	r.held -= r.current
+	r.current = 0
+	r.fill()
+	pb := r.queue[0]
+	select {
+	case <-pb.done:
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	}
+	if pb.err != nil {
+		return pb.err
+	}
+
+	data, err := FileData(pb.nd)
+	if err != nil {
+		return err
+	}
+
+	path, err := pb.up.Child(pb.c)
+	if err != nil {
+		return err
+	}
+	links := pb.nd.Links()
+	children := make([]*pendingBlock, len(links), len(links)+len(r.queue)-1)
+	for i, l := range links {
+		if _, err := path.Child(l.Cid); err != nil {
+			return err
+		}
+		size := int64(l.Size)
+		if size <= 0 || size > r.maxBlock {
+			// the size of a link is that of everything below it
+			size = r.maxBlock
+		}
+		children[i] = &pendingBlock{c: l.Cid, up: path, size: size}
+	}
+	r.queue = append(children, r.queue[1:]...)
+	// the block now counts as what it is, until its data is read
+	r.current = int64(len(pb.nd.RawData()))
+	r.held += r.current - pb.size
+	r.buf = data
+	r.fill()
+	return nil
+}
+
+func (r *orderedReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if len(r.queue) == 0 {
+			return 0, io.EOF
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// FileData returns the file content stored directly in nd, not counting its
+// children.
+func FileData(nd ipld.Node) ([]byte, error) {
+	switch nd := nd.(type) {
+	case *dag.RawNode:
+		return nd.RawData(), nil
+	case *dag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+		if err != nil {
+			return nil, err
+		}
+		switch fsn.Type() {
+		case unixfspb.Data_File, unixfspb.Data_Raw:
+			return fsn.Data(), nil
+		default:
+			return nil, fmt.Errorf("%s is not a file", nd.Cid())
+		}
+	default:
+		return nil, fmt.Errorf("%s is not a unixfs node", nd.Cid())
+	}
+}
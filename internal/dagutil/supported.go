@@ -0,0 +1,64 @@
+package dagutil
+
+import (
+	"fmt"
+
+	cid "github.com/ipfs/go-cid"
+	verifcid "github.com/ipfs/go-verifcid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// DagJSON is the multicodec of dag-json, which this version of go-cid
+// doesn't know about.
+const DagJSON = 0x0129
+
+// minHashLength is the shortest digest blocks are accepted with, as
+// enforced by go-verifcid.
+const minHashLength = 20
+
+// SupportedCodecs are the codecs of the nodes ipget can write out.
+var SupportedCodecs = map[uint64]string{
+	cid.DagProtobuf: "dag-pb",
+	cid.Raw:         "raw",
+	cid.DagCBOR:     "dag-cbor",
+	DagJSON:         "dag-json",
+}
+
+// SupportedHash reports whether blocks hashed with code can be verified:
+// the blockstore must allow the function and go-multihash compute it.
+func SupportedHash(code uint64) bool {
+	if !verifcid.IsGoodHash(code) {
+		return false
+	}
+	_, err := mh.Sum(nil, code, -1)
+	return err == nil
+}
+
+// HashName returns the name of the multihash function code.
+func HashName(code uint64) string {
+	if name, ok := mh.Codes[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%x", code)
+}
+
+// CheckSupported explains why c can't be fetched by this build, if it
+// can't, so the failure doesn't surface as a verification error deep in
+// the fetch.
+func CheckSupported(c cid.Cid) error {
+	pref := c.Prefix()
+	if _, ok := SupportedCodecs[pref.Codec]; !ok {
+		codec, ok := cid.CodecToStr[pref.Codec]
+		if !ok {
+			codec = fmt.Sprintf("0x%x", pref.Codec)
+		}
+		return fmt.Errorf("%s uses the codec %s, which this build of ipget can't read (see ipget --list-supported)", c, codec)
+	}
+	if !SupportedHash(pref.MhType) {
+		return fmt.Errorf("%s uses the hash function %s, which this build of ipget doesn't support (see ipget --list-supported)", c, HashName(pref.MhType))
+	}
+	if pref.MhType != mh.ID && pref.MhLength < minHashLength {
+		return fmt.Errorf("%s uses a %d byte %s digest, blocks need at least %d bytes to be verified", c, pref.MhLength, HashName(pref.MhType), minHashLength)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package ipget
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	blocks "github.com/ipfs/go-block-format"
+	files "github.com/ipfs/go-ipfs-files"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget/internal/dagutil"
+)
+
+// ipldFile returns the block of a dag-cbor or dag-json node as a file. With
+// decode set, a dag-cbor node is converted to JSON, and any remaining path is
+// resolved to the field it names inside the node.
+func ipldFile(ctx context.Context, ipfs iface.CoreAPI, rp ipath.Resolved, decode bool) (files.Node, error) {
+	r, err := ipfs.Block().Get(ctx, rp)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case !decode && rp.Remainder() != "":
+		return nil, fmt.Errorf("%q is a field inside block %s, use --decode to extract it", rp.Remainder(), rp.Cid())
+	case !decode:
+		return files.NewBytesFile(data), nil
+	case rp.Cid().Type() == dagutil.DagJSON && rp.Remainder() == "":
+		// already JSON
+		return files.NewBytesFile(data), nil
+	case rp.Cid().Type() == dagutil.DagJSON:
+		return nil, fmt.Errorf("can't resolve %q inside dag-json block %s", rp.Remainder(), rp.Cid())
+	}
+
+	blk, err := blocks.NewBlockWithCid(data, rp.Cid())
+	if err != nil {
+		return nil, err
+	}
+	nd, err := cbor.DecodeBlock(blk)
+	if err != nil {
+		return nil, err
+	}
+	var out []byte
+	if rp.Remainder() == "" {
+		out, err = nd.(*cbor.Node).MarshalJSON()
+	} else {
+		var val interface{}
+		val, _, err = nd.Resolve(strings.Split(rp.Remainder(), "/"))
+		if err == nil {
+			out, err = json.Marshal(val)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return files.NewBytesFile(bytes.TrimSpace(out)), nil
+}
@@ -0,0 +1,38 @@
+package ipget
+
+import (
+	"context"
+)
+
+// Logger receives the lines a Session logs, such as a fallback to the
+// gateways or a peer cut off. A *log.Logger is one. The logging of the
+// IPFS and libp2p subsystems underneath isn't affected, it is set up on
+// its own through go-log.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// nopLogger drops every line. It is what a Session logs to unless
+// Options.Logger is set.
+type nopLogger struct{}
+
+func (nopLogger) Printf(string, ...interface{}) {}
+
+type loggerKey struct{}
+
+// WithLogger sends the lines logged while fetching and reading the content
+// of a single Get to l, rather than to the Session's Logger. Passing nil
+// keeps the Session's, which is the default.
+func WithLogger(l Logger) GetOption {
+	return func(s *getSettings) {
+		s.logger = l
+	}
+}
+
+// logger returns the Logger ctx carries for its Get, or l if none.
+func logger(ctx context.Context, l Logger) Logger {
+	if ctxl, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return ctxl
+	}
+	return l
+}
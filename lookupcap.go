@@ -0,0 +1,105 @@
+package ipget
+
+import (
+	"context"
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+)
+
+// lookupCap bounds the rounds of the iterative DHT lookups of a spawned
+// node. The peers a lookup starts from are its first round, and the closer
+// peers one of them returns are the round after its own. Once the lookup
+// is about to ask a peer past the last round, it ends there, keeping the
+// providers it found, so its latency is bounded at the cost of finding
+// fewer of them.
+type lookupCap struct {
+	rounds int
+
+	mu     sync.Mutex
+	capped int
+}
+
+// Capped returns how many lookups were cut short.
+func (l *lookupCap) Capped() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.capped
+}
+
+// bound returns a context for a lookup, cancelled once the lookup goes
+// past the last round. The query events are passed on to those ctx was
+// registered for, if any, such as --explain's.
+func (l *lookupCap) bound(ctx context.Context) (context.Context, context.CancelFunc) {
+	outer := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	ctx, events := routing.RegisterForQueryEvents(ctx)
+	go func() {
+		round := map[peer.ID]int{}
+		capped := false
+		for ev := range events {
+			routing.PublishQueryEvent(outer, ev)
+			switch ev.Type {
+			case routing.SendingQuery:
+				if round[ev.ID] == 0 {
+					round[ev.ID] = 1
+				}
+				if round[ev.ID] > l.rounds && !capped {
+					capped = true
+					l.mu.Lock()
+					l.capped++
+					l.mu.Unlock()
+					cancel()
+				}
+			case routing.PeerResponse:
+				next := round[ev.ID] + 1
+				if next == 1 {
+					next = 2
+				}
+				for _, p := range ev.Responses {
+					if _, ok := round[p.ID]; !ok {
+						round[p.ID] = next
+					}
+				}
+			}
+		}
+	}()
+	return ctx, cancel
+}
+
+// cappedLookupRouting ends its provider and peer lookups at the last round
+// of its lookupCap.
+type cappedLookupRouting struct {
+	routing.Routing
+	cap *lookupCap
+}
+
+func (r *cappedLookupRouting) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	lctx, cancel := r.cap.bound(ctx)
+	in := r.Routing.FindProvidersAsync(lctx, c, count)
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		defer cancel()
+		// the providers found before the cap are still passed on
+		for prov := range in {
+			select {
+			case out <- prov:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (r *cappedLookupRouting) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInfo, error) {
+	ctx, cancel := r.cap.bound(ctx)
+	defer cancel()
+	return r.Routing.FindPeer(ctx, p)
+}
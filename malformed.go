@@ -0,0 +1,56 @@
+package ipget
+
+import (
+	"context"
+	"sync/atomic"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+)
+
+// malformedFilter drops the provider records found through the DHT whose
+// peer ID doesn't decode, so that a misbehaving peer's answer costs us that
+// entry rather than a dial to nowhere. The DHT already skips the addresses
+// that don't decode, one by one, but passes peer IDs on as they came.
+type malformedFilter struct {
+	dropped int64
+}
+
+// wrap returns rt with its provider lookups filtered.
+func (f *malformedFilter) wrap(rt routing.Routing) routing.Routing {
+	return &malformedFilteredRouting{Routing: rt, filter: f}
+}
+
+// Dropped returns how many malformed provider records were dropped.
+func (f *malformedFilter) Dropped() int {
+	if f == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&f.dropped))
+}
+
+type malformedFilteredRouting struct {
+	routing.Routing
+	filter *malformedFilter
+}
+
+func (r *malformedFilteredRouting) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	in := r.Routing.FindProvidersAsync(ctx, c, count)
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		for prov := range in {
+			if _, err := peer.IDFromBytes([]byte(prov.ID)); err != nil {
+				atomic.AddInt64(&r.filter.dropped, 1)
+				continue
+			}
+			select {
+			case out <- prov:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,168 @@
+package ipget
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	ipns "github.com/ipfs/go-ipns"
+	ipnspb "github.com/ipfs/go-ipns/pb"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// DefaultResolveCacheTTL is how long a resolved IPNS name is reused for by
+// default, the same as the cache of go-ipfs, so that a long session still
+// sees new records.
+const DefaultResolveCacheTTL = time.Minute
+
+// nameCache resolves IPNS names for a session, at most a few at a time.
+// Resolutions are reused for ttl, and asking for a name while it is being
+// resolved waits for that resolution rather than starting another.
+// Failures aren't kept.
+type nameCache struct {
+	// sem holds a token per resolution running, nil for no limit.
+	sem chan struct{}
+	ttl time.Duration
+	// configured is set when ttl was asked for rather than the default,
+	// which --watch then resolves through.
+	configured bool
+	// record, if set, looks up the IPNS record of a key, which keys are
+	// then resolved through so that a record valid for less than ttl is
+	// only reused while it is.
+	record func(context.Context, peer.ID) (*ipnspb.IpnsEntry, error)
+
+	mu    sync.Mutex
+	names map[string]*nameEntry
+}
+
+type nameEntry struct {
+	// done is closed once p and err are set.
+	done    chan struct{}
+	p       ipath.Path
+	err     error
+	expires time.Time
+}
+
+// newNameCache returns a cache running at most concurrency resolutions at
+// once, any number if it's 0, and reusing them for ttl,
+// DefaultResolveCacheTTL if it's 0.
+func newNameCache(concurrency int, ttl time.Duration) *nameCache {
+	nc := &nameCache{names: make(map[string]*nameEntry), ttl: ttl, configured: ttl > 0}
+	if ttl <= 0 {
+		nc.ttl = DefaultResolveCacheTTL
+	}
+	if concurrency > 0 {
+		nc.sem = make(chan struct{}, concurrency)
+	}
+	return nc
+}
+
+// resolve returns the path the IPNS name, a key or a domain, points to.
+func (nc *nameCache) resolve(ctx context.Context, ipfs iface.CoreAPI, name string) (ipath.Path, error) {
+	nc.mu.Lock()
+	e, ok := nc.names[name]
+	if ok && isClosed(e.done) && time.Now().After(e.expires) {
+		ok = false
+	}
+	if !ok {
+		e = &nameEntry{done: make(chan struct{})}
+		nc.names[name] = e
+		nc.mu.Unlock()
+		e.p, e.expires, e.err = nc.lookup(ctx, ipfs, name)
+		if e.err != nil {
+			nc.forget(name, e)
+		}
+		close(e.done)
+		return e.p, e.err
+	}
+	nc.mu.Unlock()
+
+	select {
+	case <-e.done:
+		return e.p, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// lookup resolves name and returns until when the result can be reused.
+func (nc *nameCache) lookup(ctx context.Context, ipfs iface.CoreAPI, name string) (ipath.Path, time.Time, error) {
+	if nc.sem != nil {
+		select {
+		case nc.sem <- struct{}{}:
+			defer func() { <-nc.sem }()
+		case <-ctx.Done():
+			return nil, time.Time{}, ctx.Err()
+		}
+	}
+	expires := time.Now().Add(nc.ttl)
+	pid, err := peer.Decode(name)
+	if nc.record == nil || err != nil {
+		// DNSLink names have no record to go by
+		p, err := ipfs.Name().Resolve(ctx, "/ipns/"+name)
+		return p, expires, err
+	}
+
+	entry, err := nc.record(ctx, pid)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if ttl := time.Duration(entry.GetTtl()); ttl > 0 && time.Now().Add(ttl).Before(expires) {
+		expires = time.Now().Add(ttl)
+	}
+	if eol, err := ipns.GetEOL(entry); err == nil && eol.Before(expires) {
+		expires = eol
+	}
+	p := ipath.New(string(entry.GetValue()))
+	if err := p.IsValid(); err != nil {
+		return nil, time.Time{}, err
+	}
+	if p.Namespace() == "ipns" {
+		// a name pointing at another one
+		p, err = ipfs.Name().Resolve(ctx, p.String())
+	}
+	return p, expires, err
+}
+
+// forget drops e, unless it was replaced already.
+func (nc *nameCache) forget(name string, e *nameEntry) {
+	nc.mu.Lock()
+	if nc.names[name] == e {
+		delete(nc.names, name)
+	}
+	nc.mu.Unlock()
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// IPNSRecord fetches the IPNS record of pid through a spawned node and
+// checks its signature and validity again. A daemon doesn't hand out the
+// records it resolves through, so it fails when fetching through one.
+func (s *Session) IPNSRecord(ctx context.Context, pid peer.ID) (*ipnspb.IpnsEntry, error) {
+	if !s.Embedded() {
+		return nil, fmt.Errorf("only a spawned node can look up IPNS records")
+	}
+	key := ipns.RecordKey(pid)
+	value, err := s.node.Routing.GetValue(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := (ipns.Validator{KeyBook: s.node.Peerstore}).Validate(key, value); err != nil {
+		return nil, err
+	}
+	entry := new(ipnspb.IpnsEntry)
+	if err := entry.Unmarshal(value); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
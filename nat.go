@@ -0,0 +1,38 @@
+package ipget
+
+import (
+	"context"
+
+	event "github.com/libp2p/go-libp2p-core/event"
+	host "github.com/libp2p/go-libp2p-core/host"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// logExternalAddrs logs every public address h learns it can be reached at,
+// such as the ones mapped on the router by NAT port mapping, until ctx is
+// done.
+func logExternalAddrs(ctx context.Context, h host.Host, l Logger) {
+	sub, err := h.EventBus().Subscribe(new(event.EvtLocalAddressesUpdated))
+	if err != nil {
+		l.Printf("failed to watch for external addresses: %s", err)
+		return
+	}
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				for _, addr := range e.(event.EvtLocalAddressesUpdated).Current {
+					if addr.Action == event.Added && manet.IsPublicAddr(addr.Address) {
+						l.Printf("external address: %s", addr.Address)
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
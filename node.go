@@ -1,23 +1,211 @@
-package main
+package ipget
 
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
 
-	"github.com/ipfs/go-ipfs-config"
+	"github.com/ipfs/go-datastore"
+	config "github.com/ipfs/go-ipfs-config"
 	"github.com/ipfs/go-ipfs/core"
-	"github.com/ipfs/go-ipfs/core/coreapi"
 	"github.com/ipfs/go-ipfs/core/node/libp2p"
 	"github.com/ipfs/go-ipfs/plugin/loader"
+	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/repo/fsrepo"
-	"github.com/ipfs/interface-go-ipfs-core"
+	p2p "github.com/libp2p/go-libp2p"
+	host "github.com/libp2p/go-libp2p-core/host"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	record "github.com/libp2p/go-libp2p-record"
 )
 
 type CfgOpt func(*config.Config)
 
-func spawn(ctx context.Context) (iface.CoreAPI, error) {
+// nodeOpts configures the nodes ipget spawns.
+type nodeOpts struct {
+	// cfg are applied to the node's in-memory config.
+	cfg []CfgOpt
+	// host are extra options for the node's libp2p host. They come after
+	// the ones derived from the config and so take precedence.
+	host []p2p.Option
+	// validators validate DHT records of namespaces other than the ones
+	// go-ipfs knows about.
+	validators map[string]record.Validator
+	// limit rejects oversized blocks as they are stored, if set.
+	limit *blockLimit
+	// blocks counts the blocks stored and read back, if set.
+	blocks *blockCounter
+	// swarmKey, if set, is used instead of the repo's swarm.key to join a
+	// private network.
+	swarmKey []byte
+	// maxDials caps the outbound dials in flight at once, 0 leaves the
+	// libp2p default.
+	maxDials int
+	// addrTTL, if set, is how long the peerstore keeps the addresses it
+	// learns of other peers.
+	addrTTL time.Duration
+	// relays, if set, filters relay-only providers out of DHT lookups.
+	relays *relayFilter
+	// malformed, if set, filters malformed provider records out of DHT
+	// lookups.
+	malformed *malformedFilter
+	// noVerifyRecords accepts DHT records that fail validation.
+	noVerifyRecords bool
+	// meter, if set, counts the blocks each peer sends over bitswap.
+	meter *trafficMeter
+	// guard, if set, cuts off the peers sending bad blocks over bitswap
+	// and leaves them out of provider lookups.
+	guard *blockGuard
+	// addrs, if set, is asked for the addresses of peers before the DHT, or
+	// after it if addrsAfterDHT is set.
+	addrs         AddrResolver
+	addrsAfterDHT bool
+	// providerFilter, if set, decides which of the providers found through
+	// the DHT are used.
+	providerFilter func(peer.AddrInfo) bool
+	// clusterLevel, if set, is the cluster level of the DHT queries sent.
+	clusterLevel int
+	// router builds the routing system the filters above wrap, a DHT client
+	// if nil.
+	router libp2p.RoutingOption
+	// first, if set, ends provider lookups at the first provider found
+	// while it is narrowed.
+	first *firstProvider
+	// lookups, if set, bounds the rounds of DHT lookups.
+	lookups *lookupCap
+	// protocols, if set, leaves the peers it refused out of provider
+	// lookups.
+	protocols *protocolGuard
+	// refresh, if set, looks up again the addresses of the providers that
+	// can't be dialed.
+	refresh *addrRefresher
+	// providers, if set, notes the providers lookups find.
+	providers *providerTracker
+	// policy, if set, leaves the providers it excludes out of provider
+	// lookups, and keeps bitswap from talking to them.
+	policy *peerPolicy
+	// log is where the node's wrappers log to, nothing if nil.
+	log Logger
+}
+
+// logger returns where the node's wrappers log to.
+func (o nodeOpts) logger() Logger {
+	if o.log == nil {
+		return nopLogger{}
+	}
+	return o.log
+}
+
+// routingOption builds the node's routing, a DHT client unless another
+// router is set, with our extra validators, any provider filters and
+// address resolver, its queries at the cluster level asked for, and record
+// validation turned off if asked to.
+func (o nodeOpts) routingOption() libp2p.RoutingOption {
+	base := o.router
+	if base == nil {
+		base = libp2p.DHTClientOption
+	}
+	wrapped := o.relays != nil || o.malformed != nil || o.addrs != nil || o.providerFilter != nil || o.guard != nil || o.first != nil || o.refresh != nil || o.protocols != nil || o.lookups != nil || o.providers != nil || o.policy != nil
+	if len(o.validators) == 0 && !o.noVerifyRecords && !wrapped && o.clusterLevel == 0 {
+		return base
+	}
+	return func(ctx context.Context, h host.Host, dstore datastore.Batching, validator record.Validator) (routing.Routing, error) {
+		if o.clusterLevel > 0 {
+			h = &clusterLevelHost{Host: h, level: o.clusterLevel}
+		}
+		if len(o.validators) > 0 {
+			validator = withValidators(validator, o.validators)
+		}
+		if o.noVerifyRecords {
+			validator = permissiveValidator{base: validator, log: o.logger()}
+		}
+		rt, err := base(ctx, h, dstore, validator)
+		if err != nil || !wrapped {
+			return rt, err
+		}
+		// go-ipfs only closes the DHT it knows the type of
+		if closer, ok := rt.(io.Closer); ok {
+			go func() {
+				<-ctx.Done()
+				closer.Close()
+			}()
+		}
+		if o.lookups != nil {
+			rt = &cappedLookupRouting{Routing: rt, cap: o.lookups}
+		}
+		if o.malformed != nil {
+			rt = o.malformed.wrap(rt)
+		}
+		if o.relays != nil {
+			rt = o.relays.wrap(rt)
+		}
+		if o.providerFilter != nil {
+			rt = &filteredProvidersRouting{Routing: rt, keep: o.providerFilter}
+		}
+		if o.guard != nil {
+			rt = &filteredProvidersRouting{Routing: rt, keep: o.guard.trusted}
+		}
+		if o.protocols != nil {
+			rt = &filteredProvidersRouting{Routing: rt, keep: o.protocols.allowed}
+		}
+		if o.policy != nil {
+			rt = &filteredProvidersRouting{Routing: rt, keep: o.policy.allowed}
+		}
+		if o.addrs != nil {
+			rt = &resolvingRouting{Routing: rt, resolver: o.addrs, ps: h.Peerstore(), after: o.addrsAfterDHT, log: o.logger()}
+		}
+		if o.refresh != nil {
+			// providers the filters dropped are never dialed anyway
+			rt = o.refresh.wrap(rt)
+		}
+		if o.providers != nil {
+			rt = o.providers.wrap(rt, h.Peerstore())
+		}
+		if o.first != nil {
+			// outermost, so the first provider is one the filters kept
+			rt = &firstProviderRouting{Routing: rt, first: o.first}
+		}
+		return rt, nil
+	}
+}
+
+// hostOption builds the node's libp2p host with our extra options.
+func (o nodeOpts) hostOption() libp2p.HostOption {
+	if len(o.host) == 0 && o.addrTTL == 0 && o.meter == nil && o.guard == nil && o.refresh == nil && o.policy == nil {
+		return libp2p.DefaultHostOption
+	}
+	return func(ctx context.Context, id peer.ID, ps peerstore.Peerstore, options ...p2p.Option) (host.Host, error) {
+		if o.addrTTL > 0 {
+			ps = &ttlPeerstore{Peerstore: ps, ttl: o.addrTTL}
+		}
+		h, err := libp2p.DefaultHostOption(ctx, id, ps, append(options, o.host...)...)
+		if err != nil {
+			return h, err
+		}
+		if o.meter != nil {
+			h = &meteredHost{Host: h, meter: o.meter}
+		}
+		if o.guard != nil {
+			h = &guardedHost{Host: h, guard: o.guard}
+		}
+		if o.policy != nil {
+			h = &policyHost{Host: h, policy: o.policy}
+		}
+		if o.refresh != nil {
+			h = &refreshingHost{Host: h, refresh: o.refresh}
+		}
+		return h, nil
+	}
+}
+
+func spawn(ctx context.Context, opts nodeOpts) (*core.IpfsNode, error) {
 	defaultPath, err := config.PathRoot()
 	if err != nil {
 		// shouldn't be possible
@@ -28,15 +216,46 @@ func spawn(ctx context.Context) (iface.CoreAPI, error) {
 		return nil, err
 	}
 
-	ipfs, err := open(ctx, defaultPath)
+	ipfs, err := open(ctx, defaultPath, opts)
 	if err == nil {
 		return ipfs, nil
 	}
 
-	return tmpNode(ctx)
+	return tmpNode(ctx, opts)
 }
 
+// openRepo starts a node on the repo at the default path. Unlike spawn it
+// fails rather than falling back to a temporary repo.
+func openRepo(ctx context.Context, opts nodeOpts) (*core.IpfsNode, error) {
+	defaultPath, err := config.PathRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setupPlugins(defaultPath); err != nil {
+		return nil, err
+	}
+
+	return open(ctx, defaultPath, opts)
+}
+
+var (
+	pluginsOnce sync.Once
+	pluginsErr  error
+)
+
+// setupPlugins loads the plugins of the repo at path. go-ipfs registers
+// what they provide globally and refuses to register it twice, so they are
+// loaded once per process, by the first node, and every later one shares
+// the outcome.
 func setupPlugins(path string) error {
+	pluginsOnce.Do(func() {
+		pluginsErr = loadPlugins(path)
+	})
+	return pluginsErr
+}
+
+func loadPlugins(path string) error {
 	// Load plugins. This will skip the repo if not available.
 	plugins, err := loader.NewPluginLoader(filepath.Join(path, "plugins"))
 	if err != nil {
@@ -54,27 +273,59 @@ func setupPlugins(path string) error {
 	return nil
 }
 
-func open(ctx context.Context, repoPath string) (iface.CoreAPI, error) {
+func open(ctx context.Context, repoPath string, opts nodeOpts) (*core.IpfsNode, error) {
 	// Open the repo
 	r, err := fsrepo.Open(repoPath)
 	if err != nil {
 		return nil, err
 	}
 
+	// Options only change the in-memory config, the repo on disk is left
+	// untouched.
+	cfg, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts.cfg {
+		opt(cfg)
+	}
+	if opts.limit.enabled() {
+		r = newLimitedRepo(r, opts.limit)
+	}
+	if opts.blocks != nil {
+		r = newCountingRepo(r, opts.blocks)
+	}
+	if opts.swarmKey != nil {
+		r = &swarmKeyRepo{Repo: r, key: opts.swarmKey}
+	}
+	if opts.maxDials > 0 {
+		// The swarm only reads its dial limit from the environment, when
+		// it is created.
+		if err := os.Setenv("LIBP2P_SWARM_FD_LIMIT", strconv.Itoa(opts.maxDials)); err != nil {
+			return nil, err
+		}
+	}
+
 	// Construct the node
-	node, err := core.NewNode(ctx, &core.BuildCfg{
+	return core.NewNode(ctx, &core.BuildCfg{
 		Online:  true,
-		Routing: libp2p.DHTClientOption,
+		Routing: opts.routingOption(),
+		Host:    opts.hostOption(),
 		Repo:    r,
 	})
-	if err != nil {
-		return nil, err
-	}
+}
+
+// swarmKeyRepo is a repo with a swarm key of our choosing.
+type swarmKeyRepo struct {
+	repo.Repo
+	key []byte
+}
 
-	return coreapi.NewCoreAPI(node)
+func (r *swarmKeyRepo) SwarmKey() ([]byte, error) {
+	return r.key, nil
 }
 
-func temp(ctx context.Context) (iface.CoreAPI, error) {
+func temp(ctx context.Context, opts nodeOpts) (*core.IpfsNode, error) {
 	defaultPath, err := config.PathRoot()
 	if err != nil {
 		// shouldn't be possible
@@ -85,10 +336,10 @@ func temp(ctx context.Context) (iface.CoreAPI, error) {
 		return nil, err
 	}
 
-	return tmpNode(ctx)
+	return tmpNode(ctx, opts)
 }
 
-func tmpNode(ctx context.Context) (iface.CoreAPI, error) {
+func tmpNode(ctx context.Context, opts nodeOpts) (*core.IpfsNode, error) {
 	dir, err := ioutil.TempDir("", "ipfs-shell")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get temp dir: %s", err)
@@ -107,5 +358,5 @@ func tmpNode(ctx context.Context) (iface.CoreAPI, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to init ephemeral node: %s", err)
 	}
-	return open(ctx, dir)
+	return open(ctx, dir, opts)
 }
@@ -0,0 +1,91 @@
+package ipget
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	host "github.com/libp2p/go-libp2p-core/host"
+	network "github.com/libp2p/go-libp2p-core/network"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	protocol "github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// peerPolicy restricts the peers a spawned node takes blocks from to those
+// an allowlist names, if there is one, and never those a denylist names.
+// The providers it excludes are left out of provider lookups, no bitswap
+// stream is opened to them, and those they open are reset before anything
+// is read from them. Other connections, to bootstrap peers and DHT servers,
+// are left alone: they never send blocks.
+type peerPolicy struct {
+	// allow is nil when every peer not denied is allowed.
+	allow map[peer.ID]bool
+	deny  map[peer.ID]bool
+
+	mu       sync.Mutex
+	excluded map[peer.ID]bool
+}
+
+func newPeerPolicy(allow, deny map[peer.ID]bool) *peerPolicy {
+	return &peerPolicy{allow: allow, deny: deny, excluded: map[peer.ID]bool{}}
+}
+
+// permits reports whether blocks may come from p, noting it as excluded
+// if not.
+func (pp *peerPolicy) permits(p peer.ID) bool {
+	if !pp.deny[p] && (pp.allow == nil || pp.allow[p]) {
+		return true
+	}
+	pp.mu.Lock()
+	pp.excluded[p] = true
+	pp.mu.Unlock()
+	return false
+}
+
+// allowed is permits for provider lookups.
+func (pp *peerPolicy) allowed(info peer.AddrInfo) bool {
+	return pp.permits(info.ID)
+}
+
+// Excluded returns how many peers were refused as providers.
+func (pp *peerPolicy) Excluded() int {
+	if pp == nil {
+		return 0
+	}
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	return len(pp.excluded)
+}
+
+func isBitswap(pid protocol.ID) bool {
+	return strings.HasPrefix(string(pid), "/ipfs/bitswap")
+}
+
+// policyHost only lets bitswap streams through to and from the peers
+// policy permits.
+type policyHost struct {
+	host.Host
+	policy *peerPolicy
+}
+
+func (h *policyHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	if len(pids) > 0 && isBitswap(pids[0]) && !h.policy.permits(p) {
+		return nil, fmt.Errorf("peer %s is excluded by the provider policy", p)
+	}
+	return h.Host.NewStream(ctx, p, pids...)
+}
+
+func (h *policyHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	if isBitswap(pid) {
+		inner := handler
+		handler = func(s network.Stream) {
+			if !h.policy.permits(s.Conn().RemotePeer()) {
+				s.Reset()
+				return
+			}
+			inner(s)
+		}
+	}
+	h.Host.SetStreamHandler(pid, handler)
+}
@@ -0,0 +1,62 @@
+package ipget
+
+import (
+	"context"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// AddrResolver looks up the addresses of a peer through something other
+// than the DHT, such as the service discovery of a private deployment.
+type AddrResolver interface {
+	// ResolvePeer returns the addresses p can be dialed at, none if it
+	// doesn't know of p.
+	ResolvePeer(ctx context.Context, p peer.ID) ([]ma.Multiaddr, error)
+}
+
+// resolvingRouting finds peers through an AddrResolver as well as through
+// the DHT, before it unless after is set. The addresses the resolver finds
+// are added to the peerstore, so they are dialed like any other.
+type resolvingRouting struct {
+	routing.Routing
+	resolver AddrResolver
+	ps       peerstore.Peerstore
+	after    bool
+	log      Logger
+}
+
+func (r *resolvingRouting) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInfo, error) {
+	if !r.after {
+		if info, ok := r.resolve(ctx, p); ok {
+			return info, nil
+		}
+		return r.Routing.FindPeer(ctx, p)
+	}
+	info, err := r.Routing.FindPeer(ctx, p)
+	if err == nil && len(info.Addrs) > 0 {
+		return info, nil
+	}
+	if resolved, ok := r.resolve(ctx, p); ok {
+		return resolved, nil
+	}
+	return info, err
+}
+
+// resolve asks the resolver for the addresses of p and records them.
+func (r *resolvingRouting) resolve(ctx context.Context, p peer.ID) (peer.AddrInfo, bool) {
+	addrs, err := r.resolver.ResolvePeer(ctx, p)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.log.Printf("resolving the addresses of %s: %s", p, err)
+		}
+		return peer.AddrInfo{}, false
+	}
+	if len(addrs) == 0 {
+		return peer.AddrInfo{}, false
+	}
+	r.ps.AddAddrs(p, addrs, peerstore.AddressTTL)
+	return peer.AddrInfo{ID: p, Addrs: addrs}, true
+}
@@ -0,0 +1,48 @@
+package ipget
+
+import (
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ttlPeerstore keeps the addresses learned about other peers for ttl,
+// whatever the code adding them asked for. Addresses of connected peers and
+// permanent ones, like our own, keep theirs.
+type ttlPeerstore struct {
+	peerstore.Peerstore
+	ttl time.Duration
+}
+
+// override returns the TTL to use instead of ttl.
+func (ps *ttlPeerstore) override(ttl time.Duration) time.Duration {
+	if ttl <= 0 || ttl >= peerstore.ConnectedAddrTTL {
+		// removals, and addresses that don't expire
+		return ttl
+	}
+	return ps.ttl
+}
+
+func (ps *ttlPeerstore) AddAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.AddAddr(p, addr, ps.override(ttl))
+}
+
+func (ps *ttlPeerstore) AddAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.AddAddrs(p, addrs, ps.override(ttl))
+}
+
+func (ps *ttlPeerstore) SetAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.SetAddr(p, addr, ps.override(ttl))
+}
+
+func (ps *ttlPeerstore) SetAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.SetAddrs(p, addrs, ps.override(ttl))
+}
+
+func (ps *ttlPeerstore) UpdateAddrs(p peer.ID, oldTTL time.Duration, newTTL time.Duration) {
+	// oldTTL selects addresses by the TTL they were stored with, which we
+	// replaced as well.
+	ps.Peerstore.UpdateAddrs(p, ps.override(oldTTL), ps.override(newTTL))
+}
@@ -0,0 +1,110 @@
+package ipget
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	files "github.com/ipfs/go-ipfs-files"
+)
+
+// progressInterval is the least time between two calls of a ProgressFunc,
+// other than the one made when a file is complete.
+const progressInterval = 100 * time.Millisecond
+
+// ProgressFunc is called as the content of a Get is read, with the number of
+// bytes read so far and the total expected, or -1 if that isn't known. It
+// runs on the goroutine reading the content, so it should return quickly.
+type ProgressFunc func(fetched, total int64)
+
+// GetOption configures a single Get.
+type GetOption func(*getSettings)
+
+type getSettings struct {
+	progress ProgressFunc
+	summary  *PathSummary
+	logger   Logger
+	report   *Report
+	// accurateSize works out the size of files that don't record it.
+	accurateSize bool
+}
+
+// WithProgress calls fn as the content returned by Get is read, at most once
+// every progressInterval and whenever a file is complete. Passing nil
+// disables it, which is the default.
+func WithProgress(fn ProgressFunc) GetOption {
+	return func(s *getSettings) {
+		s.progress = fn
+	}
+}
+
+// progress tracks the bytes read from a node and everything below it.
+type progress struct {
+	fn    ProgressFunc
+	total int64
+
+	mu      sync.Mutex
+	fetched int64
+	last    time.Time
+}
+
+// withProgress wraps nd so that reading it reports to fn.
+func withProgress(nd files.Node, fn ProgressFunc) files.Node {
+	total, err := nd.Size()
+	if err != nil {
+		total = -1
+	}
+	return (&progress{fn: fn, total: total}).wrap(nd)
+}
+
+func (p *progress) wrap(nd files.Node) files.Node {
+	switch nd := nd.(type) {
+	case files.File:
+		return &wrappedFile{File: nd, r: &progressReader{r: nd, p: p}}
+	case files.Directory:
+		return &progressDir{Directory: nd, p: p}
+	default:
+		return nd
+	}
+}
+
+// add counts n more bytes read, and calls fn unless it was called less than
+// progressInterval ago. force calls it regardless.
+func (p *progress) add(n int, force bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fetched += int64(n)
+	if now := time.Now(); force || now.Sub(p.last) >= progressInterval {
+		p.last = now
+		p.fn(p.fetched, p.total)
+	}
+}
+
+type progressReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.add(n, err == io.EOF)
+	return n, err
+}
+
+type progressDir struct {
+	files.Directory
+	p *progress
+}
+
+func (d *progressDir) Entries() files.DirIterator {
+	return &progressIter{DirIterator: d.Directory.Entries(), p: d.p}
+}
+
+type progressIter struct {
+	files.DirIterator
+	p *progress
+}
+
+func (it *progressIter) Node() files.Node {
+	return it.p.wrap(it.DirIterator.Node())
+}
@@ -0,0 +1,205 @@
+package ipget
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	event "github.com/libp2p/go-libp2p-core/event"
+	host "github.com/libp2p/go-libp2p-core/host"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// protocolFamilies are the protocols --min-protocol can set a minimum
+// version of, by the name it gives them, with the prefix of their IDs.
+var protocolFamilies = map[string]string{
+	"bitswap": "/ipfs/bitswap/",
+	"kad":     "/ipfs/kad/",
+}
+
+// protocolGuard watches the protocols the peers of a spawned node speak,
+// as identify reports them. It logs them if asked to, and cuts off the
+// peers that speak a protocol only in versions below the minimum set for
+// it, which old software does. Those are disconnected and left out of
+// provider lookups from then on.
+type protocolGuard struct {
+	// min are the minimum versions, by protocol family.
+	min map[string][]int
+	log bool
+	// logger is where the protocols are logged to.
+	logger Logger
+
+	mu      sync.Mutex
+	refused map[peer.ID]bool
+}
+
+// newProtocolGuard parses the --min-protocol specs, each <family>=<version>
+// such as bitswap=1.2.0.
+func newProtocolGuard(specs []string, logProtocols bool, l Logger) (*protocolGuard, error) {
+	g := &protocolGuard{min: map[string][]int{}, log: logProtocols, logger: l, refused: map[peer.ID]bool{}}
+	for _, spec := range specs {
+		i := strings.Index(spec, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("--min-protocol %q must look like <protocol>=<version>, e.g. bitswap=1.2.0", spec)
+		}
+		family := strings.ToLower(strings.TrimSpace(spec[:i]))
+		if _, ok := protocolFamilies[family]; !ok {
+			return nil, fmt.Errorf("--min-protocol %q: unknown protocol %q, the protocols are %s", spec, family, strings.Join(protocolNames(), ", "))
+		}
+		v, ok := parseProtocolVersion(strings.TrimSpace(spec[i+1:]))
+		if !ok {
+			return nil, fmt.Errorf("--min-protocol %q: the version must be dotted numbers, e.g. 1.2.0", spec)
+		}
+		g.min[family] = v
+	}
+	return g, nil
+}
+
+func protocolNames() []string {
+	var names []string
+	for name := range protocolFamilies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parseProtocolVersion(s string) ([]int, bool) {
+	if s == "" {
+		return nil, false
+	}
+	var v []int
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		v = append(v, n)
+	}
+	return v, true
+}
+
+// familyVersion reports whether proto belongs to the family of IDs
+// starting with prefix, and returns its version, nil if it doesn't parse.
+// The bare prefix, which is how bitswap named its first version, is 1.0.0.
+func familyVersion(proto, prefix string) ([]int, bool) {
+	if proto == strings.TrimSuffix(prefix, "/") {
+		return []int{1, 0, 0}, true
+	}
+	if !strings.HasPrefix(proto, prefix) {
+		return nil, false
+	}
+	v, _ := parseProtocolVersion(strings.TrimPrefix(proto, prefix))
+	return v, true
+}
+
+// olderVersion reports whether version a comes before b, missing parts
+// counting as 0.
+func olderVersion(a, b []int) bool {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	return false
+}
+
+// watch checks every peer of h once identify is done with it, until ctx
+// is done.
+func (g *protocolGuard) watch(ctx context.Context, h host.Host) {
+	sub, err := h.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if err != nil {
+		g.logger.Printf("failed to watch the protocols of peers: %s", err)
+		return
+	}
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				p := e.(event.EvtPeerIdentificationCompleted).Peer
+				protos, err := h.Peerstore().GetProtocols(p)
+				if err != nil {
+					continue
+				}
+				if !g.check(p, protos) {
+					h.Network().ClosePeer(p)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// check logs the protocols p speaks, if asked to, and reports whether it
+// speaks every protocol family with a minimum in a version new enough.
+// Families it doesn't speak at all are no reason to refuse it.
+func (g *protocolGuard) check(p peer.ID, protos []string) bool {
+	if g.log {
+		var ours []string
+		for _, proto := range protos {
+			for _, prefix := range protocolFamilies {
+				if _, ok := familyVersion(proto, prefix); ok {
+					ours = append(ours, proto)
+				}
+			}
+		}
+		if len(ours) > 0 {
+			sort.Strings(ours)
+			g.logger.Printf("peer %s speaks %s", p, strings.Join(ours, ", "))
+		}
+	}
+	for family, min := range g.min {
+		prefix := protocolFamilies[family]
+		spoken, recent := false, false
+		for _, proto := range protos {
+			v, ok := familyVersion(proto, prefix)
+			if !ok {
+				continue
+			}
+			spoken = true
+			if v != nil && !olderVersion(v, min) {
+				recent = true
+			}
+		}
+		if spoken && !recent {
+			g.mu.Lock()
+			g.refused[p] = true
+			g.mu.Unlock()
+			return false
+		}
+	}
+	return true
+}
+
+// allowed reports whether the peer wasn't refused.
+func (g *protocolGuard) allowed(info peer.AddrInfo) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return !g.refused[info.ID]
+}
+
+// Refused returns how many peers were cut off for speaking only old
+// protocol versions.
+func (g *protocolGuard) Refused() int {
+	if g == nil {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.refused)
+}
@@ -0,0 +1,34 @@
+package ipget
+
+import (
+	"context"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+)
+
+// filteredProvidersRouting only passes on the providers keep accepts.
+type filteredProvidersRouting struct {
+	routing.Routing
+	keep func(peer.AddrInfo) bool
+}
+
+func (r *filteredProvidersRouting) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	in := r.Routing.FindProvidersAsync(ctx, c, count)
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		for prov := range in {
+			if !r.keep(prov) {
+				continue
+			}
+			select {
+			case out <- prov:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,72 @@
+package ipget
+
+import (
+	"context"
+	"sync/atomic"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// relayFilter drops the providers found through the DHT that can only be
+// reached through a circuit relay, and strips relay addresses from the
+// others, so bitswap only asks peers it can dial directly. Providers
+// returned without any address are kept: their addresses are looked up
+// when they are dialed.
+type relayFilter struct {
+	dropped int64
+}
+
+// wrap returns rt with its provider lookups filtered.
+func (f *relayFilter) wrap(rt routing.Routing) routing.Routing {
+	return &relayFilteredRouting{Routing: rt, filter: f}
+}
+
+// Dropped returns how many provider records were dropped for having only
+// relay addresses.
+func (f *relayFilter) Dropped() int {
+	if f == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&f.dropped))
+}
+
+type relayFilteredRouting struct {
+	routing.Routing
+	filter *relayFilter
+}
+
+func (r *relayFilteredRouting) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	in := r.Routing.FindProvidersAsync(ctx, c, count)
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		for prov := range in {
+			direct := directAddrs(prov.Addrs)
+			if len(prov.Addrs) > 0 && len(direct) == 0 {
+				atomic.AddInt64(&r.filter.dropped, 1)
+				continue
+			}
+			prov.Addrs = direct
+			select {
+			case out <- prov:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// directAddrs returns the addresses in addrs that don't go through a relay.
+func directAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
+	var direct []ma.Multiaddr
+	for _, a := range addrs {
+		if _, err := a.ValueForProtocol(ma.P_CIRCUIT); err != nil {
+			direct = append(direct, a)
+		}
+	}
+	return direct
+}
@@ -0,0 +1,114 @@
+package ipget
+
+import (
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Report tells what a spawned node did for one Get: the blocks it fetched,
+// the peers that sent them, and why none came if none did. Pass one to Get
+// through WithReport. Its counts run from the start of the Get to when they
+// are asked for, the content being read lazily. Gets made at once share
+// the node, and the report of each also counts what the node did for the
+// others meanwhile.
+type Report struct {
+	s *Session
+	// the counts of the session as the Get began
+	fetched, deduplicated, seeded int
+	traffic                       map[peer.ID]PeerTraffic
+	gatewayBlocks                 int64
+	rerouted                      int
+	providers                     providerMark
+}
+
+// WithReport has Get fill in r.
+func WithReport(r *Report) GetOption {
+	return func(s *getSettings) {
+		s.report = r
+	}
+}
+
+// begin makes r the report of a Get of s starting now.
+func (r *Report) begin(s *Session) {
+	s.blocks.startJob()
+	fetched, deduplicated, seeded := s.blocks.counts()
+	*r = Report{
+		s:             s,
+		fetched:       fetched,
+		deduplicated:  deduplicated,
+		seeded:        seeded,
+		traffic:       s.traffic.snapshot(),
+		gatewayBlocks: s.hybrid.gatewayBlocks(),
+		rerouted:      s.reroute.Rerouted(),
+		providers:     s.providers.begin(fetched),
+	}
+}
+
+// PeerTraffic returns the bytes and blocks each peer sent over bitswap
+// during the Get, the peers that sent the most first. It is empty unless
+// PeerStats is set and a node was spawned.
+func (r *Report) PeerTraffic() []PeerTraffic {
+	if r == nil || r.s == nil {
+		return nil
+	}
+	return r.s.traffic.counts(r.traffic)
+}
+
+// BlockCounts returns the number of blocks fetched from the network during
+// the Get, the number of block references served from the blockstore
+// instead, and the number of blocks used from seed CARs, like
+// Session.BlockCounts does for the whole session.
+func (r *Report) BlockCounts() (fetched, deduplicated, seeded int) {
+	if r == nil || r.s == nil || !r.s.Embedded() {
+		return 0, 0, 0
+	}
+	fetched, deduplicated, seeded = r.s.blocks.counts()
+	return fetched - r.fetched, deduplicated - r.deduplicated, seeded - r.seeded
+}
+
+// GatewayBlocks returns how many blocks had to come from the gateways, one
+// at a time, during the Get. It is 0 unless GatewayBlockTimeout is set.
+func (r *Report) GatewayBlocks() int {
+	if r == nil || r.s == nil {
+		return 0
+	}
+	return int(r.s.hybrid.gatewayBlocks() - r.gatewayBlocks)
+}
+
+// ReroutedBlocks returns how many times, during the Get, a block that
+// didn't come within BlockTimeout was rerouted to other providers.
+func (r *Report) ReroutedBlocks() int {
+	if r == nil || r.s == nil {
+		return 0
+	}
+	return r.s.reroute.Rerouted() - r.rerouted
+}
+
+// ProviderRecords returns how many providers the lookups of a spawned node
+// found during the Get, and how many provider records they got in all.
+// Each provider is only dialed again when it comes with new addresses.
+func (r *Report) ProviderRecords() (unique, total int) {
+	if r == nil || r.s == nil || !r.s.Embedded() {
+		return 0, 0
+	}
+	return r.s.providers.Records(r.providers)
+}
+
+// Unavailable explains why the Get got no block at all: no provider was
+// found, none could be dialed, or none sent a block. The error is an
+// *Error of ErrNoProviders, ErrUndialable or ErrNotServed, and why is its
+// message's prefix. It is nil if blocks did arrive, or if the node isn't a
+// spawned one, which is the only kind that tells.
+func (r *Report) Unavailable(why string) error {
+	if r == nil || r.s == nil || !r.s.Embedded() {
+		return nil
+	}
+	fetched, _, _ := r.s.blocks.counts()
+	return r.s.providers.explain(r.s.node.PeerHost.Network(), r.providers, fetched, why)
+}
+
+// latest returns the report of the latest Get, nil before the first.
+func (s *Session) latest() *Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.report
+}
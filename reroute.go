@@ -0,0 +1,109 @@
+package ipget
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	host "github.com/libp2p/go-libp2p-core/host"
+	network "github.com/libp2p/go-libp2p-core/network"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+)
+
+// rerouteProviders is how many providers of a late block are looked up.
+const rerouteProviders = 5
+
+// blockRerouter keeps one slow peer from holding up a block. Bitswap keeps
+// asking the peers of its session for a block until it comes, so a block
+// only one slow peer was asked for can hold up a fetch for as long as that
+// peer takes. The rerouter watches the wantlist of a spawned node, and
+// every time a block has been wanted for timeout without coming it looks
+// up other providers of that very block and connects to them, which bitswap
+// then asks too. The lookups go through the node's routing, so the peers
+// cut off for sending bad blocks aren't among them.
+type blockRerouter struct {
+	timeout time.Duration
+	// log is nil unless every reroute is to be logged.
+	log Logger
+
+	mu       sync.Mutex
+	rerouted int
+}
+
+// Rerouted returns how many times a block was rerouted to other providers
+// since the node started.
+func (r *blockRerouter) Rerouted() int {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rerouted
+}
+
+// watch checks wants every so often until ctx is done, rerouting the
+// blocks wanted for longer than the timeout through rt and h.
+func (r *blockRerouter) watch(ctx context.Context, wants func() []cid.Cid, rt routing.ContentRouting, h host.Host) {
+	interval := r.timeout / 2
+	if interval > time.Second || interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// due is when each wanted block is next rerouted
+	due := map[cid.Cid]time.Time{}
+	for {
+		select {
+		case now := <-ticker.C:
+			wanted := map[cid.Cid]bool{}
+			for _, c := range wants() {
+				wanted[c] = true
+				at, ok := due[c]
+				if !ok {
+					due[c] = now.Add(r.timeout)
+					continue
+				}
+				if now.Before(at) {
+					continue
+				}
+				due[c] = now.Add(r.timeout)
+				go r.reroute(ctx, rt, h, c)
+			}
+			for c := range due {
+				if !wanted[c] {
+					delete(due, c)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reroute connects h to the providers of c it isn't connected to yet.
+func (r *blockRerouter) reroute(ctx context.Context, rt routing.ContentRouting, h host.Host, c cid.Cid) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	dialed := 0
+	for prov := range rt.FindProvidersAsync(ctx, c, rerouteProviders) {
+		if prov.ID == h.ID() || h.Network().Connectedness(prov.ID) == network.Connected {
+			continue
+		}
+		if err := h.Connect(ctx, prov); err == nil {
+			dialed++
+		}
+	}
+	if dialed == 0 {
+		if r.log != nil {
+			r.log.Printf("block %s: nothing after %s, and no other provider to ask", c, r.timeout)
+		}
+		return
+	}
+	r.mu.Lock()
+	r.rerouted++
+	r.mu.Unlock()
+	if r.log != nil {
+		r.log.Printf("block %s: nothing after %s, rerouted to %d more providers", c, r.timeout, dialed)
+	}
+}
@@ -0,0 +1,88 @@
+package ipget
+
+import (
+	"context"
+	"fmt"
+	"os"
+	gopath "path"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	unixfs "github.com/ipfs/go-unixfs"
+	uio "github.com/ipfs/go-unixfs/io"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// resolveSubpath walks a /ipfs/<cid>/<path> down the unixfs directories it
+// names, one link at a time, and returns the path of the node it ends at.
+// Only the directories along the way are fetched, and a missing entry is
+// reported together with the directory it was looked up in.
+//
+// Other paths, and the part of a path that leaves unixfs, are returned for
+// the generic resolver to handle.
+func resolveSubpath(ctx context.Context, dserv ipld.DAGService, p ipath.Path) (ipath.Path, error) {
+	parts := strings.Split(strings.Trim(p.String(), "/"), "/")
+	if len(parts) <= 2 || parts[0] != "ipfs" {
+		return p, nil
+	}
+	c, err := cid.Decode(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if c.Type() != cid.DagProtobuf {
+		// unixfs directories are always dag-pb
+		return p, nil
+	}
+	nd, err := dserv.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	names := parts[2:]
+	for i, name := range names {
+		if name == "" {
+			continue
+		}
+		parent := gopath.Join(append([]string{"/ipfs", c.String()}, names[:i]...)...)
+		dir, err := uio.NewDirectoryFromNode(dserv, nd)
+		if err != nil {
+			if isUnixfsFile(nd) {
+				return nil, notFoundError(fmt.Sprintf("%s is a file, it has no entry named %q", parent, name))
+			}
+			// not unixfs, leave the rest to the generic resolver
+			return ipath.Join(ipath.IpfsPath(nd.Cid()), names[i:]...), nil
+		}
+		nd, err = dir.Find(ctx, name)
+		if err == os.ErrNotExist {
+			return nil, notFoundError(fmt.Sprintf("no link named %q under %s", name, parent))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ipath.IpfsPath(nd.Cid()), nil
+}
+
+// notFoundError is returned by resolveSubpath when a path names an entry
+// that doesn't exist.
+type notFoundError string
+
+func (e notFoundError) Error() string { return string(e) }
+
+// isUnixfsFile reports whether nd is a unixfs file, raw leaf or symlink.
+func isUnixfsFile(nd ipld.Node) bool {
+	switch nd := nd.(type) {
+	case *dag.RawNode:
+		return true
+	case *dag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+		if err != nil {
+			return false
+		}
+		return !fsn.IsDir()
+	default:
+		return false
+	}
+}
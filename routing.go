@@ -0,0 +1,252 @@
+package ipget
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	nethttp "net/http"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-ipfs/core/node/libp2p"
+	host "github.com/libp2p/go-libp2p-core/host"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	record "github.com/libp2p/go-libp2p-record"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// routingBackends are the routing systems a spawned node can use: a DHT
+// client, delegated routing over the HTTP routing v1 API, or none at all,
+// in which case content only comes from the peers connected to.
+var routingBackends = []string{"dht", "http", "none"}
+
+// checkRoutingBackend makes sure name is one of routingBackends and that
+// endpoint is given for, and only for, the HTTP one.
+func checkRoutingBackend(name, endpoint string) error {
+	switch name {
+	case "", "dht", "none":
+		if endpoint != "" {
+			return fmt.Errorf("--routing-endpoint is for --routing=http")
+		}
+		return nil
+	case "http":
+		if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+			return fmt.Errorf("--routing=http needs the http(s) URL of a --routing-endpoint")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown routing %q, must be one of %s", name, strings.Join(routingBackends, ", "))
+}
+
+// routingBackend returns the option building the routing system name,
+// checked by checkRoutingBackend.
+func routingBackend(name, endpoint, userAgent string) libp2p.RoutingOption {
+	switch name {
+	case "http":
+		return func(_ context.Context, _ host.Host, _ datastore.Batching, validator record.Validator) (routing.Routing, error) {
+			return &httpRouting{
+				endpoint:  strings.TrimSuffix(endpoint, "/"),
+				client:    &nethttp.Client{},
+				userAgent: userAgent,
+				validator: validator,
+			}, nil
+		}
+	case "none":
+		return libp2p.NilRouterOption
+	default:
+		return libp2p.DHTClientOption
+	}
+}
+
+// httpRouting finds providers, peers and IPNS records through a delegated
+// routing endpoint speaking the HTTP routing v1 API, instead of querying the
+// DHT. It can't publish anything.
+type httpRouting struct {
+	endpoint  string
+	client    *nethttp.Client
+	userAgent string
+	validator record.Validator
+}
+
+// httpRecord is a provider or peer as the routing v1 API lists them. Older
+// endpoints list providers with the "bitswap" schema and a single protocol.
+type httpRecord struct {
+	Schema    string
+	ID        string
+	Addrs     []string
+	Protocols []string
+	Protocol  string
+}
+
+// addrInfo returns the peer r describes, false if it isn't one that can
+// be fetched from over bitswap or its ID doesn't decode. The addresses
+// that don't decode are left out.
+func (r httpRecord) addrInfo() (peer.AddrInfo, bool) {
+	if r.Schema != "peer" && r.Schema != "bitswap" {
+		return peer.AddrInfo{}, false
+	}
+	protos := r.Protocols
+	if r.Protocol != "" {
+		protos = append(protos, r.Protocol)
+	}
+	if len(protos) > 0 && !contains(protos, "transport-bitswap") {
+		return peer.AddrInfo{}, false
+	}
+	id, err := peer.Decode(r.ID)
+	if err != nil {
+		return peer.AddrInfo{}, false
+	}
+	info := peer.AddrInfo{ID: id}
+	for _, s := range r.Addrs {
+		if a, err := ma.NewMultiaddr(s); err == nil {
+			info.Addrs = append(info.Addrs, a)
+		}
+	}
+	return info, true
+}
+
+func (r *httpRouting) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		var resp struct{ Providers []httpRecord }
+		if err := r.getJSON(ctx, "/routing/v1/providers/"+c.String(), &resp); err != nil {
+			return
+		}
+		sent := 0
+		for _, rec := range resp.Providers {
+			info, ok := rec.addrInfo()
+			if !ok {
+				continue
+			}
+			select {
+			case out <- info:
+			case <-ctx.Done():
+				return
+			}
+			if sent++; count > 0 && sent >= count {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (r *httpRouting) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInfo, error) {
+	var resp struct{ Peers []httpRecord }
+	if err := r.getJSON(ctx, "/routing/v1/peers/"+peer.ToCid(p).String(), &resp); err != nil {
+		return peer.AddrInfo{}, err
+	}
+	for _, rec := range resp.Peers {
+		if info, ok := rec.addrInfo(); ok && info.ID == p {
+			return info, nil
+		}
+	}
+	return peer.AddrInfo{}, routing.ErrNotFound
+}
+
+// GetValue fetches IPNS records, the only kind of record the routing v1
+// API serves, and validates them.
+func (r *httpRouting) GetValue(ctx context.Context, key string, _ ...routing.Option) ([]byte, error) {
+	if !strings.HasPrefix(key, "/ipns/") {
+		return nil, routing.ErrNotSupported
+	}
+	id, err := peer.IDFromBytes([]byte(strings.TrimPrefix(key, "/ipns/")))
+	if err != nil {
+		return nil, err
+	}
+	body, err := r.get(ctx, "/routing/v1/ipns/"+peer.ToCid(id).String(), "application/vnd.ipfs.ipns-record")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxRecordSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxRecordSize {
+		return nil, fmt.Errorf("the IPNS record of %s is larger than %d bytes", id, maxRecordSize)
+	}
+	if err := r.validator.Validate(key, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (r *httpRouting) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	data, err := r.GetValue(ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan []byte, 1)
+	out <- data
+	close(out)
+	return out, nil
+}
+
+func (r *httpRouting) PutValue(context.Context, string, []byte, ...routing.Option) error {
+	return routing.ErrNotSupported
+}
+
+func (r *httpRouting) Provide(context.Context, cid.Cid, bool) error {
+	return routing.ErrNotSupported
+}
+
+func (r *httpRouting) Bootstrap(context.Context) error {
+	return nil
+}
+
+// maxRecordSize bounds the IPNS records read from the endpoint. Real ones
+// are a few hundred bytes.
+const maxRecordSize = 10 << 10
+
+// getJSON decodes the JSON answer of the endpoint at path into v. Not found
+// is routing.ErrNotFound.
+func (r *httpRouting) getJSON(ctx context.Context, path string, v interface{}) error {
+	body, err := r.get(ctx, path, "application/json")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(v)
+}
+
+func (r *httpRouting) get(ctx context.Context, path, accept string) (io.ReadCloser, error) {
+	req, err := nethttp.NewRequest("GET", r.endpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", accept)
+	if r.userAgent != "" {
+		req.Header.Set("User-Agent", r.userAgent)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case nethttp.StatusOK:
+		return resp.Body, nil
+	case nethttp.StatusNotFound:
+		resp.Body.Close()
+		return nil, routing.ErrNotFound
+	default:
+		resp.Body.Close()
+		return nil, errors.New("routing endpoint: " + resp.Status)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package ipget
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	host "github.com/libp2p/go-libp2p-core/host"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// routingCacheMaxAge is how long after it was last connected to a peer
+	// saved in a routing table cache is still tried.
+	routingCacheMaxAge = 7 * 24 * time.Hour
+	// routingCacheSize bounds the peers a routing table cache keeps, the
+	// most recently connected to first.
+	routingCacheSize = 200
+	// routingCacheDials is how many cached peers are dialed at once.
+	routingCacheDials = 16
+)
+
+// cachedPeer is a DHT server a spawned node was connected to.
+type cachedPeer struct {
+	ID    string    `json:"id"`
+	Addrs []string  `json:"addrs"`
+	Seen  time.Time `json:"seen"`
+}
+
+// routingCache saves the DHT servers a spawned node is connected to when it
+// closes, and dials them again when the next one starts. The DHT adds the
+// peers it is connected to to its routing table, so the node gets to a
+// useful routing table without waiting for bootstrapping to fill it.
+type routingCache struct {
+	path string
+
+	mu    sync.Mutex
+	peers []cachedPeer
+	// failed are the cached peers that couldn't be dialed, which aren't
+	// saved again.
+	failed map[string]bool
+}
+
+// loadRoutingCache reads the cache at path, leaving out the peers last seen
+// more than routingCacheMaxAge ago. A missing file is an empty cache.
+func loadRoutingCache(path string) (*routingCache, error) {
+	rc := &routingCache{path: path, failed: map[string]bool{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return rc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var peers []cachedPeer
+	if err := json.Unmarshal(data, &peers); err != nil {
+		// a damaged cache only costs a slower start
+		return rc, nil
+	}
+	for _, p := range peers {
+		if time.Since(p.Seen) < routingCacheMaxAge && len(p.Addrs) > 0 {
+			rc.peers = append(rc.peers, p)
+		}
+	}
+	return rc, nil
+}
+
+// warmUp dials the cached peers, routingCacheDials at a time, until ctx is
+// done.
+func (rc *routingCache) warmUp(ctx context.Context, h host.Host) {
+	sem := make(chan struct{}, routingCacheDials)
+	var wg sync.WaitGroup
+	for _, p := range rc.peers {
+		info, ok := p.addrInfo()
+		if !ok || info.ID == h.ID() {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(id string, info peer.AddrInfo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			h.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.TempAddrTTL)
+			if err := h.Connect(ctx, info); err != nil && ctx.Err() == nil {
+				rc.mu.Lock()
+				rc.failed[id] = true
+				rc.mu.Unlock()
+			}
+		}(p.ID, info)
+	}
+	wg.Wait()
+}
+
+// save writes the DHT servers h is connected to, followed by the cached
+// peers that are still fresh and weren't found unreachable.
+func (rc *routingCache) save(h host.Host) error {
+	now := time.Now()
+	seen := map[string]bool{}
+	var peers []cachedPeer
+	for _, id := range h.Network().Peers() {
+		protos, err := h.Peerstore().SupportsProtocols(id, string(dht.ProtocolDHT))
+		if err != nil || len(protos) == 0 {
+			continue
+		}
+		p := cachedPeer{ID: id.Pretty(), Seen: now}
+		for _, c := range h.Network().ConnsToPeer(id) {
+			p.Addrs = append(p.Addrs, c.RemoteMultiaddr().String())
+		}
+		if len(p.Addrs) > 0 {
+			peers = append(peers, p)
+			seen[p.ID] = true
+		}
+	}
+	rc.mu.Lock()
+	for _, p := range rc.peers {
+		if !seen[p.ID] && !rc.failed[p.ID] {
+			peers = append(peers, p)
+		}
+	}
+	rc.mu.Unlock()
+	sort.SliceStable(peers, func(i, j int) bool { return peers[i].Seen.After(peers[j].Seen) })
+	if len(peers) > routingCacheSize {
+		peers = peers[:routingCacheSize]
+	}
+
+	data, err := json.MarshalIndent(peers, "", "  ")
+	if err != nil {
+		return err
+	}
+	// written aside and renamed, so a crash never leaves half a cache
+	tmp := rc.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, rc.path)
+}
+
+func (p cachedPeer) addrInfo() (peer.AddrInfo, bool) {
+	id, err := peer.Decode(p.ID)
+	if err != nil {
+		return peer.AddrInfo{}, false
+	}
+	info := peer.AddrInfo{ID: id}
+	for _, s := range p.Addrs {
+		if a, err := ma.NewMultiaddr(s); err == nil {
+			info.Addrs = append(info.Addrs, a)
+		}
+	}
+	return info, len(info.Addrs) > 0
+}
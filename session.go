@@ -0,0 +1,872 @@
+// Package ipget fetches files and directories from IPFS. A Session owns
+// the node fetching them, spawned or a local daemon, and reuses it from one
+// Get to the next; WriteTo writes what Get returns to disk. The ipget
+// command, under cmd/ipget, is built on it.
+package ipget
+
+import (
+	"context"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bitswap "github.com/ipfs/go-bitswap"
+	cid "github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreapi"
+	"github.com/ipfs/go-ipfs/core/node/libp2p"
+	ipnspb "github.com/ipfs/go-ipns/pb"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/ipget/internal/dagutil"
+	p2p "github.com/libp2p/go-libp2p"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	record "github.com/libp2p/go-libp2p-record"
+)
+
+// Options configures a Session. The zero value uses the local daemon if one
+// is running and spawns a node otherwise.
+type Options struct {
+	// Node is the node strategy: "fallback" (the default), "spawn",
+	// "local", "temp" or "repo". "repo" spawns a node on the repo at the
+	// default path like "spawn" does, but fails rather than falling back
+	// to a temporary repo when that can't be opened.
+	Node string
+	// API is the multiaddr of the daemon API used by the "fallback" and
+	// "local" strategies. When empty, the one recorded in $IPFS_PATH/api is
+	// used.
+	API string
+	// Peers are multiaddrs of peers to connect to once the node is up.
+	Peers []string
+	// UserAgent is announced to peers by a spawned node and sent to the
+	// local daemon.
+	UserAgent string
+	// NoBootstrapWait lets a spawned node start fetching as soon as one peer
+	// is connected.
+	NoBootstrapWait bool
+	// Decode makes Get return dag-cbor and dag-json nodes as JSON rather than
+	// as their raw block.
+	Decode bool
+	// Config is applied to the in-memory config of a spawned node.
+	Config []CfgOpt
+	// Validators validate DHT records of namespaces other than the ones
+	// go-ipfs knows about.
+	Validators map[string]record.Validator
+	// MaxBlockSize makes a spawned node refuse to store larger blocks, 0
+	// disables the check.
+	MaxBlockSize int
+	// SwarmKey is the key of the private network a spawned node joins,
+	// instead of the one in its repo.
+	SwarmKey []byte
+	// MaxDials caps the outbound dials a spawned node has in flight at once,
+	// 0 keeps the libp2p default. It applies to every node started by the
+	// process from then on.
+	MaxDials int
+	// LogExternalAddrs logs the public addresses a spawned node finds it is
+	// reachable at, including the ones mapped through NAT.
+	LogExternalAddrs bool
+	// PeerstoreTTL is how long a spawned node keeps the addresses it learns
+	// of other peers, 0 keeps the libp2p defaults of a few minutes.
+	PeerstoreTTL time.Duration
+	// Gateways are the URLs of HTTP gateways blocks are fetched from, and
+	// verified, when fetching through the node fails or takes longer than
+	// GatewayTimeout to get to the root.
+	Gateways []string
+	// GatewayHeaders are added to every request made to the gateways, such
+	// as an Authorization header for one that isn't public.
+	GatewayHeaders nethttp.Header
+	// HTTPRetries is how many times a block request to the gateways is
+	// retried, across all of them.
+	HTTPRetries int
+	// GatewayTimeout bounds the attempt made through the node before falling
+	// back to the gateways, 0 waits for it to fail.
+	GatewayTimeout time.Duration
+	// GatewayBlockTimeout, if set, falls back to the gateways one block at
+	// a time instead, for the blocks that don't come through the node
+	// within it. GatewayTimeout is then unused.
+	GatewayBlockTimeout time.Duration
+	// TrustGateway skips checking the blocks from the gateways against
+	// their CID, which saves hashing them. It is unsafe: a gateway can then
+	// send anything. Blocks the node stores with GatewayBlockTimeout are
+	// hashed regardless.
+	TrustGateway bool
+	// StrictProviderAddrs makes a spawned node ignore providers that can only
+	// be reached through a circuit relay.
+	StrictProviderAddrs bool
+	// NoVerifyRecords makes a spawned node accept DHT records that fail
+	// validation, warning about each. It is unsafe: any peer can then feed
+	// the node forged records.
+	NoVerifyRecords bool
+	// PeerStats makes a spawned node count the blocks and bytes each peer
+	// sends it, for PeerTraffic.
+	PeerStats bool
+	// AddrResolver, if set, is asked for the addresses of the peers a
+	// spawned node looks up, before the DHT is. The addresses it returns
+	// are added to the peerstore.
+	AddrResolver AddrResolver
+	// AddrResolverAfterDHT only asks AddrResolver about the peers the DHT
+	// finds no addresses for.
+	AddrResolverAfterDHT bool
+	// ProviderFilter, if set, is called for every provider a spawned node
+	// finds through the DHT, and only those it returns true for are used.
+	ProviderFilter func(peer.AddrInfo) bool
+	// ProviderAllowlist, if set, are the only peers a spawned node takes
+	// blocks from, and ProviderDenylist peers it never takes any from.
+	// Other providers are left out of provider lookups and bitswap never
+	// talks to them. The local daemon can't be restricted that way.
+	ProviderAllowlist map[peer.ID]bool
+	ProviderDenylist  map[peer.ID]bool
+	// ResolveConcurrency bounds how many IPNS names are resolved at once,
+	// 0 for no bound.
+	ResolveConcurrency int
+	// ResolveCacheTTL is how long a resolved IPNS name is reused for,
+	// DefaultResolveCacheTTL if 0. A spawned node resolves keys through
+	// their records and reuses them no longer than the records' own TTL
+	// and validity allow.
+	ResolveCacheTTL time.Duration
+	// ClusterLevel is the cluster level a spawned node sends its DHT
+	// queries at, for experimenting with the DHT's clustering. 0 leaves
+	// them as they are.
+	ClusterLevel int
+	// Routing is how a spawned node finds content, peers and IPNS records:
+	// "dht" (the default), "http" for a delegated routing endpoint speaking
+	// the HTTP routing v1 API at RoutingEndpoint, or "none" to only fetch
+	// from the peers it connects to.
+	Routing         string
+	RoutingEndpoint string
+	// CustomRouting, if set, builds the routing of a spawned node instead
+	// of Routing. Provider filters and address resolvers still apply to it.
+	CustomRouting libp2p.RoutingOption
+	// RoutingTableCache, if set, is a file a spawned node saves the DHT
+	// servers it is connected to in when the session closes, and dials
+	// again when the next one starts, to fill its routing table sooner.
+	RoutingTableCache string
+	// SOCKS5, if set, is the host:port of a SOCKS5 proxy, such as Tor's,
+	// that a spawned node makes all its connections through. The node
+	// then doesn't listen, and only dials TCP and onion addresses; the
+	// local daemon can't be used.
+	SOCKS5 string
+	// OnionOnly restricts the dials made through SOCKS5 to onion
+	// addresses.
+	OnionOnly bool
+	// CompleteOnFirstProvider makes a spawned node stop looking for
+	// providers once it finds one, for the first seconds of each Get and
+	// unless the content is larger than a block or two, so small content
+	// is fetched without waiting on a longer DHT walk.
+	CompleteOnFirstProvider bool
+	// NoAddrRefresh stops a spawned node from looking up the current
+	// addresses of a provider it fails to dial, and dialing it again at
+	// those, before giving up on it.
+	NoAddrRefresh bool
+	// MinProtocol sets the minimum versions of the protocols a spawned
+	// node's peers may speak, each as <protocol>=<version> where the
+	// protocol is bitswap or kad. Peers speaking a protocol only in older
+	// versions are disconnected and left out of provider lookups.
+	MinProtocol []string
+	// LogProtocols logs the bitswap and DHT protocol versions each peer of
+	// a spawned node speaks.
+	LogProtocols bool
+	// MaxLookupRounds, if positive, caps the rounds of the iterative DHT
+	// lookups of a spawned node: each lookup ends once it would ask peers
+	// further than that many rounds from the ones it started from, and the
+	// providers found by then are used.
+	MaxLookupRounds int
+	// BlockTimeout, if set, reroutes the blocks a spawned node doesn't get
+	// within it: other providers of the block are looked up and connected
+	// to, every BlockTimeout until it comes.
+	BlockTimeout time.Duration
+	// LogReroutes logs every block BlockTimeout reroutes.
+	LogReroutes bool
+	// BootstrapMinPeers is how many peers a spawned node must be connected
+	// to before it is ready to fetch, DefaultBootstrapPeers if zero. A Get
+	// waits for that, up to a few seconds, unless NoBootstrapWait is set.
+	BootstrapMinPeers int
+	// BootstrapProgress, if set, is told of every change of the count of
+	// peers a spawned node is connected to, until that reaches
+	// BootstrapMinPeers. It is called from the node's goroutines and mustn't
+	// block.
+	BootstrapProgress func(connected, wanted int)
+	// Exchange is how blocks missing from the blockstore are got, one of
+	// exchanges. "none" fails a Get on the first block that is missing,
+	// unless a gateway is set to fall back to. Bitswap if empty.
+	Exchange string
+	// MaxConnections, if positive, caps the connections of a spawned node
+	// across all the Gets going through it: past it, those the connection
+	// manager values least are closed, and its watermarks are brought
+	// within it.
+	MaxConnections int
+	// Logger, if set, receives the lines the Session logs. They are
+	// dropped otherwise, nothing goes to the standard logger unless it is
+	// passed here. WithLogger sets another for a single Get.
+	Logger Logger
+}
+
+// nodeOpts returns the options to spawn a node with.
+func (o Options) nodeOpts(limit *blockLimit, blocks *blockCounter) nodeOpts {
+	nopts := nodeOpts{
+		cfg:             o.Config,
+		validators:      o.Validators,
+		limit:           limit,
+		blocks:          blocks,
+		maxDials:        o.MaxDials,
+		addrTTL:         o.PeerstoreTTL,
+		noVerifyRecords: o.NoVerifyRecords,
+		swarmKey:        o.SwarmKey,
+		addrs:           o.AddrResolver,
+		addrsAfterDHT:   o.AddrResolverAfterDHT,
+		providerFilter:  o.ProviderFilter,
+		clusterLevel:    o.ClusterLevel,
+		router:          o.CustomRouting,
+	}
+	if nopts.router == nil {
+		nopts.router = routingBackend(o.Routing, o.RoutingEndpoint, o.UserAgent)
+	}
+	if o.UserAgent != "" {
+		nopts.host = append(nopts.host, p2p.UserAgent(o.UserAgent))
+	}
+	return nopts
+}
+
+// Session fetches IPFS paths through a node it keeps for its whole
+// lifetime, so connections, the peerstore and the routing table are reused
+// from one Get to the next. It is safe for concurrent use. PeerTraffic and
+// the other reports on the latest Get tell of whichever of the Gets made at
+// once began last; pass each a Report of its own through WithReport to
+// tell them apart.
+type Session struct {
+	api iface.CoreAPI
+	// node is the node we spawned, nil when talking to a local daemon.
+	node   *core.IpfsNode
+	eager  bool
+	decode bool
+	limit  *blockLimit
+	blocks *blockCounter
+	// gateway is nil when no gateways were given.
+	gateway        *gatewayFetcher
+	gatewayTimeout time.Duration
+	// hybrid is nil unless GatewayBlockTimeout is set.
+	hybrid *hybridDAG
+	// relays is nil unless StrictProviderAddrs is set.
+	relays *relayFilter
+	// malformed counts the malformed provider records a spawned node
+	// dropped.
+	malformed *malformedFilter
+	// traffic is nil unless PeerStats is set.
+	traffic *trafficMeter
+	// guard cuts off the peers sending a spawned node bad blocks.
+	guard *blockGuard
+	// names caches the IPNS names resolved.
+	names *nameCache
+	// rtcache is nil unless RoutingTableCache is set.
+	rtcache *routingCache
+	// first is nil unless CompleteOnFirstProvider is set.
+	first *firstProvider
+	// refresh is nil if NoAddrRefresh is set.
+	refresh *addrRefresher
+	// protocols is nil unless MinProtocol or LogProtocols is set.
+	protocols *protocolGuard
+	// lookups is nil unless MaxLookupRounds is set.
+	lookups *lookupCap
+	// reroute is nil unless BlockTimeout is set.
+	reroute *blockRerouter
+	// log is where the Session logs to, nopLogger unless Logger is set.
+	log Logger
+	// providers follows the providers of each Get.
+	providers *providerTracker
+	// conns is nil unless MaxConnections is set.
+	conns *connCap
+	// boot is nil when fetching through a daemon.
+	boot *bootstrapWatch
+	// policy is nil unless ProviderAllowlist or ProviderDenylist is set.
+	policy *peerPolicy
+
+	mu sync.Mutex
+	// report is that of the latest Get.
+	report *Report
+}
+
+// New sets up a node as described by opts. A spawned node stays up until
+// Close is called or ctx is done.
+func New(ctx context.Context, opts Options) (*Session, error) {
+	if err := checkRoutingBackend(opts.Routing, opts.RoutingEndpoint); err != nil {
+		return nil, err
+	}
+	if err := checkExchange(opts.Exchange); err != nil {
+		return nil, err
+	}
+	if opts.SOCKS5 != "" {
+		switch opts.Node {
+		case "", "fallback":
+			// the daemon doesn't go through the proxy
+			opts.Node = "spawn"
+		case "local":
+			return nil, fmt.Errorf("the local daemon can't be made to go through a SOCKS5 proxy, spawn a node instead")
+		}
+	} else if opts.OnionOnly {
+		return nil, fmt.Errorf("onion addresses can only be dialed through a SOCKS5 proxy")
+	}
+	if opts.ProviderAllowlist != nil || len(opts.ProviderDenylist) > 0 {
+		switch opts.Node {
+		case "", "fallback":
+			// the daemon takes blocks from whoever sends them
+			opts.Node = "spawn"
+		case "local":
+			return nil, fmt.Errorf("the local daemon can't be restricted to a list of providers, spawn a node instead")
+		}
+	}
+	s := &Session{
+		eager:  opts.NoBootstrapWait,
+		decode: opts.Decode,
+		limit:  newBlockLimit(opts.MaxBlockSize),
+		blocks: newBlockCounter(),
+
+		malformed:      &malformedFilter{},
+		guard:          newBlockGuard(),
+		names:          newNameCache(opts.ResolveConcurrency, opts.ResolveCacheTTL),
+		providers:      newProviderTracker(),
+		log:            opts.Logger,
+		gatewayTimeout: opts.GatewayTimeout,
+	}
+	if s.log == nil {
+		s.log = nopLogger{}
+	}
+	if len(opts.Gateways) > 0 {
+		s.gateway = newGatewayFetcher(opts.Gateways, opts.HTTPRetries, opts.UserAgent, opts.MaxBlockSize, opts.GatewayHeaders)
+		s.gateway.trust = opts.TrustGateway
+	}
+	if s.gateway != nil && opts.GatewayBlockTimeout > 0 {
+		s.hybrid = &hybridDAG{g: s.gateway, timeout: opts.GatewayBlockTimeout, log: s.log}
+	}
+	if opts.StrictProviderAddrs {
+		s.relays = &relayFilter{}
+	}
+	nopts := opts.nodeOpts(s.limit, s.blocks)
+	nopts.relays = s.relays
+	nopts.malformed = s.malformed
+	nopts.guard = s.guard
+	nopts.providers = s.providers
+	nopts.log = s.log
+	if opts.CompleteOnFirstProvider {
+		s.first = &firstProvider{}
+		nopts.first = s.first
+	}
+	if len(opts.MinProtocol) > 0 || opts.LogProtocols {
+		g, err := newProtocolGuard(opts.MinProtocol, opts.LogProtocols, s.log)
+		if err != nil {
+			return nil, err
+		}
+		s.protocols = g
+		if len(opts.MinProtocol) > 0 {
+			nopts.protocols = g
+		}
+	}
+	if opts.ProviderAllowlist != nil || len(opts.ProviderDenylist) > 0 {
+		s.policy = newPeerPolicy(opts.ProviderAllowlist, opts.ProviderDenylist)
+		nopts.policy = s.policy
+	}
+	if opts.MaxLookupRounds > 0 {
+		s.lookups = &lookupCap{rounds: opts.MaxLookupRounds}
+		nopts.lookups = s.lookups
+	}
+	if !opts.NoAddrRefresh {
+		s.refresh = newAddrRefresher()
+		nopts.refresh = s.refresh
+	}
+	if opts.BlockTimeout > 0 {
+		s.reroute = &blockRerouter{timeout: opts.BlockTimeout}
+		if opts.LogReroutes {
+			s.reroute.log = s.log
+		}
+	}
+	if opts.MaxConnections > 0 {
+		s.conns = &connCap{max: opts.MaxConnections, log: s.log}
+		nopts.cfg = append(nopts.cfg, s.conns.cfg())
+	}
+	if opts.PeerStats {
+		s.traffic = newTrafficMeter()
+		nopts.meter = s.traffic
+	}
+	if opts.SOCKS5 != "" {
+		tpt, err := newSocksTransport(opts.SOCKS5, opts.OnionOnly)
+		if err != nil {
+			return nil, err
+		}
+		nopts.cfg = append(nopts.cfg, throughProxy)
+		nopts.host = append(nopts.host, p2p.NoTransports, p2p.Transport(tpt), p2p.NoListenAddrs)
+	}
+
+	var err error
+	if opts.RoutingTableCache != "" {
+		if s.rtcache, err = loadRoutingCache(opts.RoutingTableCache); err != nil {
+			return nil, err
+		}
+	}
+	switch opts.Node {
+	case "", "fallback":
+		s.api, err = http(ctx, opts.API, opts.UserAgent)
+		if err == nil {
+			break
+		}
+		s.node, err = spawn(ctx, nopts)
+	case "spawn":
+		s.node, err = spawn(ctx, nopts)
+	case "local":
+		s.api, err = http(ctx, opts.API, opts.UserAgent)
+	case "temp":
+		s.node, err = temp(ctx, nopts)
+	case "repo":
+		s.node, err = openRepo(ctx, nopts)
+	default:
+		return nil, fmt.Errorf("no such 'node' strategy, %q", opts.Node)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.node != nil {
+		if s.api, err = coreapi.NewCoreAPI(s.node); err != nil {
+			s.node.Close()
+			return nil, err
+		}
+		if bs, ok := s.node.Exchange.(*bitswap.Bitswap); ok {
+			s.guard.start(bs.GetWantlist, s.node.Blockstore.Has)
+			if s.reroute != nil {
+				go s.reroute.watch(ctx, bs.GetWantlist, s.node.Routing, s.node.PeerHost)
+			}
+		}
+		if s.rtcache != nil {
+			go s.rtcache.warmUp(ctx, s.node.PeerHost)
+		}
+		if opts.ResolveCacheTTL > 0 && !opts.NoVerifyRecords {
+			s.names.record = func(ctx context.Context, pid peer.ID) (*ipnspb.IpnsEntry, error) {
+				return s.IPNSRecord(ctx, pid)
+			}
+		}
+		if opts.LogExternalAddrs {
+			logExternalAddrs(ctx, s.node.PeerHost, s.log)
+		}
+		if s.protocols != nil {
+			s.protocols.watch(ctx, s.node.PeerHost)
+		}
+		s.providers.watch(s.node.PeerHost.Network())
+		want := opts.BootstrapMinPeers
+		if want <= 0 {
+			want = DefaultBootstrapPeers
+		}
+		s.boot = newBootstrapWatch(want, opts.BootstrapProgress)
+		s.boot.watch(s.node.PeerHost.Network())
+		if s.conns != nil {
+			cm := s.node.PeerHost.ConnManager()
+			s.conns.watch(s.node.PeerHost.Network(), func(p peer.ID) int {
+				if info := cm.GetTagInfo(p); info != nil {
+					return info.Value
+				}
+				return 0
+			})
+		}
+		s.blocks.reset()
+	}
+	if s.api, err = withExchange(s.api, opts.Exchange, s.node != nil); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if s.hybrid != nil {
+		s.hybrid.api = s.api
+	}
+
+	go connect(ctx, s.api, opts.Peers, s.log)
+	return s, nil
+}
+
+// copyBufferSize is the size of the buffer GetTo copies through.
+const copyBufferSize = 256 << 10
+
+// eagerGetTimeout bounds the first fetch attempt made before bootstrapping
+// has finished.
+const eagerGetTimeout = 15 * time.Second
+
+// Get fetches p. The returned node reads lazily through ctx, which must stay
+// alive until it has been read in full. Errors are categorized as described
+// at ErrNotFound.
+func (s *Session) Get(ctx context.Context, p ipath.Path, opts ...GetOption) (files.Node, error) {
+	var settings getSettings
+	for _, opt := range opts {
+		opt(&settings)
+	}
+	if err := p.IsValid(); err != nil {
+		return nil, &Error{Kind: ErrInvalidPath, Err: err}
+	}
+	if settings.logger != nil {
+		ctx = context.WithValue(ctx, loggerKey{}, settings.logger)
+	}
+	report := settings.report
+	if report == nil {
+		report = &Report{}
+	}
+	report.begin(s)
+	s.mu.Lock()
+	s.report = report
+	s.mu.Unlock()
+	window := s.first.begin()
+	nd, err := s.getOrFallback(ctx, p)
+	if err == nil && s.first != nil {
+		if size, serr := nd.Size(); serr != nil || size > firstProviderMaxSize {
+			s.first.widen(window)
+		}
+	}
+	if err != nil {
+		err = categorize(err)
+		if settings.summary != nil {
+			settings.summary.Error = err.Error()
+		}
+		return nil, err
+	}
+	if settings.accurateSize {
+		nd = s.accurateSize(ctx, p, nd)
+	}
+	if ps := settings.summary; ps != nil {
+		fn := settings.progress
+		settings.progress = func(fetched, total int64) {
+			atomic.StoreInt64(&ps.Bytes, fetched)
+			if fn != nil {
+				fn(fetched, total)
+			}
+		}
+	}
+	if settings.progress == nil {
+		return nd, nil
+	}
+	return withProgress(nd, settings.progress), nil
+}
+
+// getOrFallback fetches p through the node and, should that fail or not get
+// to the root within gatewayTimeout, from the gateways.
+func (s *Session) getOrFallback(ctx context.Context, p ipath.Path) (files.Node, error) {
+	if s.gateway == nil {
+		return s.get(ctx, p)
+	}
+	if s.hybrid != nil {
+		return s.getHybrid(ctx, p)
+	}
+
+	var nd files.Node
+	var err error
+	if s.gatewayTimeout > 0 {
+		// as with the eager get, only time out the attempt while it is still
+		// resolving
+		nctx, cancel := context.WithCancel(ctx)
+		timer := time.AfterFunc(s.gatewayTimeout, cancel)
+		nd, err = s.get(nctx, p)
+		if timer.Stop() && err == nil {
+			return nd, nil
+		}
+		if err == nil {
+			nd.Close()
+			err = fmt.Errorf("no root after %s", s.gatewayTimeout)
+		}
+		cancel()
+	} else if nd, err = s.get(ctx, p); err == nil {
+		return nd, nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	logger(ctx, s.log).Printf("fetching %s through the node failed, trying the gateways: %s", p, err)
+	return s.gateway.get(ctx, p)
+}
+
+// getHybrid fetches p through the node, getting the blocks it can't find
+// from the gateways one by one. Names are resolved through the node.
+func (s *Session) getHybrid(ctx context.Context, p ipath.Path) (files.Node, error) {
+	if s.Embedded() {
+		if err := s.WaitBootstrap(ctx); err != nil {
+			return nil, err
+		}
+	}
+	p, err := s.resolveName(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return getFromDAG(ctx, s.hybrid, p)
+}
+
+// get waits for a spawned node to bootstrap before fetching p, unless
+// NoBootstrapWait is set, in which case a single connected peer is enough to
+// start; should that attempt fail, we wait for bootstrap and try again.
+func (s *Session) get(ctx context.Context, p ipath.Path) (files.Node, error) {
+	if !s.Embedded() {
+		return s.fetch(ctx, p)
+	}
+
+	if s.eager {
+		if err := waitBootstrap(ctx, s.api, 1); err != nil {
+			return nil, err
+		}
+		// The returned node keeps using the context it was fetched with, so
+		// only time out the attempt while it is still resolving.
+		ectx, cancel := context.WithCancel(ctx)
+		timer := time.AfterFunc(eagerGetTimeout, cancel)
+		out, err := s.fetch(ectx, p)
+		if timer.Stop() && err == nil {
+			return out, nil
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		logger(ctx, s.log).Printf("fetch before bootstrap failed, retrying: %s", err)
+	}
+
+	if err := s.WaitBootstrap(ctx); err != nil {
+		return nil, err
+	}
+	return s.fetch(ctx, p)
+}
+
+// GetTo fetches the file at p and writes its content to w as it arrives,
+// with at most copyBufferSize bytes buffered at a time. Directories can't be
+// written to a single stream; use Get to walk them instead.
+func (s *Session) GetTo(ctx context.Context, p ipath.Path, w io.Writer, opts ...GetOption) error {
+	nd, err := s.Get(ctx, p, opts...)
+	if err != nil {
+		return err
+	}
+	defer nd.Close()
+	f, ok := nd.(files.File)
+	if !ok {
+		return &Error{Kind: ErrInvalidPath, Err: fmt.Errorf("%s is not a file, use Get to walk directories", p)}
+	}
+	_, err = io.CopyBuffer(localWriter{w}, f, make([]byte, copyBufferSize))
+	return categorize(err)
+}
+
+// Resolve returns the CID p points to without fetching it, once a spawned
+// node has bootstrapped.
+func (s *Session) Resolve(ctx context.Context, p ipath.Path) (ipath.Resolved, error) {
+	if err := p.IsValid(); err != nil {
+		return nil, &Error{Kind: ErrInvalidPath, Err: err}
+	}
+	if err := s.WaitBootstrap(ctx); err != nil {
+		return nil, categorize(err)
+	}
+	rp, err := s.resolve(ctx, p)
+	return rp, categorize(err)
+}
+
+// ResolveFresh resolves p like Resolve, but bypasses the cache of IPNS
+// records so that an update is seen as soon as it is published, unless
+// ResolveCacheTTL was set to poll less often.
+func (s *Session) ResolveFresh(ctx context.Context, p ipath.Path) (ipath.Resolved, error) {
+	if err := p.IsValid(); err != nil {
+		return nil, &Error{Kind: ErrInvalidPath, Err: err}
+	}
+	if err := s.WaitBootstrap(ctx); err != nil {
+		return nil, categorize(err)
+	}
+	if p.Namespace() == "ipns" && !s.names.configured {
+		var err error
+		if p, err = s.api.Name().Resolve(ctx, p.String(), options.Name.Cache(false)); err != nil {
+			return nil, categorize(err)
+		}
+	}
+	return s.Resolve(ctx, p)
+}
+
+// resolve resolves p one directory at a time, so a missing entry is
+// reported by name. A root that this build can't fetch is reported before
+// asking the network for it.
+func (s *Session) resolve(ctx context.Context, p ipath.Path) (ipath.Resolved, error) {
+	p, err := s.resolveName(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if parts := strings.Split(strings.Trim(p.String(), "/"), "/"); parts[0] == "ipfs" {
+		if c, err := cid.Decode(parts[1]); err == nil {
+			if err := dagutil.CheckSupported(c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	resolved, err := resolveSubpath(ctx, s.api.Dag(), p)
+	if err != nil {
+		return nil, err
+	}
+	rp, err := s.api.ResolvePath(ctx, resolved)
+	if err != nil {
+		return nil, err
+	}
+	if err := dagutil.CheckSupported(rp.Cid()); err != nil {
+		return nil, err
+	}
+	return rp, nil
+}
+
+// resolveName replaces the IPNS name p starts with, if any, with the path
+// it points to, through the session's cache of names.
+func (s *Session) resolveName(ctx context.Context, p ipath.Path) (ipath.Path, error) {
+	parts := strings.Split(strings.Trim(p.String(), "/"), "/")
+	if parts[0] != "ipns" || len(parts) < 2 {
+		return p, nil
+	}
+	target, err := s.names.resolve(ctx, s.api, parts[1])
+	if err != nil {
+		return nil, err
+	}
+	return ipath.Join(target, parts[2:]...), nil
+}
+
+// fetch resolves p and fetches the node it ends at. Plain IPLD nodes, which
+// unixfs can't read, come back as a file holding their block.
+func (s *Session) fetch(ctx context.Context, p ipath.Path) (files.Node, error) {
+	rp, err := s.resolve(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	switch rp.Cid().Type() {
+	case cid.DagCBOR, dagutil.DagJSON:
+		return ipldFile(ctx, s.api, rp, s.decode)
+	}
+	return s.api.Unixfs().Get(ctx, rp)
+}
+
+// PeerTraffic returns the bytes and blocks each peer sent over bitswap
+// during the last Get, the peers that sent the most first. It is empty
+// unless PeerStats is set and a node was spawned.
+func (s *Session) PeerTraffic() []PeerTraffic {
+	return s.latest().PeerTraffic()
+}
+
+// RelayOnlyProviders returns how many providers a spawned node ignored for
+// StrictProviderAddrs, being reachable only through a circuit relay.
+func (s *Session) RelayOnlyProviders() int {
+	return s.relays.Dropped()
+}
+
+// MalformedRecords returns how many provider records the lookups of a
+// spawned node dropped for a peer ID that doesn't decode.
+func (s *Session) MalformedRecords() int {
+	return s.malformed.Dropped()
+}
+
+// BadBlocks returns, for each peer that sent blocks matching nothing asked
+// for, corrupt or forged, how many it sent before it was cut off. Those
+// blocks were asked for again from other peers. Only a spawned node tells.
+func (s *Session) BadBlocks() map[peer.ID]int {
+	if !s.Embedded() {
+		return nil
+	}
+	return s.guard.Bad()
+}
+
+// RefreshedProviders returns the providers a spawned node could only dial
+// after looking up their addresses again, which had changed since they
+// were found.
+func (s *Session) RefreshedProviders() []peer.ID {
+	if !s.Embedded() {
+		return nil
+	}
+	return s.refresh.Reached()
+}
+
+// Unavailable explains why the latest Get got no block at all: no provider
+// was found, none could be dialed, or none sent a block. The error is an
+// *Error of ErrNoProviders, ErrUndialable or ErrNotServed, and why is its
+// message's prefix. It is nil if blocks did arrive, or if the node isn't a
+// spawned one, which is the only kind that tells.
+func (s *Session) Unavailable(why string) error {
+	return s.latest().Unavailable(why)
+}
+
+// ReroutedBlocks returns how many times, during the latest Get, a block that
+// didn't come within BlockTimeout was rerouted to other providers.
+func (s *Session) ReroutedBlocks() int {
+	return s.latest().ReroutedBlocks()
+}
+
+// ProviderRecords returns how many providers the lookups of a spawned node
+// found during the latest Get, and how many provider records they got in
+// all. Each provider is only dialed again when it comes with new addresses.
+func (s *Session) ProviderRecords() (unique, total int) {
+	return s.latest().ProviderRecords()
+}
+
+// RefusedPeers returns how many peers a spawned node cut off for speaking
+// only protocol versions older than MinProtocol.
+func (s *Session) RefusedPeers() int {
+	return s.protocols.Refused()
+}
+
+// ExcludedProviders returns how many peers a spawned node refused to take
+// blocks from for ProviderAllowlist or ProviderDenylist.
+func (s *Session) ExcludedProviders() int {
+	return s.policy.Excluded()
+}
+
+// CappedLookups returns how many DHT lookups of a spawned node were cut
+// short by MaxLookupRounds.
+func (s *Session) CappedLookups() int {
+	return s.lookups.Capped()
+}
+
+// BlockCounts returns the number of blocks the session fetched from the
+// network, the number of block references it served from the blockstore
+// instead because an earlier Get, or the repo, already had them, and the
+// number of blocks used from seed CARs. All are 0 when talking to a local
+// daemon, whose blockstore we can't see.
+func (s *Session) BlockCounts() (fetched, deduplicated, seeded int) {
+	if !s.Embedded() {
+		return 0, 0, 0
+	}
+	return s.blocks.counts()
+}
+
+// Seed imports the blocks of the CAR read from r, so that fetches only go to
+// the network for the blocks it lacks. Every block is checked against its
+// CID. It returns the number of blocks imported.
+func (s *Session) Seed(ctx context.Context, r io.Reader) (int, error) {
+	var seeded []cid.Cid
+	err := readCAR(r, func(c cid.Cid, data []byte) error {
+		if err := putBlock(ctx, s.api, c, data); err != nil {
+			return err
+		}
+		seeded = append(seeded, c)
+		return nil
+	})
+	s.blocks.markSeeded(seeded)
+	return len(seeded), err
+}
+
+// Close shuts down the node if we spawned it.
+func (s *Session) Close() error {
+	if s.node == nil {
+		return nil
+	}
+	if s.rtcache != nil {
+		if err := s.rtcache.save(s.node.PeerHost); err != nil {
+			s.log.Printf("saving the routing table cache: %s", err)
+		}
+	}
+	return s.node.Close()
+}
+
+// Embedded reports whether the node is one we spawned ourselves rather than a
+// local daemon. Only those need to wait for bootstrapping.
+func (s *Session) Embedded() bool {
+	return s.node != nil
+}
+
+// API returns the CoreAPI the Session fetches through, that of the spawned
+// node or of the local daemon.
+func (s *Session) API() iface.CoreAPI {
+	return s.api
+}
+
+// Node returns the spawned node, nil when fetching through a local daemon.
+func (s *Session) Node() *core.IpfsNode {
+	return s.node
+}
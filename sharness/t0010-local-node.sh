@@ -9,6 +9,9 @@ test_description="test the ipget command using a local node"
 test_init_ipfs
 test_launch_ipfs_daemon
 
+command -v python3 > /dev/null && test_set_prereq PYTHON3
+/usr/bin/time -v true > /dev/null 2>&1 && test_set_prereq GNU_TIME
+
 test_expect_success "create a test file" "
     echo 'hello ipget' | ipfs add -q > hash
     cat hash
@@ -41,6 +44,201 @@ test_expect_success "retrieve a directory" "
     ls got_dir/data.txt > /dev/null
 "
 
+test_expect_success "refuse to overwrite an existing file with --overwrite-policy=error" "
+    test_must_fail ipget --node=local -o data.txt --overwrite-policy=error $file
+"
+
+test_expect_success "rename when the file exists" "
+    ipget --node=local -o data.txt --overwrite-policy=rename $file &&
+    shasum data.1.txt | cut -d ' ' -f 1 > actual &&
+    diff expected actual
+"
+
+test_expect_success "retrieve a file under a directory" "
+    ipget --node=local -o sub.txt /ipfs/$dir/data.txt &&
+    shasum sub.txt | cut -d ' ' -f 1 > actual &&
+    diff expected actual
+"
+
+test_expect_success "report a missing entry under a directory" "
+    test_must_fail ipget --node=local /ipfs/$dir/missing.txt 2> err &&
+    grep 'no link named \"missing.txt\" under /ipfs/$dir' err
+"
+
+test_expect_success "resolve a subpath without downloading it" "
+    ipget --node=local --resolve-only --out-format=cid /ipfs/$dir/data.txt > actual &&
+    echo $file > expected &&
+    diff expected actual
+"
+
+test_expect_success "create an empty file and empty directories" "
+    touch empty.txt &&
+    ipfs add -q empty.txt > empty_hash &&
+    mkdir -p holes/none &&
+    touch holes/empty.txt &&
+    ipfs add -rq holes | tail -n 1 > holes_hash &&
+    ipfs object new unixfs-dir > empty_dir_hash
+"
+
+empty=$(cat empty_hash)
+holes=$(cat holes_hash)
+empty_dir=$(cat empty_dir_hash)
+
+test_expect_success "retrieve an empty file" "
+    ipget --node=local -o got_empty.txt $empty &&
+    test -f got_empty.txt &&
+    test ! -s got_empty.txt
+"
+
+test_expect_success "name an empty file without an extension with --detect-type" "
+    ipget --node=local --detect-type $empty &&
+    test -f $empty &&
+    test ! -e $empty.txt
+"
+
+test_expect_success "retrieve an empty directory" "
+    ipget --node=local -o got_empty_dir $empty_dir &&
+    test -d got_empty_dir &&
+    ls -A got_empty_dir > actual &&
+    test ! -s actual
+"
+
+test_expect_success "retrieve a directory holding an empty file and directory" "
+    ipget --node=local -o got_holes $holes &&
+    test -f got_holes/empty.txt &&
+    test ! -s got_holes/empty.txt &&
+    test -d got_holes/none &&
+    ls -A got_holes/none > actual &&
+    test ! -s actual
+"
+
+test_expect_success "create balanced and trickle files" "
+    head -c 300000 /dev/urandom > big.bin &&
+    ipfs add -q --chunker=size-1024 big.bin > balanced_hash &&
+    ipfs add -q --chunker=size-1024 --trickle big.bin > trickle_hash &&
+    shasum big.bin | cut -d ' ' -f 1 > expected
+"
+
+balanced=$(cat balanced_hash)
+trickle=$(cat trickle_hash)
+
+test_expect_success "retrieve a balanced file" "
+    ipget --node=local -o balanced.bin --verify-layout $balanced 2> err &&
+    grep 'layout: balanced' err &&
+    shasum balanced.bin | cut -d ' ' -f 1 > actual &&
+    diff expected actual
+"
+
+test_expect_success "retrieve a trickle file" "
+    ipget --node=local -o trickle.bin --verify-layout $trickle 2> err &&
+    grep 'layout: trickle' err &&
+    shasum trickle.bin | cut -d ' ' -f 1 > actual &&
+    diff expected actual
+"
+
+test_expect_success "skip an unchanged path with --since" "
+    ipget --node=local -o since.txt --since=state.json $file &&
+    test_expect_code 3 ipget --node=local -o since2.txt --since=state.json $file &&
+    test ! -e since2.txt
+"
+
+# slow_reader reads stdin 64KiB at a time, pausing after each read, so
+# that ipget has to wait for the output to be written
+slow_reader() {
+    python3 -c '
+import sys, time
+while sys.stdin.buffer.read(65536):
+    time.sleep(0.005)
+'
+}
+
+test_expect_success "create a large file" "
+    head -c 268435456 /dev/urandom > huge.bin &&
+    ipfs add -q huge.bin > huge_hash
+"
+
+huge=$(cat huge_hash)
+test_expect_success PYTHON3,GNU_TIME "bound the memory of a fetch to a slow writer with --max-memory" "
+    /usr/bin/time -v ipget --node=local --max-memory=4MB -o - $huge 2> time_err | slow_reader &&
+    ! grep 'exited with non-zero status' time_err &&
+    rss=\$(grep 'Maximum resident set size' time_err | awk '{print \$NF}') &&
+    echo \"peak RSS \$rss KiB\" &&
+    test \$rss -lt 131072
+"
+
+test_expect_success PYTHON3,GNU_TIME "stream a large file to a pipe in bounded memory" "
+    /usr/bin/time -v ipget --node=local -o - $huge 2> time_err | slow_reader &&
+    ! grep 'exited with non-zero status' time_err &&
+    rss=\$(grep 'Maximum resident set size' time_err | awk '{print \$NF}') &&
+    echo \"peak RSS \$rss KiB\" &&
+    test \$rss -lt 131072
+"
+
+test_expect_success "stream a large file to a pipe intact" "
+    shasum huge.bin | cut -d ' ' -f 1 > expected &&
+    ipget --node=local -o - $huge | shasum | cut -d ' ' -f 1 > actual &&
+    diff expected actual
+"
+
+test_expect_success "create a file the local node serves corrupt" "
+    head -c 3000 /dev/urandom > corrupt.bin &&
+    ipfs add -q --raw-leaves --chunker=size-1024 corrupt.bin > corrupt_hash &&
+    shasum corrupt.bin | cut -d ' ' -f 1 > corrupt_expected
+"
+
+corrupt=$(cat corrupt_hash)
+test_expect_success "let the gateway serve the file, and corrupt a block on the node" "
+    mkdir -p gateway/ipfs &&
+    for c in $corrupt \$(ipfs refs -r $corrupt); do
+        ipfs block get \$c > gateway/ipfs/\$c
+    done &&
+    leaf=\$(ipfs refs $corrupt | head -n 1) &&
+    for f in \$(find \"\$IPFS_PATH/blocks\" -name '*.data'); do
+        if cmp -s \$f gateway/ipfs/\$leaf; then
+            head -c 1024 /dev/urandom > \$f
+        fi
+    done &&
+    ipfs block get \$leaf > served &&
+    ! cmp -s served gateway/ipfs/\$leaf
+"
+
+test_expect_success "create a directory a gateway serves as linking back to itself" "
+    echo 'not a directory' | ipfs add -q > loop_hash
+"
+
+loop=$(cat loop_hash)
+test_expect_success "serve the directory in place of what it links to" "
+    ipfs object patch add-link $empty_dir loop $loop > cyclic_hash &&
+    mkdir -p gateway/ipfs &&
+    ipfs block get \$(cat cyclic_hash) > gateway/ipfs/$loop &&
+    ipfs pin rm $loop &&
+    ipfs block rm $loop
+"
+
+if test_have_prereq PYTHON3; then
+    (cd gateway && exec python3 -m http.server 8089 > /dev/null 2>&1) &
+    gateway_pid=$!
+    go-sleep 1s
+fi
+
+test_expect_success PYTHON3 "cut off a peer that sends corrupt blocks" "
+    peer=\$(ipfs id -f '<id>') &&
+    ipget --node=spawn --bootstrap=/ip4/127.0.0.1/tcp/$PORT_SWARM/p2p/\$peer \
+        --gateway=http://127.0.0.1:8089 --gateway-block-timeout=5s --timeout=2m \
+        -o corrupt_got.bin $corrupt 2> err &&
+    grep \"WARNING: peer \$peer sent [0-9]* corrupt or unrequested blocks\" err &&
+    shasum corrupt_got.bin | cut -d ' ' -f 1 > actual &&
+    diff corrupt_expected actual
+"
+
+test_expect_success PYTHON3 "refuse to follow a cycle from a trusted gateway" "
+    test_must_fail ipget --node=local --gateway=http://127.0.0.1:8089 --trust-gateway \
+        --gateway-timeout=1s --timeout=1m -o cyclic $loop 2> err &&
+    grep 'cycle detected at CID $loop' err
+"
+
+test_have_prereq PYTHON3 && kill $gateway_pid
+
 # kill the local ipfs node
 test_kill_ipfs_daemon
 
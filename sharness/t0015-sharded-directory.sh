@@ -0,0 +1,53 @@
+#!/bin/sh
+
+test_description="test the ipget command on a HAMT-sharded directory"
+
+
+. lib/test-lib.sh
+
+# start a local ipfs node that shards the directories it adds
+test_init_ipfs
+
+test_expect_success "enable directory sharding" "
+    ipfs config --json Experimental.ShardingEnabled true
+"
+
+test_launch_ipfs_daemon
+
+test_expect_success "create a sharded directory" "
+    mkdir big_dir &&
+    for i in \$(test_seq 1 2000); do
+        echo \$i > big_dir/file\$i
+    done &&
+    ipfs add -rq big_dir | tail -n 1 > dir_hash
+"
+dir=$(cat dir_hash)
+
+test_expect_success "say the directory is sharded with --explain" "
+    ipget --node=local --explain -o explained $dir 2> err &&
+    grep '$dir is a HAMT-sharded directory' err
+"
+
+test_expect_success "retrieve every entry of a sharded directory" "
+    ipget --node=local -o got_dir $dir &&
+    ls got_dir | sort > actual &&
+    ls big_dir | sort > expected &&
+    diff expected actual &&
+    diff -r big_dir got_dir
+"
+
+test_expect_success "retrieve a file under a sharded directory" "
+    ipget --node=local -o file1234 /ipfs/$dir/file1234 &&
+    echo 1234 > expected &&
+    diff expected file1234
+"
+
+test_expect_success "report a missing entry under a sharded directory" "
+    test_must_fail ipget --node=local /ipfs/$dir/missing 2> err &&
+    grep 'no link named \"missing\" under /ipfs/$dir' err
+"
+
+# kill the local ipfs node
+test_kill_ipfs_daemon
+
+test_done
@@ -0,0 +1,43 @@
+#!/bin/sh
+
+test_description="test that ipget keeps the entries of a directory inside it"
+
+
+. lib/test-lib.sh
+
+# start the local ipfs node
+test_init_ipfs
+test_launch_ipfs_daemon
+
+test_expect_success "create a directory with entries named to escape it" "
+    echo 'hello ipget' | ipfs add -q > hash &&
+    file=\$(cat hash) &&
+    printf '{\"Data\":\"CAE=\",\"Links\":[' > dir.json &&
+    printf '{\"Name\":\"../escaped\",\"Hash\":\"%s\",\"Size\":20},' \$file >> dir.json &&
+    printf '{\"Name\":\"..\",\"Hash\":\"%s\",\"Size\":20},' \$file >> dir.json &&
+    printf '{\"Name\":\"ok.txt\",\"Hash\":\"%s\",\"Size\":20}]}' \$file >> dir.json &&
+    ipfs object put --datafieldenc=base64 -q dir.json > dir_hash
+"
+dir=$(cat dir_hash)
+
+test_expect_success "refuse an entry that would be written outside" "
+    mkdir out &&
+    test_must_fail ipget --node=local -o out/got $dir 2> err &&
+    grep 'refusing the directory entry' err &&
+    test ! -e out/escaped &&
+    test ! -e escaped
+"
+
+test_expect_success "write the other entries with --keep-going" "
+    test_must_fail ipget --node=local --keep-going -o out/kept $dir 2> err &&
+    grep 'refusing the directory entry \"../escaped\"' err &&
+    grep 'refusing the directory entry \"..\"' err &&
+    echo 'hello ipget' > expected &&
+    diff expected out/kept/ok.txt &&
+    test ! -e out/escaped
+"
+
+# kill the local ipfs node
+test_kill_ipfs_daemon
+
+test_done
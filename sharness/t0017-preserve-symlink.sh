@@ -0,0 +1,39 @@
+#!/bin/sh
+
+test_description="test that --preserve sets the time of symlinks themselves"
+
+
+. lib/test-lib.sh
+
+# start the local ipfs node
+test_init_ipfs
+test_launch_ipfs_daemon
+
+test "$(uname)" = Linux && test_set_prereq LINUX
+
+test_expect_success "create a directory holding a symlink with an mtime" "
+    echo 'hello ipget' | ipfs add -q > hash &&
+    file=\$(cat hash) &&
+    printf '{\"Data\":\"CAQSCGRhdGEudHh0QgYIgJTr3AM=\",\"Links\":[]}' > link.json &&
+    ipfs object put --datafieldenc=base64 -q link.json > link_hash &&
+    printf '{\"Data\":\"CAE=\",\"Links\":[' > dir.json &&
+    printf '{\"Name\":\"data.txt\",\"Hash\":\"%s\",\"Size\":20},' \$file >> dir.json &&
+    printf '{\"Name\":\"link\",\"Hash\":\"%s\",\"Size\":30}]}' \$(cat link_hash) >> dir.json &&
+    ipfs object put --datafieldenc=base64 -q dir.json > dir_hash
+"
+dir=$(cat dir_hash)
+
+test_expect_success LINUX "set the mtime of the symlink, not of its target" "
+    ipget --node=local --preserve=mtime -o got $dir &&
+    test -h got/link &&
+    echo 1000000000 > expected &&
+    stat -c %Y got/link > actual &&
+    diff expected actual &&
+    stat -L -c %Y got/link > target &&
+    ! diff expected target
+"
+
+# kill the local ipfs node
+test_kill_ipfs_daemon
+
+test_done
@@ -18,6 +18,27 @@ test_expect_success "retrieve a known popular single file with browser protocol
     diff expected actual
 "
 
+test_expect_success "retrieve a known popular single file with a subdomain gateway URL" "
+    ipget 'https://bafybeidsg6t7ici2osxjkukisd5inixiunqdpq2q5jy4a2ruzdf6ewsqk4.ipfs.dweb.link/cat.gif?filename=cat.gif' &&
+    shasum cat.gif | cut -d ' ' -f 1 > actual &&
+    diff expected actual
+"
+
+test_expect_success "point at the mistyped character of an invalid CID" "
+    test_must_fail ipget QmQ2r6iMNpky5f1m4cnm3Yqw8VSvjuKpTcK0X7dBR1LkJF 2> err &&
+    grep \"'0' at position 36 is not a base58btc character\" err
+"
+
+test_expect_success "reject an unknown placeholder in an output template" "
+    test_must_fail ipget --out-template '{hash}.bin' QmQ2r6iMNpky5f1m4cnm3Yqw8VSvjuKpTcK1X7dBR1LkJF 2> err &&
+    grep 'unknown placeholder {hash}' err
+"
+
+test_expect_success "reject an output template that names every path the same" "
+    test_must_fail ipget --out-template 'out.bin' QmQ2r6iMNpky5f1m4cnm3Yqw8VSvjuKpTcK1X7dBR1LkJF /ipfs/QmQ2r6iMNpky5f1m4cnm3Yqw8VSvjuKpTcK1X7dBR1LkJF/cat.gif 2> err &&
+    grep 'would write every path to the same place' err
+"
+
 test_expect_failure "don't allow non-(HTTP)gateway URLS" "
     ipget ftp://ipfs.io/ipfs/QmQ2r6iMNpky5f1m4cnm3Yqw8VSvjuKpTcK1X7dBR1LkJF/cat.gif
 "
@@ -0,0 +1,130 @@
+package ipget
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	config "github.com/ipfs/go-ipfs-config"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	transport "github.com/libp2p/go-libp2p-core/transport"
+	tptu "github.com/libp2p/go-libp2p-transport-upgrader"
+	ma "github.com/multiformats/go-multiaddr"
+	"golang.org/x/net/proxy"
+)
+
+// socksTransport dials TCP and onion addresses through a SOCKS5 proxy, such
+// as the one Tor runs. Host names are handed to the proxy to resolve, so
+// they don't leak through local DNS lookups. It can't listen: peers behind
+// the proxy aren't reachable from outside.
+type socksTransport struct {
+	upgrader *tptu.Upgrader
+	dialer   proxy.ContextDialer
+	// onionOnly restricts dials to onion addresses.
+	onionOnly bool
+}
+
+// newSocksTransport returns the constructor of a transport going through
+// the SOCKS5 proxy at addr, for libp2p to hand the upgrader to.
+func newSocksTransport(addr string, onionOnly bool) (func(*tptu.Upgrader) (*socksTransport, error), error) {
+	d, err := proxy.SOCKS5("tcp", addr, nil, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+	cd, ok := d.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("the SOCKS5 dialer can't be cancelled")
+	}
+	return func(u *tptu.Upgrader) (*socksTransport, error) {
+		return &socksTransport{upgrader: u, dialer: cd, onionOnly: onionOnly}, nil
+	}, nil
+}
+
+// throughProxy keeps a node dialing through a proxy from listening, mapping
+// ports or looking for peers on the local network, all of which would give
+// it away.
+func throughProxy(cfg *config.Config) {
+	cfg.Addresses.Swarm = []string{}
+	cfg.Swarm.DisableNatPortMap = true
+	cfg.Discovery.MDNS.Enabled = false
+}
+
+// socksTarget returns the host:port the proxy is asked to connect to for
+// the multiaddr a, and whether it is an onion address.
+func socksTarget(a ma.Multiaddr) (string, bool, error) {
+	var host, port string
+	onion := false
+	var err error
+	ma.ForEach(a, func(c ma.Component) bool {
+		switch c.Protocol().Code {
+		case ma.P_IP4, ma.P_IP6, ma.P_DNS, ma.P_DNS4, ma.P_DNS6:
+			host = c.Value()
+		case ma.P_TCP:
+			port = c.Value()
+		case ma.P_ONION3:
+			// the value is the address and the port, separated by a colon
+			i := strings.LastIndex(c.Value(), ":")
+			host, port, onion = c.Value()[:i]+".onion", c.Value()[i+1:], true
+		default:
+			err = fmt.Errorf("%s can't be dialed through a SOCKS5 proxy", a)
+			return false
+		}
+		return true
+	})
+	if err == nil && (host == "" || port == "") {
+		err = fmt.Errorf("%s has no host and TCP port", a)
+	}
+	return net.JoinHostPort(host, port), onion, err
+}
+
+func (t *socksTransport) CanDial(a ma.Multiaddr) bool {
+	_, onion, err := socksTarget(a)
+	return err == nil && (onion || !t.onionOnly)
+}
+
+func (t *socksTransport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	target, onion, err := socksTarget(raddr)
+	if err != nil {
+		return nil, err
+	}
+	if t.onionOnly && !onion {
+		return nil, fmt.Errorf("%s is not an onion address", raddr)
+	}
+	conn, err := t.dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return nil, err
+	}
+	return t.upgrader.UpgradeOutbound(ctx, t, &socksConn{Conn: conn, raddr: raddr}, p)
+}
+
+func (t *socksTransport) Listen(laddr ma.Multiaddr) (transport.Listener, error) {
+	return nil, fmt.Errorf("a node dialing through a SOCKS5 proxy doesn't listen")
+}
+
+func (t *socksTransport) Protocols() []int {
+	return []int{ma.P_TCP, ma.P_ONION3}
+}
+
+func (t *socksTransport) Proxy() bool {
+	return false
+}
+
+func (t *socksTransport) String() string {
+	return "SOCKS5"
+}
+
+// socksConn is a connection through the proxy, which stands for the peer
+// address dialed rather than the proxy's.
+type socksConn struct {
+	net.Conn
+	raddr ma.Multiaddr
+}
+
+// socksLocalAddr is the local address of every connection through the
+// proxy, which hides the real one.
+var socksLocalAddr = ma.StringCast("/ip4/0.0.0.0/tcp/0")
+
+func (c *socksConn) LocalMultiaddr() ma.Multiaddr { return socksLocalAddr }
+
+func (c *socksConn) RemoteMultiaddr() ma.Multiaddr { return c.raddr }
@@ -0,0 +1,124 @@
+package ipget
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Summary describes a whole run: the paths asked for, what they resolved
+// to, the files written, the bytes read and the peers they came from, and
+// what went wrong. ipget writes it for --summary-json; embedders build one
+// with NewSummary, pass each Get an entry through WithSummary and Finish it
+// once done.
+type Summary struct {
+	Start time.Time `json:"start"`
+	// Duration is in seconds, from Start to Finish.
+	Duration float64 `json:"duration"`
+	// Bytes is the total of the Bytes of Paths.
+	Bytes int64 `json:"bytes"`
+	// Peers are those that sent blocks for any of the Paths.
+	Peers  []string       `json:"peers"`
+	Paths  []*PathSummary `json:"paths"`
+	Errors []string       `json:"errors,omitempty"`
+
+	mu sync.Mutex
+}
+
+// PathSummary is the result of fetching one path.
+type PathSummary struct {
+	Requested string `json:"requested"`
+	// CID is what Requested resolved to, if it got that far.
+	CID    string `json:"cid,omitempty"`
+	Output string `json:"output,omitempty"`
+	// Bytes is the content read from the node Get returned.
+	Bytes int64 `json:"bytes"`
+	// Peers are those that sent blocks, known for a spawned node only.
+	Peers []string      `json:"peers,omitempty"`
+	Files []FileSummary `json:"files,omitempty"`
+	// Written counts the files and symlinks written, Skipped the entries
+	// the filters left out, which aren't failures, and Failed the files
+	// that couldn't be written, which are listed in FailedPaths.
+	Written     int      `json:"written"`
+	Skipped     int      `json:"skipped"`
+	Failed      int      `json:"failed"`
+	FailedPaths []string `json:"failed_paths,omitempty"`
+	// Missing are the paths --fail-on-partial found missing from the
+	// output, relative to it.
+	Missing []string `json:"missing,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	// NotModified is set when --since skipped the path.
+	NotModified bool `json:"not_modified,omitempty"`
+}
+
+// FileSummary is one file of a path, written or failed.
+type FileSummary struct {
+	// Path is relative to the output, with forward slashes.
+	Path  string `json:"path"`
+	CID   string `json:"cid,omitempty"`
+	Size  int64  `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+// NewSummary starts a summary of a run.
+func NewSummary() *Summary {
+	return &Summary{Start: time.Now(), Paths: []*PathSummary{}}
+}
+
+// Add returns a new entry for the path requested, to fill in as it is
+// fetched.
+func (s *Summary) Add(requested string) *PathSummary {
+	ps := &PathSummary{Requested: requested}
+	s.mu.Lock()
+	s.Paths = append(s.Paths, ps)
+	s.mu.Unlock()
+	return ps
+}
+
+// Finish totals the entries of s and records err, if any, as the error that
+// ended the run.
+func (s *Summary) Finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Duration = time.Since(s.Start).Seconds()
+	s.Bytes = 0
+	peers := map[string]bool{}
+	s.Errors = nil
+	for _, ps := range s.Paths {
+		s.Bytes += atomic.LoadInt64(&ps.Bytes)
+		for _, p := range ps.Peers {
+			peers[p] = true
+		}
+		if ps.Error != "" {
+			s.Errors = append(s.Errors, ps.Requested+": "+ps.Error)
+		}
+	}
+	if err != nil && !s.reported(err) {
+		s.Errors = append(s.Errors, err.Error())
+	}
+	s.Peers = make([]string, 0, len(peers))
+	for p := range peers {
+		s.Peers = append(s.Peers, p)
+	}
+	sort.Strings(s.Peers)
+}
+
+// reported reports whether err is already the error of one of the paths.
+func (s *Summary) reported(err error) bool {
+	for _, ps := range s.Paths {
+		if ps.Error == err.Error() {
+			return true
+		}
+	}
+	return false
+}
+
+// WithSummary makes Get record into ps whether it failed and, as the
+// content it returns is read, how many bytes were. Passing nil disables it,
+// which is the default.
+func WithSummary(ps *PathSummary) GetOption {
+	return func(s *getSettings) {
+		s.summary = ps
+	}
+}
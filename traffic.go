@@ -0,0 +1,149 @@
+package ipget
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+	"sync"
+
+	bitswappb "github.com/ipfs/go-bitswap/message/pb"
+	host "github.com/libp2p/go-libp2p-core/host"
+	network "github.com/libp2p/go-libp2p-core/network"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	protocol "github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// PeerTraffic is what one peer sent us over bitswap.
+type PeerTraffic struct {
+	Peer   peer.ID
+	Bytes  int64
+	Blocks int
+}
+
+// trafficMeter attributes the blocks a spawned node receives over bitswap
+// to the peers that sent them. Bitswap doesn't say who sent what, so the
+// meter reads along the incoming bitswap streams and decodes the messages a
+// second time.
+type trafficMeter struct {
+	mu    sync.Mutex
+	peers map[peer.ID]*PeerTraffic
+}
+
+func newTrafficMeter() *trafficMeter {
+	return &trafficMeter{peers: make(map[peer.ID]*PeerTraffic)}
+}
+
+// snapshot returns what each peer sent so far, for counts to start from.
+func (m *trafficMeter) snapshot() map[peer.ID]PeerTraffic {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[peer.ID]PeerTraffic, len(m.peers))
+	for p, t := range m.peers {
+		out[p] = *t
+	}
+	return out
+}
+
+// counts returns what each peer sent since base was taken, the peers that
+// sent the most first.
+func (m *trafficMeter) counts(base map[peer.ID]PeerTraffic) []PeerTraffic {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]PeerTraffic, 0, len(m.peers))
+	for p, t := range m.peers {
+		b := base[p]
+		if t.Blocks == b.Blocks {
+			continue
+		}
+		out = append(out, PeerTraffic{Peer: p, Bytes: t.Bytes - b.Bytes, Blocks: t.Blocks - b.Blocks})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Bytes > out[j].Bytes })
+	return out
+}
+
+func (m *trafficMeter) add(p peer.ID, msg *bitswappb.Message) {
+	blocks := len(msg.Blocks) + len(msg.Payload)
+	if blocks == 0 {
+		return
+	}
+	var n int64
+	for _, b := range msg.Blocks {
+		n += int64(len(b))
+	}
+	for _, b := range msg.Payload {
+		n += int64(len(b.Data))
+	}
+	m.mu.Lock()
+	t, ok := m.peers[p]
+	if !ok {
+		t = &PeerTraffic{Peer: p}
+		m.peers[p] = t
+	}
+	t.Bytes += n
+	t.Blocks += blocks
+	m.mu.Unlock()
+}
+
+// meteredHost hands the bitswap stream handlers metered streams.
+type meteredHost struct {
+	host.Host
+	meter *trafficMeter
+}
+
+func (h *meteredHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	if strings.HasPrefix(string(pid), "/ipfs/bitswap") {
+		inner := handler
+		handler = func(s network.Stream) {
+			inner(&meteredStream{Stream: s, meter: h.meter})
+		}
+	}
+	h.Host.SetStreamHandler(pid, handler)
+}
+
+// meteredStream decodes the length prefixed bitswap messages read from it,
+// as they go by. Should a message not decode, the stream is left alone from
+// then on.
+type meteredStream struct {
+	network.Stream
+	meter  *trafficMeter
+	buf    []byte
+	broken bool
+}
+
+func (s *meteredStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	if n > 0 && !s.broken {
+		s.buf = append(s.buf, p[:n]...)
+		s.decode()
+	}
+	return n, err
+}
+
+func (s *meteredStream) decode() {
+	for {
+		l, k := binary.Uvarint(s.buf)
+		if k == 0 {
+			return // the length isn't all there yet
+		}
+		if k < 0 || l > network.MessageSizeMax {
+			s.broken, s.buf = true, nil
+			return
+		}
+		if uint64(len(s.buf)-k) < l {
+			return
+		}
+		var msg bitswappb.Message
+		if err := msg.Unmarshal(s.buf[k : k+int(l)]); err != nil {
+			s.broken, s.buf = true, nil
+			return
+		}
+		s.meter.add(s.Conn().RemotePeer(), &msg)
+		s.buf = append(s.buf[:0], s.buf[k+int(l):]...)
+	}
+}
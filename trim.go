@@ -0,0 +1,78 @@
+package ipget
+
+import (
+	"context"
+	"fmt"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+)
+
+// untrimmed returns the blocks stored since it was last called.
+func (b *blockCounter) untrimmed() []datastore.Key {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var keys []datastore.Key
+	for key, job := range b.stored {
+		if job > b.trimmed {
+			keys = append(keys, key)
+		}
+	}
+	b.trimmed = b.job
+	return keys
+}
+
+// forget stops counting key as stored, it was removed since.
+func (b *blockCounter) forget(key datastore.Key) {
+	b.mu.Lock()
+	delete(b.stored, key)
+	b.mu.Unlock()
+}
+
+// Trim removes from a spawned node's blockstore the blocks the Gets since
+// the last Trim fetched, once what they fetched is written and the blocks
+// are no longer needed, so a long run through a persistent repo doesn't
+// fill it. Only the blocks fetched are removed: those found in the
+// blockstore already, and those seeded, are left for whatever else uses
+// them, and so are the pinned ones. A later Get needing one of the blocks
+// removed fetches it again. It returns how many blocks were removed and
+// how many were kept for being pinned.
+func (s *Session) Trim(ctx context.Context) (removed, pinned int, err error) {
+	if !s.Embedded() {
+		return 0, 0, nil
+	}
+	keys := s.blocks.untrimmed()
+	if len(keys) == 0 {
+		return 0, 0, nil
+	}
+	cids := make([]cid.Cid, 0, len(keys))
+	byCid := make(map[cid.Cid]datastore.Key, len(keys))
+	for _, key := range keys {
+		c, err := dshelp.DsKeyToCid(datastore.NewKey(key.BaseNamespace()))
+		if err != nil {
+			return removed, pinned, fmt.Errorf("%s: %s", key, err)
+		}
+		cids = append(cids, c)
+		byCid[c] = key
+	}
+
+	// no pin may be added while checking and removing
+	defer s.node.Blockstore.GCLock().Unlock()
+	pins, err := s.node.Pinning.CheckIfPinned(ctx, cids...)
+	if err != nil {
+		return removed, pinned, err
+	}
+	for _, p := range pins {
+		if p.Pinned() {
+			pinned++
+			continue
+		}
+		if err := s.node.Blockstore.DeleteBlock(p.Key); err != nil {
+			return removed, pinned, err
+		}
+		s.blocks.forget(byCid[p.Key])
+		removed++
+	}
+	return removed, pinned, nil
+}
@@ -1,16 +1,16 @@
-package main
+package ipget
 
 import (
 	"context"
-	"log"
 	"sync"
+	"time"
 
 	iface "github.com/ipfs/interface-go-ipfs-core"
 	peer "github.com/libp2p/go-libp2p-core/peer"
 	ma "github.com/multiformats/go-multiaddr"
 )
 
-func connect(ctx context.Context, ipfs iface.CoreAPI, peers []string) error {
+func connect(ctx context.Context, ipfs iface.CoreAPI, peers []string, l Logger) error {
 	var wg sync.WaitGroup
 	pinfos := make(map[peer.ID]*peer.AddrInfo, len(peers))
 	for _, addrStr := range peers {
@@ -34,14 +34,59 @@ func connect(ctx context.Context, ipfs iface.CoreAPI, peers []string) error {
 	for _, pi := range pinfos {
 		go func(pi *peer.AddrInfo) {
 			defer wg.Done()
-			log.Printf("attempting to connect to peer: %q\n", pi)
+			l.Printf("attempting to connect to peer: %q\n", pi)
 			err := ipfs.Swarm().Connect(ctx, *pi)
 			if err != nil {
-				log.Printf("failed to connect to %s: %s", pi.ID, err)
+				l.Printf("failed to connect to %s: %s", pi.ID, err)
 			}
-			log.Printf("successfully connected to %s\n", pi.ID)
+			l.Printf("successfully connected to %s\n", pi.ID)
 		}(pi)
 	}
 	wg.Wait()
 	return nil
 }
+
+const (
+	// DefaultBootstrapPeers is the number of connected peers after which a
+	// spawned node is considered bootstrapped. It matches go-ipfs's own
+	// threshold.
+	DefaultBootstrapPeers = 4
+
+	// bootstrapTimeout bounds how long we wait for bootstrapping before
+	// trying to fetch anyway.
+	bootstrapTimeout = 10 * time.Second
+)
+
+// waitForPeers blocks until ipfs is connected to at least n peers, or the
+// context is done.
+func waitForPeers(ctx context.Context, ipfs iface.CoreAPI, n int) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		conns, err := ipfs.Swarm().Peers(ctx)
+		if err != nil {
+			return err
+		}
+		if len(conns) >= n {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitBootstrap waits up to bootstrapTimeout for ipfs to be connected to at
+// least n peers. Running out of time is not an error; the fetch may still
+// succeed with whatever peers we have.
+func waitBootstrap(ctx context.Context, ipfs iface.CoreAPI, n int) error {
+	wctx, cancel := context.WithTimeout(ctx, bootstrapTimeout)
+	defer cancel()
+	err := waitForPeers(wctx, ipfs, n)
+	if err == context.DeadlineExceeded && ctx.Err() == nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package ipget
+
+import (
+	"fmt"
+	"strings"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	record "github.com/libp2p/go-libp2p-record"
+)
+
+// withValidators returns base extended with validators for additional
+// namespaces.
+func withValidators(base record.Validator, validators map[string]record.Validator) record.Validator {
+	ns := record.NamespacedValidator{}
+	if nsBase, ok := base.(record.NamespacedValidator); ok {
+		for name, v := range nsBase {
+			ns[name] = v
+		}
+	}
+	for name, v := range validators {
+		ns[name] = v
+	}
+	return ns
+}
+
+// permissiveValidator accepts the records base rejects, logging a warning
+// for each. It backs --no-verify-records, for debugging interop with
+// misbehaving networks only: any peer can then feed us forged records.
+type permissiveValidator struct {
+	base record.Validator
+	log  Logger
+}
+
+func (v permissiveValidator) Validate(key string, value []byte) error {
+	if err := v.base.Validate(key, value); err != nil {
+		v.log.Printf("WARNING: accepting the record for %s, which failed validation (--no-verify-records): %s", recordKeyString(key), err)
+	}
+	return nil
+}
+
+func (v permissiveValidator) Select(key string, values [][]byte) (int, error) {
+	i, err := v.base.Select(key, values)
+	if err != nil {
+		v.log.Printf("WARNING: picking the first record for %s, they couldn't be compared (--no-verify-records): %s", recordKeyString(key), err)
+		return 0, nil
+	}
+	return i, nil
+}
+
+// recordKeyString returns key printably, with the peer ID of an IPNS or
+// public key record decoded.
+func recordKeyString(key string) string {
+	for _, ns := range []string{"/ipns/", "/pk/"} {
+		if strings.HasPrefix(key, ns) {
+			if pid, err := peer.IDFromBytes([]byte(key[len(ns):])); err == nil {
+				return ns + pid.String()
+			}
+		}
+	}
+	return fmt.Sprintf("%q", key)
+}